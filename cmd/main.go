@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,12 +12,21 @@ import (
 	e "github.com/ardihikaru/go-modules/pkg/utils/error"
 	"github.com/ardihikaru/go-modules/pkg/utils/web"
 	wBot "github.com/ardihikaru/go-modules/pkg/whatsappbot"
-	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
 	"go.uber.org/zap"
 
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/app"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/config"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/router"
+	autoReplySvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/autoreply"
+	campaignSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/campaign"
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	mediaSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/media"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	scheduleSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/schedule"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+	sinkSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/sink"
+	webhookSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/webhook"
 )
 
 // Version sets the default build version
@@ -53,8 +63,108 @@ func main() {
 	// initializes http client
 	httpClient := web.BuildHttpClient(cfg.HttpClientTLS)
 
-	// creates list to store created whatsapp bot clients
-	botClients := make(botHook.BotClientList)
+	// creates hub to stream the session events
+	eventHub := eventSvc.NewHub(cfg.EventStreamBufferSize)
+
+	// creates registry to store created whatsapp bot clients
+	// every session state change is published to the event hub
+	botClients := sessionSvc.NewRegistry()
+	botClients.OnStateChange(sessionSvc.StateChangePublisher(eventHub))
+
+	// creates the conversation store, it records every inbound and outbound message
+	chats := chatSvc.NewService(db, log)
+
+	// creates the outbound message queue, it sends the stored messages through the connected devices
+	// and follows their delivery receipts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgQueue := messageSvc.NewQueue(db, log, botClients, chats, messageSvc.QueueConfig{
+		ImageDir:     cfg.WhatsappImageDir,
+		MediaDir:     cfg.WhatsappMediaDir,
+		Workers:      cfg.MsgQueueWorkers,
+		MaxAttempts:  cfg.MsgQueueMaxAttempts,
+		RetryBackoff: cfg.MsgQueueRetryBackoff,
+		PollInterval: cfg.MsgQueuePollInterval,
+		RateLimit: messageSvc.RateLimitPolicy{
+			MessagesPerMinute: cfg.RateLimitPerMinute,
+			MinDelay:          cfg.RateLimitMinDelay,
+			Jitter:            cfg.RateLimitJitter,
+			DailyCap:          cfg.RateLimitDailyCap,
+		},
+		QueueOverLimit: cfg.RateLimitQueue,
+	})
+	err = msgQueue.Start(ctx)
+	if err != nil {
+		e.FatalOnError(err, "failed to start the outbound message queue")
+	}
+	msgQueue.ConsumeReceipts(ctx, eventHub)
+
+	// creates the inbound media service, the media are downloaded on the first request unless eager
+	mediaService := mediaSvc.NewService(db, log, botClients, mediaSvc.NewFileStore(cfg.MediaDownloadDir),
+		mediaSvc.Config{Eager: cfg.MediaDownloadEager, Workers: cfg.MediaDownloadWorkers})
+	mediaService.ConsumeEvents(ctx, eventHub)
+
+	// queues the messages sent on behalf of the API clients, e.g. the webhook replies, the auto-replies and the broker
+	// commands
+	messageService := messageSvc.NewService(db, log, botClients, msgQueue, httpClient, messageSvc.ServiceConfig{
+		IdempotencyTTL: cfg.MsgIdempotencyKeyTTL,
+		MaxMediaSize:   cfg.MsgMediaMaxSize,
+	})
+
+	// forwards the incoming messages to the device webhook, the deliveries are signed and retried
+	// and the webhook replies are queued as any other message
+	// the echo mode is left to the whatsapp bot module, it does not call any webhook
+	webhooks := webhookSvc.NewForwarder(db, log, botClients, messageService, httpClient, webhookSvc.Config{
+		Enabled:      cfg.WhatsappWebhookEnabled && !cfg.WhatsappWebhookEcho,
+		BaseURL:      cfg.PublicBaseURL,
+		Workers:      cfg.WebhookWorkers,
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		RetryBackoff: cfg.WebhookRetryBackoff,
+	})
+	webhooks.Start(ctx, eventHub)
+
+	// answers the incoming messages matching the auto-reply rules of the device, alongside the webhook
+	autoReplies := autoReplySvc.NewService(db, log, messageService, autoReplySvc.Config{Workers: cfg.AutoReplyWorkers})
+	autoReplies.Start(ctx, eventHub)
+
+	// dispatches the scheduled messages once due, the devices without a live session are skipped
+	schedules := scheduleSvc.NewService(db, log, botClients, messageService, scheduleSvc.Config{
+		PollInterval: cfg.SchedulerPollInterval,
+	})
+	schedules.Start(ctx)
+
+	// broadcasts the campaigns over time, the recipients are checked on whatsapp before being sent the message
+	checker := sessionSvc.NewChecker(db, log, botClients, sessionSvc.CheckerConfig{
+		BatchSize:  cfg.OnWhatsappBatchSize,
+		CacheTTL:   cfg.OnWhatsappCacheTTL,
+		MaxNumbers: cfg.OnWhatsappMaxNumbers,
+		Policy:     sessionSvc.CheckerPolicy(cfg.OnWhatsappPolicy),
+		Phone:      cfg.OnWhatsappPhone,
+	})
+	campaigns := campaignSvc.NewService(db, log, botClients, messageService, checker, campaignSvc.Config{
+		MaxRecipients: cfg.CampaignMaxRecipients,
+	})
+	campaigns.Start(ctx)
+
+	// publishes the events on the message broker, and sends the messages commanded through it
+	if cfg.EventSinkDriver != "" {
+		broker, err := sinkSvc.NewBroker(cfg.EventSinkDriver, cfg.EventSinkURL, log)
+		if err != nil {
+			e.FatalOnError(err, "failed to connect to the event sink")
+		}
+		defer broker.Close()
+
+		err = sinkSvc.NewService(broker, log, messageService, sinkSvc.Config{
+			EventsTopic:   cfg.EventSinkEventsTopic,
+			CommandsTopic: cfg.EventSinkCommandsTopic,
+			ResultsTopic:  cfg.EventSinkResultsTopic,
+			Group:         cfg.EventSinkGroup,
+			BaseURL:       cfg.PublicBaseURL,
+		}).Start(ctx, eventHub)
+		if err != nil {
+			e.FatalOnError(err, "failed to start the event sink")
+		}
+	}
 
 	// initializes whatsapp bot
 	whatsAppBot := wBot.InitWhatsappContainer(cfg.WhatsappDbName, log)
@@ -66,7 +176,15 @@ func main() {
 		Log:         log,
 		HttpClient:  httpClient,
 		WhatsAppBot: whatsAppBot,
-		BotClients:  &botClients,
+		BotClients:  botClients,
+		Events:      eventHub,
+		MsgQueue:    msgQueue,
+		Chats:       chats,
+		Media:       mediaService,
+		Webhooks:    webhooks,
+		AutoReplies: autoReplies,
+		Schedules:   schedules,
+		Campaigns:   campaigns,
 	}
 
 	// starts the api server
@@ -81,6 +199,7 @@ func main() {
 	// shutdowns the RESTApi Server
 	<-c
 	log.Info("gracefully shutting down the system")
+	cancel()
 
 	// exit app
 	os.Exit(0)
@@ -16,7 +16,7 @@ func AutoStartLoggedSessions(deps *Dependencies) {
 	sessionService := sessionSvc.NewService(deviceService, deps.Log, deps.WhatsAppBot, deps.HttpClient,
 		deps.Config.WhatsappImageDir, deps.Config.WhatsappQrCodeDir,
 		deps.Config.WhatsappWebhookEcho, deps.Config.WhatsappWebhookEnabled, deps.Config.WhatsappQrToTerminal,
-		deps.BotClients)
+		deps.BotClients, deps.Events, deps.Chats)
 
 	// builds query parameters
 	params := httputils.GetQueryParams{
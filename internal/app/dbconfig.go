@@ -1,6 +1,8 @@
 package app
 
 import (
+	"context"
+
 	"github.com/ardihikaru/go-modules/pkg/logger"
 	e "github.com/ardihikaru/go-modules/pkg/utils/error"
 
@@ -16,6 +18,12 @@ func InitializeDB(cfg *config.Config, log *logger.Logger) *storage.DataStoreMong
 		e.FatalOnError(err, "failed to connect to db")
 	}
 
+	// creates the indexes required by the queries
+	err = db.EnsureIndexes(context.Background())
+	if err != nil {
+		e.FatalOnError(err, "failed to create db indexes")
+	}
+
 	log.Debug("database has been initialized successfully")
 
 	return db
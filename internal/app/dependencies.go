@@ -7,6 +7,15 @@ import (
 	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
 
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/config"
+	autoReplySvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/autoreply"
+	campaignSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/campaign"
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	mediaSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/media"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	scheduleSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/schedule"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+	webhookSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/webhook"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/storage"
 )
 
@@ -18,5 +27,13 @@ type Dependencies struct {
 	Log         *logger.Logger
 	HttpClient  *http.Client
 	WhatsAppBot *botHook.WaManager
-	BotClients  *botHook.BotClientList
+	BotClients  *sessionSvc.Registry
+	Events      *eventSvc.Hub
+	MsgQueue    *messageSvc.Queue
+	Chats       *chatSvc.Service
+	Media       *mediaSvc.Service
+	Webhooks    *webhookSvc.Forwarder
+	AutoReplies *autoReplySvc.Service
+	Schedules   *scheduleSvc.Service
+	Campaigns   *campaignSvc.Service
 }
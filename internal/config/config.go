@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -33,7 +34,50 @@ const (
 	whatsappWebhookEnabledEnv = "WHATSAPP_WEBHOOK_ENABLED"
 	whatsappWebhookEchoEnv    = "WHATSAPP_WEBHOOK_ECHO"
 	whatsappImageDirEnv       = "WHATSAPP_IMAGE_DIR"
+	whatsappMediaDirEnv       = "WHATSAPP_MEDIA_DIR"
 	httpClientTlsEnv          = "HTTP_CLIENT_TLS"
+	eventStreamBufferSizeEnv  = "EVENT_STREAM_BUFFER_SIZE"
+	msgQueueWorkersEnv        = "MESSAGE_QUEUE_WORKERS"
+	msgQueueMaxAttemptsEnv    = "MESSAGE_QUEUE_MAX_ATTEMPTS"
+	msgQueueRetryBackoffEnv   = "MESSAGE_QUEUE_RETRY_BACKOFF"
+	msgQueuePollIntervalEnv   = "MESSAGE_QUEUE_POLL_INTERVAL"
+	msgSendWaitTimeoutEnv     = "MESSAGE_SEND_WAIT_TIMEOUT"
+	msgIdempotencyKeyTTLEnv   = "MESSAGE_IDEMPOTENCY_KEY_TTL"
+	msgMediaMaxSizeEnv        = "MESSAGE_MEDIA_MAX_SIZE"
+	rateLimitPerMinuteEnv     = "RATE_LIMIT_PER_MINUTE"
+	rateLimitMinDelayEnv      = "RATE_LIMIT_MIN_DELAY"
+	rateLimitJitterEnv        = "RATE_LIMIT_JITTER"
+	rateLimitDailyCapEnv      = "RATE_LIMIT_DAILY_CAP"
+	rateLimitQueueEnv         = "RATE_LIMIT_QUEUE"
+	onWhatsappBatchSizeEnv    = "ON_WHATSAPP_BATCH_SIZE"
+	onWhatsappCacheTTLEnv     = "ON_WHATSAPP_CACHE_TTL"
+	onWhatsappMaxNumbersEnv   = "ON_WHATSAPP_MAX_NUMBERS"
+	onWhatsappPolicyEnv       = "ON_WHATSAPP_CHECKER_POLICY"
+	onWhatsappPhoneEnv        = "ON_WHATSAPP_CHECKER_PHONE"
+	mediaDownloadEagerEnv     = "MEDIA_DOWNLOAD_EAGER"
+	mediaDownloadDirEnv       = "MEDIA_DOWNLOAD_DIR"
+	mediaDownloadWorkersEnv   = "MEDIA_DOWNLOAD_WORKERS"
+	publicBaseURLEnv          = "PUBLIC_BASE_URL"
+	webhookWorkersEnv         = "WEBHOOK_WORKERS"
+	webhookMaxAttemptsEnv     = "WEBHOOK_MAX_ATTEMPTS"
+	webhookRetryBackoffEnv    = "WEBHOOK_RETRY_BACKOFF"
+	eventSinkDriverEnv        = "EVENT_SINK_DRIVER"
+	eventSinkURLEnv           = "EVENT_SINK_URL"
+	eventSinkEventsTopicEnv   = "EVENT_SINK_EVENTS_TOPIC"
+	eventSinkCommandsTopicEnv = "EVENT_SINK_COMMANDS_TOPIC"
+	eventSinkResultsTopicEnv  = "EVENT_SINK_RESULTS_TOPIC"
+	eventSinkGroupEnv         = "EVENT_SINK_GROUP"
+	autoReplyWorkersEnv       = "AUTO_REPLY_WORKERS"
+	schedulerPollIntervalEnv  = "SCHEDULER_POLL_INTERVAL"
+	campaignMaxRecipientsEnv  = "CAMPAIGN_MAX_RECIPIENTS"
+)
+
+const (
+	defaultAddress   = "0.0.0.0"
+	defaultPort      = 80
+	defaultLogLevel  = "info"
+	defaultLogFormat = "json"
+	defaultDbName    = "whatsappDb"
 )
 
 var defaultCORSAllowOrigins = []string{"*"}
@@ -67,7 +111,42 @@ type Config struct {
 	WhatsappWebhookEnabled bool                   `config:"WHATSAPP_WEBHOOK_ENABLED"`
 	WhatsappWebhookEcho    bool                   `config:"WHATSAPP_WEBHOOK_ECHO"`
 	WhatsappImageDir       string                 `config:"WHATSAPP_IMAGE_DIR"`
+	WhatsappMediaDir       string                 `config:"WHATSAPP_MEDIA_DIR"`
 	HttpClientTLS          bool                   `config:"HTTP_CLIENT_TLS"`
+	EventStreamBufferSize  int                    `config:"EVENT_STREAM_BUFFER_SIZE"`
+	MsgQueueWorkers        int                    `config:"MESSAGE_QUEUE_WORKERS"`
+	MsgQueueMaxAttempts    int                    `config:"MESSAGE_QUEUE_MAX_ATTEMPTS"`
+	MsgQueueRetryBackoff   time.Duration          `config:"MESSAGE_QUEUE_RETRY_BACKOFF"`
+	MsgQueuePollInterval   time.Duration          `config:"MESSAGE_QUEUE_POLL_INTERVAL"`
+	MsgSendWaitTimeout     time.Duration          `config:"MESSAGE_SEND_WAIT_TIMEOUT"`
+	MsgIdempotencyKeyTTL   time.Duration          `config:"MESSAGE_IDEMPOTENCY_KEY_TTL"`
+	MsgMediaMaxSize        int64                  `config:"MESSAGE_MEDIA_MAX_SIZE"`
+	RateLimitPerMinute     int                    `config:"RATE_LIMIT_PER_MINUTE"`
+	RateLimitMinDelay      time.Duration          `config:"RATE_LIMIT_MIN_DELAY"`
+	RateLimitJitter        time.Duration          `config:"RATE_LIMIT_JITTER"`
+	RateLimitDailyCap      int                    `config:"RATE_LIMIT_DAILY_CAP"`
+	RateLimitQueue         bool                   `config:"RATE_LIMIT_QUEUE"`
+	OnWhatsappBatchSize    int                    `config:"ON_WHATSAPP_BATCH_SIZE"`
+	OnWhatsappCacheTTL     time.Duration          `config:"ON_WHATSAPP_CACHE_TTL"`
+	OnWhatsappMaxNumbers   int                    `config:"ON_WHATSAPP_MAX_NUMBERS"`
+	OnWhatsappPolicy       string                 `config:"ON_WHATSAPP_CHECKER_POLICY" validate:"oneof=dedicated round-robin lru"`
+	OnWhatsappPhone        string                 `config:"ON_WHATSAPP_CHECKER_PHONE"`
+	MediaDownloadEager     bool                   `config:"MEDIA_DOWNLOAD_EAGER"`
+	MediaDownloadDir       string                 `config:"MEDIA_DOWNLOAD_DIR"`
+	MediaDownloadWorkers   int                    `config:"MEDIA_DOWNLOAD_WORKERS"`
+	PublicBaseURL          string                 `config:"PUBLIC_BASE_URL"`
+	WebhookWorkers         int                    `config:"WEBHOOK_WORKERS"`
+	WebhookMaxAttempts     int                    `config:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookRetryBackoff    time.Duration          `config:"WEBHOOK_RETRY_BACKOFF"`
+	EventSinkDriver        string                 `config:"EVENT_SINK_DRIVER" validate:"omitempty,oneof=nats amqp redis"`
+	EventSinkURL           string                 `config:"EVENT_SINK_URL"`
+	EventSinkEventsTopic   string                 `config:"EVENT_SINK_EVENTS_TOPIC"`
+	EventSinkCommandsTopic string                 `config:"EVENT_SINK_COMMANDS_TOPIC"`
+	EventSinkResultsTopic  string                 `config:"EVENT_SINK_RESULTS_TOPIC"`
+	EventSinkGroup         string                 `config:"EVENT_SINK_GROUP"`
+	AutoReplyWorkers       int                    `config:"AUTO_REPLY_WORKERS"`
+	SchedulerPollInterval  time.Duration          `config:"SCHEDULER_POLL_INTERVAL"`
+	CampaignMaxRecipients  int                    `config:"CAMPAIGN_MAX_RECIPIENTS"`
 }
 
 // Get returns the configuration loaded from the environment variable.
@@ -77,15 +156,15 @@ func Get() (*Config, error) {
 
 	c := Config{
 		BuildMode:              "dev",
-		Address:                "0.0.0.0",
-		Port:                   80,
-		LogLevel:               "info",
-		LogFormat:              "json",
+		Address:                defaultAddress,
+		Port:                   defaultPort,
+		LogLevel:               defaultLogLevel,
+		LogFormat:              defaultLogFormat,
 		CORSAllowOrigins:       defaultCORSAllowOrigins,
 		CORSAllowHeaders:       defaultCORSAllowHeaders,
 		CORSExposedHeaders:     defaultCORSExposedHeaders,
 		DbConnURI:              "mongodb://localhost:27017",
-		DBName:                 "whatsappDb",
+		DBName:                 defaultDbName,
 		DbConnTimeout:          30 * time.Second,
 		DbHeartBeatInterval:    10 * time.Second,
 		DbLocalThreshold:       15 * time.Second,
@@ -100,7 +179,42 @@ func Get() (*Config, error) {
 		WhatsappWebhookEnabled: false,
 		WhatsappWebhookEcho:    true,
 		WhatsappImageDir:       "./data/images",
+		WhatsappMediaDir:       "./data/media",
 		HttpClientTLS:          true,
+		EventStreamBufferSize:  100,
+		MsgQueueWorkers:        1,
+		MsgQueueMaxAttempts:    5,
+		MsgQueueRetryBackoff:   5 * time.Second,
+		MsgQueuePollInterval:   1 * time.Second,
+		MsgSendWaitTimeout:     30 * time.Second,
+		MsgIdempotencyKeyTTL:   24 * time.Hour,
+		MsgMediaMaxSize:        16 << 20, // 16 MiB
+		RateLimitPerMinute:     0,        // unlimited
+		RateLimitMinDelay:      0,
+		RateLimitJitter:        0,
+		RateLimitDailyCap:      0, // unlimited
+		RateLimitQueue:         false,
+		OnWhatsappBatchSize:    100,
+		OnWhatsappCacheTTL:     24 * time.Hour,
+		OnWhatsappMaxNumbers:   50000,
+		OnWhatsappPolicy:       "round-robin",
+		OnWhatsappPhone:        "",
+		MediaDownloadEager:     false,
+		MediaDownloadDir:       "./data/images/inbound",
+		MediaDownloadWorkers:   4,
+		PublicBaseURL:          "",
+		WebhookWorkers:         2,
+		WebhookMaxAttempts:     5,
+		WebhookRetryBackoff:    1 * time.Second,
+		EventSinkDriver:        "",
+		EventSinkURL:           "",
+		EventSinkEventsTopic:   "whatsapp.events",
+		EventSinkCommandsTopic: "whatsapp.commands",
+		EventSinkResultsTopic:  "whatsapp.commands.results",
+		EventSinkGroup:         "go-whatsapp-multi-device",
+		AutoReplyWorkers:       4,
+		SchedulerPollInterval:  5 * time.Second,
+		CampaignMaxRecipients:  10000,
 	}
 
 	// try to find the variable inside the environment variable
@@ -226,6 +340,9 @@ func (c *Config) validateAndLoadSystemEnv() error {
 	if os.Getenv(whatsappImageDirEnv) != "" {
 		c.WhatsappImageDir = os.Getenv(whatsappImageDirEnv)
 	}
+	if os.Getenv(whatsappMediaDirEnv) != "" {
+		c.WhatsappMediaDir = os.Getenv(whatsappMediaDirEnv)
+	}
 
 	// http client
 	if os.Getenv(httpClientTlsEnv) != "" {
@@ -237,5 +354,253 @@ func (c *Config) validateAndLoadSystemEnv() error {
 		c.HttpClientTLS = boolHttpClientTLS
 	}
 
+	// event stream
+	if os.Getenv(eventStreamBufferSizeEnv) != "" {
+		c.EventStreamBufferSize, err = strconv.Atoi(os.Getenv(eventStreamBufferSizeEnv))
+		if err != nil {
+			return err
+		}
+	}
+
+	// outbound message queue
+	if os.Getenv(msgQueueWorkersEnv) != "" {
+		c.MsgQueueWorkers, err = strconv.Atoi(os.Getenv(msgQueueWorkersEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgQueueMaxAttemptsEnv) != "" {
+		c.MsgQueueMaxAttempts, err = strconv.Atoi(os.Getenv(msgQueueMaxAttemptsEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgQueueRetryBackoffEnv) != "" {
+		c.MsgQueueRetryBackoff, err = time.ParseDuration(os.Getenv(msgQueueRetryBackoffEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgQueuePollIntervalEnv) != "" {
+		c.MsgQueuePollInterval, err = time.ParseDuration(os.Getenv(msgQueuePollIntervalEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgSendWaitTimeoutEnv) != "" {
+		c.MsgSendWaitTimeout, err = time.ParseDuration(os.Getenv(msgSendWaitTimeoutEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgIdempotencyKeyTTLEnv) != "" {
+		c.MsgIdempotencyKeyTTL, err = time.ParseDuration(os.Getenv(msgIdempotencyKeyTTLEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(msgMediaMaxSizeEnv) != "" {
+		c.MsgMediaMaxSize, err = strconv.ParseInt(os.Getenv(msgMediaMaxSizeEnv), 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	// outbound rate limit
+	if os.Getenv(rateLimitPerMinuteEnv) != "" {
+		c.RateLimitPerMinute, err = strconv.Atoi(os.Getenv(rateLimitPerMinuteEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(rateLimitMinDelayEnv) != "" {
+		c.RateLimitMinDelay, err = time.ParseDuration(os.Getenv(rateLimitMinDelayEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(rateLimitJitterEnv) != "" {
+		c.RateLimitJitter, err = time.ParseDuration(os.Getenv(rateLimitJitterEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(rateLimitDailyCapEnv) != "" {
+		c.RateLimitDailyCap, err = strconv.Atoi(os.Getenv(rateLimitDailyCapEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(rateLimitQueueEnv) != "" {
+		// validates the boolean value
+		boolRateLimitQueue, err := strconv.ParseBool(os.Getenv(rateLimitQueueEnv))
+		if err != nil {
+			return err
+		}
+		c.RateLimitQueue = boolRateLimitQueue
+	}
+
+	// bulk on-whatsapp check
+	if os.Getenv(onWhatsappBatchSizeEnv) != "" {
+		c.OnWhatsappBatchSize, err = strconv.Atoi(os.Getenv(onWhatsappBatchSizeEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(onWhatsappCacheTTLEnv) != "" {
+		c.OnWhatsappCacheTTL, err = time.ParseDuration(os.Getenv(onWhatsappCacheTTLEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(onWhatsappMaxNumbersEnv) != "" {
+		c.OnWhatsappMaxNumbers, err = strconv.Atoi(os.Getenv(onWhatsappMaxNumbersEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(onWhatsappPolicyEnv) != "" {
+		c.OnWhatsappPolicy = os.Getenv(onWhatsappPolicyEnv)
+	}
+	if os.Getenv(onWhatsappPhoneEnv) != "" {
+		c.OnWhatsappPhone = os.Getenv(onWhatsappPhoneEnv)
+	}
+
+	// inbound media download
+	if os.Getenv(mediaDownloadEagerEnv) != "" {
+		// validates the boolean value
+		boolMediaDownloadEager, err := strconv.ParseBool(os.Getenv(mediaDownloadEagerEnv))
+		if err != nil {
+			return err
+		}
+		c.MediaDownloadEager = boolMediaDownloadEager
+	}
+	if os.Getenv(mediaDownloadDirEnv) != "" {
+		c.MediaDownloadDir = os.Getenv(mediaDownloadDirEnv)
+	}
+	if os.Getenv(mediaDownloadWorkersEnv) != "" {
+		c.MediaDownloadWorkers, err = strconv.Atoi(os.Getenv(mediaDownloadWorkersEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(publicBaseURLEnv) != "" {
+		c.PublicBaseURL = strings.TrimSuffix(os.Getenv(publicBaseURLEnv), "/")
+	}
+
+	// webhook deliveries
+	if os.Getenv(webhookWorkersEnv) != "" {
+		c.WebhookWorkers, err = strconv.Atoi(os.Getenv(webhookWorkersEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(webhookMaxAttemptsEnv) != "" {
+		c.WebhookMaxAttempts, err = strconv.Atoi(os.Getenv(webhookMaxAttemptsEnv))
+		if err != nil {
+			return err
+		}
+	}
+	if os.Getenv(webhookRetryBackoffEnv) != "" {
+		c.WebhookRetryBackoff, err = time.ParseDuration(os.Getenv(webhookRetryBackoffEnv))
+		if err != nil {
+			return err
+		}
+	}
+
+	// event sink, it is disabled without any driver
+	if os.Getenv(eventSinkDriverEnv) != "" {
+		c.EventSinkDriver = os.Getenv(eventSinkDriverEnv)
+	}
+	if os.Getenv(eventSinkURLEnv) != "" {
+		c.EventSinkURL = os.Getenv(eventSinkURLEnv)
+	}
+	if os.Getenv(eventSinkEventsTopicEnv) != "" {
+		c.EventSinkEventsTopic = os.Getenv(eventSinkEventsTopicEnv)
+	}
+	if v, ok := os.LookupEnv(eventSinkCommandsTopicEnv); ok {
+		// an empty topic consumes no command
+		c.EventSinkCommandsTopic = v
+	}
+	if v, ok := os.LookupEnv(eventSinkResultsTopicEnv); ok {
+		// an empty topic publishes no command result
+		c.EventSinkResultsTopic = v
+	}
+	if os.Getenv(eventSinkGroupEnv) != "" {
+		c.EventSinkGroup = os.Getenv(eventSinkGroupEnv)
+	}
+
+	// auto-replies
+	if os.Getenv(autoReplyWorkersEnv) != "" {
+		c.AutoReplyWorkers, err = strconv.Atoi(os.Getenv(autoReplyWorkersEnv))
+		if err != nil {
+			return err
+		}
+	}
+
+	// scheduled messages
+	if os.Getenv(schedulerPollIntervalEnv) != "" {
+		c.SchedulerPollInterval, err = time.ParseDuration(os.Getenv(schedulerPollIntervalEnv))
+		if err != nil {
+			return err
+		}
+	}
+
+	// broadcast campaigns
+	if os.Getenv(campaignMaxRecipientsEnv) != "" {
+		c.CampaignMaxRecipients, err = strconv.Atoi(os.Getenv(campaignMaxRecipientsEnv))
+		if err != nil {
+			return err
+		}
+	}
+
+	switch c.EventSinkDriver {
+	case "", "nats", "amqp", "redis":
+	default:
+		return fmt.Errorf("%s must be one of nats, amqp or redis", eventSinkDriverEnv)
+	}
+	if c.EventSinkDriver != "" && c.EventSinkURL == "" {
+		return fmt.Errorf("%s is required by the %s event sink", eventSinkURLEnv, c.EventSinkDriver)
+	}
+
+	// the dedicated policy only uses the lookup device
+	if c.OnWhatsappPolicy == "dedicated" && c.OnWhatsappPhone == "" {
+		return fmt.Errorf("%s is required by the dedicated checker policy", onWhatsappPhoneEnv)
+	}
+
+	// the queue never sends any message without a worker and an attempt
+	if c.MsgQueueWorkers <= 0 {
+		return fmt.Errorf("%s must be positive", msgQueueWorkersEnv)
+	}
+	if c.MsgQueueMaxAttempts <= 0 {
+		return fmt.Errorf("%s must be positive", msgQueueMaxAttemptsEnv)
+	}
+
+	// the incoming messages are never forwarded without a webhook worker and an attempt
+	if c.WebhookWorkers <= 0 {
+		return fmt.Errorf("%s must be positive", webhookWorkersEnv)
+	}
+	if c.WebhookMaxAttempts <= 0 {
+		return fmt.Errorf("%s must be positive", webhookMaxAttemptsEnv)
+	}
+
+	// the eager download never downloads any media without a worker
+	if c.MediaDownloadWorkers <= 0 {
+		return fmt.Errorf("%s must be positive", mediaDownloadWorkersEnv)
+	}
+
+	// the incoming messages are never answered without an auto-reply worker
+	if c.AutoReplyWorkers <= 0 {
+		return fmt.Errorf("%s must be positive", autoReplyWorkersEnv)
+	}
+
+	// a ticker panics on a non-positive interval
+	if c.MsgQueuePollInterval <= 0 {
+		return fmt.Errorf("%s must be positive", msgQueuePollIntervalEnv)
+	}
+	if c.SchedulerPollInterval <= 0 {
+		return fmt.Errorf("%s must be positive", schedulerPollIntervalEnv)
+	}
+
 	return nil
 }
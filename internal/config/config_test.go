@@ -34,3 +34,18 @@ func TestGet(t *testing.T) {
 	assert.Equal(t, c.LogFormat, defaultLogFormat)
 	assert.Equal(t, c.DBName, defaultDbName)
 }
+
+func TestGetRejectsNonPositiveSettings(t *testing.T) {
+	for _, env := range []string{msgQueueWorkersEnv, msgQueueMaxAttemptsEnv, msgQueuePollIntervalEnv,
+		webhookWorkersEnv, webhookMaxAttemptsEnv, schedulerPollIntervalEnv, mediaDownloadWorkersEnv,
+		autoReplyWorkersEnv} {
+		for _, value := range []string{"0", "-1", "0s", "-1s"} {
+			os.Clearenv()
+			assert.NoError(t, os.Setenv(env, value))
+
+			_, err := Get()
+			assert.Error(t, err, env+"="+value)
+		}
+	}
+	os.Clearenv()
+}
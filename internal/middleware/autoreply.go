@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// RuleMiddlewareCtx enriches the request with the captured auto-reply rule ID on the URL parameter
+func RuleMiddlewareCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// define the URL parameters
+		var ruleKey Rule = RuleKey
+
+		// read the URL parameter
+		ctx := context.WithValue(r.Context(), ruleKey, chi.URLParam(r, RuleKey))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// ChatMiddlewareCtx enriches the request with the captured chat JID on the URL parameter
+func ChatMiddlewareCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// define the URL parameters
+		var chatKey Chat = ChatKey
+
+		// read the URL parameter
+		ctx := context.WithValue(r.Context(), chatKey, chi.URLParam(r, ChatKey))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
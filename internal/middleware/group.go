@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// GroupMiddlewareCtx enriches the request with the captured group JID on the URL parameter
+func GroupMiddlewareCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// define the URL parameters
+		var groupKey Group = GroupKey
+
+		// read the URL parameter
+		ctx := context.WithValue(r.Context(), groupKey, chi.URLParam(r, GroupKey))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -2,11 +2,16 @@ package middleware
 
 import (
 	"github.com/ardihikaru/go-modules/pkg/logger"
-	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
 )
 
 type ID string
 type Phone string
+type Group string
+type Chat string
+type Subscription string
+type Rule string
 type QueryLimit string
 type QueryOffset string
 type QueryOrder string
@@ -39,10 +44,23 @@ const (
 
 	// PhoneKey is the identifier key to store phone which is captured from the request URL parameters
 	PhoneKey = "phone"
+
+	// GroupKey is the identifier key to store group JID which is captured from the request URL parameters
+	GroupKey = "group"
+
+	// ChatKey is the identifier key to store chat JID which is captured from the request URL parameters
+	ChatKey = "jid"
+
+	// SubscriptionKey is the identifier key to store webhook subscription ID which is captured from the request URL
+	// parameters
+	SubscriptionKey = "subscription"
+
+	// RuleKey is the identifier key to store auto-reply rule ID which is captured from the request URL parameters
+	RuleKey = "rule"
 )
 
 // Resource is a middleware resource
 type Resource struct {
 	Log        *logger.Logger
-	BotClients *botHook.BotClientList
+	BotClients *sessionSvc.Registry
 }
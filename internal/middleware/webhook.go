@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// SubscriptionMiddlewareCtx enriches the request with the captured webhook subscription ID on the URL parameter
+func SubscriptionMiddlewareCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// define the URL parameters
+		var subscriptionKey Subscription = SubscriptionKey
+
+		// read the URL parameter
+		ctx := context.WithValue(r.Context(), subscriptionKey, chi.URLParam(r, SubscriptionKey))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -16,7 +16,7 @@ func (rs Resource) WhatsappCtx(next http.Handler) http.Handler {
 		phone := chi.URLParam(r, PhoneKey)
 
 		// validates
-		if _, ok := (*rs.BotClients)[phone]; ok {
+		if entry, ok := rs.BotClients.Get(phone); ok && entry.IsActive() {
 			httputils.RenderErrResponse(w, r,
 				"session for this device has been logged in",
 				304,
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	autoReplySvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/autoreply"
+)
+
+// ruleURLParams extracts the device ID and the auto-reply rule ID from the context
+func ruleURLParams(r *http.Request) (string, string) {
+	var idKey m.ID = m.IDKey
+	var ruleKey m.Rule = m.RuleKey
+
+	return r.Context().Value(idKey).(string), r.Context().Value(ruleKey).(string)
+}
+
+// ruleList processes the request to list the auto-reply rules of the device
+func ruleList(autoReplies *autoReplySvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts device id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceID := r.Context().Value(idKey).(string)
+
+		rules, err := autoReplies.GetRules(r.Context(), deviceID)
+		if err != nil {
+			renderRuleError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        rules,
+			MessageText: "fetch auto-reply rules success",
+			Total:       int64(len(rules)),
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// ruleCreate processes the request to add an auto-reply rule to the device
+func ruleCreate(autoReplies *autoReplySvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var spec autoReplySvc.RuleSpec
+
+		// extracts device id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceID := r.Context().Value(idKey).(string)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &spec)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		rule, err := autoReplies.CreateRule(r.Context(), deviceID, spec)
+		if err != nil {
+			renderRuleError(w, r, log, httputils.CreateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        rule,
+			MessageText: "auto-reply rule has been created",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// ruleGet processes the request to fetch an auto-reply rule of the device
+func ruleGet(autoReplies *autoReplySvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID, id := ruleURLParams(r)
+
+		rule, err := autoReplies.GetRule(r.Context(), deviceID, id)
+		if err != nil {
+			renderRuleError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        rule,
+			MessageText: "fetch auto-reply rule success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// ruleUpdate processes the request to replace the definition of an auto-reply rule
+func ruleUpdate(autoReplies *autoReplySvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var spec autoReplySvc.RuleSpec
+
+		deviceID, id := ruleURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &spec)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		rule, err := autoReplies.UpdateRule(r.Context(), deviceID, id, spec)
+		if err != nil {
+			renderRuleError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        rule,
+			MessageText: "auto-reply rule has been updated",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// ruleDelete processes the request to remove an auto-reply rule of the device
+func ruleDelete(autoReplies *autoReplySvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID, id := ruleURLParams(r)
+
+		err := autoReplies.DeleteRule(r.Context(), deviceID, id)
+		if err != nil {
+			renderRuleError(w, r, log, httputils.DeleteDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        nil,
+			MessageText: "auto-reply rule has been deleted",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// renderRuleError maps the auto-reply errors into the HTTP status codes
+func renderRuleError(w http.ResponseWriter, r *http.Request, log *logger.Logger, appCode int, err error) {
+	log.Debug(httputils.ResponseText("", appCode), zap.Error(err))
+
+	status := http.StatusBadRequest
+	if errors.Is(err, autoReplySvc.ErrRuleNotFound) {
+		status = http.StatusNotFound
+	}
+
+	httputils.RenderErrResponse(w, r, err.Error(), int64(appCode), status, nil)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	campaignSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/campaign"
+)
+
+const (
+	// recipientsFormFile is the multipart form field of the CSV file of the recipients
+	recipientsFormFile = "recipients"
+
+	// maxCampaignRequestSize is the maximum size of a multipart campaign request
+	maxCampaignRequestSize = 32 << 20
+)
+
+// CampaignMainHandler handles all broadcast campaign related routes
+func CampaignMainHandler(log *logger.Logger, campaigns *campaignSvc.Service) http.Handler {
+	r := chi.NewRouter()
+
+	r.Post("/", campaignCreate(campaigns, log))
+
+	// extracts the pagination on the URL query parameters
+	r.With(m.URLQueryCtx).Get("/", campaignList(campaigns, log)) // GET /api/campaign?phone=&status=
+
+	r.Route("/{id}", func(r chi.Router) {
+		// extracts the id on the URL parameter
+		r.Use(m.MiddlewareIDCtx)
+
+		r.Get("/", campaignGet(campaigns, log))
+		r.With(m.URLQueryCtx).Get("/recipients", campaignRecipients(campaigns, log)) // GET ?status=
+		r.Post("/pause", campaignAction(campaigns.Pause, "campaign has been paused", log))
+		r.Post("/resume", campaignAction(campaigns.Resume, "campaign has been resumed", log))
+		r.Post("/cancel", campaignAction(campaigns.Cancel, "campaign has been cancelled", log))
+	})
+
+	return r
+}
+
+// campaignCreate processes the request to broadcast a message,
+// the recipients are given on the JSON body, or as a CSV file of a multipart request
+func campaignCreate(campaigns *campaignSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload campaignSvc.Payload
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType == "multipart/form-data" {
+			err := parseCampaignForm(w, r, &payload)
+			if err != nil {
+				log.Debug(httputils.ResponseText("", httputils.RequestJSONExtractionFailed), zap.Error(err))
+				httputils.RenderErrResponse(w, r, err.Error(), httputils.RequestJSONExtractionFailed,
+					http.StatusBadRequest, nil)
+				return
+			}
+		} else {
+			// extracts request body
+			eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+			if err != nil {
+				log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+				httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+				return
+			}
+		}
+
+		c, err := campaigns.Create(r.Context(), payload)
+		if err != nil {
+			renderCampaignError(w, r, log, httputils.CreateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        c,
+			MessageText: "campaign has been started",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// parseCampaignForm extracts the campaign of a multipart request, with the recipients of its CSV file
+func parseCampaignForm(w http.ResponseWriter, r *http.Request, payload *campaignSvc.Payload) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCampaignRequestSize)
+	err := r.ParseMultipartForm(multipartMemory)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	form := url.Values(r.MultipartForm.Value)
+	payload.From = form.Get("from")
+	payload.Name = form.Get("name")
+	payload.Type = form.Get("type")
+	payload.Template = form.Get("template")
+	payload.FileName = form.Get("file_name")
+
+	for field, value := range map[string]*int{
+		"interval_seconds": &payload.Throttle.IntervalSeconds,
+		"jitter_seconds":   &payload.Throttle.JitterSeconds,
+	} {
+		if form.Get(field) == "" {
+			continue
+		}
+		*value, err = strconv.Atoi(form.Get(field))
+		if err != nil {
+			return fmt.Errorf("%s must be a number", field)
+		}
+	}
+
+	file, _, err := r.FormFile(recipientsFormFile)
+	if errors.Is(err, http.ErrMissingFile) {
+		return fmt.Errorf("the `%s` CSV file is required", recipientsFormFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	payload.Recipients, err = campaignSvc.ParseCSV(file)
+
+	return err
+}
+
+// campaignList processes the request to list the campaigns with their counts
+func campaignList(campaigns *campaignSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		total, list, err := campaigns.GetCampaigns(r.Context(), r.URL.Query().Get("phone"),
+			r.URL.Query().Get("status"), params)
+		if err != nil {
+			renderCampaignError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        list,
+			MessageText: "fetch campaigns success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// campaignGet processes the request to fetch a campaign with its counts
+func campaignGet(campaigns *campaignSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		c, err := campaigns.GetCampaign(r.Context(), id)
+		if err != nil {
+			renderCampaignError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        c,
+			MessageText: "fetch campaign success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// campaignRecipients processes the request to list the recipients of a campaign with their results
+func campaignRecipients(campaigns *campaignSvc.Service,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		total, recipients, err := campaigns.GetRecipients(r.Context(), id, r.URL.Query().Get("status"), params)
+		if err != nil {
+			renderCampaignError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        recipients,
+			MessageText: "fetch campaign recipients success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// campaignAction processes the request to pause, resume or cancel a campaign
+func campaignAction(action func(ctx context.Context, id string) (campaignSvc.Campaign, error), msgText string,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		c, err := action(r.Context(), id)
+		if err != nil {
+			renderCampaignError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        c,
+			MessageText: msgText,
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// renderCampaignError maps the campaign errors into the HTTP status codes
+func renderCampaignError(w http.ResponseWriter, r *http.Request, log *logger.Logger, appCode int, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, campaignSvc.ErrCampaignNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, campaignSvc.ErrInvalidTransition):
+		status = http.StatusConflict
+	case !errors.Is(err, campaignSvc.ErrInvalidCampaign):
+		// the storage errors are not shown to the client
+		log.Error(httputils.ResponseText("", appCode), zap.Error(err))
+		httputils.RenderErrResponse(w, r, httputils.ResponseText("", appCode), int64(appCode),
+			http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Debug(httputils.ResponseText("", appCode), zap.Error(err))
+	httputils.RenderErrResponse(w, r, err.Error(), int64(appCode), status, nil)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/query"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/storage"
+)
+
+// ChatMainHandler handles all conversation history related routes
+func ChatMainHandler(db *storage.DataStoreMongo, log *logger.Logger) http.Handler {
+	r := chi.NewRouter()
+
+	// initializes services
+	chatService := chatSvc.NewService(db, log)
+
+	r.Route("/{phone}", func(r chi.Router) {
+		// extracts the phone on the URL parameter and the pagination on the URL query parameters
+		r.Use(m.PhoneMiddlewareCtx)
+		r.Use(m.URLQueryCtx)
+
+		r.Get("/", chatList(chatService, log)) // GET /api/chat/{phone} - list chats with their last message
+
+		r.Route("/{jid}", func(r chi.Router) {
+			// extracts the chat JID on the URL parameter
+			r.Use(m.ChatMiddlewareCtx)
+
+			r.Get("/messages", chatMessages(chatService, log)) // GET /api/chat/{phone}/{jid}/messages - history
+		})
+	})
+
+	return r
+}
+
+// chatList processes the request to list the conversations of the device
+func chatList(chatService *chatSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		total, chats, err := chatService.GetChats(r.Context(), phone, params)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.FailedToFetchData),
+				httputils.FailedToFetchData,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        chats,
+			MessageText: "fetch chats success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// chatMessages processes the request to list the messages of a conversation of the device
+func chatMessages(chatService *chatSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone and chat JID from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+		var chatKey m.Chat = m.ChatKey
+		chat := r.Context().Value(chatKey).(string)
+
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// validates the chat JID
+		_, err = chatSvc.ParseChatJID(chat)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.BadRequest,
+				http.StatusBadRequest, nil)
+			return
+		}
+
+		total, messages, err := chatService.GetMessages(r.Context(), phone, chat, params)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.FailedToFetchData),
+				httputils.FailedToFetchData,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        messages,
+			MessageText: "fetch messages success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// queryParams builds the query parameters captured by the URLQueryCtx middleware,
+// the filter is optional and only its keyword is used
+func queryParams(r *http.Request) (httputils.GetQueryParams, error) {
+	// extracts limit, offset, order, sort and filter from the context
+	var limitKey m.QueryLimit = m.QueryLimitKey
+	var offsetKey m.QueryOffset = m.QueryOffsetKey
+	var orderKey m.QueryOrder = m.QueryOrderKey
+	var sortKey m.QuerySort = m.QuerySortKey
+	var filterKey m.QueryFilter = m.QueryFilterKey
+	filter := r.Context().Value(filterKey).(string)
+
+	var filterParams query.FilterQueryParams
+	if filter != "" {
+		err := json.Unmarshal([]byte(filter), &filterParams)
+		if err != nil {
+			return httputils.GetQueryParams{}, err
+		}
+	}
+
+	return httputils.GetQueryParams{
+		Limit:  r.Context().Value(limitKey).(int64),
+		Offset: r.Context().Value(offsetKey).(int64),
+		Order:  r.Context().Value(orderKey).(string),
+		Sort:   r.Context().Value(sortKey).(string),
+		Search: filterParams.Keyword,
+	}, nil
+}
@@ -12,13 +12,17 @@ import (
 	"go.uber.org/zap"
 
 	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	autoReplySvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/autoreply"
 	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
 	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	webhookSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/webhook"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/storage"
 )
 
 // AuthMainHandler handles all device related routes
-func AuthMainHandler(db *storage.DataStoreMongo, log *logger.Logger) http.Handler {
+func AuthMainHandler(db *storage.DataStoreMongo, log *logger.Logger, webhooks *webhookSvc.Forwarder,
+	autoReplies *autoReplySvc.Service, msgQueue *messageSvc.Queue) http.Handler {
 	r := chi.NewRouter()
 
 	// Initialize services
@@ -43,11 +47,55 @@ func AuthMainHandler(db *storage.DataStoreMongo, log *logger.Logger) http.Handle
 			r.Put("/", deviceWebhook(deviceService, log))
 		})
 
+		r.Route("/webhook-secret/{id}", func(r chi.Router) {
+			// extracts the id on the URL parameter
+			r.Use(m.MiddlewareIDCtx)
+
+			r.Put("/", deviceWebhookSecretPut(deviceService, log))
+		})
+
+		r.Route("/rate-limit/{id}", func(r chi.Router) {
+			// extracts the id on the URL parameter
+			r.Use(m.MiddlewareIDCtx)
+
+			r.Put("/", deviceRateLimitPut(deviceService, msgQueue, log))
+		})
+
 		r.Route("/{id}", func(r chi.Router) {
 			// extracts the phone as id on the URL parameter
 			r.Use(m.MiddlewareIDCtx)
 
 			r.Get("/", getDeviceByPhone(deviceService, log))
+
+			// the webhook subscriptions are identified by the device ID
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", subscriptionList(webhooks, log))
+				r.Post("/", subscriptionCreate(webhooks, log))
+
+				r.Route("/{subscription}", func(r chi.Router) {
+					// extracts the subscription id on the URL parameter
+					r.Use(m.SubscriptionMiddlewareCtx)
+
+					r.Get("/", subscriptionGet(webhooks, log))
+					r.Put("/", subscriptionUpdate(webhooks, log))
+					r.Delete("/", subscriptionDelete(webhooks, log))
+				})
+			})
+
+			// the auto-reply rules are identified by the device ID
+			r.Route("/rules", func(r chi.Router) {
+				r.Get("/", ruleList(autoReplies, log))
+				r.Post("/", ruleCreate(autoReplies, log))
+
+				r.Route("/{rule}", func(r chi.Router) {
+					// extracts the rule id on the URL parameter
+					r.Use(m.RuleMiddlewareCtx)
+
+					r.Get("/", ruleGet(autoReplies, log))
+					r.Put("/", ruleUpdate(autoReplies, log))
+					r.Delete("/", ruleDelete(autoReplies, log))
+				})
+			})
 		})
 	})
 
@@ -269,3 +317,80 @@ func deviceWebhook(svc *deviceSvc.Service, log *logger.Logger) func(http.Respons
 		_ = httputils.RenderOKResponse(w, r, respBody)
 	}
 }
+
+// deviceWebhookSecretPut processes the request to rotate the secret which signs the webhook deliveries
+func deviceWebhookSecretPut(svc *deviceSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts userID from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceId := r.Context().Value(idKey).(string)
+
+		// rotates the secret now
+		secret, err := svc.RotateWebhookSecret(r.Context(), deviceId)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.UpdateDataFailed), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.UpdateDataFailed),
+				httputils.UpdateDataFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        map[string]string{"webhook_secret": secret},
+			MessageText: "webhook secret has been rotated",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// deviceRateLimitPut processes the request to update the rate limit override of the device
+// a `null` body removes the override, so that the device follows the global rate limit again
+func deviceRateLimitPut(svc *deviceSvc.Service, msgQueue *messageSvc.Queue,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reqPayload *deviceSvc.RateLimit
+
+		// extracts userID from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceId := r.Context().Value(idKey).(string)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &reqPayload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		// update rate limit now
+		err = svc.UpdateRateLimit(r.Context(), deviceId, reqPayload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.UpdateDataFailed), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.UpdateDataFailed),
+				httputils.UpdateDataFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// the next messages follow the updated rate limit
+		msgQueue.InvalidatePolicies()
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        reqPayload,
+			MessageText: "rate limit has been updated",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/config"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+const (
+	// streamKeepAliveInterval is the interval to keep the idle stream connection open
+	streamKeepAliveInterval = 30 * time.Second
+
+	// wsWriteTimeout is the maximum duration to write a single websocket frame
+	wsWriteTimeout = 10 * time.Second
+)
+
+// EventMainHandler handles all session event stream related routes
+func EventMainHandler(cfg *config.Config, log *logger.Logger, eventHub *eventSvc.Hub) http.Handler {
+	r := chi.NewRouter()
+
+	// accepts the websocket handshake from the allowed CORS origins only
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return isOriginAllowed(r.Header.Get("Origin"), cfg.CORSAllowOrigins)
+		},
+	}
+
+	r.Route("/", func(r chi.Router) {
+		r.Get("/", streamEvents(eventHub, upgrader, log)) // GET /api/events?phone={phone} - SSE or websocket
+	})
+
+	return r
+}
+
+// streamEvents processes the request to stream the session events over websocket or server-sent events
+func streamEvents(eventHub *eventSvc.Hub, upgrader websocket.Upgrader,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts the optional phone filter
+		phone := r.URL.Query().Get("phone")
+		if phone != "" {
+			plusSymbol := false
+			phone = common.SanitizePhone(phone, &plusSymbol)
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			streamWebsocket(eventHub, upgrader, log, phone, w, r)
+		} else {
+			streamSSE(eventHub, log, phone, w, r)
+		}
+	}
+}
+
+// streamSSE streams the session events as server-sent events
+func streamSSE(eventHub *eventSvc.Hub, log *logger.Logger, phone string, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httputils.RenderErrResponse(w, r,
+			"streaming is not supported",
+			httputils.BadRequest,
+			http.StatusInternalServerError, nil)
+		return
+	}
+
+	sub := eventHub.Subscribe(phone)
+	defer eventHub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case evt, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(evt.Body)
+			if err != nil {
+				log.Warn("failed to encode the session event", zap.Error(err))
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Body.EventType, b)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamWebsocket streams the session events as websocket text frames
+func streamWebsocket(eventHub *eventSvc.Hub, upgrader websocket.Upgrader, log *logger.Logger, phone string,
+	w http.ResponseWriter, r *http.Request) {
+	// the upgrader renders the error response by itself
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("failed to upgrade the websocket connection", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	sub := eventHub.Subscribe(phone)
+	defer eventHub.Unsubscribe(sub)
+
+	// reads (and discards) the incoming frames to detect when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				return
+			}
+		case evt, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(evt.Body)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// isOriginAllowed verifies if the origin is one of the allowed CORS origins
+func isOriginAllowed(origin string, allowedOrigins []string) bool {
+	// non-browser clients do not send any origin
+	if origin == "" {
+		return true
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
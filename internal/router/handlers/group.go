@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	groupSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/group"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// subjectPayload is the input JSON body captured from the group subject request
+type subjectPayload struct {
+	Subject string `json:"subject"`
+}
+
+// descriptionPayload is the input JSON body captured from the group description request
+type descriptionPayload struct {
+	Description string `json:"description"`
+}
+
+// joinPayload is the input JSON body captured from the join group request
+type joinPayload struct {
+	Code string `json:"code"`
+}
+
+// GroupMainHandler handles all group related routes
+func GroupMainHandler(log *logger.Logger, bcList *sessionSvc.Registry) http.Handler {
+	r := chi.NewRouter()
+
+	// initializes services
+	groupService := groupSvc.NewService(log, bcList)
+
+	r.Route("/{phone}", func(r chi.Router) {
+		// extracts the phone on the URL parameter
+		r.Use(m.PhoneMiddlewareCtx)
+
+		r.Get("/", groupList(groupService, log))      // GET /api/group/{phone} - list joined groups
+		r.Post("/", groupCreate(groupService, log))   // POST /api/group/{phone} - create a group
+		r.Post("/join", groupJoin(groupService, log)) // POST /api/group/{phone}/join - join via invite code
+
+		r.Route("/{group}", func(r chi.Router) {
+			// extracts the group JID on the URL parameter
+			r.Use(m.GroupMiddlewareCtx)
+
+			r.Get("/", groupInfo(groupService, log, false))
+			r.Get("/participants", groupInfo(groupService, log, true))
+			r.Post("/participants", groupParticipantsUpdate(groupService, log))
+			r.Put("/subject", groupSubjectPut(groupService, log))
+			r.Put("/description", groupDescriptionPut(groupService, log))
+			r.Put("/settings", groupSettingsPut(groupService, log))
+			r.Get("/invite-link", groupInviteLink(groupService, log, false))
+			r.Delete("/invite-link", groupInviteLink(groupService, log, true))
+			r.Post("/leave", groupLeave(groupService, log))
+		})
+	})
+
+	return r
+}
+
+// groupList processes the request to list the groups joined by the device
+func groupList(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		groups, err := groupService.ListJoined(phone)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        groups,
+			MessageText: "fetch groups success",
+			Total:       int64(len(groups)),
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupCreate processes the request to create a new group
+func groupCreate(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload groupSvc.CreatePayload
+
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		group, err := groupService.Create(phone, payload)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.CreateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        group,
+			MessageText: "group has been created",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupJoin processes the request to join a group with an invite code
+func groupJoin(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload joinPayload
+
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		group, err := groupService.Join(phone, payload.Code)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        group,
+			MessageText: "group has been joined",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupInfo processes the request to fetch the info of a group, or its participants only
+func groupInfo(groupService *groupSvc.Service, log *logger.Logger,
+	participantsOnly bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, group := groupURLParams(r)
+
+		info, err := groupService.GetInfo(phone, group)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        info,
+			MessageText: "fetch group success",
+			Total:       1,
+		}
+		if participantsOnly {
+			respBody.Data = info.Participants
+			respBody.MessageText = "fetch group participants success"
+			respBody.Total = int64(len(info.Participants))
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupParticipantsUpdate processes the request to add, remove, promote or demote participants of a group
+func groupParticipantsUpdate(groupService *groupSvc.Service,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload groupSvc.ParticipantsPayload
+		phone, group := groupURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		results, err := groupService.UpdateParticipants(phone, group, payload)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        results,
+			MessageText: "group participants have been updated",
+			Total:       int64(len(results)),
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupSubjectPut processes the request to change the subject of a group
+func groupSubjectPut(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload subjectPayload
+		phone, group := groupURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		err = groupService.SetSubject(phone, group, payload.Subject)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		renderGroupUpdated(w, r, "group subject has been updated")
+	}
+}
+
+// groupDescriptionPut processes the request to change the description of a group
+func groupDescriptionPut(groupService *groupSvc.Service,
+	log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload descriptionPayload
+		phone, group := groupURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		err = groupService.SetDescription(phone, group, payload.Description)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		renderGroupUpdated(w, r, "group description has been updated")
+	}
+}
+
+// groupSettingsPut processes the request to change the settings of a group
+func groupSettingsPut(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload groupSvc.SettingsPayload
+		phone, group := groupURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		err = groupService.UpdateSettings(phone, group, payload)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		renderGroupUpdated(w, r, "group settings have been updated")
+	}
+}
+
+// groupInviteLink processes the request to get the invite link of a group, or to revoke it for a new one
+func groupInviteLink(groupService *groupSvc.Service, log *logger.Logger,
+	revoke bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, group := groupURLParams(r)
+
+		link, err := groupService.GetInviteLink(phone, group, revoke)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        link,
+			MessageText: "fetch invite link success",
+			Total:       1,
+		}
+		if revoke {
+			respBody.MessageText = "invite link has been revoked"
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// groupLeave processes the request to leave a group
+func groupLeave(groupService *groupSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		phone, group := groupURLParams(r)
+
+		err := groupService.Leave(phone, group)
+		if err != nil {
+			renderGroupError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		renderGroupUpdated(w, r, "group has been left")
+	}
+}
+
+// groupURLParams extracts the phone and the group JID from the context
+func groupURLParams(r *http.Request) (string, string) {
+	var phoneKey m.Phone = m.PhoneKey
+	var groupKey m.Group = m.GroupKey
+
+	return r.Context().Value(phoneKey).(string), r.Context().Value(groupKey).(string)
+}
+
+// renderGroupUpdated renders the response of a successful group update
+func renderGroupUpdated(w http.ResponseWriter, r *http.Request, msgText string) {
+	// prepares response body
+	respBody := httputils.Response{
+		Success:     true,
+		Data:        nil,
+		MessageText: msgText,
+		Total:       1,
+	}
+
+	// renders OK response
+	_ = httputils.RenderOKResponse(w, r, respBody)
+}
+
+// renderGroupError renders the error of a failed group request
+func renderGroupError(w http.ResponseWriter, r *http.Request, log *logger.Logger, appCode int, err error) {
+	log.Debug(httputils.ResponseText("", appCode), zap.Error(err))
+
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, sessionSvc.ErrSessionNotFound), errors.Is(err, groupSvc.ErrGroupNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, sessionSvc.ErrSessionNotReady):
+		status = http.StatusConflict
+	case errors.Is(err, groupSvc.ErrNotInGroup):
+		status = http.StatusForbidden
+	}
+
+	httputils.RenderErrResponse(w, r, err.Error(), int64(appCode), status, nil)
+}
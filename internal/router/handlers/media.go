@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	mediaSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/media"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// MediaMainHandler handles all inbound media related routes
+func MediaMainHandler(log *logger.Logger, mediaService *mediaSvc.Service) http.Handler {
+	r := chi.NewRouter()
+
+	r.Route("/{phone}/{id}", func(r chi.Router) {
+		// extracts the phone and the message ID on the URL parameters
+		r.Use(m.PhoneMiddlewareCtx)
+		r.Use(m.MiddlewareIDCtx)
+
+		r.Get("/", mediaGet(mediaService, log)) // GET /api/media/{phone}/{id} - download the media of a message
+	})
+
+	return r
+}
+
+// mediaGet processes the request to download the media of a message received by the device
+func mediaGet(mediaService *mediaSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone and message ID from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+		var idKey m.ID = m.IDKey
+		messageID := r.Context().Value(idKey).(string)
+
+		file, err := mediaService.Get(r.Context(), phone, messageID)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, chatSvc.ErrMessageNotFound), errors.Is(err, mediaSvc.ErrMediaNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, mediaSvc.ErrMediaExpired):
+				status = http.StatusGone
+			case errors.Is(err, sessionSvc.ErrSessionNotFound), errors.Is(err, sessionSvc.ErrSessionNotReady):
+				status = http.StatusConflict
+			}
+
+			httputils.RenderErrResponse(w, r, err.Error(), httputils.FailedToFetchData, status, nil)
+			return
+		}
+
+		contentType := file.MimeType
+		if contentType == "" {
+			contentType = http.DetectContentType(file.Data)
+		}
+
+		// writes the media as is, instead of the JSON response,
+		// the type is set by the sender, hence it is never sniffed and only the images, audios and videos are inline
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Length", strconv.Itoa(len(file.Data)))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition(contentType),
+			map[string]string{"filename": file.FileName}))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(file.Data)
+	}
+}
+
+// disposition returns inline for the images, the audios and the videos, any other media is downloaded as an attachment,
+// e.g. the SVG images which may run scripts
+func disposition(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return "attachment"
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"):
+		return "inline"
+	default:
+		return "attachment"
+	}
+}
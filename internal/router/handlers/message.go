@@ -1,9 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ardihikaru/go-modules/pkg/logger"
 	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
@@ -12,47 +21,194 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/config"
-	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
 	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/storage"
 )
 
+const (
+	// idempotencyKeyHeader is the request header carrying the client supplied idempotency key
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader is set on the response when the message has been queued by an earlier request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the maximum length of the idempotency key
+	maxIdempotencyKeyLength = 255
+
+	// mediaFormFile is the multipart form field of the uploaded media
+	mediaFormFile = "file"
+
+	// maxMediaRequestOverhead is the room left for the other fields of a media request
+	maxMediaRequestOverhead = 1 << 20
+
+	// multipartMemory is the part of a multipart request kept in memory, the rest is stored in temporary files
+	multipartMemory = 8 << 20
+)
+
+// sendResult is the response body of a submitted message
+// the whatsapp message ID and timestamp are only known when the request waits for the message to be sent
+type sendResult struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	WaMessageID string     `json:"wa_message_id,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+}
+
+// mediaRequest is the parsed body of a media message request
+type mediaRequest struct {
+	// form holds the values of a multipart request, it is nil on a JSON request
+	form url.Values
+
+	// source is the media content given on the request, if any
+	source messageSvc.MediaSource
+
+	// msgCtx is the optional quote and mentions given on the request
+	msgCtx messageSvc.MessageContext
+
+	// close releases the uploaded file
+	close func()
+}
+
+// sendOptions are the options of a send request, given on its URL query and headers
+type sendOptions struct {
+	// wait makes the request wait for the message to be sent
+	wait bool
+
+	// idempotencyKey is the optional idempotency key supplied by the client
+	idempotencyKey string
+}
+
 // MessageMainHandler handles all whatsapp message related routes
 func MessageMainHandler(cfg *config.Config, db *storage.DataStoreMongo, log *logger.Logger,
-	whatsAppBot *botHook.WaManager, httpClient *http.Client, bcList *botHook.BotClientList) http.Handler {
+	httpClient *http.Client, bcList *sessionSvc.Registry, msgQueue *messageSvc.Queue) http.Handler {
 	r := chi.NewRouter()
 
 	// initializes services
-	deviceService := deviceSvc.NewService(db, log)
-	sessionService := sessionSvc.NewService(deviceService, log, whatsAppBot, httpClient,
-		cfg.WhatsappImageDir, cfg.WhatsappQrCodeDir, cfg.WhatsappWebhookEcho, cfg.WhatsappWebhookEnabled,
-		cfg.WhatsappQrToTerminal, bcList)
+	messageService := messageSvc.NewService(db, log, bcList, msgQueue, httpClient, messageSvc.ServiceConfig{
+		IdempotencyTTL: cfg.MsgIdempotencyKeyTTL,
+		MaxMediaSize:   cfg.MsgMediaMaxSize,
+	})
 
 	r.Route("/", func(r chi.Router) {
-		r.Post("/text", postMessage(sessionService, log))
-		r.Post("/image", postImageMessage(sessionService, log))
+		r.Post("/text", postMessage(messageService, log, cfg.MsgSendWaitTimeout))
+		r.Post("/image", postImageMessage(messageService, log, cfg.MsgSendWaitTimeout, cfg.MsgMediaMaxSize))
+		r.Post("/video", postMediaMessage(messageService, log, cfg.MsgSendWaitTimeout, cfg.MsgMediaMaxSize,
+			messageSvc.TypeVideo))
+		r.Post("/audio", postMediaMessage(messageService, log, cfg.MsgSendWaitTimeout, cfg.MsgMediaMaxSize,
+			messageSvc.TypeAudio))
+		r.Post("/document", postMediaMessage(messageService, log, cfg.MsgSendWaitTimeout, cfg.MsgMediaMaxSize,
+			messageSvc.TypeDocument))
+		r.Post("/location", postLocationMessage(messageService, log, cfg.MsgSendWaitTimeout))
+		r.Post("/contact", postContactMessage(messageService, log, cfg.MsgSendWaitTimeout))
+		r.Post("/reaction", postActionMessage(messageService, log, cfg.MsgSendWaitTimeout, messageSvc.TypeReaction))
+		r.Post("/edit", postActionMessage(messageService, log, cfg.MsgSendWaitTimeout, messageSvc.TypeEdit))
+		r.Post("/revoke", postActionMessage(messageService, log, cfg.MsgSendWaitTimeout, messageSvc.TypeRevoke))
+
+		r.Route("/{id}", func(r chi.Router) {
+			// extracts the id on the URL parameter
+			r.Use(m.MiddlewareIDCtx)
+
+			r.Get("/", getMessageByID(messageService, log))
+		})
 	})
 
 	return r
 }
 
 // postMessage processes the request to send a whatsapp message
-func postMessage(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+func postMessage(messageService *messageSvc.Service, log *logger.Logger,
+	waitTimeout time.Duration) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload botHook.MessagePayload
 
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
+			return
+		}
+
+		// extracts JSON body from the request, along with the optional quote and mentions
+		var msgCtx messageSvc.MessageContext
+		if !decodeJSONBody(w, r, log, &payload, &msgCtx) {
+			return
+		}
+
+		// queues new message
+		msg, replayed, err := messageService.SendTextMessage(r.Context(), payload, msgCtx, opts.idempotencyKey)
+		if err != nil {
+			renderSendError(w, r, log, err)
+			return
+		}
+
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, "message has been")
+	}
+}
+
+// postImageMessage processes the request to send a whatsapp image-based message
+// the image is given as a JSON body, with an existing file name, base64 content or URL, or as a multipart upload
+func postImageMessage(messageService *messageSvc.Service, log *logger.Logger, waitTimeout time.Duration,
+	maxMediaSize int64) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload botHook.MessagePayload
+
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
+			return
+		}
+
 		// extracts request body
-		b, err := io.ReadAll(r.Body)
+		req, err := parseMediaRequest(w, r, maxMediaSize, &payload)
 		if err != nil {
 			log.Debug(httputils.ResponseText("", httputils.InvalidRequestJSON), zap.Error(err))
-			httputils.RenderErrResponse(w, r, httputils.ResponseText("", httputils.InvalidRequestJSON),
-				httputils.InvalidRequestJSON, http.StatusBadRequest, err)
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.InvalidRequestJSON),
+				httputils.InvalidRequestJSON,
+				http.StatusBadRequest, err)
 			return
 		}
-		defer r.Body.Close()
+		defer req.close()
+		if req.form != nil {
+			payload = botHook.MessagePayload{
+				From:          req.form.Get("from"),
+				To:            req.form.Get("to"),
+				ImageFileName: req.form.Get("image_filename"),
+				ImageCaption:  req.form.Get("image_caption"),
+			}
+			if payload.ImageCaption == "" {
+				payload.ImageCaption = req.form.Get("caption")
+			}
+		}
 
-		// read JSON body from the request
-		err = json.Unmarshal(b, &payload)
+		// queues new message
+		msg, replayed, err := messageService.SendImageMessage(r.Context(), payload, req.msgCtx, req.source,
+			opts.idempotencyKey)
+		if err != nil {
+			renderSendError(w, r, log, err)
+			return
+		}
+
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, "image message has been")
+	}
+}
+
+// postMediaMessage processes the request to send a whatsapp video, audio or document message
+// the media is given as a JSON body, with an existing file name, base64 content or URL, or as a multipart upload
+func postMediaMessage(messageService *messageSvc.Service, log *logger.Logger, waitTimeout time.Duration,
+	maxMediaSize int64, msgType string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload messageSvc.MediaPayload
+
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
+			return
+		}
+
+		// extracts request body
+		req, err := parseMediaRequest(w, r, maxMediaSize, &payload)
 		if err != nil {
 			log.Debug(httputils.ResponseText("", httputils.InvalidRequestJSON), zap.Error(err))
 			httputils.RenderErrResponse(w, r,
@@ -61,73 +217,343 @@ func postMessage(sessionService *sessionSvc.Service, log *logger.Logger) func(ht
 				http.StatusBadRequest, err)
 			return
 		}
+		defer req.close()
+		if req.form != nil {
+			payload = messageSvc.MediaPayload{
+				From:         req.form.Get("from"),
+				To:           req.form.Get("to"),
+				FileName:     req.form.Get("file_name"),
+				Caption:      req.form.Get("caption"),
+				MimeType:     req.form.Get("mimetype"),
+				DocumentName: req.form.Get("document_name"),
+			}
+			payload.MessageContext = req.msgCtx
+			payload.PTT, _ = strconv.ParseBool(req.form.Get("ptt"))
+		}
 
-		// submits new message
-		err = sessionService.SendTextMessage(payload)
+		// queues new message
+		msg, replayed, err := messageService.SendMediaMessage(r.Context(), msgType, payload, req.source,
+			opts.idempotencyKey)
 		if err != nil {
-			log.Debug(httputils.ResponseText("", httputils.CreateDataFailed), zap.Error(err))
-			httputils.RenderErrResponse(w, r,
-				err.Error(),
-				httputils.CreateDataFailed,
-				http.StatusBadRequest, nil)
+			renderSendError(w, r, log, err)
 			return
 		}
 
-		// prepares response body
-		respBody := httputils.Response{
-			Success:     true,
-			Data:        nil,
-			MessageText: "message has been sent",
-			Total:       1,
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, msgType+" message has been")
+	}
+}
+
+// postLocationMessage processes the request to send a whatsapp location message
+func postLocationMessage(messageService *messageSvc.Service, log *logger.Logger,
+	waitTimeout time.Duration) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload messageSvc.LocationPayload
+
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
+			return
 		}
 
-		// renders OK response
-		_ = httputils.RenderOKResponse(w, r, respBody)
+		// extracts JSON body from the request
+		if !decodeJSONBody(w, r, log, &payload) {
+			return
+		}
+
+		// queues new message
+		msg, replayed, err := messageService.SendLocationMessage(r.Context(), payload, opts.idempotencyKey)
+		if err != nil {
+			renderSendError(w, r, log, err)
+			return
+		}
+
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, "location message has been")
 	}
 }
 
-// postImageMessage processes the request to send a whatsapp image-based message
-func postImageMessage(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+// postContactMessage processes the request to send a whatsapp contact message
+func postContactMessage(messageService *messageSvc.Service, log *logger.Logger,
+	waitTimeout time.Duration) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var payload botHook.MessagePayload
+		var payload messageSvc.ContactPayload
 
-		// extracts request body
-		b, err := io.ReadAll(r.Body)
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
+			return
+		}
+
+		// extracts JSON body from the request
+		if !decodeJSONBody(w, r, log, &payload) {
+			return
+		}
+
+		// queues new message
+		msg, replayed, err := messageService.SendContactMessage(r.Context(), payload, opts.idempotencyKey)
 		if err != nil {
-			log.Debug(httputils.ResponseText("", httputils.InvalidRequestJSON), zap.Error(err))
-			httputils.RenderErrResponse(w, r, httputils.ResponseText("", httputils.InvalidRequestJSON),
-				httputils.InvalidRequestJSON, http.StatusBadRequest, err)
+			renderSendError(w, r, log, err)
+			return
+		}
+
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, "contact message has been")
+	}
+}
+
+// postActionMessage processes the request to react to, edit or revoke an existing whatsapp message
+func postActionMessage(messageService *messageSvc.Service, log *logger.Logger, waitTimeout time.Duration,
+	msgType string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload messageSvc.ActionPayload
+
+		// extracts the optional synchronous mode and idempotency key
+		opts, ok := parseSendOptions(w, r)
+		if !ok {
 			return
 		}
+
+		// extracts JSON body from the request
+		if !decodeJSONBody(w, r, log, &payload) {
+			return
+		}
+
+		// queues new message
+		msg, replayed, err := messageService.SendActionMessage(r.Context(), msgType, payload, opts.idempotencyKey)
+		if err != nil {
+			renderSendError(w, r, log, err)
+			return
+		}
+
+		renderSendResult(w, r, messageService, log, msg, replayed, opts, waitTimeout, msgType+" message has been")
+	}
+}
+
+// parseMediaRequest parses a multipart form, or a JSON body into payload.
+// The body is limited according to the maximum media size
+func parseMediaRequest(w http.ResponseWriter, r *http.Request, maxMediaSize int64,
+	payload interface{}) (mediaRequest, error) {
+	req := mediaRequest{close: func() {}}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "multipart/form-data" {
+		// base64 content is a third larger than the decoded media
+		r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize/3*4+maxMediaRequestOverhead)
 		defer r.Body.Close()
 
-		// read JSON body from the request
-		err = json.Unmarshal(b, &payload)
+		b, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Debug(httputils.ResponseText("", httputils.InvalidRequestJSON), zap.Error(err))
-			httputils.RenderErrResponse(w, r,
-				httputils.ResponseText("", httputils.InvalidRequestJSON),
-				httputils.InvalidRequestJSON,
+			return req, err
+		}
+
+		err = json.Unmarshal(b, payload)
+		if err != nil {
+			return req, err
+		}
+
+		err = json.Unmarshal(b, &req.msgCtx)
+		if err != nil {
+			return req, err
+		}
+
+		return req, json.Unmarshal(b, &req.source)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize+maxMediaRequestOverhead)
+	err := r.ParseMultipartForm(multipartMemory)
+	if err != nil {
+		return req, err
+	}
+	req.form = r.MultipartForm.Value
+	req.msgCtx = formMessageContext(req.form)
+	req.close = func() { _ = r.MultipartForm.RemoveAll() }
+
+	file, header, err := r.FormFile(mediaFormFile)
+	if errors.Is(err, http.ErrMissingFile) {
+		return req, nil
+	}
+	if err != nil {
+		req.close()
+		return req, err
+	}
+
+	req.source.Upload = file
+	req.source.UploadName = header.Filename
+	req.close = func() {
+		_ = file.Close()
+		_ = r.MultipartForm.RemoveAll()
+	}
+
+	return req, nil
+}
+
+// formMessageContext extracts the optional quote and mentions of a multipart request,
+// the mentions are given either as repeated fields or as a comma separated list
+func formMessageContext(form url.Values) messageSvc.MessageContext {
+	var msgCtx messageSvc.MessageContext
+
+	if id := form.Get("reply_to_message_id"); id != "" {
+		msgCtx.ReplyTo = &messageSvc.ReplyTo{
+			MessageID: id,
+			Sender:    form.Get("reply_to_sender"),
+			Text:      form.Get("reply_to_text"),
+		}
+		msgCtx.ReplyTo.FromMe, _ = strconv.ParseBool(form.Get("reply_to_from_me"))
+	}
+
+	for _, value := range form["mentions"] {
+		for _, mention := range strings.Split(value, ",") {
+			if mention = strings.TrimSpace(mention); mention != "" {
+				msgCtx.Mentions = append(msgCtx.Mentions, mention)
+			}
+		}
+	}
+
+	return msgCtx
+}
+
+// renderSendError renders the error of a rejected message
+func renderSendError(w http.ResponseWriter, r *http.Request, log *logger.Logger, err error) {
+	log.Debug(httputils.ResponseText("", httputils.CreateDataFailed), zap.Error(err))
+
+	status := http.StatusBadRequest
+	var rateLimitErr *messageSvc.RateLimitError
+	switch {
+	case errors.Is(err, messageSvc.ErrIdempotencyKeyInProgress):
+		status = http.StatusConflict
+	case errors.Is(err, messageSvc.ErrMediaTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, messageSvc.ErrMediaTypeMismatch):
+		status = http.StatusUnsupportedMediaType
+	case errors.As(err, &rateLimitErr):
+		status = http.StatusTooManyRequests
+		retryAfter := int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	httputils.RenderErrResponse(w, r,
+		err.Error(),
+		httputils.CreateDataFailed,
+		status, nil)
+}
+
+// parseSendOptions extracts the `wait` URL query and the idempotency key header of a send request,
+// the message is sent asynchronously by default. The error response is rendered on an invalid request
+func parseSendOptions(w http.ResponseWriter, r *http.Request) (sendOptions, bool) {
+	var opts sendOptions
+
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		var err error
+		opts.wait, err = strconv.ParseBool(wait)
+		if err != nil {
+			httputils.RenderErrResponse(w, r, "invalid wait parameter", httputils.BadRequest,
 				http.StatusBadRequest, err)
+			return sendOptions{}, false
+		}
+	}
+
+	opts.idempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	if len(opts.idempotencyKey) > maxIdempotencyKeyLength {
+		httputils.RenderErrResponse(w, r,
+			fmt.Sprintf("%s must not exceed %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength),
+			httputils.BadRequest, http.StatusBadRequest, nil)
+		return sendOptions{}, false
+	}
+
+	return opts, true
+}
+
+// decodeJSONBody reads the JSON body of the request into each of the payloads.
+// The error response is rendered on an invalid body
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, log *logger.Logger, payloads ...interface{}) bool {
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	for i := 0; err == nil && i < len(payloads); i++ {
+		err = json.Unmarshal(b, payloads[i])
+	}
+	if err != nil {
+		log.Debug(httputils.ResponseText("", httputils.InvalidRequestJSON), zap.Error(err))
+		httputils.RenderErrResponse(w, r,
+			httputils.ResponseText("", httputils.InvalidRequestJSON),
+			httputils.InvalidRequestJSON,
+			http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
+}
+
+// renderSendResult renders the queued message, or the sent message if the request waits for it,
+// a message queued by an earlier request with the same idempotency key is flagged as replayed
+func renderSendResult(w http.ResponseWriter, r *http.Request, messageService *messageSvc.Service,
+	log *logger.Logger, msg messageSvc.OutboundMessage, replayed bool, opts sendOptions, waitTimeout time.Duration,
+	msgPrefix string) {
+	if replayed {
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
+	msgText := msgPrefix + " queued"
+
+	if opts.wait {
+		ctx, cancel := context.WithTimeout(r.Context(), waitTimeout)
+		defer cancel()
+
+		var err error
+		msg, err = messageService.WaitForMessage(ctx, msg)
+		switch {
+		case errors.Is(err, messageSvc.ErrWaitTimeout):
+			// the message stays in the queue, its status can be fetched later on
+			log.Debug(fmt.Sprintf("message [%s] has not been sent within %s", msg.ID, waitTimeout))
+			msgText = fmt.Sprintf("%s queued, it has not been sent within %s", msgPrefix, waitTimeout)
+		case msg.Status == messageSvc.StatusFailed:
+			httputils.RenderErrResponse(w, r,
+				fmt.Sprintf("failed to send message [%s]", msg.ID),
+				httputils.CreateDataFailed,
+				http.StatusBadGateway, errors.New(msg.LastError))
 			return
+		default:
+			msgText = msgPrefix + " sent"
 		}
+	}
+
+	// prepares response body
+	respBody := httputils.Response{
+		Success: true,
+		Data: sendResult{
+			ID:          msg.ID,
+			Status:      msg.Status,
+			WaMessageID: msg.WaMessageID,
+			Timestamp:   msg.SentAt,
+		},
+		MessageText: msgText,
+		Total:       1,
+	}
 
-		// submits new message
-		err = sessionService.SendImageMessage(payload)
+	// renders OK response
+	_ = httputils.RenderOKResponse(w, r, respBody)
+}
+
+// getMessageByID processes the request to get the delivery status of an outbound message
+func getMessageByID(messageService *messageSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		// gets message document
+		msg, err := messageService.GetMessage(r.Context(), id)
 		if err != nil {
-			log.Debug(httputils.ResponseText("", httputils.CreateDataFailed), zap.Error(err))
+			log.Debug("message not found", zap.Error(err))
 			httputils.RenderErrResponse(w, r,
-				err.Error(),
-				httputils.CreateDataFailed,
-				http.StatusBadRequest, nil)
+				"message not found",
+				httputils.FailedToFetchData,
+				http.StatusNotFound, nil)
 			return
 		}
 
 		// prepares response body
 		respBody := httputils.Response{
 			Success:     true,
-			Data:        nil,
-			MessageText: "image message has been sent",
+			Data:        msg,
+			MessageText: "fetch success",
 			Total:       1,
 		}
 
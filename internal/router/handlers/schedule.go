@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	scheduleSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/schedule"
+)
+
+// ScheduleMainHandler handles all scheduled message related routes
+func ScheduleMainHandler(log *logger.Logger, schedules *scheduleSvc.Service) http.Handler {
+	r := chi.NewRouter()
+
+	r.Post("/", scheduleCreate(schedules, log))
+
+	// extracts the pagination on the URL query parameters
+	r.With(m.URLQueryCtx).Get("/", scheduleList(schedules, log)) // GET /api/schedule?phone=&status=
+
+	r.Route("/{id}", func(r chi.Router) {
+		// extracts the id on the URL parameter
+		r.Use(m.MiddlewareIDCtx)
+
+		r.Get("/", scheduleGet(schedules, log))
+		r.Delete("/", scheduleCancel(schedules, log))
+	})
+
+	return r
+}
+
+// scheduleCreate processes the request to send a message later on, once or on a recurrence
+func scheduleCreate(schedules *scheduleSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload scheduleSvc.Payload
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		msg, err := schedules.Create(r.Context(), payload)
+		if err != nil {
+			renderScheduleError(w, r, log, httputils.CreateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        msg,
+			MessageText: "message has been scheduled",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// scheduleList processes the request to list the scheduled messages
+func scheduleList(schedules *scheduleSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		total, msgs, err := schedules.GetScheduledMessages(r.Context(), r.URL.Query().Get("phone"),
+			r.URL.Query().Get("status"), params)
+		if err != nil {
+			renderScheduleError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        msgs,
+			MessageText: "fetch scheduled messages success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// scheduleGet processes the request to fetch a scheduled message
+func scheduleGet(schedules *scheduleSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		msg, err := schedules.GetScheduledMessage(r.Context(), id)
+		if err != nil {
+			renderScheduleError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        msg,
+			MessageText: "fetch scheduled message success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// scheduleCancel processes the request to cancel a pending scheduled message
+func scheduleCancel(schedules *scheduleSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		msg, err := schedules.Cancel(r.Context(), id)
+		if err != nil {
+			renderScheduleError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        msg,
+			MessageText: "scheduled message has been cancelled",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// renderScheduleError maps the scheduled message errors into the HTTP status codes
+func renderScheduleError(w http.ResponseWriter, r *http.Request, log *logger.Logger, appCode int, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, scheduleSvc.ErrScheduleNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, scheduleSvc.ErrNotCancellable):
+		status = http.StatusConflict
+	case !errors.Is(err, scheduleSvc.ErrInvalidSchedule):
+		// the storage errors are not shown to the client
+		log.Error(httputils.ResponseText("", appCode), zap.Error(err))
+		httputils.RenderErrResponse(w, r, httputils.ResponseText("", appCode), int64(appCode),
+			http.StatusInternalServerError, nil)
+		return
+	}
+
+	log.Debug(httputils.ResponseText("", appCode), zap.Error(err))
+	httputils.RenderErrResponse(w, r, err.Error(), int64(appCode), status, nil)
+}
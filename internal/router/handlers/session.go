@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/ardihikaru/go-modules/pkg/logger"
 	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
@@ -11,21 +15,32 @@ import (
 
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/config"
 	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
 	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
 	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
 	"github.com/ardihikaru/go-whatsapp-multi-device/internal/storage"
 )
 
 // SessionMainHandler handles all session related routes
 func SessionMainHandler(cfg *config.Config, db *storage.DataStoreMongo, log *logger.Logger,
-	whatsAppBot *botHook.WaManager, httpClient *http.Client, bcList *botHook.BotClientList) http.Handler {
+	whatsAppBot *botHook.WaManager, httpClient *http.Client, bcList *sessionSvc.Registry,
+	eventHub *eventSvc.Hub, chats *chatSvc.Service) http.Handler {
 	r := chi.NewRouter()
 
 	// initializes services
 	deviceService := deviceSvc.NewService(db, log)
 	sessionService := sessionSvc.NewService(deviceService, log, whatsAppBot, httpClient,
 		cfg.WhatsappImageDir, cfg.WhatsappQrCodeDir, cfg.WhatsappWebhookEcho, cfg.WhatsappWebhookEnabled,
-		cfg.WhatsappQrToTerminal, bcList)
+		cfg.WhatsappQrToTerminal, bcList, eventHub, chats)
+
+	checker := sessionSvc.NewChecker(db, log, bcList, sessionSvc.CheckerConfig{
+		BatchSize:  cfg.OnWhatsappBatchSize,
+		CacheTTL:   cfg.OnWhatsappCacheTTL,
+		MaxNumbers: cfg.OnWhatsappMaxNumbers,
+		Policy:     sessionSvc.CheckerPolicy(cfg.OnWhatsappPolicy),
+		Phone:      cfg.OnWhatsappPhone,
+	})
 
 	// initializes middleware resources
 	waM := m.Resource{
@@ -35,6 +50,24 @@ func SessionMainHandler(cfg *config.Config, db *storage.DataStoreMongo, log *log
 
 	r.Route("/", func(r chi.Router) {
 
+		r.Get("/", sessionList(sessionService, log)) // GET /api/session - list all sessions
+
+		r.Post("/on-whatsapp", bulkOnWhatsapp(checker, log)) // POST /api/session/on-whatsapp - are phones on WA?
+
+		r.Route("/status/{phone}", func(r chi.Router) {
+			// extracts the phone on the URL parameter
+			r.Use(m.PhoneMiddlewareCtx)
+
+			r.Get("/", sessionStatus(sessionService, log)) // GET /api/session/status/{phone} - session status
+		})
+
+		r.Route("/qr/{phone}", func(r chi.Router) {
+			// extracts the phone on the URL parameter
+			r.Use(m.PhoneMiddlewareCtx)
+
+			r.Get("/", sessionQRCode(sessionService, log)) // GET /api/session/qr/{phone} - current QR Code
+		})
+
 		r.Route("/{phone}", func(r chi.Router) {
 			// extracts the phone on the URL parameter
 			r.Use(waM.WhatsappCtx)
@@ -53,7 +86,7 @@ func SessionMainHandler(cfg *config.Config, db *storage.DataStoreMongo, log *log
 			// extracts the phone on the URL parameter
 			r.Use(m.PhoneMiddlewareCtx)
 
-			r.Get("/", isOnWhatsapp(sessionService, log)) // GET /api/session/on-whatsapp/{phone} - is on WA?
+			r.Get("/", isOnWhatsapp(checker, log)) // GET /api/session/on-whatsapp/{phone} - is on WA?
 		})
 	})
 
@@ -67,8 +100,24 @@ func sessionConnect(sessionService *sessionSvc.Service, log *logger.Logger) func
 		var phoneKey m.Phone = m.PhoneKey
 		phone := r.Context().Value(phoneKey).(string)
 
+		// extracts the login mode, QR Code by default
+		mode, err := sessionSvc.ValidateLoginMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.BadRequest,
+				http.StatusBadRequest, nil)
+			return
+		}
+
+		// links the device with a pairing code
+		if mode == sessionSvc.LoginModeCode {
+			sessionPairingCode(sessionService, log, phone, w, r)
+			return
+		}
+
 		// creates new whatsapp session
-		err := sessionService.New(r.Context(), phone)
+		err = sessionService.New(r.Context(), phone)
 		if err != nil {
 			log.Debug(httputils.ResponseText("", httputils.UpdateDataFailed), zap.Error(err))
 			httputils.RenderErrResponse(w, r,
@@ -91,6 +140,158 @@ func sessionConnect(sessionService *sessionSvc.Service, log *logger.Logger) func
 	}
 }
 
+// sessionPairingCode creates new whatsapp session and responds with the pairing code
+func sessionPairingCode(sessionService *sessionSvc.Service, log *logger.Logger, phone string,
+	w http.ResponseWriter, r *http.Request) {
+	code, err := sessionService.NewWithPairingCode(r.Context(), phone)
+	if err != nil {
+		log.Debug(httputils.ResponseText("", httputils.UpdateDataFailed), zap.Error(err))
+
+		httputils.RenderErrResponse(w, r,
+			httputils.ResponseText("", httputils.UpdateDataFailed),
+			httputils.UpdateDataFailed,
+			http.StatusBadRequest, err)
+		return
+	}
+
+	// prepares response body
+	respBody := httputils.Response{
+		Success:     true,
+		Data:        code,
+		MessageText: "enter the pairing code on the phone to link the device",
+		Total:       1,
+	}
+
+	// renders OK response
+	_ = httputils.RenderOKResponse(w, r, respBody)
+}
+
+// sessionList processes the request to list the status of all whatsapp sessions
+func sessionList(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// lists all registered sessions
+		statuses := sessionService.GetStatuses()
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        statuses,
+			MessageText: "fetch sessions success",
+			Total:       int64(len(statuses)),
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// sessionStatus processes the request to get the status of a whatsapp session
+func sessionStatus(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		// gets the session status
+		status, err := sessionService.GetStatus(phone)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.FailedToFetchData,
+				http.StatusNotFound, nil)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        status,
+			MessageText: "fetch success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// sessionQRCode processes the request to get the current QR Code of a pending whatsapp session
+func sessionQRCode(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts phone from the context and cast them into a string
+		var phoneKey m.Phone = m.PhoneKey
+		phone := r.Context().Value(phoneKey).(string)
+
+		// extracts the designated format, PNG image by default
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = sessionSvc.QRFormatPNG
+		}
+
+		// gets the latest QR Code
+		qr, err := sessionService.GetQRCode(phone)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+
+			httpCode := http.StatusNotFound
+			switch {
+			case errors.Is(err, sessionSvc.ErrQRTimeout):
+				httpCode = http.StatusGone
+			case errors.Is(err, sessionSvc.ErrQRNotPending):
+				httpCode = http.StatusConflict
+			case errors.Is(err, sessionSvc.ErrQRNotReady):
+				// the QR Code is expected to be available in a moment
+				w.Header().Set("Retry-After", "1")
+				httpCode = http.StatusServiceUnavailable
+			}
+
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.FailedToFetchData,
+				httpCode, nil)
+			return
+		}
+
+		// raw string is returned as a regular JSON response
+		if format == sessionSvc.QRFormatString {
+			respBody := httputils.Response{
+				Success:     true,
+				Data:        qr,
+				MessageText: "fetch success",
+				Total:       1,
+			}
+
+			_ = httputils.RenderOKResponse(w, r, respBody)
+			return
+		}
+
+		// renders the QR Code as an image
+		img, contentType, err := sessionSvc.RenderQRCode(qr.Code, format)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.BadRequest), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.BadRequest,
+				http.StatusBadRequest, nil)
+			return
+		}
+
+		// the QR Code rotates, hence asks the client to refresh once it expires
+		refreshIn := int(math.Ceil(time.Until(qr.ExpiresAt).Seconds()))
+		if refreshIn < 1 {
+			refreshIn = 1
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Refresh", strconv.Itoa(refreshIn))
+		w.Header().Set("X-QR-Expires-At", qr.ExpiresAt.Format(time.RFC3339))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(img)
+	}
+}
+
 // sessionDisconnect processes the request to delete an existing whatsapp session
 func sessionDisconnect(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -115,39 +316,100 @@ func sessionDisconnect(sessionService *sessionSvc.Service, log *logger.Logger) f
 }
 
 // isOnWhatsapp processes the request to verify if the designated phone on whatsapp or not
-func isOnWhatsapp(sessionService *sessionSvc.Service, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+func isOnWhatsapp(checker *sessionSvc.Checker, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extracts phone from the context and cast them into a string
 		var phoneKey m.Phone = m.PhoneKey
 		phone := r.Context().Value(phoneKey).(string)
 
-		// checks if on WA or not
-		onWhatsapp, err := sessionService.IsOnWhatsapp(phone)
+		// checks if on WA or not, with the session given by `?via={phone}` if any
+		results, err := checker.Check(r.Context(), []string{phone}, r.URL.Query().Get("via"))
+		// special case: no session active that can be utilized
+		if errors.Is(err, sessionSvc.ErrNoActiveSession) {
+			httputils.RenderErrResponse(w, r,
+				err.Error(),
+				httputils.FailedToFetchData,
+				http.StatusNoContent, nil)
+			return
+		}
 		if err != nil {
+			renderCheckerError(w, r, log, err)
+			return
+		}
+		if results[0].Error != "" {
 			httputils.RenderErrResponse(w, r,
-				"failed to check on the Whatsapp Server",
+				results[0].Error,
 				httputils.BadRequest,
 				http.StatusBadRequest, nil)
 			return
 		}
-		// special case: no session active that can be utilized
-		if onWhatsapp == nil {
-			httputils.RenderErrResponse(w, r,
-				"no active session to use",
-				httputils.FailedToFetchData,
-				http.StatusNoContent, nil)
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        results[0].IsIn,
+			MessageText: "fetch success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// bulkOnWhatsappPayload is the input JSON body captured from the bulk on-whatsapp request
+type bulkOnWhatsappPayload struct {
+	Phones []string `json:"phones"`
+}
+
+// bulkOnWhatsapp processes the request to verify if the designated phones are on whatsapp or not
+func bulkOnWhatsapp(checker *sessionSvc.Checker, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload bulkOnWhatsappPayload
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		// checks the phones, reusing the cached results, with the session given by `?via={phone}` if any
+		results, err := checker.Check(r.Context(), payload.Phones, r.URL.Query().Get("via"))
+		if err != nil {
+			renderCheckerError(w, r, log, err)
 			return
 		}
 
 		// prepares response body
 		respBody := httputils.Response{
 			Success:     true,
-			Data:        *onWhatsapp,
+			Data:        results,
 			MessageText: "fetch success",
-			Total:       1,
+			Total:       int64(len(results)),
 		}
 
 		// renders OK response
 		_ = httputils.RenderOKResponse(w, r, respBody)
 	}
 }
+
+// renderCheckerError renders the error of an on-whatsapp check with the matching status code
+func renderCheckerError(w http.ResponseWriter, r *http.Request, log *logger.Logger, err error) {
+	httpCode := http.StatusBadRequest
+	switch {
+	case errors.Is(err, sessionSvc.ErrNoActiveSession):
+		httpCode = http.StatusServiceUnavailable
+	case errors.Is(err, sessionSvc.ErrSessionNotFound):
+		httpCode = http.StatusNotFound
+	case errors.Is(err, sessionSvc.ErrSessionNotReady):
+		httpCode = http.StatusConflict
+	}
+
+	log.Debug("failed to check on the Whatsapp Server", zap.Error(err))
+	httputils.RenderErrResponse(w, r,
+		err.Error(),
+		httputils.FailedToFetchData,
+		httpCode, nil)
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	m "github.com/ardihikaru/go-whatsapp-multi-device/internal/middleware"
+	webhookSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/webhook"
+)
+
+// WebhookMainHandler handles all webhook delivery related routes
+func WebhookMainHandler(log *logger.Logger, webhooks *webhookSvc.Forwarder) http.Handler {
+	r := chi.NewRouter()
+
+	r.Route("/dead-letters", func(r chi.Router) {
+		// extracts the pagination on the URL query parameters
+		r.With(m.URLQueryCtx).Get("/", deadLetterList(webhooks, log)) // GET /api/webhook/dead-letters?phone=
+
+		r.Route("/{id}", func(r chi.Router) {
+			// extracts the id on the URL parameter
+			r.Use(m.MiddlewareIDCtx)
+
+			r.Get("/", deadLetterGet(webhooks, log))
+			r.Delete("/", deadLetterDelete(webhooks, log))
+			r.Post("/replay", deadLetterReplay(webhooks, log))
+		})
+	})
+
+	return r
+}
+
+// deadLetterList processes the request to list the failed webhook deliveries
+func deadLetterList(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := queryParams(r)
+		if err != nil {
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.RequestJSONExtractionFailed),
+				httputils.RequestJSONExtractionFailed,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		total, letters, err := webhooks.GetDeadLetters(r.Context(), r.URL.Query().Get("phone"), params)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", httputils.FailedToFetchData), zap.Error(err))
+			httputils.RenderErrResponse(w, r,
+				httputils.ResponseText("", httputils.FailedToFetchData),
+				httputils.FailedToFetchData,
+				http.StatusBadRequest, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        letters,
+			MessageText: "fetch dead letters success",
+			Total:       total,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// deadLetterGet processes the request to inspect a failed webhook delivery
+func deadLetterGet(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		letter, err := webhooks.GetDeadLetter(r.Context(), id)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        letter,
+			MessageText: "fetch dead letter success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// deadLetterDelete processes the request to discard a failed webhook delivery
+func deadLetterDelete(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		err := webhooks.DeleteDeadLetter(r.Context(), id)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.DeleteDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        nil,
+			MessageText: "dead letter has been deleted",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// deadLetterReplay processes the request to deliver a failed webhook delivery once more
+func deadLetterReplay(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		id := r.Context().Value(idKey).(string)
+
+		err := webhooks.Replay(r.Context(), id)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        nil,
+			MessageText: "dead letter has been delivered",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// subscriptionURLParams extracts the device ID and the webhook subscription ID from the context
+func subscriptionURLParams(r *http.Request) (string, string) {
+	var idKey m.ID = m.IDKey
+	var subscriptionKey m.Subscription = m.SubscriptionKey
+
+	return r.Context().Value(idKey).(string), r.Context().Value(subscriptionKey).(string)
+}
+
+// subscriptionList processes the request to list the webhook subscriptions of the device
+func subscriptionList(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extracts device id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceID := r.Context().Value(idKey).(string)
+
+		subs, err := webhooks.GetSubscriptions(r.Context(), deviceID)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        subs,
+			MessageText: "fetch webhook subscriptions success",
+			Total:       int64(len(subs)),
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// subscriptionCreate processes the request to add a webhook subscription to the device
+func subscriptionCreate(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter,
+	*http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookSvc.SubscriptionPayload
+
+		// extracts device id from the context and cast them into a string
+		var idKey m.ID = m.IDKey
+		deviceID := r.Context().Value(idKey).(string)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		sub, err := webhooks.CreateSubscription(r.Context(), deviceID, payload)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.CreateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        sub,
+			MessageText: "webhook subscription has been created",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// subscriptionGet processes the request to fetch a webhook subscription of the device
+func subscriptionGet(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID, id := subscriptionURLParams(r)
+
+		sub, err := webhooks.GetSubscription(r.Context(), deviceID, id)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.FailedToFetchData, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        sub,
+			MessageText: "fetch webhook subscription success",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// subscriptionUpdate processes the request to replace the endpoint and the filters of a webhook subscription
+func subscriptionUpdate(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter,
+	*http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookSvc.SubscriptionPayload
+
+		deviceID, id := subscriptionURLParams(r)
+
+		// extracts request body
+		eCode, httpCode, err := httputils.GetJsonBody(r.Body, &payload)
+		if err != nil {
+			log.Debug(httputils.ResponseText("", eCode), zap.Error(err))
+			httputils.RenderErrResponse(w, r, httputils.ResponseText("", eCode), int64(eCode), httpCode, err)
+			return
+		}
+
+		sub, err := webhooks.UpdateSubscription(r.Context(), deviceID, id, payload)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.UpdateDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        sub,
+			MessageText: "webhook subscription has been updated",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// subscriptionDelete processes the request to remove a webhook subscription of the device
+func subscriptionDelete(webhooks *webhookSvc.Forwarder, log *logger.Logger) func(http.ResponseWriter,
+	*http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID, id := subscriptionURLParams(r)
+
+		err := webhooks.DeleteSubscription(r.Context(), deviceID, id)
+		if err != nil {
+			renderWebhookError(w, r, log, httputils.DeleteDataFailed, err)
+			return
+		}
+
+		// prepares response body
+		respBody := httputils.Response{
+			Success:     true,
+			Data:        nil,
+			MessageText: "webhook subscription has been deleted",
+			Total:       1,
+		}
+
+		// renders OK response
+		_ = httputils.RenderOKResponse(w, r, respBody)
+	}
+}
+
+// renderWebhookError maps the webhook errors into the HTTP status codes
+func renderWebhookError(w http.ResponseWriter, r *http.Request, log *logger.Logger, appCode int, err error) {
+	log.Debug(httputils.ResponseText("", appCode), zap.Error(err))
+
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, webhookSvc.ErrDeadLetterNotFound), errors.Is(err, webhookSvc.ErrSubscriptionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, webhookSvc.ErrDeliveryFailed):
+		status = http.StatusBadGateway
+	}
+
+	httputils.RenderErrResponse(w, r, err.Error(), int64(appCode), status, nil)
+}
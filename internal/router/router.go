@@ -42,13 +42,35 @@ func GetRouter(deps *app.Dependencies) *chi.Mux {
 // buildTree builds routes
 func buildTree(r *chi.Mux, deps *app.Dependencies) {
 	// handles device related route(s)
-	r.Mount("/api/device", h.AuthMainHandler(deps.DB, deps.Log))
+	r.Mount("/api/device", h.AuthMainHandler(deps.DB, deps.Log, deps.Webhooks, deps.AutoReplies,
+		deps.MsgQueue))
 
 	// handles session related route(s)
 	r.Mount("/api/session", h.SessionMainHandler(deps.Config, deps.DB, deps.Log, deps.WhatsAppBot,
-		deps.HttpClient, deps.BotClients))
+		deps.HttpClient, deps.BotClients, deps.Events, deps.Chats))
+
+	// handles session event stream route(s)
+	r.Mount("/api/events", h.EventMainHandler(deps.Config, deps.Log, deps.Events))
 
 	// handles whatsapp message related route(s)
-	r.Mount("/api/message", h.MessageMainHandler(deps.Config, deps.DB, deps.Log, deps.WhatsAppBot,
-		deps.HttpClient, deps.BotClients))
+	r.Mount("/api/message", h.MessageMainHandler(deps.Config, deps.DB, deps.Log, deps.HttpClient,
+		deps.BotClients, deps.MsgQueue))
+
+	// handles whatsapp group related route(s)
+	r.Mount("/api/group", h.GroupMainHandler(deps.Log, deps.BotClients))
+
+	// handles conversation history related route(s)
+	r.Mount("/api/chat", h.ChatMainHandler(deps.DB, deps.Log))
+
+	// handles inbound media related route(s)
+	r.Mount("/api/media", h.MediaMainHandler(deps.Log, deps.Media))
+
+	// handles webhook delivery related route(s)
+	r.Mount("/api/webhook", h.WebhookMainHandler(deps.Log, deps.Webhooks))
+
+	// handles scheduled message related route(s)
+	r.Mount("/api/schedule", h.ScheduleMainHandler(deps.Log, deps.Schedules))
+
+	// handles broadcast campaign related route(s)
+	r.Mount("/api/campaign", h.CampaignMainHandler(deps.Log, deps.Campaigns))
 }
@@ -0,0 +1,402 @@
+// Package autoreply answers the incoming messages matching the auto-reply rules of the device,
+// the rules are evaluated alongside the webhook delivery and their answers are queued as any outbound message
+package autoreply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.uber.org/zap"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+const (
+	// maxCooldowns is the number of the tracked cooldowns beyond which the expired ones are pruned
+	maxCooldowns = 10000
+
+	// answerQueueSize is the number of the messages waiting for a worker, the messages beyond are not answered
+	answerQueueSize = 1000
+
+	// rulesCacheTTL bounds the time the rules of a device are cached, e.g. when they are changed by another instance
+	rulesCacheTTL = time.Minute
+)
+
+// ErrRuleNotFound is returned when the auto-reply rule does not exist on the device
+var ErrRuleNotFound = errors.New("auto-reply rule not found")
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	GetDeviceByID(ctx context.Context, id string) (deviceSvc.Device, error)
+	GetDeviceByPhone(ctx context.Context, phone string) (deviceSvc.Device, error)
+	InsertAutoReplyRule(ctx context.Context, doc Rule) (Rule, error)
+	GetAutoReplyRule(ctx context.Context, deviceID, id string) (Rule, error)
+	GetAutoReplyRules(ctx context.Context, deviceID string, enabledOnly bool) ([]Rule, error)
+	UpdateAutoReplyRule(ctx context.Context, doc Rule) error
+	DeleteAutoReplyRule(ctx context.Context, deviceID, id string) error
+}
+
+// sender queues the outbound messages, it is implemented by the message service
+type sender interface {
+	SendTextMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx messageSvc.MessageContext,
+		idempotencyKey string) (messageSvc.OutboundMessage, bool, error)
+	SendImageMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx messageSvc.MessageContext,
+		src messageSvc.MediaSource, idempotencyKey string) (messageSvc.OutboundMessage, bool, error)
+	SendMediaMessage(ctx context.Context, msgType string, payload messageSvc.MediaPayload,
+		src messageSvc.MediaSource, idempotencyKey string) (messageSvc.OutboundMessage, bool, error)
+}
+
+// Config sets up the auto-reply service
+type Config struct {
+	// Workers is the number of the messages answered concurrently
+	Workers int
+}
+
+// deviceRules are the enabled rules of a device, compiled once they are loaded
+type deviceRules struct {
+	deviceID  string
+	rules     []compiledRule
+	expiresAt time.Time
+}
+
+// Service manages the auto-reply rules and answers the incoming messages
+type Service struct {
+	storage storage
+	log     *logger.Logger
+	sender  sender
+	cfg     Config
+	queue   chan eventSvc.Event
+	now     func() time.Time
+
+	// cooldowns holds the end of the cooldown of each rule and contact
+	mu        sync.Mutex
+	cooldowns map[string]time.Time
+
+	// rules caches the rules of each device phone, the generation is increased whenever a rule changes,
+	// hence the rules loaded before a change are not cached
+	rulesMu    sync.Mutex
+	rules      map[string]deviceRules
+	generation uint64
+}
+
+// NewService creates an auto-reply service
+func NewService(storage storage, log *logger.Logger, sender sender, cfg Config) *Service {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+
+	return &Service{
+		storage:   storage,
+		log:       log,
+		sender:    sender,
+		cfg:       cfg,
+		queue:     make(chan eventSvc.Event, answerQueueSize),
+		now:       time.Now,
+		cooldowns: make(map[string]time.Time),
+		rules:     make(map[string]deviceRules),
+	}
+}
+
+// Start answers the incoming messages published on the hub until the context is done
+func (s *Service) Start(ctx context.Context, hub *eventSvc.Hub) {
+	for i := 0; i < s.cfg.Workers; i++ {
+		go s.work(ctx)
+	}
+
+	sub := hub.Subscribe("")
+
+	go func() {
+		defer hub.Unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.C:
+				if evt.Body.EventType != eventSvc.TypeIncomingMessage || evt.Body.Message == "" {
+					continue
+				}
+
+				// the media answers may be downloaded, hence the hub is never blocked by an answer
+				select {
+				case s.queue <- evt:
+				default:
+					s.log.Warn(fmt.Sprintf("the answer queue is full, the message [%s] is not answered",
+						evt.Body.MsgId))
+				}
+			}
+		}
+	}()
+}
+
+// work answers the queued messages one at a time
+func (s *Service) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-s.queue:
+			s.handle(ctx, evt)
+		}
+	}
+}
+
+// handle answers the message with the first matching rule,
+// a matching rule still cooling down for the contact stops the evaluation, the next rules do not answer either
+func (s *Service) handle(ctx context.Context, evt eventSvc.Event) {
+	rules, err := s.deviceRules(ctx, evt.Phone)
+	if err != nil {
+		s.log.Debug(fmt.Sprintf("failed to get the auto-reply rules of the device [%s] of the message [%s]",
+			evt.Phone, evt.Body.MsgId), zap.Error(err))
+		return
+	}
+
+	now := s.now()
+	for _, rule := range rules {
+		groups, ok := rule.evaluate(evt, now)
+		if !ok {
+			continue
+		}
+
+		if !s.claim(rule.Rule, evt.Body.Phone, now) {
+			return
+		}
+
+		err = s.reply(ctx, rule, evt, groups)
+		if err != nil {
+			s.log.Warn(fmt.Sprintf("failed to answer the message [%s] with the auto-reply rule [%s]",
+				evt.Body.MsgId, rule.ID), zap.Error(err))
+		}
+
+		return
+	}
+}
+
+// deviceRules returns the enabled rules of the device, they are loaded and compiled once, until a rule changes
+func (s *Service) deviceRules(ctx context.Context, phone string) ([]compiledRule, error) {
+	now := s.now()
+
+	s.rulesMu.Lock()
+	cached, ok := s.rules[phone]
+	generation := s.generation
+	s.rulesMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.rules, nil
+	}
+
+	device, err := s.storage.GetDeviceByPhone(ctx, "+"+phone)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.storage.GetAutoReplyRules(ctx, device.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]compiledRule, 0, len(stored))
+	for _, rule := range stored {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			s.log.Warn(fmt.Sprintf("the auto-reply rule [%s] is invalid and skipped", rule.ID), zap.Error(err))
+			continue
+		}
+		rules = append(rules, compiled)
+	}
+
+	s.rulesMu.Lock()
+	if s.generation == generation {
+		s.rules[phone] = deviceRules{deviceID: device.ID, rules: rules, expiresAt: now.Add(rulesCacheTTL)}
+	}
+	s.rulesMu.Unlock()
+
+	return rules, nil
+}
+
+// invalidateRules drops the cached rules of the device
+func (s *Service) invalidateRules(deviceID string) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+
+	s.generation++
+	for phone, cached := range s.rules {
+		if cached.deviceID == deviceID {
+			delete(s.rules, phone)
+		}
+	}
+}
+
+// evaluate checks whether the rule answers the event at the given time
+func (c compiledRule) evaluate(evt eventSvc.Event, now time.Time) ([]string, bool) {
+	if !c.Enabled || !c.Scope.inScope(evt) || !c.ActiveHours.isActive(now.In(c.loc)) {
+		return nil, false
+	}
+
+	return c.Match.matchText(evt.Body.Message, c.re)
+}
+
+// claim starts the cooldown of the rule for the contact, it fails if the cooldown is still running
+func (s *Service) claim(rule Rule, contact string, now time.Time) bool {
+	if rule.CooldownSeconds == 0 {
+		return true
+	}
+
+	key := rule.ID + "|" + contact
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until, ok := s.cooldowns[key]; ok && now.Before(until) {
+		return false
+	}
+
+	if len(s.cooldowns) >= maxCooldowns {
+		for k, until := range s.cooldowns {
+			if !now.Before(until) {
+				delete(s.cooldowns, k)
+			}
+		}
+	}
+	s.cooldowns[key] = now.Add(time.Duration(rule.CooldownSeconds) * time.Second)
+
+	return true
+}
+
+// reply queues the answer of the rule into the chat of the event,
+// the idempotency key prevents answering twice a message delivered twice by whatsapp
+func (s *Service) reply(ctx context.Context, rule compiledRule, evt eventSvc.Event, groups []string) error {
+	text, err := rule.render(TemplateData{
+		Name:    evt.Body.Name,
+		Phone:   evt.Body.Phone,
+		Message: evt.Body.Message,
+		Groups:  groups,
+	})
+	if err != nil {
+		return err
+	}
+
+	var msgCtx messageSvc.MessageContext
+	if rule.Response.Quote {
+		msgCtx.ReplyTo = &messageSvc.ReplyTo{
+			MessageID: evt.Body.MsgId,
+			Sender:    evt.Body.Phone,
+			Text:      evt.Body.Message,
+		}
+	}
+
+	key := "autoreply:" + rule.ID + ":" + evt.Body.MsgId
+	src := messageSvc.MediaSource{URL: rule.Response.URL}
+
+	switch rule.Response.Type {
+	case messageSvc.TypeText:
+		_, _, err = s.sender.SendTextMessage(ctx, botHook.MessagePayload{
+			From:    evt.Phone,
+			To:      evt.Body.ChatJID,
+			Message: text,
+		}, msgCtx, key)
+	case messageSvc.TypeImage:
+		_, _, err = s.sender.SendImageMessage(ctx, botHook.MessagePayload{
+			From:          evt.Phone,
+			To:            evt.Body.ChatJID,
+			ImageFileName: rule.Response.FileName,
+			ImageCaption:  text,
+		}, msgCtx, src, key)
+	default:
+		_, _, err = s.sender.SendMediaMessage(ctx, rule.Response.Type, messageSvc.MediaPayload{
+			From:           evt.Phone,
+			To:             evt.Body.ChatJID,
+			FileName:       rule.Response.FileName,
+			Caption:        text,
+			MessageContext: msgCtx,
+		}, src, key)
+	}
+
+	return err
+}
+
+// GetRules lists the auto-reply rules of the device, by ascending priority
+func (s *Service) GetRules(ctx context.Context, deviceID string) ([]Rule, error) {
+	_, err := s.storage.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetAutoReplyRules(ctx, deviceID, false)
+}
+
+// GetRule extracts an auto-reply rule of the device based on the ID
+func (s *Service) GetRule(ctx context.Context, deviceID, id string) (Rule, error) {
+	return s.storage.GetAutoReplyRule(ctx, deviceID, id)
+}
+
+// CreateRule adds an auto-reply rule to the device
+func (s *Service) CreateRule(ctx context.Context, deviceID string, spec RuleSpec) (Rule, error) {
+	spec.Sanitize()
+	err := spec.Validate()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	_, err = s.storage.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	now := time.Now().UTC()
+
+	rule, err := s.storage.InsertAutoReplyRule(ctx, Rule{
+		DeviceID:  deviceID,
+		RuleSpec:  spec,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return Rule{}, err
+	}
+	s.invalidateRules(deviceID)
+
+	return rule, nil
+}
+
+// UpdateRule replaces the definition of an auto-reply rule
+func (s *Service) UpdateRule(ctx context.Context, deviceID, id string, spec RuleSpec) (Rule, error) {
+	spec.Sanitize()
+	err := spec.Validate()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule, err := s.storage.GetAutoReplyRule(ctx, deviceID, id)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule.RuleSpec = spec
+	rule.UpdatedAt = time.Now().UTC()
+
+	err = s.storage.UpdateAutoReplyRule(ctx, rule)
+	if err != nil {
+		return Rule{}, err
+	}
+	s.invalidateRules(deviceID)
+
+	return rule, nil
+}
+
+// DeleteRule removes an auto-reply rule of the device
+func (s *Service) DeleteRule(ctx context.Context, deviceID, id string) error {
+	err := s.storage.DeleteAutoReplyRule(ctx, deviceID, id)
+	if err != nil {
+		return err
+	}
+	s.invalidateRules(deviceID)
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package autoreply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+)
+
+// fakeStorage serves the rules of a single device
+type fakeStorage struct {
+	rules []Rule
+	loads int
+}
+
+func (f *fakeStorage) GetDeviceByID(_ context.Context, id string) (deviceSvc.Device, error) {
+	return deviceSvc.Device{ID: id}, nil
+}
+
+func (f *fakeStorage) GetDeviceByPhone(_ context.Context, phone string) (deviceSvc.Device, error) {
+	return deviceSvc.Device{ID: "device-1", Phone: phone}, nil
+}
+
+func (f *fakeStorage) InsertAutoReplyRule(_ context.Context, doc Rule) (Rule, error) {
+	doc.ID = "rule-new"
+	f.rules = append(f.rules, doc)
+
+	return doc, nil
+}
+
+func (f *fakeStorage) GetAutoReplyRule(_ context.Context, deviceID, id string) (Rule, error) {
+	for _, r := range f.rules {
+		if r.ID == id && r.DeviceID == deviceID {
+			return r, nil
+		}
+	}
+
+	return Rule{}, ErrRuleNotFound
+}
+
+func (f *fakeStorage) GetAutoReplyRules(_ context.Context, _ string, enabledOnly bool) ([]Rule, error) {
+	f.loads++
+	res := make([]Rule, 0, len(f.rules))
+	for _, r := range f.rules {
+		if r.Enabled || !enabledOnly {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
+
+func (f *fakeStorage) UpdateAutoReplyRule(_ context.Context, doc Rule) error {
+	for i, r := range f.rules {
+		if r.ID == doc.ID {
+			f.rules[i] = doc
+			return nil
+		}
+	}
+
+	return ErrRuleNotFound
+}
+
+func (f *fakeStorage) DeleteAutoReplyRule(_ context.Context, _, _ string) error {
+	return nil
+}
+
+func newTestService(rules ...Rule) (*Service, *servicetest.Sender) {
+	sender := &servicetest.Sender{}
+	s := NewService(&fakeStorage{rules: rules}, &logger.Logger{Logger: zap.NewNop()}, sender, Config{})
+
+	return s, sender
+}
+
+func incoming(chat, sender, text string) eventSvc.Event {
+	return eventSvc.Event{
+		Phone: "6281111",
+		Body: eventSvc.Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner: "+6281111",
+				EventType:  eventSvc.TypeIncomingMessage,
+				MsgId:      "MSG1",
+				Phone:      sender,
+				Name:       "Budi",
+				Message:    text,
+				TargetJID:  sender + "@s.whatsapp.net",
+			},
+			ChatJID: chat,
+		},
+	}
+}
+
+func textRule(id, matchType, pattern, text string) Rule {
+	return Rule{
+		ID:       id,
+		DeviceID: "device-1",
+		RuleSpec: RuleSpec{
+			Name:     id,
+			Enabled:  true,
+			Match:    Match{Type: matchType, Pattern: pattern},
+			Scope:    Scope{Chats: ScopeAny},
+			Response: Response{Type: messageSvc.TypeText, Text: text},
+		},
+	}
+}
+
+func TestRuleSpecValidate(t *testing.T) {
+	valid := func() RuleSpec {
+		return RuleSpec{
+			Name:     "greeting",
+			Match:    Match{Type: "Contains", Pattern: "hello"},
+			Response: Response{Text: "Hi {{.Name}}"},
+		}
+	}
+
+	spec := valid()
+	spec.Scope.JIDs = []string{"+62 811", "123@g.us"}
+	spec.Sanitize()
+	assert.NoError(t, spec.Validate())
+	assert.Equal(t, MatchContains, spec.Match.Type)
+	assert.Equal(t, ScopeAny, spec.Scope.Chats)
+	assert.Equal(t, messageSvc.TypeText, spec.Response.Type)
+
+	cases := map[string]func(s *RuleSpec){
+		"missing name":     func(s *RuleSpec) { s.Name = "" },
+		"unknown match":    func(s *RuleSpec) { s.Match.Type = "fuzzy" },
+		"invalid regex":    func(s *RuleSpec) { s.Match = Match{Type: MatchRegex, Pattern: "(a"} },
+		"unknown scope":    func(s *RuleSpec) { s.Scope.Chats = "channel" },
+		"invalid template": func(s *RuleSpec) { s.Response.Text = "{{.Name" },
+		"invalid hours":    func(s *RuleSpec) { s.ActiveHours = &ActiveHours{Start: "9am", End: "17:00"} },
+		"invalid timezone": func(s *RuleSpec) { s.ActiveHours = &ActiveHours{Start: "09:00", End: "17:00", Timezone: "Mars/Base"} },
+		"invalid day":      func(s *RuleSpec) { s.ActiveHours = &ActiveHours{Start: "09:00", End: "17:00", Days: []int{7}} },
+		"negative cooldown": func(s *RuleSpec) {
+			s.CooldownSeconds = -1
+		},
+		"media without file": func(s *RuleSpec) {
+			s.Response = Response{Type: messageSvc.TypeDocument}
+		},
+		"media with a path": func(s *RuleSpec) {
+			s.Response = Response{Type: messageSvc.TypeImage, FileName: "../secret.jpg"}
+		},
+		"audio caption": func(s *RuleSpec) {
+			s.Response = Response{Type: messageSvc.TypeAudio, URL: "https://example.com/a.ogg", Text: "listen"}
+		},
+	}
+	for name, mutate := range cases {
+		spec := valid()
+		spec.Sanitize()
+		mutate(&spec)
+		assert.Error(t, spec.Validate(), name)
+	}
+}
+
+func TestMatchText(t *testing.T) {
+	_, ok := Match{Type: MatchExact, Pattern: "price"}.matchText("  PRICE ", nil)
+	assert.True(t, ok)
+
+	_, ok = Match{Type: MatchExact, Pattern: "price", CaseSensitive: true}.matchText("Price", nil)
+	assert.False(t, ok)
+
+	_, ok = Match{Type: MatchContains, Pattern: "Hours"}.matchText("what are your opening hours?", nil)
+	assert.True(t, ok)
+
+	regex := Match{Type: MatchRegex, Pattern: `^order #(\d+)$`}
+	re, err := regex.compile()
+	assert.NoError(t, err)
+
+	groups, ok := regex.matchText("ORDER #42", re)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ORDER #42", "42"}, groups)
+
+	_, ok = regex.matchText("my order", re)
+	assert.False(t, ok)
+}
+
+func TestScope(t *testing.T) {
+	private := incoming("6282222@s.whatsapp.net", "6282222", "hi")
+	group := incoming("12345@g.us", "6283333", "hi")
+
+	assert.True(t, Scope{Chats: ScopeAny}.inScope(private))
+	assert.True(t, Scope{Chats: ScopePrivate}.inScope(private))
+	assert.False(t, Scope{Chats: ScopePrivate}.inScope(group))
+	assert.True(t, Scope{Chats: ScopeGroup}.inScope(group))
+	assert.False(t, Scope{Chats: ScopeGroup}.inScope(private))
+
+	// the JIDs match either the chat or the sender
+	assert.True(t, Scope{Chats: ScopeAny, JIDs: []string{"12345@g.us"}}.inScope(group))
+	assert.True(t, Scope{Chats: ScopeAny, JIDs: []string{normalizeJID("+6283333")}}.inScope(group))
+	assert.False(t, Scope{Chats: ScopeAny, JIDs: []string{"99999@g.us"}}.inScope(group))
+}
+
+func TestActiveHours(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	office := &ActiveHours{Start: "09:00", End: "17:00", Days: []int{1, 2, 3, 4, 5}}
+	assert.True(t, office.isActive(at(9, 0)))
+	assert.False(t, office.isActive(at(17, 0)))
+	assert.False(t, office.isActive(at(8, 59)))
+	assert.False(t, office.isActive(at(12, 0).AddDate(0, 0, -1)))
+
+	// the window is evaluated in its own time zone
+	jakarta := &ActiveHours{Start: "09:00", End: "17:00", Timezone: "Asia/Jakarta"}
+	rule := textRule("r1", MatchContains, "hello", "Hi!")
+	rule.ActiveHours = jakarta
+	compiled, err := compileRule(rule)
+	assert.NoError(t, err)
+	_, ok := compiled.evaluate(incoming("6282222@s.whatsapp.net", "6282222", "hello"), at(2, 0))
+	assert.True(t, ok)
+	_, ok = compiled.evaluate(incoming("6282222@s.whatsapp.net", "6282222", "hello"), at(12, 0))
+	assert.False(t, ok)
+
+	// an overnight window belongs to the day it starts on
+	night := &ActiveHours{Start: "22:00", End: "06:00", Days: []int{0}}
+	assert.True(t, night.isActive(at(2, 0)))
+	assert.False(t, night.isActive(at(23, 0)))
+	assert.False(t, night.isActive(at(12, 0)))
+
+	var always *ActiveHours
+	assert.True(t, always.isActive(at(3, 0)))
+}
+
+func TestHandleFirstMatchingRule(t *testing.T) {
+	quoted := textRule("r2", MatchRegex, `order #(\d+)`, "Hi {{.Name}}, order {{index .Groups 1}} is on its way")
+	quoted.Response.Quote = true
+	disabled := textRule("r0", MatchContains, "order", "disabled")
+	disabled.Enabled = false
+	groupsOnly := textRule("r1", MatchContains, "order", "groups only")
+	groupsOnly.Scope.Chats = ScopeGroup
+
+	s, sender := newTestService(disabled, groupsOnly, quoted, textRule("r3", MatchContains, "order", "fallback"))
+	s.handle(context.Background(), incoming("6282222@s.whatsapp.net", "6282222", "where is order #42?"))
+
+	assert.Len(t, sender.Texts, 1)
+	assert.Equal(t, "Hi Budi, order 42 is on its way", sender.Texts[0].Message)
+	assert.Equal(t, "6281111", sender.Texts[0].From)
+	assert.Equal(t, "6282222@s.whatsapp.net", sender.Texts[0].To)
+	assert.Equal(t, "autoreply:r2:MSG1", sender.Keys[0])
+	assert.Equal(t, "MSG1", sender.Contexts[0].ReplyTo.MessageID)
+	assert.Equal(t, "6282222", sender.Contexts[0].ReplyTo.Sender)
+}
+
+func TestHandleCooldownPerContact(t *testing.T) {
+	rule := textRule("r1", MatchContains, "hello", "Hi!")
+	rule.CooldownSeconds = 60
+
+	s, sender := newTestService(rule, textRule("r2", MatchContains, "hello", "fallback"))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	s.handle(context.Background(), incoming("6282222@s.whatsapp.net", "6282222", "hello"))
+	s.handle(context.Background(), incoming("6282222@s.whatsapp.net", "6282222", "hello again"))
+	s.handle(context.Background(), incoming("6283333@s.whatsapp.net", "6283333", "hello"))
+	assert.Len(t, sender.Texts, 2)
+	assert.Equal(t, "Hi!", sender.Texts[1].Message)
+
+	now = now.Add(time.Minute)
+	s.handle(context.Background(), incoming("6282222@s.whatsapp.net", "6282222", "hello"))
+	assert.Len(t, sender.Texts, 3)
+}
+
+func TestHandleMediaResponse(t *testing.T) {
+	rule := textRule("r1", MatchExact, "catalog", "Our catalog, {{.Name}}")
+	rule.Response = Response{Type: messageSvc.TypeDocument, URL: "https://example.com/catalog.pdf",
+		Text: "Our catalog, {{.Name}}"}
+
+	s, sender := newTestService(rule)
+	s.handle(context.Background(), incoming("12345@g.us", "6283333", "Catalog"))
+
+	assert.Len(t, sender.Media, 1)
+	assert.Equal(t, "12345@g.us", sender.Media[0].To)
+	assert.Equal(t, "Our catalog, Budi", sender.Media[0].Caption)
+	assert.Equal(t, "https://example.com/catalog.pdf", sender.Sources[0].URL)
+}
+
+func TestHandleCachesRules(t *testing.T) {
+	s, sender := newTestService(textRule("r1", MatchContains, "hello", "Hi!"))
+	storage := s.storage.(*fakeStorage)
+
+	s.handle(context.Background(), incoming("6282222@s.whatsapp.net", "6282222", "hello"))
+	s.handle(context.Background(), incoming("6283333@s.whatsapp.net", "6283333", "hello"))
+	assert.Len(t, sender.Texts, 2)
+	assert.Equal(t, 1, storage.loads)
+
+	// a changed rule answers the next message
+	_, err := s.UpdateRule(context.Background(), "device-1", "r1", RuleSpec{
+		Name:     "greeting",
+		Enabled:  true,
+		Match:    Match{Type: MatchContains, Pattern: "hello"},
+		Response: Response{Text: "Hello {{.Name}}"},
+	})
+	assert.NoError(t, err)
+
+	s.handle(context.Background(), incoming("6284444@s.whatsapp.net", "6284444", "hello"))
+	assert.Equal(t, 2, storage.loads)
+	assert.Len(t, sender.Texts, 3)
+	assert.Equal(t, "Hello Budi", sender.Texts[2].Message)
+}
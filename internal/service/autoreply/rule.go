@@ -0,0 +1,389 @@
+package autoreply
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+const (
+	// MatchExact matches the whole text, surrounding spaces aside
+	MatchExact = "exact"
+
+	// MatchContains matches a part of the text
+	MatchContains = "contains"
+
+	// MatchRegex matches the text against a regular expression, its groups are given to the response template
+	MatchRegex = "regex"
+)
+
+const (
+	// ScopeAny answers in any chat
+	ScopeAny = "any"
+
+	// ScopePrivate answers in the private chats only
+	ScopePrivate = "private"
+
+	// ScopeGroup answers in the group chats only
+	ScopeGroup = "group"
+)
+
+// clockLayout is the layout of the active hours
+const clockLayout = "15:04"
+
+// Match defines the texts triggering the rule
+type Match struct {
+	Type          string `json:"type"`
+	Pattern       string `json:"pattern"`
+	CaseSensitive bool   `json:"case_sensitive,omitempty"`
+}
+
+// Scope restricts the chats answered by the rule, the JIDs restrict it further to the given chats or senders
+type Scope struct {
+	Chats string   `json:"chats"`
+	JIDs  []string `json:"jids,omitempty"`
+}
+
+// ActiveHours restricts the rule to a daily time window, an end before the start spans over midnight
+// an empty list of days is active every day, the days are numbered from 0 (Sunday) to 6 (Saturday)
+type ActiveHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+	Days     []int  `json:"days,omitempty"`
+}
+
+// Response is the answer of the rule, its type is a text, image, video, audio or document message type
+// its text, or media caption, is a Go template, see TemplateData
+// the media is an existing file of the media directory, or downloaded from the URL on each answer
+type Response struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Quote    bool   `json:"quote,omitempty"`
+}
+
+// RuleSpec is the definition of a rule, given on the rule requests
+type RuleSpec struct {
+	Name            string       `json:"name"`
+	Enabled         bool         `json:"enabled"`
+	Priority        int          `json:"priority"`
+	Match           Match        `json:"match"`
+	Scope           Scope        `json:"scope"`
+	ActiveHours     *ActiveHours `json:"active_hours,omitempty"`
+	CooldownSeconds int          `json:"cooldown_seconds,omitempty"`
+	Response        Response     `json:"response"`
+}
+
+// Rule is an auto-reply rule of a device, the rules are evaluated by ascending priority
+type Rule struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	RuleSpec
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// compiledRule is a rule along with its regular expression, response template and time zone, compiled once loaded
+type compiledRule struct {
+	Rule
+	re   *regexp.Regexp
+	tmpl *template.Template
+	loc  *time.Location
+}
+
+// TemplateData is given to the response template
+type TemplateData struct {
+	// Name is the push name of the sender
+	Name string
+
+	// Phone is the phone of the sender
+	Phone string
+
+	// Message is the received text
+	Message string
+
+	// Groups are the submatches of a regex rule, the first one being the whole match
+	Groups []string
+}
+
+// Sanitize normalizes the input data
+func (r *RuleSpec) Sanitize() {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Match.Type = strings.ToLower(strings.TrimSpace(r.Match.Type))
+	r.Scope.Chats = strings.ToLower(strings.TrimSpace(r.Scope.Chats))
+	if r.Scope.Chats == "" {
+		r.Scope.Chats = ScopeAny
+	}
+	for i, jid := range r.Scope.JIDs {
+		r.Scope.JIDs[i] = normalizeJID(jid)
+	}
+	r.Response.Type = strings.ToLower(strings.TrimSpace(r.Response.Type))
+	if r.Response.Type == "" {
+		r.Response.Type = messageSvc.TypeText
+	}
+}
+
+// Validate validates the input data
+func (r *RuleSpec) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.CooldownSeconds < 0 {
+		return fmt.Errorf("cooldown_seconds must not be negative")
+	}
+
+	// validates the match
+	if r.Match.Pattern == "" {
+		return fmt.Errorf("match.pattern is required")
+	}
+	switch r.Match.Type {
+	case MatchExact, MatchContains:
+	case MatchRegex:
+		if _, err := r.Match.compile(); err != nil {
+			return fmt.Errorf("match.pattern is not a valid regular expression: %w", err)
+		}
+	default:
+		return fmt.Errorf("match.type must be one of %s, %s or %s", MatchExact, MatchContains, MatchRegex)
+	}
+
+	// validates the scope
+	switch r.Scope.Chats {
+	case ScopeAny, ScopePrivate, ScopeGroup:
+	default:
+		return fmt.Errorf("scope.chats must be one of %s, %s or %s", ScopeAny, ScopePrivate, ScopeGroup)
+	}
+	for _, jid := range r.Scope.JIDs {
+		if _, err := types.ParseJID(jid); err != nil || !strings.Contains(jid, "@") {
+			return fmt.Errorf("scope.jids has an invalid JID [%s]", jid)
+		}
+	}
+
+	// validates the active hours
+	if r.ActiveHours != nil {
+		err := r.ActiveHours.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.Response.validate()
+}
+
+// validate validates the time window
+func (h *ActiveHours) validate() error {
+	if _, err := time.Parse(clockLayout, h.Start); err != nil {
+		return fmt.Errorf("active_hours.start must be formatted as HH:MM")
+	}
+	if _, err := time.Parse(clockLayout, h.End); err != nil {
+		return fmt.Errorf("active_hours.end must be formatted as HH:MM")
+	}
+	if _, err := time.LoadLocation(h.Timezone); err != nil {
+		return fmt.Errorf("active_hours.timezone is not a valid time zone: %w", err)
+	}
+	for _, d := range h.Days {
+		if d < 0 || d > 6 {
+			return fmt.Errorf("active_hours.days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+
+	return nil
+}
+
+// validate validates the answer
+func (r *Response) validate() error {
+	if _, err := r.parse(); err != nil {
+		return fmt.Errorf("response.text is not a valid template: %w", err)
+	}
+
+	switch r.Type {
+	case messageSvc.TypeText:
+		if r.Text == "" {
+			return fmt.Errorf("response.text is required")
+		}
+		if r.FileName != "" || r.URL != "" {
+			return fmt.Errorf("a text response has no media")
+		}
+
+		return nil
+	case messageSvc.TypeImage, messageSvc.TypeVideo, messageSvc.TypeAudio, messageSvc.TypeDocument:
+	default:
+		return fmt.Errorf("response.type must be one of %s, %s, %s, %s or %s", messageSvc.TypeText,
+			messageSvc.TypeImage, messageSvc.TypeVideo, messageSvc.TypeAudio, messageSvc.TypeDocument)
+	}
+
+	if (r.FileName == "") == (r.URL == "") {
+		return fmt.Errorf("either response.file_name or response.url is required")
+	}
+	if r.FileName != "" && filepath.Base(r.FileName) != r.FileName {
+		return fmt.Errorf("response.file_name must not contain a path")
+	}
+	if r.Type == messageSvc.TypeAudio && r.Text != "" {
+		return fmt.Errorf("caption is not supported on audio messages")
+	}
+
+	return nil
+}
+
+// compileRule compiles the regular expression, the response template and the time zone of a validated rule
+func compileRule(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule, loc: time.UTC}
+
+	var err error
+	if rule.Match.Type == MatchRegex {
+		c.re, err = rule.Match.compile()
+		if err != nil {
+			return compiledRule{}, err
+		}
+	}
+
+	c.tmpl, err = rule.Response.parse()
+	if err != nil {
+		return compiledRule{}, err
+	}
+
+	if rule.ActiveHours != nil {
+		c.loc, err = time.LoadLocation(rule.ActiveHours.Timezone)
+		if err != nil {
+			return compiledRule{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// compile compiles the regular expression of the match
+func (m Match) compile() (*regexp.Regexp, error) {
+	pattern := m.Pattern
+	if !m.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	return regexp.Compile(pattern)
+}
+
+// matchText checks whether the text triggers the rule, it returns the submatches of a regex rule
+// the regular expression is the compiled pattern of a regex rule
+func (m Match) matchText(text string, re *regexp.Regexp) ([]string, bool) {
+	switch m.Type {
+	case MatchExact:
+		text = strings.TrimSpace(text)
+		if m.CaseSensitive {
+			return nil, text == m.Pattern
+		}
+		return nil, strings.EqualFold(text, m.Pattern)
+	case MatchContains:
+		if m.CaseSensitive {
+			return nil, strings.Contains(text, m.Pattern)
+		}
+		return nil, strings.Contains(strings.ToLower(text), strings.ToLower(m.Pattern))
+	case MatchRegex:
+		if re == nil {
+			return nil, false
+		}
+		groups := re.FindStringSubmatch(text)
+		return groups, groups != nil
+	default:
+		return nil, false
+	}
+}
+
+// inScope checks whether the chat of the event is answered by the rule
+func (s Scope) inScope(evt eventSvc.Event) bool {
+	chat, err := types.ParseJID(evt.Body.ChatJID)
+	if err != nil {
+		return false
+	}
+
+	isGroup := chat.Server == types.GroupServer
+	if (s.Chats == ScopePrivate && isGroup) || (s.Chats == ScopeGroup && !isGroup) {
+		return false
+	}
+
+	if len(s.JIDs) == 0 {
+		return true
+	}
+
+	sender := types.NewJID(evt.Body.Phone, types.DefaultUserServer).String()
+	for _, jid := range s.JIDs {
+		if jid == chat.String() || jid == sender {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isActive checks whether the time, given in the time zone of the window, is inside the time window
+func (h *ActiveHours) isActive(now time.Time) bool {
+	if h == nil {
+		return true
+	}
+
+	// an overnight window belongs to the day it starts on
+	start, _ := time.Parse(clockLayout, h.Start)
+	end, _ := time.Parse(clockLayout, h.End)
+	minutes := now.Hour()*60 + now.Minute()
+	startMin := start.Hour()*60 + start.Minute()
+	endMin := end.Hour()*60 + end.Minute()
+
+	day := now.Weekday()
+	switch {
+	case startMin <= endMin:
+		if minutes < startMin || minutes >= endMin {
+			return false
+		}
+	case minutes >= startMin:
+	case minutes < endMin:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(h.Days) == 0 {
+		return true
+	}
+	for _, d := range h.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parse parses the template of the response text
+func (r Response) parse() (*template.Template, error) {
+	return template.New("response").Option("missingkey=zero").Parse(r.Text)
+}
+
+// render executes the response template
+func (c compiledRule) render(data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	err := c.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// normalizeJID turns a phone into a user JID, the JIDs are kept as is
+func normalizeJID(jid string) string {
+	jid = strings.TrimSpace(jid)
+	if jid == "" || strings.Contains(jid, "@") {
+		return jid
+	}
+
+	return strings.TrimPrefix(jid, "+") + "@" + types.DefaultUserServer
+}
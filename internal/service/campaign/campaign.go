@@ -0,0 +1,529 @@
+// Package campaign broadcasts a templated message to a list of recipients from a device,
+// the messages are spread over time and the result of each recipient is tracked
+package campaign
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// StatusPending means that the recipients of the campaign are being stored, it is not sent yet
+	StatusPending = "pending"
+
+	// StatusRunning means that the campaign is sending its messages
+	StatusRunning = "running"
+
+	// StatusPaused means that the campaign waits to be resumed
+	StatusPaused = "paused"
+
+	// StatusCancelled means that the campaign has been stopped, its pending recipients are never sent
+	StatusCancelled = "cancelled"
+
+	// StatusCompleted means that every recipient has a result
+	StatusCompleted = "completed"
+)
+
+const (
+	// RecipientPending means that the message has not been sent yet
+	RecipientPending = "pending"
+
+	// RecipientSent means that the message has been sent
+	RecipientSent = "sent"
+
+	// RecipientFailed means that the message has been rejected, or could not be sent
+	RecipientFailed = "failed"
+
+	// RecipientNotOnWhatsapp means that the phone is not registered on whatsapp, no message is sent
+	RecipientNotOnWhatsapp = "not_on_whatsapp"
+)
+
+// phoneVariable is the template variable holding the phone of the recipient
+const phoneVariable = "phone"
+
+var (
+	// ErrCampaignNotFound is returned when the campaign does not exist
+	ErrCampaignNotFound = errors.New("campaign not found")
+
+	// ErrInvalidTransition is returned when the campaign can not be paused, resumed or cancelled in its status
+	ErrInvalidTransition = errors.New("invalid campaign status transition")
+
+	// ErrInvalidCampaign is returned when the requested campaign is not valid
+	ErrInvalidCampaign = errors.New("invalid campaign")
+)
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	InsertCampaign(ctx context.Context, doc Campaign) (Campaign, error)
+	InsertCampaignRecipients(ctx context.Context, docs []Recipient) error
+	GetCampaign(ctx context.Context, id string) (Campaign, error)
+	GetCampaigns(ctx context.Context, phone, status string, params httputils.GetQueryParams) (int64, []Campaign,
+		error)
+	GetRunningCampaigns(ctx context.Context) ([]Campaign, error)
+	UpdateCampaignStatus(ctx context.Context, id string, from []string, to string) error
+	CountCampaignRecipients(ctx context.Context, campaignIDs []string) (map[string]Counts, error)
+	GetCampaignRecipients(ctx context.Context, campaignID, status string,
+		params httputils.GetQueryParams) (int64, []Recipient, error)
+	GetPendingCampaignRecipients(ctx context.Context, campaignID string, limit int64) ([]Recipient, error)
+	UpdateCampaignRecipient(ctx context.Context, doc Recipient) error
+}
+
+// sender queues the campaign messages and follows them, it is implemented by the message service
+type sender interface {
+	messageSvc.Sender
+	WaitForMessage(ctx context.Context, msg messageSvc.OutboundMessage) (messageSvc.OutboundMessage, error)
+}
+
+// checker checks whether the phones are registered on whatsapp, it is implemented by the session checker
+type checker interface {
+	Check(ctx context.Context, phones []string, via string) ([]sessionSvc.OnWhatsapp, error)
+}
+
+// Throttle spreads the messages over time, on top of the rate limit of the device
+type Throttle struct {
+	// IntervalSeconds is the delay between two recipients
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// JitterSeconds is the random delay added to the interval, so that the messages are not evenly spaced
+	JitterSeconds int `json:"jitter_seconds,omitempty"`
+}
+
+// Counts are the number of the recipients by result
+type Counts struct {
+	Total         int64 `json:"total"`
+	Pending       int64 `json:"pending"`
+	Sent          int64 `json:"sent"`
+	Failed        int64 `json:"failed"`
+	NotOnWhatsapp int64 `json:"not_on_whatsapp"`
+}
+
+// Campaign is a message broadcast by a device
+type Campaign struct {
+	ID          string     `json:"id"`
+	Phone       string     `json:"phone"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Template    string     `json:"template"`
+	FileName    string     `json:"file_name,omitempty"`
+	Throttle    Throttle   `json:"throttle"`
+	Status      string     `json:"status"`
+	Counts      Counts     `json:"counts"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Recipient is a recipient of a campaign, and its result
+type Recipient struct {
+	ID         string            `json:"id"`
+	CampaignID string            `json:"campaign_id"`
+	Phone      string            `json:"phone"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Status     string            `json:"status"`
+	MessageID  string            `json:"message_id,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// RecipientInput is a recipient given on the campaign request, the variables are given to the template
+type RecipientInput struct {
+	Phone     string            `json:"phone"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Payload is the input JSON body captured from the campaign request
+// the template is a Go template of the text, or of the media caption, e.g. `Hello {{.name}}`,
+// it is given the variables of the recipient, and its phone as `{{.phone}}`
+// the media is an existing file of the media directory, or of the image directory for the images
+type Payload struct {
+	From       string           `json:"from"`
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Template   string           `json:"template"`
+	FileName   string           `json:"file_name"`
+	Throttle   Throttle         `json:"throttle"`
+	Recipients []RecipientInput `json:"recipients"`
+}
+
+// Sanitize normalizes the input data, the duplicated recipients are removed
+func (p *Payload) Sanitize() {
+	plusSymbol := false
+
+	p.From = strings.TrimSpace(p.From)
+	if p.From != "" {
+		p.From = common.SanitizePhone(p.From, &plusSymbol)
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+	if p.Type == "" {
+		p.Type = messageSvc.TypeText
+	}
+	p.FileName = strings.TrimSpace(p.FileName)
+
+	recipients := make([]RecipientInput, 0, len(p.Recipients))
+	seen := make(map[string]struct{}, len(p.Recipients))
+	for _, r := range p.Recipients {
+		r.Phone = strings.TrimSpace(r.Phone)
+		if r.Phone != "" {
+			r.Phone = common.SanitizePhone(r.Phone, &plusSymbol)
+		}
+		if _, ok := seen[r.Phone]; ok && r.Phone != "" {
+			continue
+		}
+		seen[r.Phone] = struct{}{}
+		recipients = append(recipients, r)
+	}
+	p.Recipients = recipients
+}
+
+// Validate validates the input data, the template is rendered for each recipient
+func (p *Payload) Validate(maxRecipients int) error {
+	if p.From == "" {
+		return fmt.Errorf("from is required")
+	}
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.Throttle.IntervalSeconds < 0 || p.Throttle.JitterSeconds < 0 {
+		return fmt.Errorf("throttle must not be negative")
+	}
+
+	switch p.Type {
+	case messageSvc.TypeText:
+		if p.FileName != "" {
+			return fmt.Errorf("a text campaign has no media")
+		}
+	case messageSvc.TypeImage, messageSvc.TypeVideo, messageSvc.TypeAudio, messageSvc.TypeDocument:
+		if p.FileName == "" {
+			return fmt.Errorf("file_name is required by a %s campaign", p.Type)
+		}
+		if filepath.Base(p.FileName) != p.FileName {
+			return fmt.Errorf("file_name must not contain a path")
+		}
+		if p.Type == messageSvc.TypeAudio && p.Template != "" {
+			return fmt.Errorf("caption is not supported on audio messages")
+		}
+	default:
+		return fmt.Errorf("type must be one of %s, %s, %s, %s or %s", messageSvc.TypeText, messageSvc.TypeImage,
+			messageSvc.TypeVideo, messageSvc.TypeAudio, messageSvc.TypeDocument)
+	}
+
+	if len(p.Recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required")
+	}
+	if maxRecipients > 0 && len(p.Recipients) > maxRecipients {
+		return fmt.Errorf("at most %d recipients can be given", maxRecipients)
+	}
+
+	tmpl, err := parseTemplate(p.Template)
+	if err != nil {
+		return fmt.Errorf("template is not valid: %w", err)
+	}
+
+	for i, r := range p.Recipients {
+		if r.Phone == "" || strings.Trim(r.Phone, "0123456789") != "" {
+			return fmt.Errorf("recipients[%d]: invalid phone [%s]", i, r.Phone)
+		}
+
+		text, err := render(tmpl, r.Phone, r.Variables)
+		if err != nil {
+			return fmt.Errorf("recipients[%d]: %w", i, err)
+		}
+		if p.Type == messageSvc.TypeText && strings.TrimSpace(text) == "" {
+			return fmt.Errorf("recipients[%d]: the rendered message is empty", i)
+		}
+	}
+
+	return nil
+}
+
+// ParseCSV reads the recipients of a CSV file, its header names the columns,
+// the `phone` column is required and the other columns are the variables of the template
+func ParseCSV(r io.Reader) ([]RecipientInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+
+	phoneCol := -1
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if strings.EqualFold(header[i], phoneVariable) {
+			phoneCol = i
+		}
+	}
+	if phoneCol < 0 {
+		return nil, fmt.Errorf("the CSV header has no `%s` column", phoneVariable)
+	}
+
+	recipients := make([]RecipientInput, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CSV line %d: %w", line, err)
+		}
+		if phoneCol >= len(record) {
+			return nil, fmt.Errorf("the CSV line %d has no phone", line)
+		}
+
+		r := RecipientInput{Phone: record[phoneCol], Variables: make(map[string]string)}
+		for i, value := range record {
+			if i != phoneCol && i < len(header) && header[i] != "" {
+				r.Variables[header[i]] = value
+			}
+		}
+		recipients = append(recipients, r)
+	}
+
+	return recipients, nil
+}
+
+// parseTemplate parses the message template, a missing variable fails the rendering
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("campaign").Option("missingkey=error").Parse(text)
+}
+
+// render executes the template with the variables of the recipient
+func render(tmpl *template.Template, phone string, variables map[string]string) (string, error) {
+	data := make(map[string]string, len(variables)+1)
+	for k, v := range variables {
+		data[k] = v
+	}
+	data[phoneVariable] = phone
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Config sets up the campaigns
+type Config struct {
+	// MaxRecipients is the maximum number of the recipients of a campaign, zero is unlimited
+	MaxRecipients int
+}
+
+// Service stores the campaigns and sends their messages
+type Service struct {
+	storage    storage
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+	sender     sender
+	checker    checker
+	cfg        Config
+
+	// runners holds the sending goroutines of the campaigns, they are bound to the context given on start
+	mu      sync.Mutex
+	ctx     context.Context
+	runners map[string]*runner
+}
+
+// NewService creates a campaign service
+func NewService(storage storage, log *logger.Logger, registry *sessionSvc.Registry, sender sender,
+	checker checker, cfg Config) *Service {
+	return &Service{
+		storage:    storage,
+		log:        log,
+		BotClients: registry,
+		sender:     sender,
+		checker:    checker,
+		cfg:        cfg,
+		runners:    make(map[string]*runner),
+	}
+}
+
+// Create stores the campaign and its recipients, and starts sending
+func (s *Service) Create(ctx context.Context, payload Payload) (Campaign, error) {
+	payload.Sanitize()
+	err := payload.Validate(s.cfg.MaxRecipients)
+	if err != nil {
+		return Campaign{}, fmt.Errorf("%w: %v", ErrInvalidCampaign, err)
+	}
+
+	now := time.Now().UTC()
+	c, err := s.storage.InsertCampaign(ctx, Campaign{
+		Phone:     payload.From,
+		Name:      payload.Name,
+		Type:      payload.Type,
+		Template:  payload.Template,
+		FileName:  payload.FileName,
+		Throttle:  payload.Throttle,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	recipients := make([]Recipient, len(payload.Recipients))
+	for i, r := range payload.Recipients {
+		recipients[i] = Recipient{
+			CampaignID: c.ID,
+			Phone:      r.Phone,
+			Variables:  r.Variables,
+			Status:     RecipientPending,
+			UpdatedAt:  now,
+		}
+	}
+
+	err = s.storage.InsertCampaignRecipients(ctx, recipients)
+	if err != nil {
+		// the campaign is not sent without all its recipients
+		_ = s.storage.UpdateCampaignStatus(ctx, c.ID, []string{StatusPending}, StatusCancelled)
+		return Campaign{}, err
+	}
+
+	// the campaign is picked up on restart only once all its recipients are stored
+	err = s.storage.UpdateCampaignStatus(ctx, c.ID, []string{StatusPending}, StatusRunning)
+	if err != nil {
+		// the campaign is not left pending for good
+		_ = s.storage.UpdateCampaignStatus(ctx, c.ID, []string{StatusPending}, StatusCancelled)
+		return Campaign{}, err
+	}
+
+	c.Status = StatusRunning
+	c.Counts = Counts{Total: int64(len(recipients)), Pending: int64(len(recipients))}
+	s.run(c)
+
+	return c, nil
+}
+
+// GetCampaigns lists the campaigns with their counts, of a device and of a status if they are set
+func (s *Service) GetCampaigns(ctx context.Context, phone, status string,
+	params httputils.GetQueryParams) (int64, []Campaign, error) {
+	if phone != "" {
+		plusSymbol := false
+		phone = common.SanitizePhone(phone, &plusSymbol)
+	}
+
+	total, campaigns, err := s.storage.GetCampaigns(ctx, phone, status, params)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ids := make([]string, len(campaigns))
+	for i, c := range campaigns {
+		ids[i] = c.ID
+	}
+
+	counts, err := s.storage.CountCampaignRecipients(ctx, ids)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range campaigns {
+		campaigns[i].Counts = counts[campaigns[i].ID]
+	}
+
+	return total, campaigns, nil
+}
+
+// GetCampaign extracts a campaign with its counts based on the ID
+func (s *Service) GetCampaign(ctx context.Context, id string) (Campaign, error) {
+	c, err := s.storage.GetCampaign(ctx, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	counts, err := s.storage.CountCampaignRecipients(ctx, []string{id})
+	if err != nil {
+		return Campaign{}, err
+	}
+	c.Counts = counts[id]
+
+	return c, nil
+}
+
+// GetRecipients lists the recipients of a campaign with their results, of a status if it is set
+func (s *Service) GetRecipients(ctx context.Context, id, status string,
+	params httputils.GetQueryParams) (int64, []Recipient, error) {
+	_, err := s.storage.GetCampaign(ctx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.storage.GetCampaignRecipients(ctx, id, status, params)
+}
+
+// Pause stops sending the messages of a running campaign, the message being sent is still followed on resume
+func (s *Service) Pause(ctx context.Context, id string) (Campaign, error) {
+	c, err := s.transition(ctx, id, []string{StatusRunning}, StatusPaused)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	s.stop(id)
+
+	return c, nil
+}
+
+// Resume sends again the messages of a paused campaign
+func (s *Service) Resume(ctx context.Context, id string) (Campaign, error) {
+	c, err := s.transition(ctx, id, []string{StatusPaused}, StatusRunning)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	s.run(c)
+
+	return c, nil
+}
+
+// Cancel stops a running or paused campaign for good, the messages already queued are not recalled
+func (s *Service) Cancel(ctx context.Context, id string) (Campaign, error) {
+	c, err := s.transition(ctx, id, []string{StatusRunning, StatusPaused}, StatusCancelled)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	s.stop(id)
+
+	return c, nil
+}
+
+// transition changes the status of the campaign, it fails if the campaign is not in one of the given statuses
+func (s *Service) transition(ctx context.Context, id string, from []string, to string) (Campaign, error) {
+	c, err := s.storage.GetCampaign(ctx, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	err = s.storage.UpdateCampaignStatus(ctx, id, from, to)
+	if errors.Is(err, ErrCampaignNotFound) {
+		return Campaign{}, fmt.Errorf("%w: the campaign is %s", ErrInvalidTransition, c.Status)
+	}
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	return s.GetCampaign(ctx, id)
+}
@@ -0,0 +1,362 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// fakeStorage keeps the campaigns and their recipients in memory
+type fakeStorage struct {
+	campaigns  map[string]Campaign
+	recipients []Recipient
+
+	// recipientsErr fails the insertion of the recipients
+	recipientsErr error
+
+	// startErr fails the start of the campaigns
+	startErr error
+}
+
+func (f *fakeStorage) InsertCampaign(_ context.Context, doc Campaign) (Campaign, error) {
+	doc.ID = "campaign-1"
+	f.campaigns[doc.ID] = doc
+
+	return doc, nil
+}
+
+func (f *fakeStorage) InsertCampaignRecipients(_ context.Context, docs []Recipient) error {
+	if f.recipientsErr != nil {
+		return f.recipientsErr
+	}
+
+	for _, doc := range docs {
+		doc.ID = fmt.Sprintf("rcpt-%d", len(f.recipients)+1)
+		f.recipients = append(f.recipients, doc)
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) GetCampaign(_ context.Context, id string) (Campaign, error) {
+	c, ok := f.campaigns[id]
+	if !ok {
+		return Campaign{}, ErrCampaignNotFound
+	}
+
+	return c, nil
+}
+
+func (f *fakeStorage) GetCampaigns(_ context.Context, _, _ string,
+	_ httputils.GetQueryParams) (int64, []Campaign, error) {
+	return 0, nil, nil
+}
+
+func (f *fakeStorage) GetRunningCampaigns(_ context.Context) ([]Campaign, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) UpdateCampaignStatus(_ context.Context, id string, from []string, to string) error {
+	if to == StatusRunning && f.startErr != nil {
+		return f.startErr
+	}
+
+	c, ok := f.campaigns[id]
+	if !ok {
+		return ErrCampaignNotFound
+	}
+	for _, status := range from {
+		if c.Status == status {
+			c.Status = to
+			f.campaigns[id] = c
+			return nil
+		}
+	}
+
+	return ErrCampaignNotFound
+}
+
+func (f *fakeStorage) CountCampaignRecipients(_ context.Context, _ []string) (map[string]Counts, error) {
+	res := make(map[string]Counts)
+	for _, r := range f.recipients {
+		counts := res[r.CampaignID]
+		counts.Total++
+		switch r.Status {
+		case RecipientPending:
+			counts.Pending++
+		case RecipientSent:
+			counts.Sent++
+		case RecipientFailed:
+			counts.Failed++
+		case RecipientNotOnWhatsapp:
+			counts.NotOnWhatsapp++
+		}
+		res[r.CampaignID] = counts
+	}
+
+	return res, nil
+}
+
+func (f *fakeStorage) GetCampaignRecipients(_ context.Context, _, _ string,
+	_ httputils.GetQueryParams) (int64, []Recipient, error) {
+	return int64(len(f.recipients)), f.recipients, nil
+}
+
+func (f *fakeStorage) GetPendingCampaignRecipients(_ context.Context, campaignID string,
+	limit int64) ([]Recipient, error) {
+	res := make([]Recipient, 0)
+	for _, r := range f.recipients {
+		if r.CampaignID == campaignID && r.Status == RecipientPending && int64(len(res)) < limit {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
+
+func (f *fakeStorage) UpdateCampaignRecipient(_ context.Context, doc Recipient) error {
+	for i, r := range f.recipients {
+		if r.ID == doc.ID {
+			f.recipients[i] = doc
+			return nil
+		}
+	}
+
+	return fmt.Errorf("recipient [%s] not found", doc.ID)
+}
+
+// fakeSender queues the messages on the recording sender, the failing messages fail once sent
+type fakeSender struct {
+	*servicetest.Sender
+	failing map[string]bool
+}
+
+func (f *fakeSender) WaitForMessage(_ context.Context,
+	msg messageSvc.OutboundMessage) (messageSvc.OutboundMessage, error) {
+	msg.Status = messageSvc.StatusSent
+	if f.failing[msg.ID] {
+		msg.Status = messageSvc.StatusFailed
+		msg.LastError = "device has been logged out"
+	}
+
+	return msg, nil
+}
+
+// fakeChecker reports the phones that are not registered on whatsapp
+type fakeChecker struct {
+	missing map[string]bool
+	checked []string
+}
+
+func (f *fakeChecker) Check(_ context.Context, phones []string, _ string) ([]sessionSvc.OnWhatsapp, error) {
+	f.checked = append(f.checked, phones...)
+
+	results := make([]sessionSvc.OnWhatsapp, len(phones))
+	for i, phone := range phones {
+		results[i] = sessionSvc.OnWhatsapp{Phone: phone, IsIn: !f.missing[phone]}
+		if results[i].IsIn {
+			results[i].JID = phone + "@s.whatsapp.net"
+		}
+	}
+
+	return results, nil
+}
+
+func newTestService() (*Service, *fakeStorage, *fakeSender, *fakeChecker, *sessionSvc.Registry) {
+	storage := &fakeStorage{campaigns: make(map[string]Campaign)}
+	sender := &fakeSender{Sender: &servicetest.Sender{}, failing: make(map[string]bool)}
+	checker := &fakeChecker{missing: make(map[string]bool)}
+	registry := sessionSvc.NewRegistry()
+
+	s := NewService(storage, &logger.Logger{Logger: zap.NewNop()}, registry, sender, checker, Config{
+		MaxRecipients: 3,
+	})
+
+	return s, storage, sender, checker, registry
+}
+
+func textPayload(recipients ...RecipientInput) Payload {
+	return Payload{
+		From:       "+6281111",
+		Name:       "promo",
+		Template:   "Hello {{.name}}, this is for {{.phone}}",
+		Recipients: recipients,
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	recipients, err := ParseCSV(strings.NewReader("\ufeffname, Phone,city\nAlice,+6282222,Jakarta\nBob,6283333,\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []RecipientInput{
+		{Phone: "+6282222", Variables: map[string]string{"name": "Alice", "city": "Jakarta"}},
+		{Phone: "6283333", Variables: map[string]string{"name": "Bob", "city": ""}},
+	}, recipients)
+
+	_, err = ParseCSV(strings.NewReader("name,number\nAlice,6282222\n"))
+	assert.Error(t, err)
+
+	_, err = ParseCSV(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestPayloadValidate(t *testing.T) {
+	alice := RecipientInput{Phone: "6282222", Variables: map[string]string{"name": "Alice"}}
+	nameless := RecipientInput{Phone: "6283333"}
+
+	cases := map[string]struct {
+		payload Payload
+		valid   bool
+	}{
+		"text":              {textPayload(alice), true},
+		"duplicated":        {textPayload(alice, alice, alice, alice), true},
+		"image":             {Payload{From: "6281111", Name: "promo", Type: "image", FileName: "promo.jpg", Recipients: []RecipientInput{nameless}}, true},
+		"missing from":      {Payload{Name: "promo", Template: "Hi", Recipients: []RecipientInput{alice}}, false},
+		"missing name":      {Payload{From: "6281111", Template: "Hi", Recipients: []RecipientInput{alice}}, false},
+		"unknown type":      {Payload{From: "6281111", Name: "promo", Type: "location", Recipients: []RecipientInput{alice}}, false},
+		"media without":     {Payload{From: "6281111", Name: "promo", Type: "video", Recipients: []RecipientInput{alice}}, false},
+		"media path":        {Payload{From: "6281111", Name: "promo", Type: "video", FileName: "../a.mp4", Recipients: []RecipientInput{alice}}, false},
+		"no recipient":      {textPayload(), false},
+		"too many":          {textPayload(alice, nameless, RecipientInput{Phone: "6284444"}, RecipientInput{Phone: "6285555"}), false},
+		"invalid phone":     {textPayload(RecipientInput{Phone: "abc", Variables: alice.Variables}), false},
+		"missing variable":  {textPayload(alice, nameless), false},
+		"invalid template":  {Payload{From: "6281111", Name: "promo", Template: "Hi {{.name", Recipients: []RecipientInput{alice}}, false},
+		"negative throttle": {Payload{From: "6281111", Name: "promo", Template: "Hi", Throttle: Throttle{IntervalSeconds: -1}, Recipients: []RecipientInput{alice}}, false},
+	}
+	for name, c := range cases {
+		c.payload.Sanitize()
+		err := c.payload.Validate(3)
+		if c.valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	s, storage, sender, checker, registry := newTestService()
+	servicetest.Connect(t, registry, "6281111")
+
+	c, err := s.Create(context.Background(), textPayload(
+		RecipientInput{Phone: "+6282222", Variables: map[string]string{"name": "Alice"}},
+		RecipientInput{Phone: "6283333", Variables: map[string]string{"name": "Bob"}},
+		RecipientInput{Phone: "6284444", Variables: map[string]string{"name": "Carol"}},
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRunning, c.Status)
+	assert.Equal(t, StatusRunning, storage.campaigns[c.ID].Status)
+	assert.Equal(t, Counts{Total: 3, Pending: 3}, c.Counts)
+
+	checker.missing["6283333"] = true
+	// the second queued message, to Carol, fails
+	sender.failing["msg-2"] = true
+	sender.Errors = []error{&messageSvc.RateLimitError{Reason: "too fast", RetryAfter: time.Millisecond}}
+
+	s.send(context.Background(), c)
+
+	assert.Equal(t, []string{"6282222", "6283333", "6284444"}, checker.checked)
+	assert.Len(t, sender.Texts, 2)
+	assert.Equal(t, "Hello Alice, this is for 6282222", sender.Texts[0].Message)
+	assert.Equal(t, "6282222@s.whatsapp.net", sender.Texts[0].To)
+	assert.Equal(t, "campaign:campaign-1:rcpt-1", sender.Keys[0])
+
+	assert.Equal(t, RecipientSent, storage.recipients[0].Status)
+	assert.Equal(t, "msg-1", storage.recipients[0].MessageID)
+	assert.Equal(t, RecipientNotOnWhatsapp, storage.recipients[1].Status)
+	assert.Equal(t, RecipientFailed, storage.recipients[2].Status)
+	assert.Equal(t, "device has been logged out", storage.recipients[2].Error)
+
+	c, err = s.GetCampaign(context.Background(), c.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, c.Status)
+	assert.Equal(t, Counts{Total: 3, Sent: 1, Failed: 1, NotOnWhatsapp: 1}, c.Counts)
+}
+
+func TestCreateWithoutRecipients(t *testing.T) {
+	s, storage, _, _, _ := newTestService()
+	storage.recipientsErr = errors.New("write conflict")
+
+	// the campaign never runs without its recipients, even after a restart
+	_, err := s.Create(context.Background(), textPayload(
+		RecipientInput{Phone: "6282222", Variables: map[string]string{"name": "Alice"}},
+	))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidCampaign)
+	assert.Equal(t, StatusCancelled, storage.campaigns["campaign-1"].Status)
+
+	_, err = s.Create(context.Background(), textPayload())
+	assert.ErrorIs(t, err, ErrInvalidCampaign)
+}
+
+func TestCreateNotStarted(t *testing.T) {
+	s, storage, _, _, _ := newTestService()
+	storage.startErr = errors.New("write conflict")
+
+	// the campaign which can not be started is not left pending
+	_, err := s.Create(context.Background(), textPayload(
+		RecipientInput{Phone: "6282222", Variables: map[string]string{"name": "Alice"}},
+	))
+	assert.Error(t, err)
+	assert.Equal(t, StatusCancelled, storage.campaigns["campaign-1"].Status)
+}
+
+func TestSendQueuedRecipient(t *testing.T) {
+	s, storage, sender, checker, registry := newTestService()
+	servicetest.Connect(t, registry, "6281111")
+
+	c, err := s.Create(context.Background(), textPayload(
+		RecipientInput{Phone: "6282222", Variables: map[string]string{"name": "Alice"}},
+	))
+	assert.NoError(t, err)
+
+	// the message queued before the pause is awaited, not queued again
+	storage.recipients[0].MessageID = "out-1"
+	s.send(context.Background(), c)
+
+	assert.Empty(t, checker.checked)
+	assert.Empty(t, sender.Texts)
+	assert.Equal(t, RecipientSent, storage.recipients[0].Status)
+}
+
+func TestTransitions(t *testing.T) {
+	s, _, _, _, _ := newTestService()
+
+	c, err := s.Create(context.Background(), textPayload(
+		RecipientInput{Phone: "6282222", Variables: map[string]string{"name": "Alice"}},
+	))
+	assert.NoError(t, err)
+
+	c, err = s.Pause(context.Background(), c.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPaused, c.Status)
+	assert.Equal(t, int64(1), c.Counts.Pending)
+
+	_, err = s.Pause(context.Background(), c.ID)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	c, err = s.Resume(context.Background(), c.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRunning, c.Status)
+
+	c, err = s.Cancel(context.Background(), c.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, c.Status)
+
+	_, err = s.Resume(context.Background(), c.ID)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	_, err = s.Cancel(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrCampaignNotFound)
+}
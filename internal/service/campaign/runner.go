@@ -0,0 +1,308 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"text/template"
+	"time"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.uber.org/zap"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// recipientBatchSize is the number of the pending recipients checked on whatsapp at once
+	recipientBatchSize = 50
+
+	// retryDelay is the delay before retrying, e.g. while the device is disconnected
+	retryDelay = 10 * time.Second
+)
+
+// runner is the sending goroutine of a campaign
+type runner struct {
+	cancel context.CancelFunc
+}
+
+// Start resumes the running campaigns, the campaigns started afterwards are sent until the context is done
+func (s *Service) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	campaigns, err := s.storage.GetRunningCampaigns(ctx)
+	if err != nil {
+		s.log.Warn("failed to get the running campaigns", zap.Error(err))
+		return
+	}
+
+	for _, c := range campaigns {
+		s.run(c)
+	}
+}
+
+// run starts sending the campaign, unless it is already being sent or the service has not been started
+func (s *Service) run(c Campaign) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		return
+	}
+	if _, ok := s.runners[c.ID]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &runner{cancel: cancel}
+	s.runners[c.ID] = r
+
+	go func() {
+		defer s.forget(c.ID, r)
+		s.send(ctx, c)
+	}()
+}
+
+// stop cancels the sending goroutine of the campaign
+func (s *Service) stop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.runners[id]; ok {
+		r.cancel()
+		delete(s.runners, id)
+	}
+}
+
+// forget removes the finished goroutine, unless it has already been replaced on resume
+func (s *Service) forget(id string, r *runner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.cancel()
+	if s.runners[id] == r {
+		delete(s.runners, id)
+	}
+}
+
+// send sends the messages to the pending recipients until there is none left, or until the campaign is stopped
+func (s *Service) send(ctx context.Context, c Campaign) {
+	tmpl, err := parseTemplate(c.Template)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("invalid template of the campaign [%s]", c.ID), zap.Error(err))
+		return
+	}
+
+	for {
+		// waits for the session of the device
+		if _, err = s.BotClients.Bot(c.Phone); err != nil {
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+
+		recipients, err := s.storage.GetPendingCampaignRecipients(ctx, c.ID, recipientBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log.Warn(fmt.Sprintf("failed to get the recipients of the campaign [%s]", c.ID), zap.Error(err))
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+
+		if len(recipients) == 0 {
+			err = s.storage.UpdateCampaignStatus(ctx, c.ID, []string{StatusRunning}, StatusCompleted)
+			if err != nil && !errors.Is(err, ErrCampaignNotFound) && ctx.Err() == nil {
+				s.log.Warn(fmt.Sprintf("failed to complete the campaign [%s]", c.ID), zap.Error(err))
+			}
+			return
+		}
+
+		if !s.sendBatch(ctx, c, tmpl, recipients) {
+			return
+		}
+	}
+}
+
+// sendBatch checks the recipients on whatsapp and sends them the message one after the other,
+// it returns false once the campaign is stopped
+func (s *Service) sendBatch(ctx context.Context, c Campaign, tmpl *template.Template, recipients []Recipient) bool {
+	// the recipients already queued before a pause or a restart are not checked again
+	phones := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		if r.MessageID == "" {
+			phones = append(phones, r.Phone)
+		}
+	}
+
+	checked := make(map[string]sessionSvc.OnWhatsapp, len(phones))
+	if len(phones) > 0 {
+		results, err := s.checker.Check(ctx, phones, c.Phone)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			s.log.Warn(fmt.Sprintf("failed to check the recipients of the campaign [%s]", c.ID), zap.Error(err))
+			return sleep(ctx, retryDelay)
+		}
+		for i, result := range results {
+			checked[phones[i]] = result
+		}
+	}
+
+	for _, r := range recipients {
+		if r.MessageID == "" {
+			result := checked[r.Phone]
+			switch {
+			case result.Error != "":
+				s.finish(ctx, r, RecipientFailed, result.Error)
+				continue
+			case !result.IsIn:
+				s.finish(ctx, r, RecipientNotOnWhatsapp, "")
+				continue
+			}
+
+			to := result.JID
+			if to == "" {
+				to = r.Phone
+			}
+
+			if !s.queue(ctx, c, tmpl, &r, to) {
+				return false
+			}
+			if r.Status != RecipientPending {
+				continue
+			}
+		}
+
+		msg, err := s.sender.WaitForMessage(ctx, messageSvc.OutboundMessage{ID: r.MessageID})
+		if err != nil {
+			// paused or cancelled, the message is awaited again on resume
+			return false
+		}
+		if msg.Status == messageSvc.StatusFailed {
+			s.finish(ctx, r, RecipientFailed, msg.LastError)
+		} else {
+			s.finish(ctx, r, RecipientSent, "")
+		}
+
+		if !sleep(ctx, c.Throttle.delay()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queue queues the message of the recipient and stores its ID, the recipient fails if the message is rejected,
+// it returns false once the campaign is stopped
+func (s *Service) queue(ctx context.Context, c Campaign, tmpl *template.Template, r *Recipient, to string) bool {
+	text, err := render(tmpl, r.Phone, r.Variables)
+	if err != nil {
+		s.finish(ctx, *r, RecipientFailed, err.Error())
+		r.Status = RecipientFailed
+		return true
+	}
+
+	// the key prevents queuing twice the message of a recipient, e.g. when the campaign is resumed
+	key := fmt.Sprintf("campaign:%s:%s", c.ID, r.ID)
+
+	for {
+		var msg messageSvc.OutboundMessage
+		switch c.Type {
+		case messageSvc.TypeText:
+			msg, _, err = s.sender.SendTextMessage(ctx, botHook.MessagePayload{
+				From:    c.Phone,
+				To:      to,
+				Message: text,
+			}, messageSvc.MessageContext{}, key)
+		case messageSvc.TypeImage:
+			msg, _, err = s.sender.SendImageMessage(ctx, botHook.MessagePayload{
+				From:          c.Phone,
+				To:            to,
+				ImageFileName: c.FileName,
+				ImageCaption:  text,
+			}, messageSvc.MessageContext{}, messageSvc.MediaSource{}, key)
+		default:
+			msg, _, err = s.sender.SendMediaMessage(ctx, c.Type, messageSvc.MediaPayload{
+				From:     c.Phone,
+				To:       to,
+				FileName: c.FileName,
+				Caption:  text,
+			}, messageSvc.MediaSource{}, key)
+		}
+
+		// the device is over its rate limit, the message is retried once allowed
+		var rateLimitErr *messageSvc.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			if !sleep(ctx, rateLimitErr.RetryAfter) {
+				return false
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			s.finish(ctx, *r, RecipientFailed, err.Error())
+			r.Status = RecipientFailed
+			return true
+		}
+
+		r.MessageID = msg.ID
+		r.UpdatedAt = time.Now().UTC()
+		err = s.storage.UpdateCampaignRecipient(ctx, *r)
+		if err != nil && ctx.Err() == nil {
+			s.log.Warn(fmt.Sprintf("failed to update the campaign recipient [%s]", r.ID), zap.Error(err))
+		}
+
+		return true
+	}
+}
+
+// finish stores the result of the recipient
+func (s *Service) finish(ctx context.Context, r Recipient, status, reason string) {
+	r.Status = status
+	r.Error = reason
+	r.UpdatedAt = time.Now().UTC()
+
+	err := s.storage.UpdateCampaignRecipient(ctx, r)
+	if err != nil && ctx.Err() == nil {
+		s.log.Warn(fmt.Sprintf("failed to update the campaign recipient [%s]", r.ID), zap.Error(err))
+	}
+}
+
+// delay returns the interval with a random jitter
+func (t Throttle) delay() time.Duration {
+	d := time.Duration(t.IntervalSeconds) * time.Second
+	if t.JitterSeconds > 0 {
+		d += time.Duration(rand.Int63n(int64(t.JitterSeconds) * int64(time.Second)))
+	}
+
+	return d
+}
+
+// sleep waits for the given delay, it returns false if the context is done in the meantime
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
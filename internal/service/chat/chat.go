@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// TypeText is a plain or an extended text message
+	TypeText = "text"
+
+	// TypeImage is an image message
+	TypeImage = "image"
+
+	// TypeVideo is a video message
+	TypeVideo = "video"
+
+	// TypeAudio is an audio or a voice note message
+	TypeAudio = "audio"
+
+	// TypeDocument is a document message
+	TypeDocument = "document"
+
+	// TypeSticker is a sticker message
+	TypeSticker = "sticker"
+
+	// TypeLocation is a location or a live location message
+	TypeLocation = "location"
+
+	// TypeContact is a message of one or more contact cards
+	TypeContact = "contact"
+)
+
+const (
+	// StatusReceived means that the inbound message has not been read yet
+	StatusReceived = "received"
+
+	// StatusSent means that the outbound message has been sent
+	StatusSent = "sent"
+
+	// StatusDelivered means that the outbound message has been delivered to the recipient
+	StatusDelivered = "delivered"
+
+	// StatusRead means that the message has been read, by the recipient or by the device owner if it is inbound
+	StatusRead = "read"
+)
+
+// ErrMessageNotFound is returned when the message has not been recorded
+var ErrMessageNotFound = errors.New("message not found")
+
+// Media is the metadata of the media attached to a message
+type Media struct {
+	MimeType   string `json:"mimetype,omitempty"`
+	FileName   string `json:"file_name,omitempty"`
+	FileLength uint64 `json:"file_length,omitempty"`
+
+	// the references below are required to download and decrypt the media, they are not exposed
+	URL           string `json:"-"`
+	DirectPath    string `json:"-"`
+	MediaKey      []byte `json:"-"`
+	FileSHA256    []byte `json:"-"`
+	FileEncSHA256 []byte `json:"-"`
+}
+
+// Location is the location shared by a message
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Live      bool    `json:"live,omitempty"`
+}
+
+// Message is an inbound or outbound message of a conversation
+type Message struct {
+	ID         string    `json:"id"`
+	Phone      string    `json:"phone"`
+	Chat       string    `json:"chat"`
+	MessageID  string    `json:"message_id"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"`
+	FromMe     bool      `json:"from_me"`
+	Type       string    `json:"type"`
+	Text       string    `json:"text,omitempty"`
+	Media      *Media    `json:"media,omitempty"`
+	Location   *Location `json:"location,omitempty"`
+	ReplyTo    string    `json:"reply_to,omitempty"`
+	Status     string    `json:"status"`
+	Edited     bool      `json:"edited,omitempty"`
+	Revoked    bool      `json:"revoked,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Chat is a conversation of the device with its last message
+type Chat struct {
+	JID         string  `json:"jid"`
+	LastMessage Message `json:"last_message"`
+	UnreadCount int64   `json:"unread_count"`
+}
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	InsertChatMessage(ctx context.Context, doc Message) error
+	GetChatMessage(ctx context.Context, phone, messageID string) (Message, error)
+	EditChatMessage(ctx context.Context, phone, chat, messageID, text string) error
+	RevokeChatMessage(ctx context.Context, phone, chat, messageID string) error
+	UpdateChatMessagesStatus(ctx context.Context, phone, chat string, messageIDs []string, fromMe bool,
+		status string, from []string) error
+	GetChats(ctx context.Context, phone string, params httputils.GetQueryParams) (int64, []Chat, error)
+	GetChatMessages(ctx context.Context, phone, chat string, params httputils.GetQueryParams) (int64, []Message,
+		error)
+}
+
+// Service prepares the interfaces related with this chat service
+type Service struct {
+	storage storage
+	log     *logger.Logger
+}
+
+// NewService creates a chat service
+func NewService(storage storage, log *logger.Logger) *Service {
+	return &Service{
+		storage: storage,
+		log:     log,
+	}
+}
+
+// GetChats lists the conversations of the device, sorted by the time of their last message
+func (s *Service) GetChats(ctx context.Context, phone string, params httputils.GetQueryParams) (int64, []Chat,
+	error) {
+	return s.storage.GetChats(ctx, strings.TrimPrefix(phone, "+"), params)
+}
+
+// GetMessages lists the messages of a conversation of the device, sorted by their time
+func (s *Service) GetMessages(ctx context.Context, phone, chat string, params httputils.GetQueryParams) (int64,
+	[]Message, error) {
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return s.storage.GetChatMessages(ctx, strings.TrimPrefix(phone, "+"), jid.String(), params)
+}
+
+// ParseUserJID parses the phone number or the JID of a user
+func ParseUserJID(value string) (types.JID, error) {
+	if strings.Contains(value, "@") {
+		jid, err := types.ParseJID(value)
+		if err != nil || jid.User == "" || jid.Server != types.DefaultUserServer {
+			return types.JID{}, fmt.Errorf("invalid JID: %s", value)
+		}
+		return jid.ToNonAD(), nil
+	}
+
+	phone := ""
+	if value != "" {
+		plusSymbol := false
+		phone = common.SanitizePhone(value, &plusSymbol)
+	}
+	if phone == "" || strings.Trim(phone, "0123456789") != "" {
+		return types.JID{}, fmt.Errorf("invalid phone: %s", value)
+	}
+
+	return types.NewJID(phone, types.DefaultUserServer), nil
+}
+
+// ParseChatJID parses the JID of a private or a group chat, a phone number stands for a private chat
+func ParseChatJID(value string) (types.JID, error) {
+	if !strings.Contains(value, "@") {
+		return ParseUserJID(value)
+	}
+
+	jid, err := types.ParseJID(value)
+	if err != nil || jid.User == "" {
+		return types.JID{}, fmt.Errorf("invalid JID: %s", value)
+	}
+	if jid.Server != types.DefaultUserServer && jid.Server != types.GroupServer {
+		return types.JID{}, fmt.Errorf("unsupported chat: %s", value)
+	}
+
+	return jid.ToNonAD(), nil
+}
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"go.uber.org/zap"
+)
+
+// recordTimeout bounds the time spent to record an event, the whatsapp events are handled one at a time
+const recordTimeout = 10 * time.Second
+
+// RecordMessage stores an inbound or outbound message of the device,
+// the edits and revokes are applied on the stored message they target
+func (s *Service) RecordMessage(phone string, info types.MessageInfo, msg *waProto.Message) {
+	if msg == nil || info.Chat == types.StatusBroadcastJID {
+		return
+	}
+
+	// the edits sent by the device are wrapped
+	if edited := msg.GetEditedMessage().GetMessage(); edited != nil {
+		msg = edited
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	chat := info.Chat.ToNonAD().String()
+
+	var err error
+	if protocol := msg.GetProtocolMessage(); protocol != nil {
+		id := protocol.GetKey().GetId()
+		switch protocol.GetType() {
+		case waProto.ProtocolMessage_REVOKE:
+			err = s.storage.RevokeChatMessage(ctx, phone, chat, id)
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			edited, ok := newMessage(phone, info, protocol.GetEditedMessage())
+			if ok {
+				err = s.storage.EditChatMessage(ctx, phone, chat, id, edited.Text)
+			}
+		}
+	} else if m, ok := newMessage(phone, info, msg); ok {
+		err = s.storage.InsertChatMessage(ctx, m)
+	}
+
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to record the message [%s] of [%s]", info.ID, phone), zap.Error(err))
+	}
+}
+
+// RecordReceipt updates the status of the messages acknowledged by the receipt
+func (s *Service) RecordReceipt(phone string, v *events.Receipt) {
+	fromMe := true
+	var status string
+	var from []string
+	switch {
+	case v.IsFromMe && v.Type == events.ReceiptTypeReadSelf:
+		// the device owner read the inbound messages on another device
+		fromMe = false
+		status, from = StatusRead, []string{StatusReceived}
+	case v.IsFromMe:
+		return
+	case v.Type == events.ReceiptTypeDelivered:
+		status, from = StatusDelivered, []string{StatusSent}
+	case v.Type == events.ReceiptTypeRead || v.Type == events.ReceiptTypePlayed:
+		status, from = StatusRead, []string{StatusSent, StatusDelivered}
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	err := s.storage.UpdateChatMessagesStatus(ctx, phone, v.Chat.ToNonAD().String(), v.MessageIDs, fromMe,
+		status, from)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to record the %s receipt of [%s]", status, phone), zap.Error(err))
+	}
+}
+
+// newMessage builds the stored message from the whatsapp message,
+// it returns false for the messages without any content to keep in the history, e.g. the reactions
+func newMessage(phone string, info types.MessageInfo, msg *waProto.Message) (Message, bool) {
+	m := Message{
+		Phone:      phone,
+		Chat:       info.Chat.ToNonAD().String(),
+		MessageID:  info.ID,
+		Sender:     info.Sender.ToNonAD().String(),
+		SenderName: info.PushName,
+		FromMe:     info.IsFromMe,
+		Status:     StatusReceived,
+		Timestamp:  info.Timestamp.UTC(),
+	}
+	if m.FromMe {
+		m.Status = StatusSent
+	}
+
+	var ctxInfo *waProto.ContextInfo
+	switch {
+	case msg.GetConversation() != "":
+		m.Type, m.Text = TypeText, msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		v := msg.GetExtendedTextMessage()
+		m.Type, m.Text, ctxInfo = TypeText, v.GetText(), v.GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		v := msg.GetImageMessage()
+		m.Type, m.Text, ctxInfo = TypeImage, v.GetCaption(), v.GetContextInfo()
+		m.Media = newMedia(v, v.GetMimetype(), "")
+	case msg.GetVideoMessage() != nil:
+		v := msg.GetVideoMessage()
+		m.Type, m.Text, ctxInfo = TypeVideo, v.GetCaption(), v.GetContextInfo()
+		m.Media = newMedia(v, v.GetMimetype(), "")
+	case msg.GetAudioMessage() != nil:
+		v := msg.GetAudioMessage()
+		m.Type, ctxInfo = TypeAudio, v.GetContextInfo()
+		m.Media = newMedia(v, v.GetMimetype(), "")
+	case msg.GetDocumentMessage() != nil:
+		v := msg.GetDocumentMessage()
+		m.Type, m.Text, ctxInfo = TypeDocument, v.GetCaption(), v.GetContextInfo()
+		m.Media = newMedia(v, v.GetMimetype(), v.GetFileName())
+	case msg.GetStickerMessage() != nil:
+		v := msg.GetStickerMessage()
+		m.Type, ctxInfo = TypeSticker, v.GetContextInfo()
+		m.Media = newMedia(v, v.GetMimetype(), "")
+	case msg.GetLocationMessage() != nil:
+		v := msg.GetLocationMessage()
+		m.Type, ctxInfo = TypeLocation, v.GetContextInfo()
+		m.Location = &Location{Latitude: v.GetDegreesLatitude(), Longitude: v.GetDegreesLongitude(),
+			Name: v.GetName(), Address: v.GetAddress()}
+	case msg.GetLiveLocationMessage() != nil:
+		v := msg.GetLiveLocationMessage()
+		m.Type, m.Text, ctxInfo = TypeLocation, v.GetCaption(), v.GetContextInfo()
+		m.Location = &Location{Latitude: v.GetDegreesLatitude(), Longitude: v.GetDegreesLongitude(), Live: true}
+	case msg.GetContactMessage() != nil:
+		v := msg.GetContactMessage()
+		m.Type, m.Text, ctxInfo = TypeContact, v.GetDisplayName(), v.GetContextInfo()
+	case msg.GetContactsArrayMessage() != nil:
+		v := msg.GetContactsArrayMessage()
+		names := make([]string, 0, len(v.GetContacts()))
+		for _, contact := range v.GetContacts() {
+			names = append(names, contact.GetDisplayName())
+		}
+		m.Type, m.Text, ctxInfo = TypeContact, strings.Join(names, ", "), v.GetContextInfo()
+	default:
+		return Message{}, false
+	}
+
+	m.ReplyTo = ctxInfo.GetStanzaId()
+
+	return m, true
+}
+
+// downloadableMedia is the whatsapp message of a media, the getters are shared by all the media types
+type downloadableMedia interface {
+	GetUrl() string
+	GetDirectPath() string
+	GetMediaKey() []byte
+	GetFileSha256() []byte
+	GetFileEncSha256() []byte
+	GetFileLength() uint64
+}
+
+// newMedia builds the metadata of the media with the references to download it later on
+func newMedia(v downloadableMedia, mimeType, fileName string) *Media {
+	return &Media{
+		MimeType:      mimeType,
+		FileName:      fileName,
+		FileLength:    v.GetFileLength(),
+		URL:           v.GetUrl(),
+		DirectPath:    v.GetDirectPath(),
+		MediaKey:      v.GetMediaKey(),
+		FileSHA256:    v.GetFileSha256(),
+		FileEncSHA256: v.GetFileEncSha256(),
+	}
+}
@@ -0,0 +1,228 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/stretchr/testify/assert"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// statusUpdate is a status update captured by the fake storage
+type statusUpdate struct {
+	chat   string
+	ids    []string
+	fromMe bool
+	status string
+	from   []string
+}
+
+// fakeStorage captures the recorded messages and their updates
+type fakeStorage struct {
+	inserted []Message
+	edited   map[string]string
+	revoked  []string
+	statuses []statusUpdate
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{edited: make(map[string]string)}
+}
+
+func (f *fakeStorage) InsertChatMessage(_ context.Context, doc Message) error {
+	f.inserted = append(f.inserted, doc)
+	return nil
+}
+
+func (f *fakeStorage) GetChatMessage(context.Context, string, string) (Message, error) {
+	return Message{}, ErrMessageNotFound
+}
+
+func (f *fakeStorage) EditChatMessage(_ context.Context, _, _, messageID, text string) error {
+	f.edited[messageID] = text
+	return nil
+}
+
+func (f *fakeStorage) RevokeChatMessage(_ context.Context, _, _, messageID string) error {
+	f.revoked = append(f.revoked, messageID)
+	return nil
+}
+
+func (f *fakeStorage) UpdateChatMessagesStatus(_ context.Context, _, chat string, messageIDs []string, fromMe bool,
+	status string, from []string) error {
+	f.statuses = append(f.statuses, statusUpdate{chat: chat, ids: messageIDs, fromMe: fromMe, status: status,
+		from: from})
+	return nil
+}
+
+func (f *fakeStorage) GetChats(context.Context, string, httputils.GetQueryParams) (int64, []Chat, error) {
+	return 0, nil, nil
+}
+
+func (f *fakeStorage) GetChatMessages(context.Context, string, string, httputils.GetQueryParams) (int64,
+	[]Message, error) {
+	return 0, nil, nil
+}
+
+// messageInfo builds the info of a message received in a private chat
+func messageInfo(id string, fromMe bool) types.MessageInfo {
+	chat := types.NewJID("628222", types.DefaultUserServer)
+	sender := chat
+	if fromMe {
+		sender = types.NewJID("628111", types.DefaultUserServer)
+	}
+
+	return types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, Sender: sender, IsFromMe: fromMe},
+		ID:            id,
+		PushName:      "Budi",
+		Timestamp:     time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	m, ok := newMessage("628111", messageInfo("A1", false), &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String("hello"),
+			ContextInfo: &waProto.ContextInfo{StanzaId: proto.String("A0")},
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, TypeText, m.Type)
+	assert.Equal(t, "hello", m.Text)
+	assert.Equal(t, "A0", m.ReplyTo)
+	assert.Equal(t, "628222@s.whatsapp.net", m.Chat)
+	assert.Equal(t, "Budi", m.SenderName)
+	assert.Equal(t, StatusReceived, m.Status)
+
+	m, ok = newMessage("628111", messageInfo("A2", true), &waProto.Message{
+		DocumentMessage: &waProto.DocumentMessage{
+			Mimetype:   proto.String("application/pdf"),
+			FileName:   proto.String("invoice.pdf"),
+			FileLength: proto.Uint64(1024),
+			Caption:    proto.String("your invoice"),
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, TypeDocument, m.Type)
+	assert.Equal(t, "your invoice", m.Text)
+	assert.Equal(t, &Media{MimeType: "application/pdf", FileName: "invoice.pdf", FileLength: 1024}, m.Media)
+	assert.Equal(t, StatusSent, m.Status)
+
+	m, ok = newMessage("628111", messageInfo("A3", false), &waProto.Message{
+		LocationMessage: &waProto.LocationMessage{
+			DegreesLatitude:  proto.Float64(-6.2),
+			DegreesLongitude: proto.Float64(106.8),
+			Name:             proto.String("Monas"),
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, TypeLocation, m.Type)
+	assert.Equal(t, &Location{Latitude: -6.2, Longitude: 106.8, Name: "Monas"}, m.Location)
+
+	// the reactions are not kept in the history
+	_, ok = newMessage("628111", messageInfo("A4", false), &waProto.Message{
+		ReactionMessage: &waProto.ReactionMessage{Text: proto.String("👍")},
+	})
+	assert.False(t, ok)
+}
+
+func TestRecordMessage(t *testing.T) {
+	storage := newFakeStorage()
+	s := NewService(storage, nil)
+
+	s.RecordMessage("628111", messageInfo("A1", false), &waProto.Message{Conversation: proto.String("hi")})
+	assert.Len(t, storage.inserted, 1)
+
+	// an edit sent by the device is unwrapped and applied on its target
+	s.RecordMessage("628111", messageInfo("A2", true), &waProto.Message{
+		EditedMessage: &waProto.FutureProofMessage{Message: &waProto.Message{
+			ProtocolMessage: &waProto.ProtocolMessage{
+				Key:           &waProto.MessageKey{Id: proto.String("A0")},
+				Type:          waProto.ProtocolMessage_MESSAGE_EDIT.Enum(),
+				EditedMessage: &waProto.Message{Conversation: proto.String("fixed")},
+			},
+		}},
+	})
+	assert.Equal(t, map[string]string{"A0": "fixed"}, storage.edited)
+
+	s.RecordMessage("628111", messageInfo("A3", false), &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Key:  &waProto.MessageKey{Id: proto.String("A1")},
+			Type: waProto.ProtocolMessage_REVOKE.Enum(),
+		},
+	})
+	assert.Equal(t, []string{"A1"}, storage.revoked)
+
+	// the status updates are not part of any conversation
+	info := messageInfo("A4", false)
+	info.Chat = types.StatusBroadcastJID
+	s.RecordMessage("628111", info, &waProto.Message{Conversation: proto.String("story")})
+	assert.Len(t, storage.inserted, 1)
+}
+
+func TestRecordReceipt(t *testing.T) {
+	storage := newFakeStorage()
+	s := NewService(storage, nil)
+	chat := types.NewJID("628222", types.DefaultUserServer)
+
+	s.RecordReceipt("628111", &events.Receipt{
+		MessageSource: types.MessageSource{Chat: chat},
+		MessageIDs:    []string{"A1"},
+		Type:          events.ReceiptTypeRead,
+	})
+	s.RecordReceipt("628111", &events.Receipt{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: true},
+		MessageIDs:    []string{"B1", "B2"},
+		Type:          events.ReceiptTypeReadSelf,
+	})
+	s.RecordReceipt("628111", &events.Receipt{
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: true},
+		MessageIDs:    []string{"B3"},
+		Type:          events.ReceiptTypeSender,
+	})
+
+	assert.Equal(t, []statusUpdate{
+		{chat: "628222@s.whatsapp.net", ids: []string{"A1"}, fromMe: true, status: StatusRead,
+			from: []string{StatusSent, StatusDelivered}},
+		{chat: "628222@s.whatsapp.net", ids: []string{"B1", "B2"}, fromMe: false, status: StatusRead,
+			from: []string{StatusReceived}},
+	}, storage.statuses)
+}
+
+func TestParseUserJID(t *testing.T) {
+	jid, err := ParseUserJID("+62 811-222")
+	assert.NoError(t, err)
+	assert.Equal(t, "62811222@s.whatsapp.net", jid.String())
+
+	jid, err = ParseUserJID("62811222.0:2@s.whatsapp.net")
+	assert.NoError(t, err)
+	assert.Equal(t, "62811222@s.whatsapp.net", jid.String())
+
+	for _, value := range []string{"", "john", "120363025246125486@g.us"} {
+		_, err = ParseUserJID(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseChatJID(t *testing.T) {
+	jid, err := ParseChatJID("+628222")
+	assert.NoError(t, err)
+	assert.Equal(t, "628222@s.whatsapp.net", jid.String())
+
+	jid, err = ParseChatJID("120363025246125486@g.us")
+	assert.NoError(t, err)
+	assert.Equal(t, types.GroupServer, jid.Server)
+
+	_, err = ParseChatJID("62-abc")
+	assert.Error(t, err)
+	_, err = ParseChatJID("@s.whatsapp.net")
+	assert.Error(t, err)
+	_, err = ParseChatJID("status@broadcast")
+	assert.Error(t, err)
+}
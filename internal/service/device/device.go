@@ -2,6 +2,8 @@ package account
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -17,15 +19,26 @@ type RegisterPayload struct {
 	WebhookUrl string `json:"webhook_url"`
 }
 
+// RateLimit overrides the global outbound rate limit of the device, a nil field keeps the global value
+// and a zero value disables the limit
+type RateLimit struct {
+	MessagesPerMinute *int   `json:"messages_per_minute,omitempty"`
+	MinDelayMs        *int64 `json:"min_delay_ms,omitempty"`
+	JitterMs          *int64 `json:"jitter_ms,omitempty"`
+	DailyCap          *int   `json:"daily_cap,omitempty"`
+}
+
 // Device is the device object
 type Device struct {
-	ID         string    `json:"_id,omitempty"`
-	JID        string    `json:"jid,omitempty"`
-	Phone      string    `json:"phone"`
-	Name       string    `json:"name"`
-	WebhookUrl string    `json:"webhook_url,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID            string     `json:"_id,omitempty"`
+	JID           string     `json:"jid,omitempty"`
+	Phone         string     `json:"phone"`
+	Name          string     `json:"name"`
+	WebhookUrl    string     `json:"webhook_url,omitempty"`
+	WebhookSecret string     `json:"-"` // signs the webhook deliveries, it is only returned once rotated
+	RateLimit     *RateLimit `json:"rate_limit,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 // storage provides the interface for account related operations
@@ -37,7 +50,9 @@ type storage interface {
 	InsertDevice(ctx context.Context, doc Device) (Device, error)
 	UpdateDeviceName(ctx context.Context, id, deviceName string) error
 	UpdateWebhook(ctx context.Context, id, webhook string) error
+	UpdateWebhookSecret(ctx context.Context, id, secret string) error
 	UpdateJID(ctx context.Context, jid, id string) error
+	UpdateRateLimit(ctx context.Context, id string, rateLimit *RateLimit) error
 }
 
 // Service prepares the interfaces related with this account service
@@ -98,6 +113,33 @@ func (s *Service) UpdateWebhook(ctx context.Context, id, webhook string) error {
 	return s.storage.UpdateWebhook(ctx, id, webhook)
 }
 
+// RotateWebhookSecret replaces the webhook secret of the device, the previous secret is no longer used to sign
+func (s *Service) RotateWebhookSecret(ctx context.Context, id string) (string, error) {
+	secret, err := NewWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.storage.UpdateWebhookSecret(ctx, id, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// UpdateRateLimit updates the rate limit override of the device, a nil value restores the global limit
+func (s *Service) UpdateRateLimit(ctx context.Context, id string, rateLimit *RateLimit) error {
+	if rateLimit != nil {
+		err := rateLimit.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.storage.UpdateRateLimit(ctx, id, rateLimit)
+}
+
 func (s *Service) Register(ctx context.Context, payload RegisterPayload) (Device, error) {
 	var err error
 
@@ -109,13 +151,20 @@ func (s *Service) Register(ctx context.Context, payload RegisterPayload) (Device
 		return Device{}, err
 	}
 
+	// generates the secret to sign the webhook deliveries
+	secret, err := NewWebhookSecret()
+	if err != nil {
+		return Device{}, err
+	}
+
 	// builds device object
 	doc := Device{
-		Phone:      payload.Phone,
-		Name:       payload.Name,
-		WebhookUrl: payload.WebhookUrl,
-		CreatedAt:  time.Now().UTC(),
-		UpdatedAt:  time.Now().UTC(),
+		Phone:         payload.Phone,
+		Name:          payload.Name,
+		WebhookUrl:    payload.WebhookUrl,
+		WebhookSecret: secret,
+		CreatedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
 	}
 
 	// validates if this phone exists in the database
@@ -133,12 +182,41 @@ func (s *Service) Register(ctx context.Context, payload RegisterPayload) (Device
 	return device, nil
 }
 
+// NewWebhookSecret generates a random secret to sign the webhook deliveries
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 // Validate validates the input data
 func (d *RegisterPayload) Validate() error {
 
 	return nil
 }
 
+// Validate validates the rate limit values
+func (l *RateLimit) Validate() error {
+	if l.MessagesPerMinute != nil && *l.MessagesPerMinute < 0 {
+		return fmt.Errorf("messages_per_minute must not be negative")
+	}
+	if l.MinDelayMs != nil && *l.MinDelayMs < 0 {
+		return fmt.Errorf("min_delay_ms must not be negative")
+	}
+	if l.JitterMs != nil && *l.JitterMs < 0 {
+		return fmt.Errorf("jitter_ms must not be negative")
+	}
+	if l.DailyCap != nil && *l.DailyCap < 0 {
+		return fmt.Errorf("daily_cap must not be negative")
+	}
+
+	return nil
+}
+
 // Sanitize sanitizes the input data
 func (d *RegisterPayload) Sanitize() {
 	withPlusSymbol := true
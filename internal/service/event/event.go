@@ -0,0 +1,356 @@
+// Package event provides an in-process hub to stream the whatsapp session events to the subscribers
+package event
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// TypeIncomingMessage is emitted when the device receives a message
+	TypeIncomingMessage = botHook.IncomingMessage
+
+	// TypeQRCode is emitted when a new QR Code is generated for a pending session
+	TypeQRCode = "QR_CODE"
+
+	// TypeConnectionState is emitted when the state of a session changes
+	TypeConnectionState = "CONNECTION_STATE"
+
+	// TypeReceipt is emitted when a delivery or read receipt is received
+	TypeReceipt = "RECEIPT"
+
+	// TypePresence is emitted when a contact goes online or offline, or is typing in a chat
+	TypePresence = "PRESENCE"
+
+	// TypeGroupUpdate is emitted when the device joins a group, or when the group info or participants change
+	TypeGroupUpdate = "GROUP_UPDATE"
+
+	// TypeCall is emitted when a call is offered to the device, or terminated
+	TypeCall = "CALL"
+
+	// timestampLayout follows the timestamp layout of the webhook payload
+	timestampLayout = "2006-01-02 15:04:05"
+)
+
+// Event is a session event, its body is the same payload sent to the webhook
+type Event struct {
+	Phone string
+	Body  Body
+}
+
+// Body is the webhook payload, enriched with the chat of the event, with the link to download the media attached
+// to an incoming message and with the participants affected by a group update.
+// As in the payload of the whatsapp bot module, the target JID of an incoming message is its sender
+type Body struct {
+	botHook.WebhookBody
+	ChatJID      string   `json:"chat_jid,omitempty"`
+	MediaURL     string   `json:"media_url,omitempty"`
+	Participants []string `json:"participants,omitempty"`
+}
+
+// NewQRCodeEvent builds an event for a new QR Code
+func NewQRCodeEvent(phone, code string) Event {
+	return Event{
+		Phone: phone,
+		Body: Body{WebhookBody: botHook.WebhookBody{
+			PhoneOwner: phoneOwner(phone),
+			EventType:  TypeQRCode,
+			Message:    code,
+			Timestamp:  time.Now().UTC().Format(timestampLayout),
+		}},
+	}
+}
+
+// NewConnectionStateEvent builds an event for a session state change
+func NewConnectionStateEvent(phone, jid, state string, ts time.Time) Event {
+	return Event{
+		Phone: phone,
+		Body: Body{WebhookBody: botHook.WebhookBody{
+			PhoneOwner: phoneOwner(phone),
+			EventType:  TypeConnectionState,
+			Message:    state,
+			TargetJID:  jid,
+			Timestamp:  ts.Format(timestampLayout),
+		}},
+	}
+}
+
+// NewReceiptEvent builds an event for a delivery or read receipt
+// multiple message IDs are acknowledged by a single receipt, hence one event is built for each of them
+func NewReceiptEvent(phone string, v *events.Receipt) []Event {
+	evts := make([]Event, 0, len(v.MessageIDs))
+	for _, msgId := range v.MessageIDs {
+		evts = append(evts, Event{
+			Phone: phone,
+			Body: Body{
+				WebhookBody: botHook.WebhookBody{
+					PhoneOwner:   phoneOwner(phone),
+					EventType:    TypeReceipt,
+					MsgId:        msgId,
+					MsgType:      receiptType(v.Type),
+					Phone:        v.Sender.User,
+					TargetJID:    v.Chat.String(),
+					TargetDevice: v.Chat.User,
+					Timestamp:    v.Timestamp.Format(timestampLayout),
+				},
+				ChatJID: v.Chat.String(),
+			},
+		})
+	}
+
+	return evts
+}
+
+// NewPresenceEvent builds an event for a contact going online or offline
+func NewPresenceEvent(phone string, v *events.Presence) Event {
+	state := "available"
+	if v.Unavailable {
+		state = "unavailable"
+	}
+
+	ts := v.LastSeen
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+
+	return Event{
+		Phone: phone,
+		Body: Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner:   phoneOwner(phone),
+				EventType:    TypePresence,
+				MsgType:      "presence",
+				Phone:        v.From.User,
+				Message:      state,
+				TargetJID:    v.From.String(),
+				TargetDevice: v.From.User,
+				Timestamp:    ts.Format(timestampLayout),
+			},
+			ChatJID: v.From.String(),
+		},
+	}
+}
+
+// NewChatPresenceEvent builds an event for a contact typing or recording in a chat
+func NewChatPresenceEvent(phone string, v *events.ChatPresence) Event {
+	state := string(v.State)
+	if v.State == types.ChatPresenceComposing && v.Media == types.ChatPresenceMediaAudio {
+		state = "recording"
+	}
+
+	return Event{
+		Phone: phone,
+		Body: Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner:   phoneOwner(phone),
+				EventType:    TypePresence,
+				MsgType:      "chat_presence",
+				Phone:        v.Sender.User,
+				Message:      state,
+				TargetJID:    v.Chat.String(),
+				TargetDevice: v.Chat.User,
+				Timestamp:    time.Now().UTC().Format(timestampLayout),
+			},
+			ChatJID: v.Chat.String(),
+		},
+	}
+}
+
+// NewGroupInfoEvent builds an event for each change of the group,
+// a single notification may carry several changes, e.g. a participant added and another one promoted
+func NewGroupInfoEvent(phone string, v *events.GroupInfo) []Event {
+	evts := make([]Event, 0, 1)
+	build := func(change, message string, participants []types.JID) {
+		evt := newGroupEvent(phone, v.JID, change, message, v.Timestamp)
+		if v.Sender != nil {
+			evt.Body.Phone = v.Sender.User
+		}
+		for _, p := range participants {
+			evt.Body.Participants = append(evt.Body.Participants, p.String())
+		}
+		evts = append(evts, evt)
+	}
+
+	if len(v.Join) > 0 {
+		build("join", v.JoinReason, v.Join)
+	}
+	if len(v.Leave) > 0 {
+		build("leave", "", v.Leave)
+	}
+	if len(v.Promote) > 0 {
+		build("promote", "", v.Promote)
+	}
+	if len(v.Demote) > 0 {
+		build("demote", "", v.Demote)
+	}
+	if v.Name != nil {
+		build("subject", v.Name.Name, nil)
+	}
+	if v.Topic != nil {
+		build("description", v.Topic.Topic, nil)
+	}
+	if v.Locked != nil {
+		build("locked", fmt.Sprintf("%t", v.Locked.IsLocked), nil)
+	}
+	if v.Announce != nil {
+		build("announce", fmt.Sprintf("%t", v.Announce.IsAnnounce), nil)
+	}
+	if v.Ephemeral != nil {
+		build("ephemeral", fmt.Sprintf("%d", v.Ephemeral.DisappearingTimer), nil)
+	}
+	if v.Delete != nil {
+		build("delete", v.Delete.DeleteReason, nil)
+	}
+	if v.NewInviteLink != nil {
+		build("invite_link", *v.NewInviteLink, nil)
+	}
+
+	return evts
+}
+
+// NewJoinedGroupEvent builds an event for the device joining a group
+func NewJoinedGroupEvent(phone string, v *events.JoinedGroup) Event {
+	return newGroupEvent(phone, v.JID, "joined", v.Name, time.Now().UTC())
+}
+
+// newGroupEvent builds a group update event, the change is named by the message type
+func newGroupEvent(phone string, group types.JID, change, message string, ts time.Time) Event {
+	return Event{
+		Phone: phone,
+		Body: Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner:   phoneOwner(phone),
+				EventType:    TypeGroupUpdate,
+				MsgType:      change,
+				Message:      message,
+				TargetJID:    group.String(),
+				TargetDevice: group.User,
+				Timestamp:    ts.Format(timestampLayout),
+			},
+			ChatJID: group.String(),
+		},
+	}
+}
+
+// NewCallEvent builds an event for a call offered to the device, or terminated,
+// the message carries the media of a group call offer, or the reason of the termination
+func NewCallEvent(phone string, evt interface{}) (Event, bool) {
+	var meta types.BasicCallMeta
+	var callType, message string
+
+	switch v := evt.(type) {
+	case *events.CallOffer:
+		meta, callType = v.BasicCallMeta, "offer"
+	case *events.CallOfferNotice:
+		meta, callType, message = v.BasicCallMeta, "offer_notice", v.Media
+	case *events.CallTerminate:
+		meta, callType, message = v.BasicCallMeta, "terminate", v.Reason
+	default:
+		return Event{}, false
+	}
+
+	return Event{
+		Phone: phone,
+		Body: Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner:   phoneOwner(phone),
+				EventType:    TypeCall,
+				MsgId:        meta.CallID,
+				MsgType:      callType,
+				Phone:        meta.From.User,
+				Message:      message,
+				TargetJID:    meta.From.ToNonAD().String(),
+				TargetDevice: meta.From.User,
+				Timestamp:    meta.Timestamp.Format(timestampLayout),
+			},
+			ChatJID: meta.From.ToNonAD().String(),
+		},
+	}, true
+}
+
+// phoneOwner formats the phone of the device as the whatsapp bot module does, with the `+` prefix
+func phoneOwner(phone string) string {
+	return "+" + phone
+}
+
+// NewIncomingMessageEvent builds an event for a received message
+// it returns false if the message has been sent by this device
+func NewIncomingMessageEvent(phone string, v *events.Message) (Event, bool) {
+	if v.Info.IsFromMe {
+		return Event{}, false
+	}
+
+	evt := Event{
+		Phone: phone,
+		Body: Body{
+			WebhookBody: botHook.WebhookBody{
+				PhoneOwner:   phoneOwner(phone),
+				EventType:    TypeIncomingMessage,
+				MsgId:        v.Info.ID,
+				MsgType:      v.Info.Type,
+				Phone:        v.Info.Sender.User,
+				Name:         v.Info.PushName,
+				Message:      MessageText(v),
+				TargetJID:    v.Info.Sender.ToNonAD().String(),
+				TargetDevice: v.Info.Sender.User,
+				Timestamp:    v.Info.Timestamp.Format(timestampLayout),
+			},
+			ChatJID: v.Info.Chat.String(),
+		},
+	}
+	if HasMedia(v) {
+		evt.Body.MediaURL = MediaPath(phone, v.Info.ID)
+	}
+
+	return evt, true
+}
+
+// HasMedia verifies if the message has a media which can be downloaded
+func HasMedia(v *events.Message) bool {
+	msg := v.Message
+
+	return msg.GetImageMessage() != nil || msg.GetVideoMessage() != nil || msg.GetAudioMessage() != nil ||
+		msg.GetDocumentMessage() != nil || msg.GetStickerMessage() != nil
+}
+
+// MediaPath builds the path of the API serving the media of the message, see `GET /api/media/{phone}/{messageId}`
+func MediaPath(phone, messageID string) string {
+	return "/api/media/" + url.PathEscape(phone) + "/" + url.PathEscape(messageID)
+}
+
+// MessageText extracts the text of the message, including the caption of the media messages
+func MessageText(v *events.Message) string {
+	msg := v.Message
+	if msg == nil {
+		return ""
+	}
+
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	default:
+		return ""
+	}
+}
+
+// receiptType names the receipt, an empty receipt type means that the message has been delivered
+func receiptType(t events.ReceiptType) string {
+	if t == events.ReceiptTypeDelivered {
+		return "delivered"
+	}
+
+	return string(t)
+}
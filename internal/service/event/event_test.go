@@ -0,0 +1,74 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewGroupInfoEvent(t *testing.T) {
+	group := types.NewJID("1203630", types.GroupServer)
+	sender := types.NewJID("628111", types.DefaultUserServer)
+
+	evts := NewGroupInfoEvent("628123", &events.GroupInfo{
+		JID:       group,
+		Sender:    &sender,
+		Timestamp: time.Now(),
+		Name:      &types.GroupName{Name: "new subject"},
+		Join:      []types.JID{types.NewJID("628222", types.DefaultUserServer)},
+	})
+
+	// one event is built for each change
+	assert.Len(t, evts, 2)
+	assert.Equal(t, TypeGroupUpdate, evts[0].Body.EventType)
+	assert.Equal(t, "join", evts[0].Body.MsgType)
+	assert.Equal(t, []string{"628222@s.whatsapp.net"}, evts[0].Body.Participants)
+	assert.Equal(t, "628111", evts[0].Body.Phone)
+	assert.Equal(t, "subject", evts[1].Body.MsgType)
+	assert.Equal(t, "new subject", evts[1].Body.Message)
+	assert.Equal(t, group.String(), evts[1].Body.TargetJID)
+}
+
+func TestNewCallEvent(t *testing.T) {
+	caller := types.NewJID("628111", types.DefaultUserServer)
+
+	evt, ok := NewCallEvent("628123", &events.CallTerminate{
+		BasicCallMeta: types.BasicCallMeta{From: caller, CallID: "call-1", Timestamp: time.Now()},
+		Reason:        "timeout",
+	})
+	assert.True(t, ok)
+	assert.Equal(t, TypeCall, evt.Body.EventType)
+	assert.Equal(t, "terminate", evt.Body.MsgType)
+	assert.Equal(t, "call-1", evt.Body.MsgId)
+	assert.Equal(t, "timeout", evt.Body.Message)
+
+	_, ok = NewCallEvent("628123", &events.Receipt{})
+	assert.False(t, ok)
+}
+
+func TestNewIncomingMessageEvent(t *testing.T) {
+	group := types.NewJID("1203630", types.GroupServer)
+	sender := types.JID{User: "628111", Device: 3, Server: types.DefaultUserServer}
+
+	evt, ok := NewIncomingMessageEvent("628123", &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: group, Sender: sender, IsGroup: true},
+			ID:            "MSG1",
+			Timestamp:     time.Now(),
+		},
+		Message: &waProto.Message{Conversation: proto.String("hello")},
+	})
+	assert.True(t, ok)
+
+	// keeps the payload of the whatsapp bot module, the chat is given aside
+	assert.Equal(t, "+628123", evt.Body.PhoneOwner)
+	assert.Equal(t, "628111@s.whatsapp.net", evt.Body.TargetJID)
+	assert.Equal(t, "628111", evt.Body.TargetDevice)
+	assert.Equal(t, group.String(), evt.Body.ChatJID)
+	assert.Equal(t, "hello", evt.Body.Message)
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"sync"
+)
+
+// Subscription receives the published events matching its phone filter
+type Subscription struct {
+	C     <-chan Event
+	c     chan Event
+	phone string
+}
+
+// Hub fans out the published events to the subscribers
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+}
+
+// NewHub creates an event hub, each subscriber buffers up to bufferSize events
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscriber
+// an empty phone subscribes to the events of all devices
+func (h *Hub) Subscribe(phone string) *Subscription {
+	c := make(chan Event, h.bufferSize)
+	sub := &Subscription{
+		C:     c,
+		c:     c,
+		phone: phone,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes the subscriber and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// Publish sends the event to every matching subscriber without blocking
+// a subscriber whose buffer is full misses the event
+func (h *Hub) Publish(evt Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subscribers {
+		if sub.phone != "" && sub.phone != evt.Phone {
+			continue
+		}
+
+		select {
+		case sub.c <- evt:
+		default:
+		}
+	}
+}
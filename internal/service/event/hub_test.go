@@ -0,0 +1,45 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubPhoneFilter(t *testing.T) {
+	h := NewHub(10)
+
+	all := h.Subscribe("")
+	one := h.Subscribe("628111")
+	defer h.Unsubscribe(all)
+	defer h.Unsubscribe(one)
+
+	h.Publish(NewQRCodeEvent("628111", "code-1"))
+	h.Publish(NewConnectionStateEvent("628222", "", "CONNECTED", time.Now()))
+
+	assert.Len(t, all.C, 2)
+	assert.Len(t, one.C, 1)
+
+	evt := <-one.C
+	assert.Equal(t, TypeQRCode, evt.Body.EventType)
+	assert.Equal(t, "code-1", evt.Body.Message)
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub(1)
+	sub := h.Subscribe("")
+
+	// publishing never blocks, the events exceeding the buffer are dropped
+	h.Publish(NewQRCodeEvent("628111", "code-1"))
+	h.Publish(NewQRCodeEvent("628111", "code-2"))
+	assert.Len(t, sub.C, 1)
+
+	h.Unsubscribe(sub)
+	h.Unsubscribe(sub)
+
+	// the drained channel is closed once unsubscribed
+	<-sub.C
+	_, ok := <-sub.C
+	assert.False(t, ok)
+}
@@ -0,0 +1,456 @@
+// Package group provides the management of the whatsapp groups joined by a device
+package group
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// inviteLinkPrefix is the prefix of the group invite links
+const inviteLinkPrefix = "https://chat.whatsapp.com/"
+
+// maxNameLength is the maximum length of a group subject accepted by whatsapp
+const maxNameLength = 25
+
+const (
+	// ActionAdd adds participants to the group
+	ActionAdd = string(whatsmeow.ParticipantChangeAdd)
+
+	// ActionRemove removes participants from the group
+	ActionRemove = string(whatsmeow.ParticipantChangeRemove)
+
+	// ActionPromote makes participants admins of the group
+	ActionPromote = string(whatsmeow.ParticipantChangePromote)
+
+	// ActionDemote revokes the admin rights of participants
+	ActionDemote = string(whatsmeow.ParticipantChangeDemote)
+)
+
+var (
+	// ErrGroupNotFound is returned when the group does not exist
+	ErrGroupNotFound = errors.New("group not found")
+
+	// ErrNotInGroup is returned when the device is not a participant of the group
+	ErrNotInGroup = errors.New("the device is not a participant of the group")
+)
+
+// Participant is a participant of a group
+type Participant struct {
+	JID          string `json:"jid"`
+	Phone        string `json:"phone"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+}
+
+// Group is the group object
+type Group struct {
+	JID               string        `json:"jid"`
+	Name              string        `json:"name"`
+	Description       string        `json:"description,omitempty"`
+	Owner             string        `json:"owner,omitempty"`
+	IsLocked          bool          `json:"is_locked"`
+	IsAnnounce        bool          `json:"is_announce"`
+	IsEphemeral       bool          `json:"is_ephemeral"`
+	DisappearingTimer uint32        `json:"disappearing_timer,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	Participants      []Participant `json:"participants,omitempty"`
+}
+
+// ParticipantResult is the outcome of a participant change, a non-zero error is the code given by whatsapp
+type ParticipantResult struct {
+	JID   string `json:"jid"`
+	Error int    `json:"error,omitempty"`
+}
+
+// CreatePayload is the input JSON body captured from the create group request
+type CreatePayload struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"`
+}
+
+// Validate validates the input data
+func (p *CreatePayload) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len([]rune(p.Name)) > maxNameLength {
+		return fmt.Errorf("name must not exceed %d characters", maxNameLength)
+	}
+
+	return nil
+}
+
+// ParticipantsPayload is the input JSON body captured from the participants update request
+type ParticipantsPayload struct {
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+}
+
+// Validate validates the input data
+func (p *ParticipantsPayload) Validate() error {
+	switch p.Action {
+	case ActionAdd, ActionRemove, ActionPromote, ActionDemote:
+	default:
+		return fmt.Errorf("action must be one of %s, %s, %s or %s", ActionAdd, ActionRemove, ActionPromote,
+			ActionDemote)
+	}
+	if len(p.Participants) == 0 {
+		return fmt.Errorf("at least one participant is required")
+	}
+
+	return nil
+}
+
+// SettingsPayload is the input JSON body captured from the group settings request, a nil field is left unchanged
+type SettingsPayload struct {
+	// Locked allows only the admins to edit the group info
+	Locked *bool `json:"locked"`
+
+	// Announce allows only the admins to send messages
+	Announce *bool `json:"announce"`
+}
+
+// Service prepares the interfaces related with this group service
+type Service struct {
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+}
+
+// NewService creates a group service
+func NewService(log *logger.Logger, registry *sessionSvc.Registry) *Service {
+	return &Service{
+		log:        log,
+		BotClients: registry,
+	}
+}
+
+// ListJoined lists the groups joined by the device
+func (s *Service) ListJoined(phone string) ([]Group, error) {
+	client, err := s.client(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := client.GetJoinedGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch joined groups: %w", err)
+	}
+
+	groups := make([]Group, len(infos))
+	for i, info := range infos {
+		groups[i] = toGroup(info, false)
+	}
+
+	return groups, nil
+}
+
+// Create creates a new group with the given participants
+func (s *Service) Create(phone string, payload CreatePayload) (Group, error) {
+	err := payload.Validate()
+	if err != nil {
+		return Group{}, err
+	}
+
+	participants, err := ParseParticipants(payload.Participants)
+	if err != nil {
+		return Group{}, err
+	}
+
+	client, err := s.client(phone)
+	if err != nil {
+		return Group{}, err
+	}
+
+	info, err := client.CreateGroup(whatsmeow.ReqCreateGroup{
+		Name:         payload.Name,
+		Participants: participants,
+	})
+	if err != nil {
+		return Group{}, fmt.Errorf("failed to create group: %w", err)
+	}
+
+	return toGroup(info, true), nil
+}
+
+// GetInfo fetches the info and the participants of the group
+func (s *Service) GetInfo(phone, group string) (Group, error) {
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return Group{}, err
+	}
+
+	info, err := client.GetGroupInfo(jid)
+	if err != nil {
+		return Group{}, wrapError(err)
+	}
+
+	return toGroup(info, true), nil
+}
+
+// UpdateParticipants adds, removes, promotes or demotes participants of the group
+func (s *Service) UpdateParticipants(phone, group string, payload ParticipantsPayload) ([]ParticipantResult, error) {
+	err := payload.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	participants, err := ParseParticipants(payload.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[types.JID]whatsmeow.ParticipantChange, len(participants))
+	for _, participant := range participants {
+		changes[participant] = whatsmeow.ParticipantChange(payload.Action)
+	}
+
+	resp, err := client.UpdateGroupParticipants(jid, changes)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return participantResults(resp), nil
+}
+
+// SetSubject changes the subject (name) of the group
+func (s *Service) SetSubject(phone, group, subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject is required")
+	}
+	if len([]rune(subject)) > maxNameLength {
+		return fmt.Errorf("subject must not exceed %d characters", maxNameLength)
+	}
+
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return err
+	}
+
+	return wrapError(client.SetGroupName(jid, subject))
+}
+
+// SetDescription changes the description of the group, an empty description removes it
+func (s *Service) SetDescription(phone, group, description string) error {
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return err
+	}
+
+	return wrapError(client.SetGroupTopic(jid, "", "", description))
+}
+
+// UpdateSettings changes who can edit the group info and who can send messages
+func (s *Service) UpdateSettings(phone, group string, payload SettingsPayload) error {
+	if payload.Locked == nil && payload.Announce == nil {
+		return fmt.Errorf("at least one of locked or announce is required")
+	}
+
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return err
+	}
+
+	if payload.Locked != nil {
+		err = client.SetGroupLocked(jid, *payload.Locked)
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+
+	if payload.Announce != nil {
+		err = client.SetGroupAnnounce(jid, *payload.Announce)
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+
+	return nil
+}
+
+// GetInviteLink returns the invite link of the group, revoking the current one first if asked to
+func (s *Service) GetInviteLink(phone, group string, revoke bool) (string, error) {
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := client.GetGroupInviteLink(jid, revoke)
+	if err != nil {
+		return "", wrapError(err)
+	}
+
+	return link, nil
+}
+
+// Join joins the group of the invite code, the full invite link is accepted as well
+func (s *Service) Join(phone, code string) (Group, error) {
+	code = strings.TrimPrefix(strings.TrimSpace(code), inviteLinkPrefix)
+	if code == "" {
+		return Group{}, fmt.Errorf("code is required")
+	}
+
+	client, err := s.client(phone)
+	if err != nil {
+		return Group{}, err
+	}
+
+	jid, err := client.JoinGroupWithLink(code)
+	if err != nil {
+		return Group{}, fmt.Errorf("failed to join group: %w", err)
+	}
+
+	// the joined group may not be available right away, returns its JID at least
+	info, err := client.GetGroupInfo(jid)
+	if err != nil {
+		return Group{JID: jid.String()}, nil
+	}
+
+	return toGroup(info, true), nil
+}
+
+// Leave leaves the group
+func (s *Service) Leave(phone, group string) error {
+	client, jid, err := s.clientAndGroup(phone, group)
+	if err != nil {
+		return err
+	}
+
+	return wrapError(client.LeaveGroup(jid))
+}
+
+// client returns the whatsapp client of the connected device
+func (s *Service) client(phone string) (*whatsmeow.Client, error) {
+	plusSymbol := false
+	phone = common.SanitizePhone(phone, &plusSymbol)
+
+	bot, err := s.BotClients.Bot(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	return bot.Client, nil
+}
+
+// clientAndGroup returns the whatsapp client of the connected device along with the parsed group JID
+func (s *Service) clientAndGroup(phone, group string) (*whatsmeow.Client, types.JID, error) {
+	jid, err := ParseGroupJID(group)
+	if err != nil {
+		return nil, types.JID{}, err
+	}
+
+	client, err := s.client(phone)
+	if err != nil {
+		return nil, types.JID{}, err
+	}
+
+	return client, jid, nil
+}
+
+// ParseGroupJID parses the JID of a group, the server part is optional
+func ParseGroupJID(group string) (types.JID, error) {
+	if !strings.Contains(group, "@") {
+		group = group + "@" + types.GroupServer
+	}
+
+	jid, err := types.ParseJID(group)
+	if err != nil || jid.User == "" || jid.Server != types.GroupServer {
+		return types.JID{}, fmt.Errorf("invalid group JID: %s", group)
+	}
+
+	return jid, nil
+}
+
+// ParseParticipants parses the phones or JIDs of the participants
+func ParseParticipants(values []string) ([]types.JID, error) {
+	participants := make([]types.JID, 0, len(values))
+	for _, value := range values {
+		jid, err := chatSvc.ParseUserJID(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid participant: %s", value)
+		}
+		participants = append(participants, jid)
+	}
+
+	return participants, nil
+}
+
+// wrapError translates the errors of whatsmeow into the errors of this service
+func wrapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, whatsmeow.ErrGroupNotFound), errors.Is(err, whatsmeow.ErrIQNotFound):
+		return fmt.Errorf("%w: %s", ErrGroupNotFound, err.Error())
+	case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrIQForbidden):
+		return fmt.Errorf("%w: %s", ErrNotInGroup, err.Error())
+	default:
+		return err
+	}
+}
+
+// participantResults extracts the outcome of each participant change from the whatsapp response
+func participantResults(resp *waBinary.Node) []ParticipantResult {
+	results := make([]ParticipantResult, 0)
+	if resp == nil {
+		return results
+	}
+
+	for _, action := range resp.GetChildren() {
+		for _, participant := range action.GetChildrenByTag("participant") {
+			ag := participant.AttrGetter()
+			results = append(results, ParticipantResult{
+				JID:   ag.OptionalJIDOrEmpty("jid").String(),
+				Error: ag.OptionalInt("error"),
+			})
+		}
+	}
+
+	return results
+}
+
+// toGroup converts the group info of whatsmeow into the Group struct
+func toGroup(info *types.GroupInfo, withParticipants bool) Group {
+	group := Group{
+		JID:               info.JID.String(),
+		Name:              info.Name,
+		Description:       info.Topic,
+		IsLocked:          info.IsLocked,
+		IsAnnounce:        info.IsAnnounce,
+		IsEphemeral:       info.IsEphemeral,
+		DisappearingTimer: info.DisappearingTimer,
+		CreatedAt:         info.GroupCreated,
+	}
+	if !info.OwnerJID.IsEmpty() {
+		group.Owner = info.OwnerJID.String()
+	}
+
+	if withParticipants {
+		group.Participants = make([]Participant, len(info.Participants))
+		for i, p := range info.Participants {
+			group.Participants[i] = Participant{
+				JID:          p.JID.String(),
+				Phone:        p.JID.User,
+				IsAdmin:      p.IsAdmin,
+				IsSuperAdmin: p.IsSuperAdmin,
+			}
+		}
+	}
+
+	return group
+}
@@ -0,0 +1,74 @@
+package group
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+func TestParseGroupJID(t *testing.T) {
+	jid, err := ParseGroupJID("120363025246125486")
+	assert.NoError(t, err)
+	assert.Equal(t, "120363025246125486@g.us", jid.String())
+
+	_, err = ParseGroupJID("62811@s.whatsapp.net")
+	assert.Error(t, err)
+}
+
+func TestParseParticipants(t *testing.T) {
+	participants, err := ParseParticipants([]string{"+62 811", "62822@s.whatsapp.net"})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.JID{
+		types.NewJID("62811", types.DefaultUserServer),
+		types.NewJID("62822", types.DefaultUserServer),
+	}, participants)
+
+	_, err = ParseParticipants([]string{"john"})
+	assert.Error(t, err)
+}
+
+func TestParticipantsPayloadValidate(t *testing.T) {
+	p := ParticipantsPayload{Action: ActionPromote, Participants: []string{"62811"}}
+	assert.NoError(t, p.Validate())
+
+	p.Action = "ban"
+	assert.Error(t, p.Validate())
+
+	p = ParticipantsPayload{Action: ActionAdd}
+	assert.Error(t, p.Validate())
+}
+
+func TestParticipantResults(t *testing.T) {
+	resp := &waBinary.Node{Tag: "iq", Content: []waBinary.Node{{
+		Tag: "add",
+		Content: []waBinary.Node{
+			{Tag: "participant", Attrs: waBinary.Attrs{"jid": types.NewJID("62811", types.DefaultUserServer)}},
+			{Tag: "participant", Attrs: waBinary.Attrs{
+				"jid":   types.NewJID("62822", types.DefaultUserServer),
+				"error": "403",
+			}},
+		},
+	}}}
+
+	assert.Equal(t, []ParticipantResult{
+		{JID: "62811@s.whatsapp.net"},
+		{JID: "62822@s.whatsapp.net", Error: 403},
+	}, participantResults(resp))
+}
+
+func TestClientSanitizesPhone(t *testing.T) {
+	registry := sessionSvc.NewRegistry()
+	servicetest.Connect(t, registry, "62811222")
+	s := NewService(nil, registry)
+
+	_, err := s.client("+62 811-222")
+	assert.NoError(t, err)
+
+	_, err = s.client("62899")
+	assert.ErrorIs(t, err, sessionSvc.ErrSessionNotFound)
+}
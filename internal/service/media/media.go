@@ -0,0 +1,243 @@
+// Package media downloads and decrypts the media of the inbound messages
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// downloadQueueSize is the number of the media waiting for a worker, the media beyond are downloaded on request
+const downloadQueueSize = 1000
+
+var (
+	// ErrMediaNotFound is returned when the message has no media, or its media has been revoked
+	ErrMediaNotFound = errors.New("the message has no media")
+
+	// ErrMediaExpired is returned when the media is no longer available on the whatsapp servers
+	ErrMediaExpired = errors.New("the media is no longer available on the Whatsapp Server")
+)
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	GetChatMessage(ctx context.Context, phone, messageID string) (chatSvc.Message, error)
+}
+
+// Config sets up the media service
+type Config struct {
+	// Eager downloads the media as soon as the message is received, instead of on the first request
+	Eager bool
+
+	// Workers is the number of the concurrent eager downloads
+	Workers int
+}
+
+// File is a downloaded and decrypted media
+type File struct {
+	Data     []byte
+	MimeType string
+	FileName string
+}
+
+// Service prepares the interfaces related with this media service
+type Service struct {
+	storage    storage
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+	store      BlobStore
+	cfg        Config
+	queue      chan eventSvc.Event
+}
+
+// NewService creates a media service, the downloaded media are kept in the blob store
+func NewService(storage storage, log *logger.Logger, registry *sessionSvc.Registry, store BlobStore,
+	cfg Config) *Service {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+
+	return &Service{
+		storage:    storage,
+		log:        log,
+		BotClients: registry,
+		store:      store,
+		cfg:        cfg,
+		queue:      make(chan eventSvc.Event, downloadQueueSize),
+	}
+}
+
+// Get returns the media of the message, it is downloaded through the session of the device on the first request
+func (s *Service) Get(ctx context.Context, phone, messageID string) (File, error) {
+	phone = strings.TrimPrefix(phone, "+")
+
+	msg, err := s.storage.GetChatMessage(ctx, phone, messageID)
+	if err != nil {
+		return File{}, err
+	}
+	if msg.Media == nil || msg.Revoked {
+		return File{}, ErrMediaNotFound
+	}
+
+	file := File{
+		MimeType: msg.Media.MimeType,
+		FileName: fileName(msg),
+	}
+
+	key := blobKey(phone, messageID)
+	file.Data, err = s.store.Get(ctx, key)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, ErrBlobNotFound) {
+		return File{}, err
+	}
+
+	file.Data, err = s.download(phone, msg)
+	if err != nil {
+		return File{}, err
+	}
+
+	// the media may expire on the whatsapp servers, keeps it for the next requests
+	err = s.store.Put(ctx, key, file.Data)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to store the media of the message [%s]", messageID), zap.Error(err))
+	}
+
+	return file, nil
+}
+
+// ConsumeEvents downloads the media of the incoming messages as soon as they are received, if enabled
+func (s *Service) ConsumeEvents(ctx context.Context, hub *eventSvc.Hub) {
+	if !s.cfg.Eager {
+		return
+	}
+
+	for i := 0; i < s.cfg.Workers; i++ {
+		go s.work(ctx)
+	}
+
+	sub := hub.Subscribe("")
+
+	go func() {
+		defer hub.Unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.C:
+				if evt.Body.EventType != eventSvc.TypeIncomingMessage || evt.Body.MediaURL == "" {
+					continue
+				}
+
+				// the hub drops the events of a slow subscriber, hence the downloads never block it
+				select {
+				case s.queue <- evt:
+				default:
+					s.log.Warn(fmt.Sprintf("the download queue is full, the media of the message [%s] "+
+						"is downloaded on request", evt.Body.MsgId))
+				}
+			}
+		}
+	}()
+}
+
+// work downloads the queued media one at a time
+func (s *Service) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-s.queue:
+			_, err := s.Get(ctx, evt.Phone, evt.Body.MsgId)
+			if err != nil {
+				s.log.Warn(fmt.Sprintf("failed to download the media of the message [%s]", evt.Body.MsgId),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// download downloads and decrypts the media through the session of the device
+func (s *Service) download(phone string, msg chatSvc.Message) ([]byte, error) {
+	bot, err := s.BotClients.Bot(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadable, err := downloadableMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bot.Client.Download(downloadable)
+	if errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410) {
+		return nil, fmt.Errorf("%w: %s", ErrMediaExpired, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the media: %w", err)
+	}
+
+	return data, nil
+}
+
+// downloadableMessage rebuilds the whatsapp message of the media from its stored references,
+// the type of the message defines the keys used to decrypt the media
+func downloadableMessage(msg chatSvc.Message) (whatsmeow.DownloadableMessage, error) {
+	m := msg.Media
+	length := proto.Uint64(m.FileLength)
+
+	switch msg.Type {
+	case chatSvc.TypeImage:
+		return &waProto.ImageMessage{Url: proto.String(m.URL), DirectPath: proto.String(m.DirectPath),
+			MediaKey: m.MediaKey, FileSha256: m.FileSHA256, FileEncSha256: m.FileEncSHA256, FileLength: length}, nil
+	case chatSvc.TypeVideo:
+		return &waProto.VideoMessage{Url: proto.String(m.URL), DirectPath: proto.String(m.DirectPath),
+			MediaKey: m.MediaKey, FileSha256: m.FileSHA256, FileEncSha256: m.FileEncSHA256, FileLength: length}, nil
+	case chatSvc.TypeAudio:
+		return &waProto.AudioMessage{Url: proto.String(m.URL), DirectPath: proto.String(m.DirectPath),
+			MediaKey: m.MediaKey, FileSha256: m.FileSHA256, FileEncSha256: m.FileEncSHA256, FileLength: length}, nil
+	case chatSvc.TypeDocument:
+		return &waProto.DocumentMessage{Url: proto.String(m.URL), DirectPath: proto.String(m.DirectPath),
+			MediaKey: m.MediaKey, FileSha256: m.FileSHA256, FileEncSha256: m.FileEncSHA256, FileLength: length}, nil
+	case chatSvc.TypeSticker:
+		return &waProto.StickerMessage{Url: proto.String(m.URL), DirectPath: proto.String(m.DirectPath),
+			MediaKey: m.MediaKey, FileSha256: m.FileSHA256, FileEncSha256: m.FileEncSHA256, FileLength: length}, nil
+	default:
+		return nil, ErrMediaNotFound
+	}
+}
+
+// fileName returns the name of the document, or builds one from the message ID and the mimetype
+func fileName(msg chatSvc.Message) string {
+	if msg.Media.FileName != "" {
+		return msg.Media.FileName
+	}
+
+	// e.g. `audio/ogg; codecs=opus` for the voice notes
+	mimeType, _, _ := mime.ParseMediaType(msg.Media.MimeType)
+	exts, _ := mime.ExtensionsByType(mimeType)
+	if len(exts) == 0 {
+		return msg.MessageID
+	}
+
+	return msg.MessageID + exts[0]
+}
+
+// blobKey builds the key of the media in the blob store
+func blobKey(phone, messageID string) string {
+	return phone + "/" + messageID
+}
@@ -0,0 +1,76 @@
+package media
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+)
+
+// fakeStorage is an in-memory storage of the recorded messages
+type fakeStorage struct {
+	messages map[string]chatSvc.Message
+}
+
+func (f *fakeStorage) GetChatMessage(_ context.Context, phone, messageID string) (chatSvc.Message, error) {
+	msg, ok := f.messages[phone+"/"+messageID]
+	if !ok {
+		return chatSvc.Message{}, chatSvc.ErrMessageNotFound
+	}
+
+	return msg, nil
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+
+	_, err := store.Get(ctx, "628123/ABC")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	assert.NoError(t, store.Put(ctx, "628123/ABC", []byte("media")))
+	data, err := store.Get(ctx, "628123/ABC")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("media"), data)
+
+	for _, key := range []string{"", "../ABC", "628123/../../ABC", "/etc/passwd"} {
+		assert.Error(t, store.Put(ctx, key, []byte("media")), key)
+	}
+}
+
+func TestServiceGet(t *testing.T) {
+	ctx := context.Background()
+	storage := &fakeStorage{messages: map[string]chatSvc.Message{
+		"628123/IMG": {MessageID: "IMG", Type: chatSvc.TypeImage, Media: &chatSvc.Media{MimeType: "image/jpeg"}},
+		"628123/DOC": {MessageID: "DOC", Type: chatSvc.TypeDocument,
+			Media: &chatSvc.Media{MimeType: "application/pdf", FileName: "invoice.pdf"}},
+		"628123/TXT": {MessageID: "TXT", Type: chatSvc.TypeText, Text: "hello"},
+		"628123/DEL": {MessageID: "DEL", Type: chatSvc.TypeImage, Media: &chatSvc.Media{}, Revoked: true},
+	}}
+	store := NewFileStore(t.TempDir())
+	assert.NoError(t, store.Put(ctx, "628123/IMG", []byte("jpeg")))
+	assert.NoError(t, store.Put(ctx, "628123/DOC", []byte("pdf")))
+
+	s := NewService(storage, nil, nil, store, Config{})
+
+	file, err := s.Get(ctx, "+628123", "IMG")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("jpeg"), file.Data)
+	assert.Equal(t, "image/jpeg", file.MimeType)
+	assert.Contains(t, []string{"IMG.jpg", "IMG.jpeg", "IMG.jpe", "IMG.jfif"}, file.FileName)
+
+	file, err = s.Get(ctx, "628123", "DOC")
+	assert.NoError(t, err)
+	assert.Equal(t, "invoice.pdf", file.FileName)
+
+	_, err = s.Get(ctx, "628123", "TXT")
+	assert.ErrorIs(t, err, ErrMediaNotFound)
+
+	_, err = s.Get(ctx, "628123", "DEL")
+	assert.ErrorIs(t, err, ErrMediaNotFound)
+
+	_, err = s.Get(ctx, "628123", "NONE")
+	assert.ErrorIs(t, err, chatSvc.ErrMessageNotFound)
+}
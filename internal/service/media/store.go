@@ -0,0 +1,81 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound is returned when the blob store has no blob for the key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores the downloaded media, it can be backed by a local directory or by an object storage
+type BlobStore interface {
+	// Get returns the blob of the key, or ErrBlobNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Put stores the blob of the key, replacing any previous blob
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// FileStore is a blob store backed by a local directory, each key is a relative file path
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a blob store in the directory
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{
+		dir: dir,
+	}
+}
+
+// Get reads the file of the key
+func (f *FileStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+
+	return data, err
+}
+
+// Put writes the file of the key, through a temporary file so that a partial file is never read
+func (f *FileStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// path builds the file path of the key, the keys escaping the directory are rejected
+func (f *FileStore) path(key string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(cleaned) || cleaned == ".." ||
+		strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key: %s", key)
+	}
+
+	return filepath.Join(f.dir, cleaned), nil
+}
@@ -0,0 +1,173 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+)
+
+// maxReactionRunes is the maximum length of a reaction, long enough for the composed emojis
+const maxReactionRunes = 10
+
+// ErrEditWindowExpired is returned when the message has been sent too long ago to be edited
+var ErrEditWindowExpired = errors.New("the message can no longer be edited")
+
+// Target is the message a reaction, an edit or a revoke acts on
+type Target struct {
+	// MessageID is the whatsapp ID of the target message
+	MessageID string `json:"message_id"`
+
+	// Sender is the JID of the author of the target message, it is empty when the device sent it
+	Sender string `json:"sender,omitempty"`
+
+	// SentAt is the time the target message has been sent by the device, if known
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// SendActionMessage queues a reaction, an edit or a revoke of an existing message
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendActionMessage(ctx context.Context, msgType string, payload ActionPayload,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	payload.Sanitize()
+	err := payload.Validate(msgType)
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	target, err := s.target(ctx, msgType, payload)
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	draft := OutboundMessage{
+		Phone:  payload.From,
+		To:     payload.Chat,
+		Type:   msgType,
+		Target: &target,
+	}
+	switch msgType {
+	case TypeReaction:
+		draft.Message = payload.Emoji
+	case TypeEdit:
+		draft.Message = payload.Message
+	}
+
+	return s.enqueue(ctx, draft, idempotencyKey)
+}
+
+// target resolves the author of the target message and checks that the action is allowed on it
+func (s *Service) target(ctx context.Context, msgType string, payload ActionPayload) (Target, error) {
+	target := Target{MessageID: payload.MessageID}
+	chat, _ := chatSvc.ParseChatJID(payload.Chat)
+
+	// the messages sent through the queue are known to be sent by the device
+	sent, err := s.storage.GetOutboundMessageByWaMessageID(ctx, payload.From, payload.MessageID)
+	found := err == nil
+	if found && msgType == TypeEdit {
+		if sent.Type != TypeText {
+			return Target{}, fmt.Errorf("only text messages can be edited")
+		}
+		err = checkEditWindow(sent.SentAt)
+		if err != nil {
+			return Target{}, err
+		}
+
+		// the edit may wait in the queue, hence the window is checked again before sending it
+		target.SentAt = sent.SentAt
+	}
+
+	if !found && !payload.FromMe {
+		switch {
+		case payload.Sender != "":
+			sender, _ := chatSvc.ParseUserJID(payload.Sender)
+			if sender.User != payload.From {
+				target.Sender = sender.String()
+			}
+		case chat.Server == types.DefaultUserServer && msgType == TypeReaction:
+			// in a private chat, the message has been sent by the other party
+			target.Sender = chat.String()
+		case msgType == TypeReaction:
+			return Target{}, fmt.Errorf("sender is required to react to a group message not sent by the device")
+		}
+	}
+
+	if target.Sender != "" {
+		switch {
+		case msgType == TypeEdit:
+			return Target{}, fmt.Errorf("only the messages sent by the device can be edited")
+		case msgType == TypeRevoke && chat.Server == types.DefaultUserServer:
+			return Target{}, fmt.Errorf("only the messages sent by the device can be revoked in a private chat")
+		}
+	}
+
+	return target, nil
+}
+
+// checkEditWindow checks that a message sent at the given time can still be edited, an unknown time is not checked
+func checkEditWindow(sentAt *time.Time) error {
+	if sentAt != nil && time.Since(*sentAt) > whatsmeow.EditWindow {
+		return fmt.Errorf("%w, the edit window is %s", ErrEditWindowExpired, whatsmeow.EditWindow)
+	}
+
+	return nil
+}
+
+// actionMessage builds the whatsapp message of a reaction, an edit or a revoke
+func actionMessage(bot *whatsmeow.Client, msg OutboundMessage) (*waProto.Message, error) {
+	if msg.Target == nil {
+		return nil, fmt.Errorf("%s message without any target", msg.Type)
+	}
+
+	chat, err := types.ParseJID(msg.Recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	sender := types.EmptyJID
+	if msg.Target.Sender != "" {
+		sender, err = types.ParseJID(msg.Target.Sender)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch msg.Type {
+	case TypeReaction:
+		return &waProto.Message{ReactionMessage: &waProto.ReactionMessage{
+			Key:               targetKey(chat, sender, msg.Target.MessageID),
+			Text:              proto.String(msg.Message),
+			SenderTimestampMs: proto.Int64(time.Now().UnixMilli()),
+		}}, nil
+	case TypeEdit:
+		return bot.BuildEdit(chat, msg.Target.MessageID, &waProto.Message{
+			Conversation: proto.String(msg.Message),
+		}), nil
+	default:
+		return bot.BuildRevoke(chat, sender, msg.Target.MessageID), nil
+	}
+}
+
+// targetKey builds the key of the target message, an empty sender stands for the device itself
+func targetKey(chat, sender types.JID, id string) *waProto.MessageKey {
+	key := &waProto.MessageKey{
+		FromMe:    proto.Bool(true),
+		Id:        proto.String(id),
+		RemoteJid: proto.String(chat.String()),
+	}
+	if !sender.IsEmpty() {
+		key.FromMe = proto.Bool(false)
+		if chat.Server != types.DefaultUserServer {
+			key.Participant = proto.String(sender.ToNonAD().String())
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,44 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestActionPayloadValidate(t *testing.T) {
+	p := ActionPayload{From: "62811", Chat: "62822@s.whatsapp.net", MessageID: "ABC", Emoji: "👍"}
+	assert.NoError(t, p.Validate(TypeReaction))
+
+	// an empty emoji removes the reaction
+	p.Emoji = ""
+	assert.NoError(t, p.Validate(TypeReaction))
+
+	assert.Error(t, p.Validate(TypeEdit))
+	p.Message = "fixed typo"
+	assert.NoError(t, p.Validate(TypeEdit))
+
+	p.Chat = "status@broadcast"
+	assert.Error(t, p.Validate(TypeRevoke))
+
+	p = ActionPayload{Chat: "62822"}
+	assert.Error(t, p.Validate(TypeRevoke))
+}
+
+func TestTargetKey(t *testing.T) {
+	group := types.NewJID("120363025246125486", types.GroupServer)
+	sender := types.NewJID("62833", types.DefaultUserServer)
+
+	key := targetKey(group, sender, "ABC")
+	assert.False(t, key.GetFromMe())
+	assert.Equal(t, "62833@s.whatsapp.net", key.GetParticipant())
+
+	key = targetKey(group, types.EmptyJID, "ABC")
+	assert.True(t, key.GetFromMe())
+	assert.Empty(t, key.GetParticipant())
+
+	key = targetKey(types.NewJID("62822", types.DefaultUserServer), sender, "ABC")
+	assert.False(t, key.GetFromMe())
+	assert.Empty(t, key.GetParticipant())
+}
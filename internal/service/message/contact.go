@@ -0,0 +1,97 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ContactCard is a contact to be shared, given either as a raw vCard or as structured fields
+type ContactCard struct {
+	// VCard is the raw vCard, the other fields are ignored when it is set
+	VCard string `json:"vcard,omitempty"`
+
+	FullName     string   `json:"full_name,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	Phones       []string `json:"phones,omitempty"`
+	Email        string   `json:"email,omitempty"`
+}
+
+// Contact is a shared contact of an outbound message
+type Contact struct {
+	DisplayName string `json:"display_name"`
+	VCard       string `json:"vcard"`
+}
+
+// Validate validates the contact card
+func (c *ContactCard) Validate() error {
+	if c.VCard != "" {
+		if !strings.HasPrefix(strings.TrimSpace(c.VCard), "BEGIN:VCARD") {
+			return fmt.Errorf("vcard must start with BEGIN:VCARD")
+		}
+		return nil
+	}
+
+	if c.FullName == "" {
+		return fmt.Errorf("full_name is required")
+	}
+	if len(c.Phones) == 0 {
+		return fmt.Errorf("at least one phone is required")
+	}
+	for _, phone := range c.Phones {
+		digits := vCardPhone(phone)
+		if digits == "" || strings.Trim(digits, "0123456789") != "" {
+			return fmt.Errorf("invalid phone: %s", phone)
+		}
+	}
+
+	return nil
+}
+
+// ToContact builds the vCard of the contact card if needed, along with its display name
+func (c *ContactCard) ToContact() Contact {
+	if c.VCard != "" {
+		return Contact{DisplayName: vCardFullName(c.VCard), VCard: c.VCard}
+	}
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	b.WriteString("FN:" + escapeVCard(c.FullName) + "\n")
+	if c.Organization != "" {
+		b.WriteString("ORG:" + escapeVCard(c.Organization) + ";\n")
+	}
+	for _, phone := range c.Phones {
+		// the waid parameter lets whatsapp link the contact to its account
+		digits := vCardPhone(phone)
+		b.WriteString(fmt.Sprintf("TEL;type=CELL;type=VOICE;waid=%s:+%s\n", digits, digits))
+	}
+	if c.Email != "" {
+		b.WriteString("EMAIL:" + escapeVCard(c.Email) + "\n")
+	}
+	b.WriteString("END:VCARD")
+
+	return Contact{DisplayName: c.FullName, VCard: b.String()}
+}
+
+// vCardPhone strips the spaces and the `+` symbol of the phone of a contact card
+func vCardPhone(phone string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(phone, " ", ""), "+")
+}
+
+// vCardFullName extracts the formatted name of a vCard
+func vCardFullName(vCard string) string {
+	for _, line := range strings.Split(vCard, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(strings.ToUpper(line), "FN:") || strings.HasPrefix(strings.ToUpper(line), "FN;") {
+			if i := strings.Index(line, ":"); i >= 0 {
+				return line[i+1:]
+			}
+		}
+	}
+
+	return ""
+}
+
+// escapeVCard escapes the special characters of a vCard value
+func escapeVCard(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,32 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContactCardToContact(t *testing.T) {
+	card := ContactCard{FullName: "Doe, John", Organization: "ACME", Phones: []string{"+62 811"}}
+	assert.NoError(t, card.Validate())
+
+	c := card.ToContact()
+	assert.Equal(t, "Doe, John", c.DisplayName)
+	assert.Contains(t, c.VCard, "FN:Doe\\, John\n")
+	assert.Contains(t, c.VCard, "TEL;type=CELL;type=VOICE;waid=62811:+62811\n")
+
+	raw := ContactCard{VCard: "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Jane\r\nEND:VCARD"}
+	assert.NoError(t, raw.Validate())
+	assert.Equal(t, "Jane", raw.ToContact().DisplayName)
+}
+
+func TestContactCardValidate(t *testing.T) {
+	assert.Error(t, (&ContactCard{VCard: "FN:Jane"}).Validate())
+	assert.Error(t, (&ContactCard{FullName: "Jane"}).Validate())
+	assert.Error(t, (&ContactCard{Phones: []string{"62811"}}).Validate())
+
+	// the phones are written as they are into the vCard
+	assert.Error(t, (&ContactCard{FullName: "Jane", Phones: []string{"08-123"}}).Validate())
+	assert.Error(t, (&ContactCard{FullName: "Jane", Phones: []string{"62811\nEMAIL:x@example.com"}}).Validate())
+	assert.Error(t, (&ContactCard{FullName: "Jane", Phones: []string{"+"}}).Validate())
+}
@@ -0,0 +1,170 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+)
+
+// ReplyTo is the message quoted by an outbound message
+type ReplyTo struct {
+	// MessageID is the whatsapp ID of the quoted message
+	MessageID string `json:"message_id"`
+
+	// Sender is the phone or JID of the author of the quoted message,
+	// it is required in groups and defaults to the recipient otherwise
+	Sender string `json:"sender,omitempty"`
+
+	// FromMe is true when the quoted message has been sent by the device itself
+	FromMe bool `json:"from_me,omitempty"`
+
+	// Text is the quoted content shown above the reply,
+	// it is filled in when the quoted message has been sent through the queue
+	Text string `json:"text,omitempty"`
+}
+
+// MessageContext holds the optional quote and mentions of a text or media message
+type MessageContext struct {
+	ReplyTo  *ReplyTo `json:"reply_to,omitempty"`
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+// Validate validates the quote and the mentions
+func (c *MessageContext) Validate() error {
+	if c.ReplyTo != nil {
+		if c.ReplyTo.MessageID == "" {
+			return fmt.Errorf("reply_to.message_id is required")
+		}
+		if c.ReplyTo.Sender != "" {
+			if _, err := chatSvc.ParseUserJID(c.ReplyTo.Sender); err != nil {
+				return fmt.Errorf("reply_to.sender: %w", err)
+			}
+		}
+	}
+
+	for i, mention := range c.Mentions {
+		if _, err := chatSvc.ParseUserJID(mention); err != nil {
+			return fmt.Errorf("mentions[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMessageContext sets the quote and the mentions on the draft, the mentions are tagged in the text if missing
+func (s *Service) applyMessageContext(ctx context.Context, draft *OutboundMessage, msgCtx MessageContext) {
+	if msgCtx.ReplyTo != nil {
+		reply := *msgCtx.ReplyTo
+		if reply.Sender != "" {
+			sender, _ := chatSvc.ParseUserJID(reply.Sender)
+			reply.Sender = sender.String()
+		}
+
+		// quotes the content of the messages sent by the device itself
+		if reply.Text == "" {
+			quoted, err := s.storage.GetOutboundMessageByWaMessageID(ctx, draft.Phone, reply.MessageID)
+			if err == nil {
+				reply.FromMe = true
+				reply.Text = quoted.Message
+				if reply.Text == "" {
+					reply.Text = quoted.Caption
+				}
+			}
+		}
+
+		draft.ReplyTo = &reply
+	}
+
+	if len(msgCtx.Mentions) == 0 {
+		return
+	}
+
+	draft.Mentions = make([]string, 0, len(msgCtx.Mentions))
+	for _, mention := range msgCtx.Mentions {
+		jid, _ := chatSvc.ParseUserJID(mention)
+		draft.Mentions = append(draft.Mentions, jid.String())
+	}
+
+	if draft.Type == TypeText {
+		draft.Message = tagMentions(draft.Message, draft.Mentions)
+	} else {
+		draft.Caption = tagMentions(draft.Caption, draft.Mentions)
+	}
+}
+
+// tagMentions appends the @ tags of the mentioned users missing from the text,
+// whatsapp only highlights the mentions tagged in the text
+func tagMentions(text string, mentions []string) string {
+	for _, mention := range mentions {
+		tag := "@" + strings.SplitN(mention, "@", 2)[0]
+		if strings.Contains(text, tag) {
+			continue
+		}
+
+		if text != "" {
+			text += " "
+		}
+		text += tag
+	}
+
+	return text
+}
+
+// contextInfo builds the quote and mentions of the whatsapp message, or nil if there is none
+func contextInfo(msg OutboundMessage, recipient, own types.JID) *waProto.ContextInfo {
+	if msg.ReplyTo == nil && len(msg.Mentions) == 0 {
+		return nil
+	}
+
+	info := &waProto.ContextInfo{}
+	if len(msg.Mentions) > 0 {
+		info.MentionedJid = msg.Mentions
+	}
+
+	if msg.ReplyTo != nil {
+		participant := msg.ReplyTo.Sender
+		if participant == "" {
+			participant = recipient.ToNonAD().String()
+			if msg.ReplyTo.FromMe {
+				participant = own.ToNonAD().String()
+			}
+		}
+
+		info.StanzaId = proto.String(msg.ReplyTo.MessageID)
+		info.Participant = proto.String(participant)
+		info.QuotedMessage = &waProto.Message{Conversation: proto.String(msg.ReplyTo.Text)}
+	}
+
+	return info
+}
+
+// setContextInfo sets the quote and mentions on the whatsapp message,
+// a plain conversation is turned into an extended text message which is able to carry them
+func setContextInfo(waMsg *waProto.Message, info *waProto.ContextInfo) {
+	if info == nil {
+		return
+	}
+
+	switch {
+	case waMsg.Conversation != nil:
+		waMsg.ExtendedTextMessage = &waProto.ExtendedTextMessage{
+			Text:        waMsg.Conversation,
+			ContextInfo: info,
+		}
+		waMsg.Conversation = nil
+	case waMsg.ImageMessage != nil:
+		waMsg.ImageMessage.ContextInfo = info
+	case waMsg.VideoMessage != nil:
+		waMsg.VideoMessage.ContextInfo = info
+	case waMsg.AudioMessage != nil:
+		waMsg.AudioMessage.ContextInfo = info
+	case waMsg.DocumentMessage != nil:
+		waMsg.DocumentMessage.ContextInfo = info
+	}
+}
@@ -0,0 +1,43 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTagMentions(t *testing.T) {
+	mentions := []string{"62811@s.whatsapp.net", "62822@s.whatsapp.net"}
+
+	assert.Equal(t, "hi @62811 @62822", tagMentions("hi @62811", mentions))
+	assert.Equal(t, "@62811 @62822", tagMentions("", mentions))
+}
+
+func TestSetContextInfo(t *testing.T) {
+	recipient := types.NewJID("62822", types.DefaultUserServer)
+	own := types.NewJID("62811", types.DefaultUserServer)
+	msg := OutboundMessage{
+		Type:     TypeText,
+		ReplyTo:  &ReplyTo{MessageID: "ABC", Text: "hello"},
+		Mentions: []string{"62833@s.whatsapp.net"},
+	}
+
+	waMsg := &waProto.Message{Conversation: proto.String("hi @62833")}
+	setContextInfo(waMsg, contextInfo(msg, recipient, own))
+
+	assert.Nil(t, waMsg.Conversation)
+	assert.Equal(t, "hi @62833", waMsg.GetExtendedTextMessage().GetText())
+	info := waMsg.GetExtendedTextMessage().GetContextInfo()
+	assert.Equal(t, "ABC", info.GetStanzaId())
+	assert.Equal(t, "62822@s.whatsapp.net", info.GetParticipant())
+	assert.Equal(t, "hello", info.GetQuotedMessage().GetConversation())
+	assert.Equal(t, []string{"62833@s.whatsapp.net"}, info.GetMentionedJid())
+
+	msg.ReplyTo.FromMe = true
+	assert.Equal(t, "62811@s.whatsapp.net", contextInfo(msg, recipient, own).GetParticipant())
+
+	assert.Nil(t, contextInfo(OutboundMessage{Type: TypeText}, recipient, own))
+}
@@ -0,0 +1,113 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// linkKeyAttempts is the number of attempts to link the idempotency key to its queued message
+	linkKeyAttempts = 3
+
+	// linkKeyDelay is the delay between two attempts to link the idempotency key
+	linkKeyDelay = 100 * time.Millisecond
+)
+
+var (
+	// ErrIdempotencyKeyExists is returned by the storage when the key has been used by the device
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+
+	// ErrIdempotencyKeyInProgress is returned when an earlier request with the same key is still being processed
+	ErrIdempotencyKeyInProgress = errors.New("a request with the same idempotency key is in progress")
+)
+
+// IdempotencyKey links a client supplied key to the message queued by the first request using it
+type IdempotencyKey struct {
+	Phone     string
+	Key       string
+	MessageID string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// reserveIdempotencyKey claims the key for the device, an empty key claims nothing.
+// If the key has been used already, it returns the message queued by the earlier request
+func (s *Service) reserveIdempotencyKey(ctx context.Context, phone, key string) (*OutboundMessage, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	doc := IdempotencyKey{
+		Phone:     phone,
+		Key:       key,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.idempotencyTTL),
+	}
+
+	err := s.storage.InsertIdempotencyKey(ctx, doc)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, ErrIdempotencyKeyExists) {
+		return nil, err
+	}
+
+	existing, err := s.storage.GetIdempotencyKey(ctx, phone, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// mongo removes the expired keys periodically, an expired key that is still there is reused
+	if existing.ExpiresAt.Before(now) {
+		err = s.storage.DeleteIdempotencyKey(ctx, phone, key)
+		if err != nil {
+			return nil, err
+		}
+		return s.reserveIdempotencyKey(ctx, phone, key)
+	}
+
+	if existing.MessageID == "" {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	msg, err := s.storage.GetOutboundMessageByID(ctx, existing.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// linkIdempotencyKey links the key to the queued message, it is retried since an unlinked key answers
+// ErrIdempotencyKeyInProgress until it expires. The message is queued already, hence the request context is not used
+func (s *Service) linkIdempotencyKey(phone, key, messageID string) error {
+	var err error
+	for attempt := 1; attempt <= linkKeyAttempts; attempt++ {
+		err = s.storage.SetIdempotencyKeyMessage(context.Background(), phone, key, messageID)
+		if err == nil {
+			return nil
+		}
+		if attempt < linkKeyAttempts {
+			time.Sleep(linkKeyDelay)
+		}
+	}
+
+	return err
+}
+
+// releaseIdempotencyKey removes the key of a request which has not queued any message, so that the client can retry
+func (s *Service) releaseIdempotencyKey(phone, key string) {
+	if key == "" {
+		return
+	}
+
+	err := s.storage.DeleteIdempotencyKey(context.Background(), phone, key)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to release idempotency key [%s]", key), zap.Error(err))
+	}
+}
@@ -0,0 +1,82 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyKeyStorage fails to link the idempotency keys a number of times
+type flakyKeyStorage struct {
+	storage
+
+	failures int
+	linked   map[string]string
+}
+
+func (f *flakyKeyStorage) SetIdempotencyKeyMessage(_ context.Context, _, key, messageID string) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection reset")
+	}
+	f.linked[key] = messageID
+
+	return nil
+}
+
+func TestLinkIdempotencyKey(t *testing.T) {
+	storage := &flakyKeyStorage{failures: linkKeyAttempts - 1, linked: make(map[string]string)}
+	s := &Service{storage: storage}
+
+	assert.NoError(t, s.linkIdempotencyKey("628123", "k-1", "msg-1"))
+	assert.Equal(t, "msg-1", storage.linked["k-1"])
+
+	// the caller is told once the key cannot be linked
+	storage.failures = linkKeyAttempts
+	assert.Error(t, s.linkIdempotencyKey("628123", "k-2", "msg-2"))
+	assert.Empty(t, storage.linked["k-2"])
+}
+
+// usedKeyStorage holds the key of a message queued by an earlier request
+type usedKeyStorage struct {
+	storage
+
+	msg OutboundMessage
+}
+
+func (f *usedKeyStorage) InsertIdempotencyKey(_ context.Context, _ IdempotencyKey) error {
+	return ErrIdempotencyKeyExists
+}
+
+func (f *usedKeyStorage) GetIdempotencyKey(_ context.Context, phone, key string) (IdempotencyKey, error) {
+	return IdempotencyKey{Phone: phone, Key: key, MessageID: f.msg.ID, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (f *usedKeyStorage) GetOutboundMessageByID(_ context.Context, _ string) (OutboundMessage, error) {
+	return f.msg, nil
+}
+
+func TestEnqueueMediaReplayed(t *testing.T) {
+	var fetched int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		_, _ = w.Write(pdfContent)
+	}))
+	defer srv.Close()
+
+	s := newMediaTestService(t, 1024)
+	s.storage = &usedKeyStorage{msg: OutboundMessage{ID: "msg-1", Status: StatusSent}}
+
+	// the replayed request neither fetches the media again nor stores it
+	msg, replayed, err := s.enqueueMedia(context.Background(), OutboundMessage{Phone: "628123", Type: TypeDocument},
+		MediaSource{URL: srv.URL + "/invoice.pdf"}, "k-1")
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, "msg-1", msg.ID)
+	assert.Zero(t, fetched)
+}
@@ -0,0 +1,289 @@
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrMediaTooLarge is returned when the media exceeds the maximum size
+	ErrMediaTooLarge = errors.New("media exceeds the maximum size")
+
+	// ErrMediaTypeMismatch is returned when the sniffed content does not match the message type
+	ErrMediaTypeMismatch = errors.New("media content does not match the message type")
+
+	// ErrMediaAddressNotAllowed is returned when the media URL leads to a loopback, private or link-local address
+	ErrMediaAddressNotAllowed = errors.New("media url must lead to a public address")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routed on the internet
+var sharedAddressSpace = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// MediaSource is the content of a media message given on the request,
+// it is used instead of a file that already exists on the server
+type MediaSource struct {
+	// Base64 is the base64 encoded content, a data URL is accepted as well
+	Base64 string `json:"base64"`
+
+	// URL is the HTTP(S) URL to download the content from
+	URL string `json:"url"`
+
+	// Upload is the content of a multipart file upload
+	Upload io.Reader `json:"-"`
+
+	// UploadName is the original name of the uploaded file
+	UploadName string `json:"-"`
+}
+
+// IsEmpty reports whether no content has been given
+func (m MediaSource) IsEmpty() bool {
+	return m.Base64 == "" && m.URL == "" && m.Upload == nil
+}
+
+// count returns the number of contents given
+func (m MediaSource) count() int {
+	total := 0
+	for _, given := range []bool{m.Base64 != "", m.URL != "", m.Upload != nil} {
+		if given {
+			total++
+		}
+	}
+
+	return total
+}
+
+// storedMedia describes the media saved from a MediaSource
+type storedMedia struct {
+	FileName     string
+	OriginalName string
+	MimeType     string
+}
+
+// storeMedia saves the given content into the directory of the message type
+func (s *Service) storeMedia(ctx context.Context, msgType string, src MediaSource) (storedMedia, error) {
+	if src.count() > 1 {
+		return storedMedia{}, fmt.Errorf("only one of base64, url or file upload can be given")
+	}
+
+	data, name, err := s.readMedia(ctx, src)
+	if err != nil {
+		return storedMedia{}, err
+	}
+
+	// sniffs the content, the mimetype declared by the client or the remote server is not trusted
+	mimeType := http.DetectContentType(data)
+	if !mimeTypeAllowed(msgType, mimeType) {
+		return storedMedia{}, fmt.Errorf("%w: got %s for a %s message", ErrMediaTypeMismatch, mimeType, msgType)
+	}
+
+	fileName, err := randomFileName(name, mimeType)
+	if err != nil {
+		return storedMedia{}, err
+	}
+
+	dir := s.queue.cfg.MediaDir
+	if msgType == TypeImage {
+		dir = s.queue.cfg.ImageDir
+	}
+
+	err = os.WriteFile(filepath.Join(dir, fileName), data, 0o644)
+	if err != nil {
+		return storedMedia{}, fmt.Errorf("failed to store media: %w", err)
+	}
+
+	return storedMedia{FileName: fileName, OriginalName: name, MimeType: mimeType}, nil
+}
+
+// removeMedia deletes a stored file that is not used by any message
+func (q *Queue) removeMedia(msgType, fileName string) {
+	dir := q.cfg.MediaDir
+	if msgType == TypeImage {
+		dir = q.cfg.ImageDir
+	}
+
+	_ = os.Remove(filepath.Join(dir, fileName))
+}
+
+// readMedia reads the content from the source, up to the maximum size.
+// It also returns the original file name, if known
+func (s *Service) readMedia(ctx context.Context, src MediaSource) ([]byte, string, error) {
+	switch {
+	case src.Upload != nil:
+		data, err := readLimited(src.Upload, s.maxMediaSize)
+		return data, src.UploadName, err
+	case src.Base64 != "":
+		return s.decodeBase64Media(src.Base64)
+	case src.URL != "":
+		return s.downloadMedia(ctx, src.URL)
+	default:
+		return nil, "", fmt.Errorf("no media content given")
+	}
+}
+
+// decodeBase64Media decodes the base64 content, with or without the data URL prefix
+func (s *Service) decodeBase64Media(encoded string) ([]byte, string, error) {
+	if strings.HasPrefix(encoded, "data:") {
+		i := strings.Index(encoded, ",")
+		if i < 0 {
+			return nil, "", fmt.Errorf("invalid data URL")
+		}
+		encoded = encoded[i+1:]
+	}
+
+	// rejects early without decoding the whole content
+	if int64(base64.StdEncoding.DecodedLen(len(encoded))) > s.maxMediaSize+2 {
+		return nil, "", ErrMediaTooLarge
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64 content: %w", err)
+	}
+	if int64(len(data)) > s.maxMediaSize {
+		return nil, "", ErrMediaTooLarge
+	}
+
+	return data, "", nil
+}
+
+// downloadMedia fetches the content from the HTTP(S) URL
+func (s *Service) downloadMedia(ctx context.Context, rawUrl string) ([]byte, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", fmt.Errorf("url must be a valid HTTP(S) URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", fmt.Errorf("failed to download media: remote server answered %s", resp.Status)
+	}
+	if resp.ContentLength > s.maxMediaSize {
+		return nil, "", ErrMediaTooLarge
+	}
+
+	data, err := readLimited(resp.Body, s.maxMediaSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, filepath.Base(u.Path), nil
+}
+
+// mediaClient returns a copy of the client which only connects to public addresses.
+// The address is checked once resolved and on each redirect, hence a DNS name cannot point to the internal network
+func mediaClient(client *http.Client) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if t, ok := client.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicAddressOnly,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.DialTLSContext = nil
+
+	// a proxy would connect on our behalf, out of reach of the check
+	transport.Proxy = nil
+
+	guarded := *client
+	guarded.Transport = transport
+
+	return &guarded
+}
+
+// publicAddressOnly rejects the connections to a non-public address, it is called with the resolved address
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrMediaAddressNotAllowed, host)
+	}
+
+	return nil
+}
+
+// isPublicIP reports whether the address is routed on the internet
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// readLimited reads the whole reader, or fails if it is larger than max
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media: %w", err)
+	}
+	if int64(len(data)) > max {
+		return nil, ErrMediaTooLarge
+	}
+
+	return data, nil
+}
+
+// mimeTypeAllowed reports whether the sniffed mimetype fits the message type
+func mimeTypeAllowed(msgType, mimeType string) bool {
+	switch msgType {
+	case TypeImage:
+		return strings.HasPrefix(mimeType, "image/")
+	case TypeVideo:
+		return strings.HasPrefix(mimeType, "video/")
+	case TypeAudio:
+		return strings.HasPrefix(mimeType, "audio/") || mimeType == "application/ogg"
+	default:
+		// any content can be sent as a document
+		return true
+	}
+}
+
+// randomFileName builds a unique file name, keeping the extension of the original name if any
+func randomFileName(original, mimeType string) (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(original))
+	if strings.Trim(strings.TrimPrefix(ext, "."), "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+		ext = ""
+	}
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	return hex.EncodeToString(b) + ext, nil
+}
@@ -0,0 +1,117 @@
+package message
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pdfContent is the beginning of a PDF file, enough to be sniffed
+var pdfContent = []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+func newMediaTestService(t *testing.T, maxSize int64) *Service {
+	return &Service{
+		queue:        &Queue{cfg: QueueConfig{ImageDir: t.TempDir(), MediaDir: t.TempDir()}},
+		httpClient:   http.DefaultClient,
+		maxMediaSize: maxSize,
+	}
+}
+
+func TestStoreMediaBase64(t *testing.T) {
+	s := newMediaTestService(t, 1024)
+
+	encoded := "data:application/pdf;base64," + base64.StdEncoding.EncodeToString(pdfContent)
+	stored, err := s.storeMedia(context.Background(), TypeDocument, MediaSource{Base64: encoded})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/pdf", stored.MimeType)
+	assert.True(t, strings.HasSuffix(stored.FileName, ".pdf"))
+
+	data, err := os.ReadFile(filepath.Join(s.queue.cfg.MediaDir, stored.FileName))
+	assert.NoError(t, err)
+	assert.Equal(t, pdfContent, data)
+
+	// the sniffed content must fit the message type
+	_, err = s.storeMedia(context.Background(), TypeVideo, MediaSource{Base64: encoded})
+	assert.ErrorIs(t, err, ErrMediaTypeMismatch)
+}
+
+func TestStoreMediaURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(pdfContent)
+	}))
+	defer srv.Close()
+
+	s := newMediaTestService(t, 1024)
+	stored, err := s.storeMedia(context.Background(), TypeDocument, MediaSource{URL: srv.URL + "/files/invoice.pdf"})
+	assert.NoError(t, err)
+	assert.Equal(t, "invoice.pdf", stored.OriginalName)
+
+	_, err = s.storeMedia(context.Background(), TypeDocument, MediaSource{URL: "file:///etc/passwd"})
+	assert.Error(t, err)
+}
+
+func TestStoreMediaURLRejectsInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(pdfContent)
+	}))
+	defer srv.Close()
+
+	s := newMediaTestService(t, 1024)
+	s.httpClient = mediaClient(http.DefaultClient)
+
+	_, err := s.storeMedia(context.Background(), TypeDocument, MediaSource{URL: srv.URL + "/files/invoice.pdf"})
+	assert.ErrorIs(t, err, ErrMediaAddressNotAllowed)
+
+	// the name is resolved before being checked
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	_, err = s.storeMedia(context.Background(), TypeDocument, MediaSource{URL: localhost + "/files/invoice.pdf"})
+	assert.ErrorIs(t, err, ErrMediaAddressNotAllowed)
+
+	for addr, public := range map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		assert.Equal(t, public, isPublicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestStoreMediaTooLarge(t *testing.T) {
+	s := newMediaTestService(t, 8)
+
+	_, err := s.storeMedia(context.Background(), TypeDocument, MediaSource{Upload: strings.NewReader(string(pdfContent))})
+	assert.ErrorIs(t, err, ErrMediaTooLarge)
+
+	_, err = s.storeMedia(context.Background(), TypeDocument,
+		MediaSource{Base64: base64.StdEncoding.EncodeToString(pdfContent)})
+	assert.ErrorIs(t, err, ErrMediaTooLarge)
+}
+
+func TestRandomFileName(t *testing.T) {
+	name, err := randomFileName("Report.PDF", "application/pdf")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(name, ".pdf"))
+
+	// an odd extension is replaced by the one of the mimetype
+	name, err = randomFileName("x.p$f", "application/pdf")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(name, ".pdf"))
+}
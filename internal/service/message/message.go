@@ -0,0 +1,492 @@
+// Package message provides the durable outbound message queue
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// TypeText is a plain text message
+	TypeText = "text"
+
+	// TypeImage is an image message with an optional caption
+	TypeImage = "image"
+
+	// TypeVideo is a video message with an optional caption
+	TypeVideo = "video"
+
+	// TypeAudio is an audio message, sent as a voice note when PTT is set
+	TypeAudio = "audio"
+
+	// TypeDocument is a document message with an optional caption
+	TypeDocument = "document"
+
+	// TypeLocation is a static or live location message
+	TypeLocation = "location"
+
+	// TypeContact is a message sharing one or more contact cards
+	TypeContact = "contact"
+
+	// TypeReaction adds, or removes, an emoji reaction on an existing message
+	TypeReaction = "reaction"
+
+	// TypeEdit replaces the text of a message sent by the device
+	TypeEdit = "edit"
+
+	// TypeRevoke deletes an existing message for everyone
+	TypeRevoke = "revoke"
+)
+
+const (
+	// StatusQueued means that the message is waiting to be sent
+	StatusQueued = "QUEUED"
+
+	// StatusSending means that a worker is sending the message
+	StatusSending = "SENDING"
+
+	// StatusSent means that the message has been accepted by the Whatsapp server
+	StatusSent = "SENT"
+
+	// StatusFailed means that the message could not be sent after all attempts
+	StatusFailed = "FAILED"
+
+	// StatusDelivered means that the message has been delivered to the recipient device
+	StatusDelivered = "DELIVERED"
+
+	// StatusRead means that the message has been read by the recipient
+	StatusRead = "READ"
+)
+
+// ErrWaitTimeout is returned when the message has not been sent before the wait timeout
+var ErrWaitTimeout = errors.New("timed out waiting for the message to be sent")
+
+// OutboundMessage is the outbound message object
+type OutboundMessage struct {
+	ID            string     `json:"_id,omitempty"`
+	Phone         string     `json:"phone"`
+	To            string     `json:"to"`
+	Recipient     string     `json:"recipient"`
+	Type          string     `json:"type"`
+	Message       string     `json:"message,omitempty"`
+	FileName      string     `json:"file_name,omitempty"`
+	Caption       string     `json:"caption,omitempty"`
+	MimeType      string     `json:"mimetype,omitempty"`
+	DocumentName  string     `json:"document_name,omitempty"`
+	PTT           bool       `json:"ptt,omitempty"`
+	StoredMedia   bool       `json:"stored_media,omitempty"`
+	Location      *Location  `json:"location,omitempty"`
+	Contacts      []Contact  `json:"contacts,omitempty"`
+	ReplyTo       *ReplyTo   `json:"reply_to,omitempty"`
+	Mentions      []string   `json:"mentions,omitempty"`
+	Target        *Target    `json:"target,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	WaMessageID   string     `json:"wa_message_id,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Location is the shared location of an outbound message
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Live      bool    `json:"live,omitempty"`
+	Caption   string  `json:"caption,omitempty"`
+}
+
+// storage provides the interface for outbound message related operations
+type storage interface {
+	InsertOutboundMessage(ctx context.Context, doc OutboundMessage) (OutboundMessage, error)
+	GetOutboundMessageByID(ctx context.Context, id string) (OutboundMessage, error)
+	GetOutboundMessageByWaMessageID(ctx context.Context, phone, waMessageID string) (OutboundMessage, error)
+	ClaimOutboundMessage(ctx context.Context, phone string) (OutboundMessage, bool, error)
+	ReleaseOutboundMessage(ctx context.Context, id string, nextAttemptAt time.Time) error
+	RetryOutboundMessage(ctx context.Context, id, lastError string, nextAttemptAt time.Time) error
+	FailOutboundMessage(ctx context.Context, id, lastError string) error
+	MarkOutboundMessageSent(ctx context.Context, id, waMessageID string, sentAt time.Time) error
+	UpdateOutboundMessageReceipt(ctx context.Context, phone, waMessageID, status string, from []string) error
+	ResetSendingOutboundMessages(ctx context.Context) (int64, error)
+	InsertIdempotencyKey(ctx context.Context, doc IdempotencyKey) error
+	GetIdempotencyKey(ctx context.Context, phone, key string) (IdempotencyKey, error)
+	SetIdempotencyKeyMessage(ctx context.Context, phone, key, messageID string) error
+	DeleteIdempotencyKey(ctx context.Context, phone, key string) error
+	GetDeviceByPhone(ctx context.Context, phone string) (deviceSvc.Device, error)
+}
+
+// Service prepares the interfaces related with this message service
+type Service struct {
+	storage        storage
+	log            *logger.Logger
+	BotClients     *sessionSvc.Registry
+	queue          *Queue
+	httpClient     *http.Client
+	idempotencyTTL time.Duration
+	maxMediaSize   int64
+}
+
+// ServiceConfig sets up the optional behaviours of the message service
+type ServiceConfig struct {
+	// IdempotencyTTL is how long an idempotency key is remembered
+	IdempotencyTTL time.Duration
+
+	// MaxMediaSize is the maximum size in bytes of the media given on the request
+	MaxMediaSize int64
+}
+
+// NewService creates a message service
+func NewService(storage storage, log *logger.Logger, registry *sessionSvc.Registry, queue *Queue,
+	httpClient *http.Client, cfg ServiceConfig) *Service {
+	return &Service{
+		storage:        storage,
+		log:            log,
+		BotClients:     registry,
+		queue:          queue,
+		httpClient:     mediaClient(httpClient),
+		idempotencyTTL: cfg.IdempotencyTTL,
+		maxMediaSize:   cfg.MaxMediaSize,
+	}
+}
+
+// GetMessage extracts outbound message data based on the ID
+func (s *Service) GetMessage(ctx context.Context, id string) (OutboundMessage, error) {
+	return s.storage.GetOutboundMessageByID(ctx, id)
+}
+
+// IsFinal reports whether the message has left the queue, either sent or failed
+func (m OutboundMessage) IsFinal() bool {
+	return m.Status != StatusQueued && m.Status != StatusSending
+}
+
+// SendTextMessage queues a text message, optionally quoting a message and mentioning users
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendTextMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx MessageContext,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	sanitizeMessagePayload(&payload)
+	err := payload.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+	err = msgCtx.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	draft := OutboundMessage{
+		Phone:   payload.From,
+		To:      payload.To,
+		Type:    TypeText,
+		Message: payload.Message,
+	}
+	s.applyMessageContext(ctx, &draft, msgCtx)
+
+	return s.enqueue(ctx, draft, idempotencyKey)
+}
+
+// SendImageMessage queues an image-based message, optionally quoting a message and mentioning users
+// the image is either an existing file of the image directory or the given source
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendImageMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx MessageContext,
+	src MediaSource, idempotencyKey string) (OutboundMessage, bool, error) {
+	sanitizeMessagePayload(&payload)
+	err := payload.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+	err = msgCtx.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	// the file must be inside the image directory
+	if payload.ImageFileName != "" && filepath.Base(payload.ImageFileName) != payload.ImageFileName {
+		return OutboundMessage{}, false, fmt.Errorf("image_file_name must not contain a path")
+	}
+
+	draft := OutboundMessage{
+		Phone:    payload.From,
+		To:       payload.To,
+		Type:     TypeImage,
+		FileName: payload.ImageFileName,
+		Caption:  payload.ImageCaption,
+	}
+	s.applyMessageContext(ctx, &draft, msgCtx)
+
+	return s.enqueueMedia(ctx, draft, src, idempotencyKey)
+}
+
+// SendMediaMessage queues a video, audio or document message
+// the media is either an existing file of the media directory or the given source
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendMediaMessage(ctx context.Context, msgType string, payload MediaPayload, src MediaSource,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	payload.Sanitize()
+	err := payload.Validate(msgType)
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	draft := payload.toOutboundMessage(msgType)
+	s.applyMessageContext(ctx, &draft, payload.MessageContext)
+
+	return s.enqueueMedia(ctx, draft, src, idempotencyKey)
+}
+
+// enqueueMedia stores the given media, if any, and queues the message
+func (s *Service) enqueueMedia(ctx context.Context, draft OutboundMessage, src MediaSource,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	if src.IsEmpty() {
+		if draft.FileName == "" {
+			return OutboundMessage{}, false, fmt.Errorf("a file name, base64 content, url or file upload is required")
+		}
+
+		return s.enqueue(ctx, draft, idempotencyKey)
+	}
+
+	if draft.FileName != "" {
+		return OutboundMessage{}, false, fmt.Errorf("a file name cannot be given along with the media content")
+	}
+
+	// the key is checked first, a replayed request neither downloads nor stores the media again
+	original, err := s.reserveIdempotencyKey(ctx, draft.Phone, idempotencyKey)
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+	if original != nil {
+		return *original, true, nil
+	}
+
+	stored, err := s.storeMedia(ctx, draft.Type, src)
+	if err != nil {
+		s.releaseIdempotencyKey(draft.Phone, idempotencyKey)
+		return OutboundMessage{}, false, err
+	}
+
+	draft.FileName = stored.FileName
+	draft.StoredMedia = true
+
+	// the mimetype declared by the client is only trusted for the existing files, not for the checked content
+	draft.MimeType = sendMimeType(draft.Type, stored.MimeType)
+	if draft.Type == TypeDocument && draft.DocumentName == "" {
+		// shows the original name to the recipient rather than the generated one
+		draft.DocumentName = stored.OriginalName
+		if draft.DocumentName == "" || draft.DocumentName == "/" || draft.DocumentName == "." {
+			draft.DocumentName = stored.FileName
+		}
+	}
+
+	msg, err := s.enqueueReserved(ctx, draft, idempotencyKey)
+
+	// the stored file is not used when the message has not been queued
+	if msg.ID == "" {
+		s.queue.removeMedia(draft.Type, stored.FileName)
+	}
+
+	return msg, false, err
+}
+
+// SendLocationMessage queues a static or live location message
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendLocationMessage(ctx context.Context, payload LocationPayload,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	payload.Sanitize()
+	err := payload.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	return s.enqueue(ctx, OutboundMessage{
+		Phone: payload.From,
+		To:    payload.To,
+		Type:  TypeLocation,
+		Location: &Location{
+			Latitude:  payload.Latitude,
+			Longitude: payload.Longitude,
+			Name:      payload.Name,
+			Address:   payload.Address,
+			Live:      payload.Live,
+			Caption:   payload.Caption,
+		},
+	}, idempotencyKey)
+}
+
+// SendContactMessage queues a message sharing one or more contact cards
+// the boolean reports whether the message has been queued by an earlier request with the same idempotency key
+func (s *Service) SendContactMessage(ctx context.Context, payload ContactPayload,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	payload.Sanitize()
+	err := payload.Validate()
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+
+	contacts := make([]Contact, len(payload.Contacts))
+	for i, card := range payload.Contacts {
+		contacts[i] = card.ToContact()
+	}
+
+	return s.enqueue(ctx, OutboundMessage{
+		Phone:    payload.From,
+		To:       payload.To,
+		Type:     TypeContact,
+		Contacts: contacts,
+	}, idempotencyKey)
+}
+
+// WaitForMessage blocks until the queued message has been sent or has failed, or until ctx is done.
+// On timeout, it returns the latest known state of the message with ErrWaitTimeout
+func (s *Service) WaitForMessage(ctx context.Context, msg OutboundMessage) (OutboundMessage, error) {
+	sent := s.queue.watch(msg.ID)
+	defer s.queue.unwatch(msg.ID)
+
+	// the message may have been sent before the waiter got registered
+	current, err := s.storage.GetOutboundMessageByID(ctx, msg.ID)
+	if err == nil {
+		if current.IsFinal() {
+			return current, nil
+		}
+		msg = current
+	}
+
+	select {
+	case result := <-sent:
+		return result, nil
+	case <-ctx.Done():
+		// the request context is over, fetches the latest state with a fresh one
+		current, err = s.storage.GetOutboundMessageByID(context.Background(), msg.ID)
+		if err == nil {
+			msg = current
+		}
+		return msg, ErrWaitTimeout
+	}
+}
+
+// enqueue stores the message to be sent by the queue workers
+// a non-empty idempotency key makes sure the device queues the message only once
+func (s *Service) enqueue(ctx context.Context, draft OutboundMessage, idempotencyKey string) (OutboundMessage,
+	bool, error) {
+	original, err := s.reserveIdempotencyKey(ctx, draft.Phone, idempotencyKey)
+	if err != nil {
+		return OutboundMessage{}, false, err
+	}
+	if original != nil {
+		return *original, true, nil
+	}
+
+	msg, err := s.enqueueReserved(ctx, draft, idempotencyKey)
+
+	return msg, false, err
+}
+
+// enqueueReserved stores the message once its idempotency key, if any, has been reserved.
+// The message is returned along with an error when it has been queued, but its key has not been linked
+func (s *Service) enqueueReserved(ctx context.Context, draft OutboundMessage,
+	idempotencyKey string) (OutboundMessage, error) {
+	msg, err := s.insert(ctx, draft)
+	if err != nil {
+		s.releaseIdempotencyKey(draft.Phone, idempotencyKey)
+		return OutboundMessage{}, err
+	}
+
+	// wakes up the workers of this device
+	s.queue.Notify(msg.Phone)
+
+	if idempotencyKey != "" {
+		err = s.linkIdempotencyKey(draft.Phone, idempotencyKey, msg.ID)
+		if err != nil {
+			s.log.Warn(fmt.Sprintf("failed to store idempotency key [%s]", idempotencyKey), zap.Error(err))
+			return msg, fmt.Errorf("the message [%s] has been queued, but not its idempotency key: %w",
+				msg.ID, err)
+		}
+	}
+
+	return msg, nil
+}
+
+// insert validates the recipient and stores the message into the queue
+func (s *Service) insert(ctx context.Context, draft OutboundMessage) (OutboundMessage, error) {
+	// if device in From (=phone) does not exist or is not ready yet, rejects
+	bot, err := s.BotClients.Bot(draft.Phone)
+	if err != nil {
+		return OutboundMessage{}, err
+	}
+
+	// validates phone number or group and get the recipient
+	recipient, err := s.recipient(bot, draft.To)
+	if err != nil {
+		return OutboundMessage{}, err
+	}
+
+	// rejects the messages over the rate limit of the device, unless they can wait in the queue
+	now := time.Now().UTC()
+	if !s.queue.cfg.QueueOverLimit {
+		err = s.queue.limiter.Admit(draft.Phone, s.queue.policy(ctx, draft.Phone), now)
+		if err != nil {
+			return OutboundMessage{}, err
+		}
+	}
+
+	draft.Recipient = recipient.String()
+	draft.Status = StatusQueued
+	draft.NextAttemptAt = now
+	draft.CreatedAt = now
+	draft.UpdatedAt = now
+
+	msg, err := s.storage.InsertOutboundMessage(ctx, draft)
+	if err != nil {
+		// a message which has not been queued does not count in the rate limit
+		if !s.queue.cfg.QueueOverLimit {
+			s.queue.limiter.Refund(draft.Phone, now)
+		}
+		return OutboundMessage{}, err
+	}
+
+	return msg, nil
+}
+
+// recipient resolves the JID of the recipient, given as a phone number or as a JID.
+// A phone number must be on whatsapp, and the device must be a participant of a group
+func (s *Service) recipient(bot *botHook.WaBot, to string) (types.JID, error) {
+	if !strings.Contains(to, "@") {
+		recipient, err := bot.ValidateAndGetRecipient(to, true)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("phone [%s] got validation error(s)", to), zap.Error(err))
+			return types.JID{}, fmt.Errorf("phone got validation error(s)")
+		}
+		return *recipient, nil
+	}
+
+	recipient, err := chatSvc.ParseChatJID(to)
+	if err != nil {
+		return types.JID{}, err
+	}
+
+	if recipient.Server == types.GroupServer {
+		_, err = bot.Client.GetGroupInfo(recipient)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("group [%s] got validation error(s)", to), zap.Error(err))
+			return types.JID{}, fmt.Errorf("group got validation error(s): %w", err)
+		}
+	}
+
+	return recipient, nil
+}
@@ -0,0 +1,231 @@
+package message
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+
+	chatSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+)
+
+// MediaPayload is the input JSON body captured from the video, audio and document message requests
+type MediaPayload struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	FileName     string `json:"file_name"`
+	Caption      string `json:"caption"`
+	MimeType     string `json:"mimetype"`
+	DocumentName string `json:"document_name"`
+	PTT          bool   `json:"ptt"`
+
+	// MessageContext holds the optional quote and mentions
+	MessageContext
+}
+
+// Sanitize sanitizes the input data
+func (p *MediaPayload) Sanitize() {
+	plusSymbol := false
+
+	p.From = common.SanitizePhone(p.From, &plusSymbol)
+	p.To = sanitizeRecipient(p.To)
+}
+
+// Validate validates the input data of the given message type
+func (p *MediaPayload) Validate(msgType string) error {
+	// the file must be inside the media directory
+	if p.FileName != "" && filepath.Base(p.FileName) != p.FileName {
+		return fmt.Errorf("file_name must not contain a path")
+	}
+
+	// whatsapp does not show any caption on audio messages
+	if msgType == TypeAudio && p.Caption != "" {
+		return fmt.Errorf("caption is not supported on audio messages")
+	}
+
+	// mentions are tagged in the caption, that audio messages do not have
+	if msgType == TypeAudio && len(p.Mentions) > 0 {
+		return fmt.Errorf("mentions are not supported on audio messages")
+	}
+
+	return p.MessageContext.Validate()
+}
+
+// toOutboundMessage builds the outbound message of the given type
+func (p *MediaPayload) toOutboundMessage(msgType string) OutboundMessage {
+	msg := OutboundMessage{
+		Phone:    p.From,
+		To:       p.To,
+		Type:     msgType,
+		FileName: p.FileName,
+		Caption:  p.Caption,
+		MimeType: p.MimeType,
+	}
+
+	switch msgType {
+	case TypeAudio:
+		msg.PTT = p.PTT
+	case TypeDocument:
+		// the file name is shown to the recipient, it defaults to the stored file name
+		msg.DocumentName = p.DocumentName
+		if msg.DocumentName == "" {
+			msg.DocumentName = p.FileName
+		}
+	}
+
+	return msg
+}
+
+// LocationPayload is the input JSON body captured from the location message request
+type LocationPayload struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Live      bool    `json:"live"`
+	Caption   string  `json:"caption"`
+}
+
+// Sanitize sanitizes the input data
+func (p *LocationPayload) Sanitize() {
+	plusSymbol := false
+
+	p.From = common.SanitizePhone(p.From, &plusSymbol)
+	p.To = sanitizeRecipient(p.To)
+}
+
+// Validate validates the input data
+func (p *LocationPayload) Validate() error {
+	if p.Latitude < -90 || p.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if p.Longitude < -180 || p.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+
+	// a live location only carries a caption
+	if p.Live && (p.Name != "" || p.Address != "") {
+		return fmt.Errorf("name and address are not supported on live locations, use caption instead")
+	}
+	if !p.Live && p.Caption != "" {
+		return fmt.Errorf("caption is only supported on live locations")
+	}
+
+	return nil
+}
+
+// ContactPayload is the input JSON body captured from the contact message request
+type ContactPayload struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Contacts []ContactCard `json:"contacts"`
+}
+
+// Sanitize sanitizes the input data
+func (p *ContactPayload) Sanitize() {
+	plusSymbol := false
+
+	p.From = common.SanitizePhone(p.From, &plusSymbol)
+	p.To = sanitizeRecipient(p.To)
+}
+
+// Validate validates the input data
+func (p *ContactPayload) Validate() error {
+	if len(p.Contacts) == 0 {
+		return fmt.Errorf("at least one contact is required")
+	}
+
+	for i, c := range p.Contacts {
+		err := c.Validate()
+		if err != nil {
+			return fmt.Errorf("contacts[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// ActionPayload is the input JSON body captured from the reaction, edit and revoke requests
+type ActionPayload struct {
+	From string `json:"from"`
+
+	// Chat is the JID, or the phone, of the chat holding the target message
+	Chat string `json:"chat"`
+
+	// MessageID is the whatsapp ID of the target message
+	MessageID string `json:"message_id"`
+
+	// Sender is the phone or JID of the author of the target message,
+	// it is required for the group messages which have not been sent by the device
+	Sender string `json:"sender"`
+
+	// FromMe is true when the target message has been sent by the device itself
+	FromMe bool `json:"from_me"`
+
+	// Emoji is the reaction, an empty emoji removes the reaction
+	Emoji string `json:"emoji"`
+
+	// Message is the new text of an edited message
+	Message string `json:"message"`
+}
+
+// Sanitize sanitizes the input data
+func (p *ActionPayload) Sanitize() {
+	plusSymbol := false
+
+	p.From = common.SanitizePhone(p.From, &plusSymbol)
+	p.Chat = sanitizeRecipient(p.Chat)
+}
+
+// Validate validates the input data of the given message type
+func (p *ActionPayload) Validate(msgType string) error {
+	if p.Chat == "" {
+		return fmt.Errorf("chat is required")
+	}
+	if _, err := chatSvc.ParseChatJID(p.Chat); err != nil {
+		return fmt.Errorf("chat: %w", err)
+	}
+	if p.MessageID == "" {
+		return fmt.Errorf("message_id is required")
+	}
+	if p.Sender != "" {
+		if _, err := chatSvc.ParseUserJID(p.Sender); err != nil {
+			return fmt.Errorf("sender: %w", err)
+		}
+	}
+
+	switch msgType {
+	case TypeReaction:
+		if utf8.RuneCountInString(p.Emoji) > maxReactionRunes {
+			return fmt.Errorf("emoji must be a single emoji")
+		}
+	case TypeEdit:
+		if p.Message == "" {
+			return fmt.Errorf("message is required")
+		}
+	}
+
+	return nil
+}
+
+// sanitizeRecipient sanitizes the phone number of the recipient, a JID is kept as is
+func sanitizeRecipient(to string) string {
+	if to == "" || strings.Contains(to, "@") {
+		return to
+	}
+
+	plusSymbol := false
+	return common.SanitizePhone(to, &plusSymbol)
+}
+
+// sanitizeMessagePayload sanitizes the text and image message payload, keeping a recipient JID as is
+func sanitizeMessagePayload(p *botHook.MessagePayload) {
+	to := p.To
+	p.Sanitize()
+	p.To = sanitizeRecipient(to)
+}
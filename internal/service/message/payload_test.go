@@ -0,0 +1,58 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaPayloadValidate(t *testing.T) {
+	p := MediaPayload{From: "628111", To: "628222", FileName: "invoice.pdf"}
+	assert.NoError(t, p.Validate(TypeDocument))
+
+	p.FileName = "../secret.pdf"
+	assert.Error(t, p.Validate(TypeDocument))
+
+	p = MediaPayload{FileName: "note.ogg", Caption: "hello"}
+	assert.Error(t, p.Validate(TypeAudio))
+}
+
+func TestMediaPayloadToOutboundMessage(t *testing.T) {
+	p := MediaPayload{From: "628111", To: "628222", FileName: "inv-1.pdf", PTT: true}
+
+	msg := p.toOutboundMessage(TypeDocument)
+	assert.Equal(t, "inv-1.pdf", msg.DocumentName)
+	assert.False(t, msg.PTT)
+
+	msg = p.toOutboundMessage(TypeAudio)
+	assert.True(t, msg.PTT)
+	assert.Empty(t, msg.DocumentName)
+}
+
+func TestMediaMimeType(t *testing.T) {
+	ogg := []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	assert.Equal(t, "audio/ogg; codecs=opus", mediaMimeType(OutboundMessage{Type: TypeAudio}, ogg))
+	assert.Equal(t, "audio/mpeg", mediaMimeType(OutboundMessage{Type: TypeAudio, MimeType: "audio/mpeg"}, ogg))
+	assert.Equal(t, "application/pdf", mediaMimeType(OutboundMessage{Type: TypeDocument}, []byte("%PDF-1.4")))
+}
+
+func TestLocationPayloadValidate(t *testing.T) {
+	p := LocationPayload{Latitude: -6.2, Longitude: 106.8, Name: "Monas"}
+	assert.NoError(t, p.Validate())
+
+	p.Latitude = 91
+	assert.Error(t, p.Validate())
+
+	p = LocationPayload{Latitude: -6.2, Longitude: 106.8, Live: true, Name: "Monas"}
+	assert.Error(t, p.Validate())
+
+	p = LocationPayload{Latitude: -6.2, Longitude: 106.8, Caption: "on my way"}
+	assert.Error(t, p.Validate())
+}
+
+func TestSanitizeRecipient(t *testing.T) {
+	assert.Equal(t, "62811222", sanitizeRecipient("+62 811-222"))
+	assert.Equal(t, "6281222-1600000000@g.us", sanitizeRecipient("6281222-1600000000@g.us"))
+	assert.Empty(t, sanitizeRecipient(""))
+}
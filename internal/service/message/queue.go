@@ -0,0 +1,625 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// maxRetryBackoff caps the exponential backoff between two attempts
+	maxRetryBackoff = 10 * time.Minute
+
+	// policyCacheTTL bounds the time the rate limit policy of a device is cached
+	policyCacheTTL = time.Minute
+)
+
+// QueueConfig sets up the required parameters to build the outbound message queue
+type QueueConfig struct {
+	// ImageDir is the directory of the image files to be sent
+	ImageDir string
+
+	// MediaDir is the directory of the video, audio and document files to be sent
+	MediaDir string
+
+	// Workers is the number of workers of each device
+	Workers int
+
+	// MaxAttempts is the maximum number of attempts before the message is marked as failed
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, it is doubled on each attempt
+	RetryBackoff time.Duration
+
+	// PollInterval is the interval to look for new messages and connected devices
+	PollInterval time.Duration
+
+	// RateLimit is the global rate limit policy, it can be overridden on each device
+	RateLimit RateLimitPolicy
+
+	// QueueOverLimit queues the messages over the rate limit instead of rejecting them
+	QueueOverLimit bool
+}
+
+// messageRecorder stores the messages of the conversations of the devices
+type messageRecorder interface {
+	RecordMessage(phone string, info types.MessageInfo, msg *waProto.Message)
+}
+
+// cachedPolicy is the rate limit policy of a device, along with its expiry
+type cachedPolicy struct {
+	policy    RateLimitPolicy
+	expiresAt time.Time
+}
+
+// pool is the set of workers of a device
+type pool struct {
+	cancel context.CancelFunc
+	wake   chan struct{}
+}
+
+// Queue sends the stored outbound messages with a pool of workers for each connected device
+type Queue struct {
+	storage    storage
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+	cfg        QueueConfig
+	limiter    *RateLimiter
+	recorder   messageRecorder
+
+	// sendMessage sends the message through the session of the device, see send
+	sendMessage func(ctx context.Context, bot *botHook.WaBot, msg OutboundMessage) (*waProto.Message,
+		whatsmeow.SendResponse, error)
+
+	mu    sync.Mutex
+	pools map[string]*pool
+
+	waitMu  sync.Mutex
+	waiters map[string]chan OutboundMessage
+
+	policyMu sync.Mutex
+	policies map[string]cachedPolicy
+}
+
+// NewQueue creates an outbound message queue
+func NewQueue(storage storage, log *logger.Logger, registry *sessionSvc.Registry, recorder messageRecorder,
+	cfg QueueConfig) *Queue {
+	q := &Queue{
+		storage:    storage,
+		log:        log,
+		BotClients: registry,
+		cfg:        cfg,
+		limiter:    NewRateLimiter(),
+		recorder:   recorder,
+		pools:      make(map[string]*pool),
+		waiters:    make(map[string]chan OutboundMessage),
+		policies:   make(map[string]cachedPolicy),
+	}
+	q.sendMessage = q.send
+
+	return q
+}
+
+// Start requeues the interrupted messages and keeps the worker pools in sync with the connected devices
+func (q *Queue) Start(ctx context.Context) error {
+	// messages being sent when the service stopped are sent again
+	total, err := q.storage.ResetSendingOutboundMessages(ctx)
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		q.log.Info(fmt.Sprintf("%d interrupted outbound message(s) have been requeued", total))
+	}
+
+	go func() {
+		ticker := time.NewTicker(q.cfg.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			q.syncPools(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Notify wakes up the workers of the device
+func (q *Queue) Notify(phone string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if p, ok := q.pools[phone]; ok {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch registers a waiter that receives the message once it has been sent or has failed
+func (q *Queue) watch(id string) <-chan OutboundMessage {
+	q.waitMu.Lock()
+	defer q.waitMu.Unlock()
+
+	ch := make(chan OutboundMessage, 1)
+	q.waiters[id] = ch
+
+	return ch
+}
+
+// unwatch removes the waiter of the message
+func (q *Queue) unwatch(id string) {
+	q.waitMu.Lock()
+	defer q.waitMu.Unlock()
+
+	delete(q.waiters, id)
+}
+
+// resolve hands the final state of the message over to its waiter, if any
+func (q *Queue) resolve(msg OutboundMessage) {
+	q.waitMu.Lock()
+	defer q.waitMu.Unlock()
+
+	if ch, ok := q.waiters[msg.ID]; ok {
+		ch <- msg
+		delete(q.waiters, msg.ID)
+	}
+}
+
+// syncPools starts the workers of the newly connected devices and stops the ones of the disconnected devices
+func (q *Queue) syncPools(ctx context.Context) {
+	connected := make(map[string]bool)
+	for _, entry := range q.BotClients.Connected() {
+		connected[entry.Phone] = true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for phone, p := range q.pools {
+		if !connected[phone] || ctx.Err() != nil {
+			p.cancel()
+			delete(q.pools, phone)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	for phone := range connected {
+		if _, ok := q.pools[phone]; ok {
+			continue
+		}
+
+		poolCtx, cancel := context.WithCancel(ctx)
+		p := &pool{
+			cancel: cancel,
+			wake:   make(chan struct{}, q.cfg.Workers),
+		}
+		q.pools[phone] = p
+
+		for i := 0; i < q.cfg.Workers; i++ {
+			go q.work(poolCtx, phone, p.wake)
+		}
+	}
+}
+
+// work sends the messages of the device one by one
+func (q *Queue) work(ctx context.Context, phone string, wake <-chan struct{}) {
+	for {
+		// keeps sending while there are pending messages
+		claimed := q.processNext(ctx, phone)
+		if claimed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// processNext claims and sends the next pending message of the device
+// it returns false when there is no pending message
+func (q *Queue) processNext(ctx context.Context, phone string) bool {
+	msg, ok, err := q.storage.ClaimOutboundMessage(ctx, phone)
+	if err != nil {
+		if ctx.Err() == nil {
+			q.log.Warn(fmt.Sprintf("failed to claim outbound message of [%s]", phone), zap.Error(err))
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	// whatsapp ignores an edit sent after the edit window, the message fails without any further attempt
+	if msg.Type == TypeEdit && msg.Target != nil {
+		err = checkEditWindow(msg.Target.SentAt)
+		if err != nil {
+			q.log.Warn(fmt.Sprintf("the edit [%s] has not been sent on time", msg.ID), zap.Error(err))
+			q.fail(msg, err)
+			return true
+		}
+	}
+
+	// the session may have dropped after the message has been claimed, gives it back without any penalty
+	bot, err := q.BotClients.Bot(phone)
+	if err != nil {
+		q.release(msg, time.Now().UTC().Add(q.cfg.PollInterval))
+		return false
+	}
+
+	// paces the device to avoid the bursts that get numbers banned
+	wait, err := q.limiter.Reserve(phone, q.policy(ctx, phone), time.Now().UTC())
+	if err != nil {
+		q.log.Info(fmt.Sprintf("device [%s] has reached its daily cap, message [%s] is postponed", phone, msg.ID))
+		q.release(msg, time.Now().UTC().Add(wait))
+		return false
+	}
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			q.release(msg, time.Now().UTC())
+			return false
+		case <-time.After(wait):
+		}
+	}
+
+	waMsg, resp, err := q.sendMessage(ctx, bot, msg)
+	if err != nil {
+		q.handleFailure(msg, err)
+		return true
+	}
+
+	sentAt := resp.Timestamp.UTC()
+	err = q.storage.MarkOutboundMessageSent(context.Background(), msg.ID, resp.ID, sentAt)
+	if err != nil {
+		q.log.Warn(fmt.Sprintf("failed to mark outbound message [%s] as sent", msg.ID), zap.Error(err))
+	}
+
+	msg.Status = StatusSent
+	msg.WaMessageID = resp.ID
+	msg.SentAt = &sentAt
+	q.resolve(msg)
+	q.cleanUp(msg)
+
+	// keeps the sent message in the conversation history
+	q.recorder.RecordMessage(phone, sentMessageInfo(bot, msg, sentAt), waMsg)
+
+	return true
+}
+
+// release gives the claimed message back to the queue without counting the attempt
+func (q *Queue) release(msg OutboundMessage, nextAttemptAt time.Time) {
+	err := q.storage.ReleaseOutboundMessage(context.Background(), msg.ID, nextAttemptAt)
+	if err != nil {
+		q.log.Warn(fmt.Sprintf("failed to release outbound message [%s]", msg.ID), zap.Error(err))
+	}
+}
+
+// cleanUp deletes the media stored from the request once the message has been sent or has failed
+func (q *Queue) cleanUp(msg OutboundMessage) {
+	if msg.StoredMedia {
+		q.removeMedia(msg.Type, msg.FileName)
+	}
+}
+
+// policy returns the rate limit policy of the device, the global policy is used if the device cannot be fetched.
+// The policy is cached until it expires or the overrides change
+func (q *Queue) policy(ctx context.Context, phone string) RateLimitPolicy {
+	now := time.Now()
+
+	q.policyMu.Lock()
+	cached, ok := q.policies[phone]
+	q.policyMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.policy
+	}
+
+	device, err := q.storage.GetDeviceByPhone(ctx, "+"+phone)
+	if err != nil {
+		return q.cfg.RateLimit
+	}
+	p := q.cfg.RateLimit.Override(device.RateLimit)
+
+	q.policyMu.Lock()
+	q.policies[phone] = cachedPolicy{policy: p, expiresAt: now.Add(policyCacheTTL)}
+	q.policyMu.Unlock()
+
+	return p
+}
+
+// InvalidatePolicies drops the cached rate limit policies, e.g. once the override of a device has been updated
+func (q *Queue) InvalidatePolicies() {
+	q.policyMu.Lock()
+	defer q.policyMu.Unlock()
+
+	q.policies = make(map[string]cachedPolicy)
+}
+
+// handleFailure schedules the next attempt with an exponential backoff, or marks the message as failed
+func (q *Queue) handleFailure(msg OutboundMessage, sendErr error) {
+	if msg.Attempts >= q.cfg.MaxAttempts {
+		q.log.Error(fmt.Sprintf("failed to send the message [%s] to [%s] after %d attempts",
+			msg.ID, msg.To, msg.Attempts), zap.Error(sendErr))
+		q.fail(msg, sendErr)
+		return
+	}
+
+	backoff := retryBackoff(q.cfg.RetryBackoff, msg.Attempts)
+	q.log.Warn(fmt.Sprintf("failed to send the message [%s] to [%s], retrying in %s",
+		msg.ID, msg.To, backoff), zap.Error(sendErr))
+	err := q.storage.RetryOutboundMessage(context.Background(), msg.ID, sendErr.Error(),
+		time.Now().UTC().Add(backoff))
+	if err != nil {
+		q.log.Warn(fmt.Sprintf("failed to update outbound message [%s]", msg.ID), zap.Error(err))
+	}
+}
+
+// fail marks the message as failed and hands it over to its waiter
+func (q *Queue) fail(msg OutboundMessage, sendErr error) {
+	err := q.storage.FailOutboundMessage(context.Background(), msg.ID, sendErr.Error())
+	if err != nil {
+		q.log.Warn(fmt.Sprintf("failed to update outbound message [%s]", msg.ID), zap.Error(err))
+	}
+
+	msg.Status = StatusFailed
+	msg.LastError = sendErr.Error()
+	q.resolve(msg)
+	q.cleanUp(msg)
+}
+
+// send builds the whatsapp message and sends it to the recipient
+func (q *Queue) send(ctx context.Context, bot *botHook.WaBot, msg OutboundMessage) (*waProto.Message,
+	whatsmeow.SendResponse, error) {
+	recipient, err := types.ParseJID(msg.Recipient)
+	if err != nil {
+		return nil, whatsmeow.SendResponse{}, err
+	}
+
+	waMsg, err := q.buildMessage(ctx, bot, msg)
+	if err != nil {
+		return nil, whatsmeow.SendResponse{}, err
+	}
+
+	// quotes the replied message and tags the mentioned users
+	setContextInfo(waMsg, contextInfo(msg, recipient, ownJID(bot)))
+
+	resp, err := bot.Client.SendMessage(ctx, recipient, waMsg)
+
+	return waMsg, resp, err
+}
+
+// ownJID returns the JID of the device, or an empty JID if the device is not logged in
+func ownJID(bot *botHook.WaBot) types.JID {
+	if bot.Client == nil || bot.Client.Store.ID == nil {
+		return types.EmptyJID
+	}
+
+	return *bot.Client.Store.ID
+}
+
+// sentMessageInfo builds the whatsapp message info of a message sent by the device
+func sentMessageInfo(bot *botHook.WaBot, msg OutboundMessage, sentAt time.Time) types.MessageInfo {
+	chat, _ := types.ParseJID(msg.Recipient)
+
+	return types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   ownJID(bot),
+			IsFromMe: true,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+		ID:        msg.WaMessageID,
+		Timestamp: sentAt,
+	}
+}
+
+// buildMessage builds the whatsapp message based on the message type
+func (q *Queue) buildMessage(ctx context.Context, bot *botHook.WaBot, msg OutboundMessage) (*waProto.Message, error) {
+	switch msg.Type {
+	case TypeText:
+		return &waProto.Message{
+			Conversation: proto.String(msg.Message),
+		}, nil
+	case TypeImage:
+		// uploads to whatsapp server
+		imgPath := fmt.Sprintf("%s/%s", q.cfg.ImageDir, msg.FileName)
+		imgInBytes, uploaded, err := bot.UploadImgToWhatsapp(imgPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload file (=%s) to Whatsapp server: %w", msg.FileName, err)
+		}
+
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+			Caption:       proto.String(msg.Caption),
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(http.DetectContentType(*imgInBytes)),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uint64(len(*imgInBytes))),
+		}}, nil
+	case TypeVideo:
+		uploaded, mimeType, err := q.uploadMedia(ctx, bot, msg, whatsmeow.MediaVideo)
+		if err != nil {
+			return nil, err
+		}
+
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{
+			Caption:       proto.String(msg.Caption),
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}}, nil
+	case TypeAudio:
+		uploaded, mimeType, err := q.uploadMedia(ctx, bot, msg, whatsmeow.MediaAudio)
+		if err != nil {
+			return nil, err
+		}
+
+		return &waProto.Message{AudioMessage: &waProto.AudioMessage{
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			Ptt:           proto.Bool(msg.PTT),
+		}}, nil
+	case TypeDocument:
+		uploaded, mimeType, err := q.uploadMedia(ctx, bot, msg, whatsmeow.MediaDocument)
+		if err != nil {
+			return nil, err
+		}
+
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+			Caption:       proto.String(msg.Caption),
+			Title:         proto.String(msg.DocumentName),
+			FileName:      proto.String(msg.DocumentName),
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimeType),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}}, nil
+	case TypeLocation:
+		if msg.Location == nil {
+			return nil, fmt.Errorf("location message without any location")
+		}
+
+		return locationMessage(*msg.Location), nil
+	case TypeContact:
+		if len(msg.Contacts) == 0 {
+			return nil, fmt.Errorf("contact message without any contact")
+		}
+
+		return contactMessage(msg.Contacts), nil
+	case TypeReaction, TypeEdit, TypeRevoke:
+		return actionMessage(bot.Client, msg)
+	default:
+		return nil, fmt.Errorf("unsupported message type: %s", msg.Type)
+	}
+}
+
+// locationMessage builds the whatsapp message of a static or live location
+func locationMessage(loc Location) *waProto.Message {
+	if loc.Live {
+		return &waProto.Message{LiveLocationMessage: &waProto.LiveLocationMessage{
+			DegreesLatitude:  proto.Float64(loc.Latitude),
+			DegreesLongitude: proto.Float64(loc.Longitude),
+			Caption:          proto.String(loc.Caption),
+		}}
+	}
+
+	return &waProto.Message{LocationMessage: &waProto.LocationMessage{
+		DegreesLatitude:  proto.Float64(loc.Latitude),
+		DegreesLongitude: proto.Float64(loc.Longitude),
+		Name:             proto.String(loc.Name),
+		Address:          proto.String(loc.Address),
+	}}
+}
+
+// contactMessage builds the whatsapp message of a single contact, or of a contact array
+func contactMessage(contacts []Contact) *waProto.Message {
+	if len(contacts) == 1 {
+		return &waProto.Message{ContactMessage: &waProto.ContactMessage{
+			DisplayName: proto.String(contacts[0].DisplayName),
+			Vcard:       proto.String(contacts[0].VCard),
+		}}
+	}
+
+	cards := make([]*waProto.ContactMessage, len(contacts))
+	for i, c := range contacts {
+		cards[i] = &waProto.ContactMessage{
+			DisplayName: proto.String(c.DisplayName),
+			Vcard:       proto.String(c.VCard),
+		}
+	}
+
+	return &waProto.Message{ContactsArrayMessage: &waProto.ContactsArrayMessage{
+		DisplayName: proto.String(fmt.Sprintf("%d contacts", len(contacts))),
+		Contacts:    cards,
+	}}
+}
+
+// uploadMedia uploads the file of the message to the whatsapp server and returns its mimetype
+func (q *Queue) uploadMedia(ctx context.Context, bot *botHook.WaBot, msg OutboundMessage,
+	mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, string, error) {
+	data, err := os.ReadFile(filepath.Join(q.cfg.MediaDir, msg.FileName))
+	if err != nil {
+		return whatsmeow.UploadResponse{}, "", fmt.Errorf("failed to read file (=%s): %w", msg.FileName, err)
+	}
+
+	uploaded, err := bot.Client.Upload(ctx, data, mediaType)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, "", fmt.Errorf("failed to upload file (=%s) to Whatsapp server: %w",
+			msg.FileName, err)
+	}
+
+	return uploaded, mediaMimeType(msg, data), nil
+}
+
+// mediaMimeType returns the mimetype given on the request, or detects it from the content
+func mediaMimeType(msg OutboundMessage, data []byte) string {
+	if msg.MimeType != "" {
+		return msg.MimeType
+	}
+
+	return sendMimeType(msg.Type, http.DetectContentType(data))
+}
+
+// sendMimeType adapts the sniffed mimetype of the content to the one sent to whatsapp
+func sendMimeType(msgType, mimeType string) string {
+	// whatsapp only plays the ogg audio files encoded with opus
+	if msgType == TypeAudio && mimeType == "application/ogg" {
+		return "audio/ogg; codecs=opus"
+	}
+
+	return mimeType
+}
+
+// retryBackoff doubles the base backoff on each attempt
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+
+	return backoff
+}
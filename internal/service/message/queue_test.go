@@ -0,0 +1,312 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, retryBackoff(5*time.Second, 1))
+	assert.Equal(t, 10*time.Second, retryBackoff(5*time.Second, 2))
+	assert.Equal(t, 40*time.Second, retryBackoff(5*time.Second, 4))
+
+	// the backoff never exceeds the cap
+	assert.Equal(t, maxRetryBackoff, retryBackoff(5*time.Second, 50))
+}
+
+func TestQueueResolveWaiter(t *testing.T) {
+	q := NewQueue(nil, nil, nil, nil, QueueConfig{})
+
+	sent := q.watch("msg-1")
+	q.resolve(OutboundMessage{ID: "msg-1", Status: StatusSent, WaMessageID: "wa-1"})
+
+	select {
+	case msg := <-sent:
+		assert.Equal(t, StatusSent, msg.Status)
+		assert.Equal(t, "wa-1", msg.WaMessageID)
+	case <-time.After(time.Second):
+		t.Fatal("waiter has not been resolved")
+	}
+
+	// resolving an unwatched message does not block
+	q.unwatch("msg-1")
+	q.resolve(OutboundMessage{ID: "msg-1", Status: StatusSent})
+}
+
+// fakeQueueStorage is an in-memory store of the outbound messages, it mimics the claims of MongoDB
+type fakeQueueStorage struct {
+	storage
+
+	mu       sync.Mutex
+	messages map[string]*OutboundMessage
+}
+
+func (f *fakeQueueStorage) ClaimOutboundMessage(_ context.Context, phone string) (OutboundMessage, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var next *OutboundMessage
+	for _, msg := range f.messages {
+		if msg.Phone != phone || msg.Status != StatusQueued || msg.NextAttemptAt.After(time.Now().UTC()) {
+			continue
+		}
+		if next == nil || msg.NextAttemptAt.Before(next.NextAttemptAt) {
+			next = msg
+		}
+	}
+	if next == nil {
+		return OutboundMessage{}, false, nil
+	}
+
+	next.Status = StatusSending
+	next.Attempts++
+
+	return *next, true, nil
+}
+
+func (f *fakeQueueStorage) ReleaseOutboundMessage(_ context.Context, id string, nextAttemptAt time.Time) error {
+	return f.update(id, func(msg *OutboundMessage) {
+		msg.Status = StatusQueued
+		msg.NextAttemptAt = nextAttemptAt
+		msg.Attempts--
+	})
+}
+
+func (f *fakeQueueStorage) RetryOutboundMessage(_ context.Context, id, lastError string,
+	nextAttemptAt time.Time) error {
+	return f.update(id, func(msg *OutboundMessage) {
+		msg.Status = StatusQueued
+		msg.LastError = lastError
+		msg.NextAttemptAt = nextAttemptAt
+	})
+}
+
+func (f *fakeQueueStorage) FailOutboundMessage(_ context.Context, id, lastError string) error {
+	return f.update(id, func(msg *OutboundMessage) {
+		msg.Status = StatusFailed
+		msg.LastError = lastError
+	})
+}
+
+func (f *fakeQueueStorage) MarkOutboundMessageSent(_ context.Context, id, waMessageID string,
+	sentAt time.Time) error {
+	return f.update(id, func(msg *OutboundMessage) {
+		msg.Status = StatusSent
+		msg.WaMessageID = waMessageID
+		msg.SentAt = &sentAt
+	})
+}
+
+func (f *fakeQueueStorage) ResetSendingOutboundMessages(_ context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var total int64
+	for _, msg := range f.messages {
+		if msg.Status == StatusSending {
+			msg.Status = StatusQueued
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+func (f *fakeQueueStorage) GetDeviceByPhone(_ context.Context, phone string) (deviceSvc.Device, error) {
+	return deviceSvc.Device{Phone: phone}, nil
+}
+
+func (f *fakeQueueStorage) update(id string, apply func(msg *OutboundMessage)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msg, ok := f.messages[id]
+	if !ok {
+		return errors.New("message not found")
+	}
+	apply(msg)
+
+	return nil
+}
+
+// get returns a copy of the stored message
+func (f *fakeQueueStorage) get(id string) OutboundMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return *f.messages[id]
+}
+
+// nopRecorder does not record the sent messages
+type nopRecorder struct{}
+
+func (nopRecorder) RecordMessage(_ string, _ types.MessageInfo, _ *waProto.Message) {}
+
+// newTestQueue creates a queue of a connected device, the messages are sent through the given function
+func newTestQueue(t *testing.T, messages []OutboundMessage, send func(msg OutboundMessage) (string, error)) (*Queue,
+	*fakeQueueStorage) {
+	store := &fakeQueueStorage{messages: make(map[string]*OutboundMessage)}
+	for i := range messages {
+		store.messages[messages[i].ID] = &messages[i]
+	}
+
+	registry := sessionSvc.NewRegistry()
+	assert.NoError(t, registry.Reserve("628111", sessionSvc.StateConnecting))
+	assert.NoError(t, registry.SetConnected("628111", "628111@s.whatsapp.net", &botHook.WaBot{Phone: "628111"}))
+
+	q := NewQueue(store, &logger.Logger{Logger: zap.NewNop()}, registry, nopRecorder{}, QueueConfig{
+		Workers:      1,
+		MaxAttempts:  2,
+		RetryBackoff: time.Minute,
+		PollInterval: 10 * time.Millisecond,
+	})
+	q.sendMessage = func(_ context.Context, _ *botHook.WaBot, msg OutboundMessage) (*waProto.Message,
+		whatsmeow.SendResponse, error) {
+		id, err := send(msg)
+		return &waProto.Message{}, whatsmeow.SendResponse{ID: id, Timestamp: time.Now()}, err
+	}
+
+	return q, store
+}
+
+// queued returns a message of the device waiting in the queue
+func queued(id string, nextAttemptAt time.Time) OutboundMessage {
+	return OutboundMessage{ID: id, Phone: "628111", To: "628222", Recipient: "628222@s.whatsapp.net",
+		Type: TypeText, Message: "hello", Status: StatusQueued, NextAttemptAt: nextAttemptAt}
+}
+
+func TestQueueProcessNextSends(t *testing.T) {
+	now := time.Now().UTC()
+	q, store := newTestQueue(t, []OutboundMessage{
+		queued("msg-2", now.Add(-time.Second)),
+		queued("msg-1", now.Add(-time.Minute)),
+		queued("msg-3", now.Add(time.Hour)),
+	}, func(msg OutboundMessage) (string, error) {
+		return "wa-" + msg.ID, nil
+	})
+
+	sent := q.watch("msg-1")
+
+	// the messages are claimed by due time, the ones not due yet are left in the queue
+	assert.True(t, q.processNext(context.Background(), "628111"))
+	assert.True(t, q.processNext(context.Background(), "628111"))
+	assert.False(t, q.processNext(context.Background(), "628111"))
+
+	msg := store.get("msg-1")
+	assert.Equal(t, StatusSent, msg.Status)
+	assert.Equal(t, "wa-msg-1", msg.WaMessageID)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.Equal(t, StatusSent, store.get("msg-2").Status)
+	assert.Equal(t, StatusQueued, store.get("msg-3").Status)
+
+	select {
+	case msg = <-sent:
+		assert.Equal(t, StatusSent, msg.Status)
+		assert.Equal(t, "wa-msg-1", msg.WaMessageID)
+	default:
+		t.Fatal("waiter has not been resolved")
+	}
+}
+
+func TestQueueProcessNextRetriesThenFails(t *testing.T) {
+	q, store := newTestQueue(t, []OutboundMessage{queued("msg-1", time.Now().UTC())},
+		func(msg OutboundMessage) (string, error) {
+			return "", errors.New("server unavailable")
+		})
+
+	failed := q.watch("msg-1")
+
+	// the first failure is retried after the backoff, without resolving the waiter
+	assert.True(t, q.processNext(context.Background(), "628111"))
+	msg := store.get("msg-1")
+	assert.Equal(t, StatusQueued, msg.Status)
+	assert.Equal(t, "server unavailable", msg.LastError)
+	assert.WithinDuration(t, time.Now().UTC().Add(time.Minute), msg.NextAttemptAt, 5*time.Second)
+	assert.Len(t, failed, 0)
+	assert.False(t, q.processNext(context.Background(), "628111"))
+
+	// the last attempt marks the message as failed
+	assert.NoError(t, store.update("msg-1", func(msg *OutboundMessage) { msg.NextAttemptAt = time.Now().UTC() }))
+	assert.True(t, q.processNext(context.Background(), "628111"))
+	assert.Equal(t, StatusFailed, store.get("msg-1").Status)
+	assert.Equal(t, 2, store.get("msg-1").Attempts)
+
+	select {
+	case msg = <-failed:
+		assert.Equal(t, StatusFailed, msg.Status)
+		assert.Equal(t, "server unavailable", msg.LastError)
+	default:
+		t.Fatal("waiter has not been resolved")
+	}
+}
+
+func TestQueueProcessNextFailsExpiredEdit(t *testing.T) {
+	sentAt := time.Now().UTC().Add(-whatsmeow.EditWindow - time.Minute)
+	edit := queued("msg-1", time.Now().UTC())
+	edit.Type = TypeEdit
+	edit.Target = &Target{MessageID: "wa-0", SentAt: &sentAt}
+
+	q, store := newTestQueue(t, []OutboundMessage{edit}, func(msg OutboundMessage) (string, error) {
+		t.Fatal("the edit has been sent after the edit window")
+		return "", nil
+	})
+
+	// the edit fails at once, without any further attempt
+	assert.True(t, q.processNext(context.Background(), "628111"))
+	msg := store.get("msg-1")
+	assert.Equal(t, StatusFailed, msg.Status)
+	assert.Contains(t, msg.LastError, ErrEditWindowExpired.Error())
+	assert.Equal(t, 1, msg.Attempts)
+}
+
+func TestQueueProcessNextReleasesWithoutSession(t *testing.T) {
+	q, store := newTestQueue(t, []OutboundMessage{queued("msg-1", time.Now().UTC())},
+		func(msg OutboundMessage) (string, error) {
+			t.Fatal("the message has been sent without any session")
+			return "", nil
+		})
+	q.BotClients.SetLoggedOut("628111")
+
+	// the attempt is not counted
+	assert.False(t, q.processNext(context.Background(), "628111"))
+	msg := store.get("msg-1")
+	assert.Equal(t, StatusQueued, msg.Status)
+	assert.Equal(t, 0, msg.Attempts)
+}
+
+func TestQueueStartRecoversInterruptedMessages(t *testing.T) {
+	interrupted := queued("msg-1", time.Now().UTC())
+	interrupted.Status = StatusSending
+	interrupted.Attempts = 1
+
+	q, store := newTestQueue(t, []OutboundMessage{interrupted}, func(msg OutboundMessage) (string, error) {
+		return "wa-" + msg.ID, nil
+	})
+	sent := q.watch("msg-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, q.Start(ctx))
+
+	select {
+	case msg := <-sent:
+		assert.Equal(t, StatusSent, msg.Status)
+		assert.Equal(t, 2, store.get("msg-1").Attempts)
+	case <-time.After(time.Second):
+		t.Fatal("the interrupted message has not been sent again")
+	}
+}
@@ -0,0 +1,211 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+)
+
+// ErrDailyCapReached is returned when the device has sent its daily cap of messages
+var ErrDailyCapReached = errors.New("daily message cap reached")
+
+// RateLimitError is returned when the device is over its rate limit and queuing is disabled
+type RateLimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s, retry after %s", e.Reason, e.RetryAfter.Round(time.Second))
+}
+
+// RateLimitPolicy defines how fast a device may send messages, a zero value disables the limit
+type RateLimitPolicy struct {
+	// MessagesPerMinute is the maximum number of messages within a sliding minute
+	MessagesPerMinute int
+
+	// MinDelay is the minimum delay between two messages
+	MinDelay time.Duration
+
+	// Jitter is the maximum random delay added on top of MinDelay
+	Jitter time.Duration
+
+	// DailyCap is the maximum number of messages within a day (UTC)
+	DailyCap int
+}
+
+// Override replaces the global values with the ones set on the device
+func (p RateLimitPolicy) Override(o *deviceSvc.RateLimit) RateLimitPolicy {
+	if o == nil {
+		return p
+	}
+
+	if o.MessagesPerMinute != nil {
+		p.MessagesPerMinute = *o.MessagesPerMinute
+	}
+	if o.MinDelayMs != nil {
+		p.MinDelay = time.Duration(*o.MinDelayMs) * time.Millisecond
+	}
+	if o.JitterMs != nil {
+		p.Jitter = time.Duration(*o.JitterMs) * time.Millisecond
+	}
+	if o.DailyCap != nil {
+		p.DailyCap = *o.DailyCap
+	}
+
+	return p
+}
+
+// window counts the events within the last minute and within the current day
+type window struct {
+	times    []time.Time
+	day      time.Time
+	dayCount int
+}
+
+// earliest returns the earliest time from at on when one more event fits the policy.
+// The boolean is true when the daily cap is reached, the returned time is then the start of the next day
+func (w *window) earliest(at time.Time, p RateLimitPolicy) (time.Time, bool) {
+	// drops the events out of the sliding minute
+	i := 0
+	for i < len(w.times) && !w.times[i].After(at.Add(-time.Minute)) {
+		i++
+	}
+	w.times = w.times[i:]
+
+	if p.MessagesPerMinute > 0 && len(w.times) >= p.MessagesPerMinute {
+		next := w.times[len(w.times)-p.MessagesPerMinute].Add(time.Minute)
+		if next.After(at) {
+			at = next
+		}
+	}
+
+	day := at.UTC().Truncate(24 * time.Hour)
+	if p.DailyCap > 0 && day.Equal(w.day) && w.dayCount >= p.DailyCap {
+		return day.Add(24 * time.Hour), true
+	}
+
+	return at, false
+}
+
+// add records an event
+func (w *window) add(t time.Time) {
+	w.times = append(w.times, t)
+
+	day := t.UTC().Truncate(24 * time.Hour)
+	if !day.Equal(w.day) {
+		w.day = day
+		w.dayCount = 0
+	}
+	w.dayCount++
+}
+
+// remove drops an event recorded at the given time, if any
+func (w *window) remove(t time.Time) {
+	for i := len(w.times) - 1; i >= 0; i-- {
+		if w.times[i].Equal(t) {
+			w.times = append(w.times[:i], w.times[i+1:]...)
+
+			if t.UTC().Truncate(24 * time.Hour).Equal(w.day) {
+				w.dayCount--
+			}
+			return
+		}
+	}
+}
+
+// deviceLimit holds the rate limit state of a device
+type deviceLimit struct {
+	admitted  window
+	scheduled window
+	lastSend  time.Time
+}
+
+// RateLimiter keeps the in-memory rate limit state of the devices.
+// The state starts empty when the service restarts
+type RateLimiter struct {
+	mu      sync.Mutex
+	devices map[string]*deviceLimit
+}
+
+// NewRateLimiter creates a rate limiter
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		devices: make(map[string]*deviceLimit),
+	}
+}
+
+// device returns the state of the device, it must be called with the lock held
+func (l *RateLimiter) device(phone string) *deviceLimit {
+	d, ok := l.devices[phone]
+	if !ok {
+		d = &deviceLimit{}
+		l.devices[phone] = d
+	}
+
+	return d
+}
+
+// Admit records a new request of the device, or rejects it if the device is over its rate limit
+func (l *RateLimiter) Admit(phone string, p RateLimitPolicy, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d := l.device(phone)
+
+	at, capped := d.admitted.earliest(now, p)
+	if capped {
+		return &RateLimitError{Reason: "daily cap reached", RetryAfter: at.Sub(now)}
+	}
+	if at.After(now) {
+		return &RateLimitError{Reason: "too many messages per minute", RetryAfter: at.Sub(now)}
+	}
+
+	d.admitted.add(now)
+
+	return nil
+}
+
+// Refund cancels the request admitted at the given time, e.g. when it could not be stored
+func (l *RateLimiter) Refund(phone string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.device(phone).admitted.remove(at)
+}
+
+// Reserve books the next send slot of the device and returns how long to wait before sending.
+// It returns ErrDailyCapReached with the delay until the next day if the daily cap is reached
+func (l *RateLimiter) Reserve(phone string, p RateLimitPolicy, now time.Time) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d := l.device(phone)
+
+	// keeps a jittered delay from the previous message
+	at := now
+	if !d.lastSend.IsZero() {
+		delay := p.MinDelay
+		if p.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(p.Jitter) + 1))
+		}
+		if next := d.lastSend.Add(delay); next.After(at) {
+			at = next
+		}
+	}
+
+	at, capped := d.scheduled.earliest(at, p)
+	if capped {
+		return at.Sub(now), ErrDailyCapReached
+	}
+
+	d.scheduled.add(at)
+	d.lastSend = at
+
+	return at.Sub(now), nil
+}
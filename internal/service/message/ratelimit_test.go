@@ -0,0 +1,74 @@
+package message
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+)
+
+func TestRateLimiterAdmit(t *testing.T) {
+	l := NewRateLimiter()
+	p := RateLimitPolicy{MessagesPerMinute: 2}
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, l.Admit("628111", p, now))
+	assert.NoError(t, l.Admit("628111", p, now.Add(10*time.Second)))
+
+	// the third message within the minute is rejected until the first one leaves the window
+	err := l.Admit("628111", p, now.Add(20*time.Second))
+	var rateLimitErr *RateLimitError
+	assert.True(t, errors.As(err, &rateLimitErr))
+	assert.Equal(t, 40*time.Second, rateLimitErr.RetryAfter)
+
+	// other devices are not affected
+	assert.NoError(t, l.Admit("628222", p, now.Add(20*time.Second)))
+
+	assert.NoError(t, l.Admit("628111", p, now.Add(61*time.Second)))
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	l := NewRateLimiter()
+	p := RateLimitPolicy{MessagesPerMinute: 1, DailyCap: 1}
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, l.Admit("628111", p, now))
+	assert.Error(t, l.Admit("628111", p, now.Add(time.Second)))
+
+	// a refunded request leaves room for the next one, within the minute and within the day
+	l.Refund("628111", now)
+	assert.NoError(t, l.Admit("628111", p, now.Add(time.Second)))
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter()
+	p := RateLimitPolicy{MinDelay: 2 * time.Second, DailyCap: 2}
+	now := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	wait, err := l.Reserve("628111", p, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// the next send keeps the minimum delay
+	wait, err = l.Reserve("628111", p, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// the daily cap postpones the next send to the next day
+	wait, err = l.Reserve("628111", p, now)
+	assert.ErrorIs(t, err, ErrDailyCapReached)
+	assert.Equal(t, 14*time.Hour, wait)
+}
+
+func TestRateLimitPolicyOverride(t *testing.T) {
+	global := RateLimitPolicy{MessagesPerMinute: 10, MinDelay: time.Second, DailyCap: 100}
+	perMinute := 0
+	delay := int64(500)
+
+	p := global.Override(&deviceSvc.RateLimit{MessagesPerMinute: &perMinute, MinDelayMs: &delay})
+	assert.Equal(t, RateLimitPolicy{MessagesPerMinute: 0, MinDelay: 500 * time.Millisecond, DailyCap: 100}, p)
+	assert.Equal(t, global, global.Override(nil))
+}
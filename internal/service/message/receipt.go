@@ -0,0 +1,51 @@
+package message
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+// receiptStatuses maps the receipt type into the message status and the statuses it may replace
+var receiptStatuses = map[string]struct {
+	status string
+	from   []string
+}{
+	"delivered": {status: StatusDelivered, from: []string{StatusSent}},
+	"read":      {status: StatusRead, from: []string{StatusSent, StatusDelivered}},
+}
+
+// ConsumeReceipts updates the delivery status of the sent messages based on the received receipts
+func (q *Queue) ConsumeReceipts(ctx context.Context, hub *eventSvc.Hub) {
+	sub := hub.Subscribe("")
+
+	go func() {
+		defer hub.Unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.C:
+				if evt.Body.EventType != eventSvc.TypeReceipt {
+					continue
+				}
+
+				receipt, ok := receiptStatuses[evt.Body.MsgType]
+				if !ok {
+					continue
+				}
+
+				err := q.storage.UpdateOutboundMessageReceipt(ctx, evt.Phone, evt.Body.MsgId, receipt.status,
+					receipt.from)
+				if err != nil {
+					q.log.Warn(fmt.Sprintf("failed to update the receipt of message [%s]", evt.Body.MsgId),
+						zap.Error(err))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,88 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+)
+
+// ErrUnsupportedType is returned when no request body is known for the message type
+var ErrUnsupportedType = errors.New("unsupported message type")
+
+// Sender queues the outbound messages of each type, it is implemented by Service
+type Sender interface {
+	SendTextMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx MessageContext,
+		idempotencyKey string) (OutboundMessage, bool, error)
+	SendImageMessage(ctx context.Context, payload botHook.MessagePayload, msgCtx MessageContext,
+		src MediaSource, idempotencyKey string) (OutboundMessage, bool, error)
+	SendMediaMessage(ctx context.Context, msgType string, payload MediaPayload, src MediaSource,
+		idempotencyKey string) (OutboundMessage, bool, error)
+	SendLocationMessage(ctx context.Context, payload LocationPayload, idempotencyKey string) (OutboundMessage,
+		bool, error)
+	SendContactMessage(ctx context.Context, payload ContactPayload, idempotencyKey string) (OutboundMessage,
+		bool, error)
+}
+
+// SendJSON decodes the JSON body of the `/api/message/{type}` request of the message type and queues the message,
+// it lets the senders outside of the REST API, e.g. the broker commands, share the request bodies
+func SendJSON(ctx context.Context, sender Sender, msgType string, body []byte,
+	idempotencyKey string) (OutboundMessage, bool, error) {
+	switch msgType {
+	case TypeText, TypeImage:
+		var payload botHook.MessagePayload
+		var msgCtx MessageContext
+		var src MediaSource
+		err := decodeJSON(body, &payload, &msgCtx, &src)
+		if err != nil {
+			return OutboundMessage{}, false, err
+		}
+
+		if msgType == TypeText {
+			return sender.SendTextMessage(ctx, payload, msgCtx, idempotencyKey)
+		}
+
+		return sender.SendImageMessage(ctx, payload, msgCtx, src, idempotencyKey)
+	case TypeVideo, TypeAudio, TypeDocument:
+		var payload MediaPayload
+		var src MediaSource
+		err := decodeJSON(body, &payload, &src)
+		if err != nil {
+			return OutboundMessage{}, false, err
+		}
+
+		return sender.SendMediaMessage(ctx, msgType, payload, src, idempotencyKey)
+	case TypeLocation:
+		var payload LocationPayload
+		err := decodeJSON(body, &payload)
+		if err != nil {
+			return OutboundMessage{}, false, err
+		}
+
+		return sender.SendLocationMessage(ctx, payload, idempotencyKey)
+	case TypeContact:
+		var payload ContactPayload
+		err := decodeJSON(body, &payload)
+		if err != nil {
+			return OutboundMessage{}, false, err
+		}
+
+		return sender.SendContactMessage(ctx, payload, idempotencyKey)
+	default:
+		return OutboundMessage{}, false, fmt.Errorf("%w [%s]", ErrUnsupportedType, msgType)
+	}
+}
+
+// decodeJSON reads the same JSON body into each of the values
+func decodeJSON(body []byte, values ...interface{}) error {
+	for _, v := range values {
+		err := json.Unmarshal(body, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
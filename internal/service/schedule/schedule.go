@@ -0,0 +1,385 @@
+// Package schedule stores the messages to be sent later on, once or on a recurrence,
+// and dispatches them to the outbound message queue when they are due
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// StatusScheduled means that the message waits for its next dispatch
+	StatusScheduled = "scheduled"
+
+	// StatusSent means that the one-time message has been queued
+	StatusSent = "sent"
+
+	// StatusFailed means that the one-time message has been rejected by the queue
+	StatusFailed = "failed"
+
+	// StatusCancelled means that the message has been cancelled before being queued
+	StatusCancelled = "cancelled"
+
+	// dispatchBatchSize is the number of the due messages dispatched on each poll
+	dispatchBatchSize = 100
+)
+
+var (
+	// ErrScheduleNotFound is returned when the scheduled message does not exist
+	ErrScheduleNotFound = errors.New("scheduled message not found")
+
+	// ErrNotCancellable is returned when the scheduled message has already been sent, failed or cancelled
+	ErrNotCancellable = errors.New("scheduled message is not pending anymore")
+
+	// ErrInvalidSchedule is returned when the requested scheduled message is not valid
+	ErrInvalidSchedule = errors.New("invalid scheduled message")
+)
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	InsertScheduledMessage(ctx context.Context, doc ScheduledMessage) (ScheduledMessage, error)
+	GetScheduledMessage(ctx context.Context, id string) (ScheduledMessage, error)
+	GetScheduledMessages(ctx context.Context, phone, status string,
+		params httputils.GetQueryParams) (int64, []ScheduledMessage, error)
+	GetDueScheduledMessages(ctx context.Context, now time.Time, phones []string,
+		limit int64) ([]ScheduledMessage, error)
+	UpdateScheduledMessageRun(ctx context.Context, doc ScheduledMessage, runAt time.Time) error
+	CancelScheduledMessage(ctx context.Context, id string) error
+}
+
+// ScheduledMessage is a message queued at a given time, and again on each occurrence of its cron expression
+type ScheduledMessage struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	Type string `json:"type"`
+
+	// Message is the JSON body of the `/api/message/{type}` request
+	Message json.RawMessage `json:"message"`
+
+	SendAt   time.Time `json:"send_at"`
+	Cron     string    `json:"cron,omitempty"`
+	Timezone string    `json:"timezone,omitempty"`
+
+	// NextRunAt is the time of the next dispatch, it is the last one once the message is not scheduled anymore
+	NextRunAt time.Time `json:"next_run_at"`
+
+	Status        string     `json:"status"`
+	Runs          int        `json:"runs"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastMessageID string     `json:"last_message_id,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Payload is the input JSON body captured from the schedule message request
+// the cron expression has 5 fields or is a descriptor, e.g. `@daily`, it is evaluated in the time zone
+// a recurring message without send_at is first sent on the next occurrence
+type Payload struct {
+	Type     string          `json:"type"`
+	SendAt   *time.Time      `json:"send_at"`
+	Cron     string          `json:"cron"`
+	Timezone string          `json:"timezone"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// recipient is the part of the message bodies identifying the device and the recipient
+type recipient struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Base64 string `json:"base64"`
+}
+
+// Sanitize normalizes the input data
+func (p *Payload) Sanitize() {
+	p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+	p.Cron = strings.TrimSpace(p.Cron)
+	p.Timezone = strings.TrimSpace(p.Timezone)
+}
+
+// Validate validates the input data, the message body itself is validated once it is dispatched
+func (p *Payload) Validate(now time.Time) error {
+	switch p.Type {
+	case messageSvc.TypeText, messageSvc.TypeImage, messageSvc.TypeVideo, messageSvc.TypeAudio,
+		messageSvc.TypeDocument, messageSvc.TypeLocation, messageSvc.TypeContact:
+	default:
+		return fmt.Errorf("type must be one of %s, %s, %s, %s, %s, %s or %s", messageSvc.TypeText,
+			messageSvc.TypeImage, messageSvc.TypeVideo, messageSvc.TypeAudio, messageSvc.TypeDocument,
+			messageSvc.TypeLocation, messageSvc.TypeContact)
+	}
+
+	var rcpt recipient
+	if len(p.Message) == 0 || json.Unmarshal(p.Message, &rcpt) != nil {
+		return fmt.Errorf("message must be the JSON body of the %s message request", p.Type)
+	}
+	if strings.TrimSpace(rcpt.From) == "" || strings.TrimSpace(rcpt.To) == "" {
+		return fmt.Errorf("message.from and message.to are required")
+	}
+	if rcpt.Base64 != "" {
+		return fmt.Errorf("base64 content can not be scheduled, give a file_name or an url")
+	}
+
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("timezone is not a valid time zone: %w", err)
+	}
+
+	if p.Cron == "" {
+		if p.SendAt == nil {
+			return fmt.Errorf("send_at is required by a one-time message")
+		}
+	} else if _, err := cron.ParseStandard(p.Cron); err != nil {
+		return fmt.Errorf("cron is not a valid cron expression: %w", err)
+	}
+
+	if p.SendAt != nil && p.SendAt.Before(now) {
+		return fmt.Errorf("send_at must be in the future")
+	}
+
+	return nil
+}
+
+// next returns the first occurrence of the cron expression after the given time
+func next(cronExpr, timezone string, after time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(cronExpr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t := sched.Next(after.In(loc))
+	if t.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression [%s] has no next occurrence", cronExpr)
+	}
+
+	return t.UTC(), nil
+}
+
+// Config sets up the scheduler
+type Config struct {
+	// PollInterval is the delay between two lookups of the due messages
+	PollInterval time.Duration
+}
+
+// Service stores the scheduled messages and dispatches them once due
+type Service struct {
+	storage    storage
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+	sender     messageSvc.Sender
+	cfg        Config
+	now        func() time.Time
+}
+
+// NewService creates a scheduled message service
+func NewService(storage storage, log *logger.Logger, registry *sessionSvc.Registry, sender messageSvc.Sender,
+	cfg Config) *Service {
+	return &Service{
+		storage:    storage,
+		log:        log,
+		BotClients: registry,
+		sender:     sender,
+		cfg:        cfg,
+		now:        time.Now,
+	}
+}
+
+// Create stores a message to be sent at the given time, and on each occurrence of the cron expression if any
+func (s *Service) Create(ctx context.Context, payload Payload) (ScheduledMessage, error) {
+	now := s.now().UTC()
+
+	payload.Sanitize()
+	err := payload.Validate(now)
+	if err != nil {
+		return ScheduledMessage{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	var rcpt recipient
+	_ = json.Unmarshal(payload.Message, &rcpt)
+	plusSymbol := false
+
+	msg := ScheduledMessage{
+		From:      common.SanitizePhone(strings.TrimSpace(rcpt.From), &plusSymbol),
+		Type:      payload.Type,
+		Message:   payload.Message,
+		Cron:      payload.Cron,
+		Timezone:  payload.Timezone,
+		Status:    StatusScheduled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if payload.SendAt != nil {
+		msg.SendAt = payload.SendAt.UTC()
+	} else {
+		msg.SendAt, err = next(payload.Cron, payload.Timezone, now)
+		if err != nil {
+			return ScheduledMessage{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	}
+	msg.NextRunAt = msg.SendAt
+
+	return s.storage.InsertScheduledMessage(ctx, msg)
+}
+
+// GetScheduledMessages lists the scheduled messages, of a device and of a status if they are set
+func (s *Service) GetScheduledMessages(ctx context.Context, phone, status string,
+	params httputils.GetQueryParams) (int64, []ScheduledMessage, error) {
+	if phone != "" {
+		plusSymbol := false
+		phone = common.SanitizePhone(phone, &plusSymbol)
+	}
+
+	return s.storage.GetScheduledMessages(ctx, phone, status, params)
+}
+
+// GetScheduledMessage extracts a scheduled message based on the ID
+func (s *Service) GetScheduledMessage(ctx context.Context, id string) (ScheduledMessage, error) {
+	return s.storage.GetScheduledMessage(ctx, id)
+}
+
+// Cancel stops a scheduled message, the messages already queued are not recalled
+func (s *Service) Cancel(ctx context.Context, id string) (ScheduledMessage, error) {
+	msg, err := s.storage.GetScheduledMessage(ctx, id)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+	if msg.Status != StatusScheduled {
+		return ScheduledMessage{}, ErrNotCancellable
+	}
+
+	err = s.storage.CancelScheduledMessage(ctx, id)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	return s.storage.GetScheduledMessage(ctx, id)
+}
+
+// Start dispatches the due messages until the context is done,
+// the messages are stored, hence the ones due while the service was stopped are dispatched on start
+func (s *Service) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.cfg.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			s.dispatchDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// dispatchDue queues the messages due by now, of the devices with a live session,
+// so that the messages kept due by the disconnected devices do not hold back the others
+func (s *Service) dispatchDue(ctx context.Context) {
+	now := s.now().UTC()
+
+	connected := s.BotClients.Connected()
+	if len(connected) == 0 {
+		return
+	}
+	phones := make([]string, len(connected))
+	for i, entry := range connected {
+		phones[i] = entry.Phone
+	}
+
+	msgs, err := s.storage.GetDueScheduledMessages(ctx, now, phones, dispatchBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.log.Warn("failed to get the due scheduled messages", zap.Error(err))
+		}
+		return
+	}
+
+	for _, msg := range msgs {
+		s.dispatch(ctx, msg, now)
+	}
+}
+
+// dispatch queues a due message and schedules its next occurrence,
+// the devices without a live session keep their messages due until they reconnect,
+// a recurring message then skips the occurrences missed in the meantime
+func (s *Service) dispatch(ctx context.Context, msg ScheduledMessage, now time.Time) {
+	if _, err := s.BotClients.Bot(msg.From); err != nil {
+		return
+	}
+
+	// the key prevents queuing twice the same occurrence, e.g. when two instances dispatch it
+	runAt := msg.NextRunAt
+	key := fmt.Sprintf("schedule:%s:%d", msg.ID, runAt.Unix())
+
+	queued, _, err := messageSvc.SendJSON(ctx, s.sender, msg.Type, msg.Message, key)
+
+	// the occurrence is not lost when the device is over its rate limit, or has lost its session meanwhile
+	if retryAfter, ok := retryable(err); ok {
+		s.log.Debug(fmt.Sprintf("the scheduled message [%s] will be queued again", msg.ID), zap.Error(err))
+		msg.NextRunAt = runAt.Add(retryAfter)
+		msg.LastError = err.Error()
+		msg.UpdatedAt = now
+
+		err = s.storage.UpdateScheduledMessageRun(ctx, msg, runAt)
+		if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+			s.log.Warn(fmt.Sprintf("failed to update the scheduled message [%s]", msg.ID), zap.Error(err))
+		}
+		return
+	}
+
+	msg.Runs++
+	msg.LastRunAt = &now
+	msg.LastMessageID = queued.ID
+	msg.LastError = ""
+	if err != nil {
+		msg.LastError = err.Error()
+		s.log.Debug(fmt.Sprintf("failed to queue the scheduled message [%s]", msg.ID), zap.Error(err))
+	}
+	msg.UpdatedAt = now
+
+	switch {
+	case msg.Cron == "" && err != nil:
+		msg.Status = StatusFailed
+	case msg.Cron == "":
+		msg.Status = StatusSent
+	default:
+		msg.NextRunAt, err = next(msg.Cron, msg.Timezone, now)
+		if err != nil {
+			msg.Status = StatusFailed
+			msg.LastError = err.Error()
+		}
+	}
+
+	err = s.storage.UpdateScheduledMessageRun(ctx, msg, runAt)
+	if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		s.log.Warn(fmt.Sprintf("failed to update the scheduled message [%s]", msg.ID), zap.Error(err))
+	}
+}
+
+// retryable verifies if the message could not be queued for a transient reason, along with the delay to wait
+func retryable(err error) (time.Duration, bool) {
+	var rateLimitErr *messageSvc.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitErr.RetryAfter, true
+	}
+
+	return 0, errors.Is(err, sessionSvc.ErrSessionNotReady) || errors.Is(err, sessionSvc.ErrSessionNotFound)
+}
@@ -0,0 +1,277 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// fakeStorage keeps the scheduled messages in memory
+type fakeStorage struct {
+	msgs map[string]ScheduledMessage
+}
+
+func (f *fakeStorage) InsertScheduledMessage(_ context.Context, doc ScheduledMessage) (ScheduledMessage, error) {
+	doc.ID = fmt.Sprintf("sched-%d", len(f.msgs)+1)
+	f.msgs[doc.ID] = doc
+
+	return doc, nil
+}
+
+func (f *fakeStorage) GetScheduledMessage(_ context.Context, id string) (ScheduledMessage, error) {
+	msg, ok := f.msgs[id]
+	if !ok {
+		return ScheduledMessage{}, ErrScheduleNotFound
+	}
+
+	return msg, nil
+}
+
+func (f *fakeStorage) GetScheduledMessages(_ context.Context, _, _ string,
+	_ httputils.GetQueryParams) (int64, []ScheduledMessage, error) {
+	return 0, nil, nil
+}
+
+func (f *fakeStorage) GetDueScheduledMessages(_ context.Context, now time.Time, phones []string,
+	limit int64) ([]ScheduledMessage, error) {
+	res := make([]ScheduledMessage, 0)
+	for _, msg := range f.msgs {
+		for _, phone := range phones {
+			if msg.From == phone && msg.Status == StatusScheduled && !msg.NextRunAt.After(now) {
+				res = append(res, msg)
+			}
+		}
+	}
+
+	// the oldest first
+	sort.Slice(res, func(i, j int) bool { return res[i].NextRunAt.Before(res[j].NextRunAt) })
+	if int64(len(res)) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (f *fakeStorage) UpdateScheduledMessageRun(_ context.Context, doc ScheduledMessage, runAt time.Time) error {
+	msg, ok := f.msgs[doc.ID]
+	if !ok || msg.Status != StatusScheduled || !msg.NextRunAt.Equal(runAt) {
+		return ErrScheduleNotFound
+	}
+	f.msgs[doc.ID] = doc
+
+	return nil
+}
+
+func (f *fakeStorage) CancelScheduledMessage(_ context.Context, id string) error {
+	msg, ok := f.msgs[id]
+	if !ok || msg.Status != StatusScheduled {
+		return ErrNotCancellable
+	}
+	msg.Status = StatusCancelled
+	f.msgs[id] = msg
+
+	return nil
+}
+
+// 2024-01-01 is a Monday
+var testNow = time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+func newTestService() (*Service, *fakeStorage, *servicetest.Sender, *sessionSvc.Registry) {
+	storage := &fakeStorage{msgs: make(map[string]ScheduledMessage)}
+	sender := &servicetest.Sender{}
+	registry := sessionSvc.NewRegistry()
+
+	s := NewService(storage, &logger.Logger{Logger: zap.NewNop()}, registry, sender, Config{
+		PollInterval: time.Second,
+	})
+	s.now = func() time.Time { return testNow }
+
+	return s, storage, sender, registry
+}
+
+func textMessage() json.RawMessage {
+	return json.RawMessage(`{"from":"+6281111","to":"6282222","message":"Your appointment is tomorrow"}`)
+}
+
+func TestPayloadValidate(t *testing.T) {
+	later := testNow.Add(time.Hour)
+	earlier := testNow.Add(-time.Hour)
+
+	cases := map[string]struct {
+		payload Payload
+		valid   bool
+	}{
+		"one-time":       {Payload{Type: "text", SendAt: &later, Message: textMessage()}, true},
+		"recurring":      {Payload{Type: "text", Cron: "0 9 * * 1-5", Message: textMessage()}, true},
+		"descriptor":     {Payload{Type: "text", Cron: "@daily", Timezone: "Asia/Jakarta", Message: textMessage()}, true},
+		"unknown type":   {Payload{Type: "sticker", SendAt: &later, Message: textMessage()}, false},
+		"missing time":   {Payload{Type: "text", Message: textMessage()}, false},
+		"past time":      {Payload{Type: "text", SendAt: &earlier, Message: textMessage()}, false},
+		"invalid cron":   {Payload{Type: "text", Cron: "every monday", Message: textMessage()}, false},
+		"invalid zone":   {Payload{Type: "text", Cron: "@daily", Timezone: "Mars/Base", Message: textMessage()}, false},
+		"missing body":   {Payload{Type: "text", SendAt: &later}, false},
+		"missing to":     {Payload{Type: "text", SendAt: &later, Message: json.RawMessage(`{"from":"6281111"}`)}, false},
+		"base64 content": {Payload{Type: "image", SendAt: &later, Message: json.RawMessage(`{"from":"6281111","to":"6282222","base64":"AAAA"}`)}, false},
+	}
+	for name, c := range cases {
+		c.payload.Sanitize()
+		err := c.payload.Validate(testNow)
+		if c.valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s, _, _, _ := newTestService()
+
+	later := testNow.Add(time.Hour)
+	msg, err := s.Create(context.Background(), Payload{Type: "Text", SendAt: &later, Message: textMessage()})
+	assert.NoError(t, err)
+	assert.Equal(t, "6281111", msg.From)
+	assert.Equal(t, StatusScheduled, msg.Status)
+	assert.Equal(t, later, msg.NextRunAt)
+
+	// the first occurrence is evaluated in the time zone, 09:00 in Jakarta is 02:00 UTC
+	msg, err = s.Create(context.Background(), Payload{Type: "text", Cron: "0 9 * * *", Timezone: "Asia/Jakarta",
+		Message: textMessage()})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC), msg.NextRunAt)
+
+	_, err = s.Create(context.Background(), Payload{Type: "sticker", SendAt: &later, Message: textMessage()})
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
+
+func TestDispatchOneTime(t *testing.T) {
+	s, storage, sender, registry := newTestService()
+
+	msg, err := s.Create(context.Background(), Payload{Type: "text", SendAt: &testNow, Message: textMessage()})
+	assert.NoError(t, err)
+
+	// the device without a live session keeps its message due
+	s.dispatchDue(context.Background())
+	assert.Empty(t, sender.Texts)
+	assert.Equal(t, StatusScheduled, storage.msgs[msg.ID].Status)
+
+	servicetest.Connect(t, registry, "6281111")
+	s.dispatchDue(context.Background())
+	s.dispatchDue(context.Background())
+
+	assert.Len(t, sender.Texts, 1)
+	assert.Equal(t, "Your appointment is tomorrow", sender.Texts[0].Message)
+	assert.Equal(t, "schedule:sched-1:1704096000", sender.Keys[0])
+
+	sent := storage.msgs[msg.ID]
+	assert.Equal(t, StatusSent, sent.Status)
+	assert.Equal(t, 1, sent.Runs)
+	assert.Equal(t, "msg-1", sent.LastMessageID)
+}
+
+func TestDispatchRecurring(t *testing.T) {
+	s, storage, sender, registry := newTestService()
+	servicetest.Connect(t, registry, "6281111")
+
+	msg, err := s.Create(context.Background(), Payload{Type: "text", Cron: "0 9 * * *", Message: textMessage()})
+	assert.NoError(t, err)
+
+	// the occurrences missed while the service was stopped are sent once
+	s.now = func() time.Time { return testNow.Add(72 * time.Hour) }
+	s.dispatchDue(context.Background())
+
+	assert.Len(t, sender.Texts, 1)
+	next := storage.msgs[msg.ID]
+	assert.Equal(t, StatusScheduled, next.Status)
+	assert.Equal(t, time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC), next.NextRunAt)
+	assert.Equal(t, 1, next.Runs)
+}
+
+func TestCancel(t *testing.T) {
+	s, _, sender, registry := newTestService()
+	servicetest.Connect(t, registry, "6281111")
+
+	msg, err := s.Create(context.Background(), Payload{Type: "text", SendAt: &testNow, Message: textMessage()})
+	assert.NoError(t, err)
+
+	cancelled, err := s.Cancel(context.Background(), msg.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, cancelled.Status)
+
+	s.dispatchDue(context.Background())
+	assert.Empty(t, sender.Texts)
+
+	_, err = s.Cancel(context.Background(), msg.ID)
+	assert.ErrorIs(t, err, ErrNotCancellable)
+
+	_, err = s.Cancel(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
+
+func TestDispatchSkipsDisconnectedDevices(t *testing.T) {
+	s, storage, sender, registry := newTestService()
+	servicetest.Connect(t, registry, "6283333")
+
+	// the disconnected device has more due messages than a dispatch batch, all older than the other device ones
+	for i := 0; i < dispatchBatchSize+10; i++ {
+		sendAt := testNow.Add(time.Duration(i) * time.Second)
+		_, err := s.Create(context.Background(), Payload{Type: "text", SendAt: &sendAt, Message: textMessage()})
+		assert.NoError(t, err)
+	}
+
+	later := testNow.Add(time.Hour)
+	msg, err := s.Create(context.Background(), Payload{Type: "text", SendAt: &later,
+		Message: json.RawMessage(`{"from":"6283333","to":"6282222","message":"Your order has shipped"}`)})
+	assert.NoError(t, err)
+
+	s.now = func() time.Time { return later }
+	s.dispatchDue(context.Background())
+
+	assert.Len(t, sender.Texts, 1)
+	assert.Equal(t, "Your order has shipped", sender.Texts[0].Message)
+	assert.Equal(t, StatusSent, storage.msgs[msg.ID].Status)
+}
+
+func TestDispatchRetriesTransientFailures(t *testing.T) {
+	s, storage, sender, registry := newTestService()
+	servicetest.Connect(t, registry, "6281111")
+
+	msg, err := s.Create(context.Background(), Payload{Type: "text", SendAt: &testNow, Message: textMessage()})
+	assert.NoError(t, err)
+
+	// the device over its rate limit queues the message once allowed
+	sender.Errors = []error{
+		&messageSvc.RateLimitError{Reason: "too fast", RetryAfter: time.Minute},
+		sessionSvc.ErrSessionNotReady,
+	}
+	s.dispatchDue(context.Background())
+
+	retry := storage.msgs[msg.ID]
+	assert.Equal(t, StatusScheduled, retry.Status)
+	assert.Equal(t, testNow.Add(time.Minute), retry.NextRunAt)
+	assert.Equal(t, 0, retry.Runs)
+	assert.NotEmpty(t, retry.LastError)
+
+	// the session dropped meanwhile, the message is kept due
+	s.now = func() time.Time { return testNow.Add(time.Minute) }
+	s.dispatchDue(context.Background())
+	assert.Equal(t, StatusScheduled, storage.msgs[msg.ID].Status)
+	assert.Equal(t, testNow.Add(time.Minute), storage.msgs[msg.ID].NextRunAt)
+
+	s.dispatchDue(context.Background())
+	assert.Len(t, sender.Texts, 1)
+	assert.Equal(t, StatusSent, storage.msgs[msg.ID].Status)
+	assert.Equal(t, 1, storage.msgs[msg.ID].Runs)
+}
@@ -0,0 +1,127 @@
+// Package servicetest provides the fakes shared by the tests of the services which queue the outbound messages
+package servicetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// Sender records the queued messages in place of the message service,
+// each queued message gets the next ID, i.e. msg-1, msg-2 and so on
+type Sender struct {
+	mu sync.Mutex
+
+	// Errors are returned, in order, by the next sends instead of queuing the messages
+	Errors []error
+
+	Texts     []botHook.MessagePayload
+	Images    []botHook.MessagePayload
+	Media     []messageSvc.MediaPayload
+	Locations []messageSvc.LocationPayload
+	Contacts  []messageSvc.ContactPayload
+	Sources   []messageSvc.MediaSource
+	Contexts  []messageSvc.MessageContext
+	Keys      []string
+}
+
+// queue records the idempotency key of the message and returns it as queued, unless an error is pending
+func (s *Sender) queue(to, idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	if len(s.Errors) > 0 {
+		err := s.Errors[0]
+		s.Errors = s.Errors[1:]
+		return messageSvc.OutboundMessage{}, false, err
+	}
+
+	s.Keys = append(s.Keys, idempotencyKey)
+
+	return messageSvc.OutboundMessage{
+		ID:     fmt.Sprintf("msg-%d", len(s.Keys)),
+		To:     to,
+		Status: messageSvc.StatusQueued,
+	}, false, nil
+}
+
+func (s *Sender) SendTextMessage(_ context.Context, payload botHook.MessagePayload, msgCtx messageSvc.MessageContext,
+	idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, replayed, err := s.queue(payload.To, idempotencyKey)
+	if err == nil {
+		s.Texts = append(s.Texts, payload)
+		s.Contexts = append(s.Contexts, msgCtx)
+	}
+
+	return msg, replayed, err
+}
+
+func (s *Sender) SendImageMessage(_ context.Context, payload botHook.MessagePayload, msgCtx messageSvc.MessageContext,
+	src messageSvc.MediaSource, idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, replayed, err := s.queue(payload.To, idempotencyKey)
+	if err == nil {
+		s.Images = append(s.Images, payload)
+		s.Sources = append(s.Sources, src)
+		s.Contexts = append(s.Contexts, msgCtx)
+	}
+
+	return msg, replayed, err
+}
+
+func (s *Sender) SendMediaMessage(_ context.Context, _ string, payload messageSvc.MediaPayload,
+	src messageSvc.MediaSource, idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, replayed, err := s.queue(payload.To, idempotencyKey)
+	if err == nil {
+		s.Media = append(s.Media, payload)
+		s.Sources = append(s.Sources, src)
+	}
+
+	return msg, replayed, err
+}
+
+func (s *Sender) SendLocationMessage(_ context.Context, payload messageSvc.LocationPayload,
+	idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, replayed, err := s.queue(payload.To, idempotencyKey)
+	if err == nil {
+		s.Locations = append(s.Locations, payload)
+	}
+
+	return msg, replayed, err
+}
+
+func (s *Sender) SendContactMessage(_ context.Context, payload messageSvc.ContactPayload,
+	idempotencyKey string) (messageSvc.OutboundMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, replayed, err := s.queue(payload.To, idempotencyKey)
+	if err == nil {
+		s.Contacts = append(s.Contacts, payload)
+	}
+
+	return msg, replayed, err
+}
+
+// Connect registers a connected session of the phone
+func Connect(t *testing.T, registry *sessionSvc.Registry, phone string) {
+	t.Helper()
+
+	assert.NoError(t, registry.Reserve(phone, sessionSvc.StateConnecting))
+	assert.NoError(t, registry.SetConnected(phone, phone+"@s.whatsapp.net", &botHook.WaBot{Phone: phone}))
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	fh "github.com/ardihikaru/go-modules/pkg/utils/filehandler"
+	qrCodeH "github.com/ardihikaru/go-modules/pkg/utils/qrcodehandler"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/mdp/qrterminal"
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"go.uber.org/zap"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+// newWhatsappClient initializes a new Whatsapp client and waits until the QR Code has been scanned
+// each rotated QR Code is published to the registry, so that it can be served over the API
+func (s *Service) newWhatsappClient(phone, webhookUrl string) (*botHook.WaBot, error) {
+	client, qrChan, err := s.connectNewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// generates file path to store the qr code
+	// makes sure that phone contains + symbol
+	filePath := fmt.Sprintf("%s/+%s.png", s.qrCodeDir, phone)
+
+	// publishing qrCode
+	for evt := range qrChan {
+		switch evt.Event {
+		case whatsmeow.QRChannelEventCode:
+			err = s.BotClients.SetQRCode(phone, evt.Code, evt.Timeout)
+			if err != nil {
+				s.log.Warn(fmt.Sprintf("session [%s] is no longer waiting for a QR Code", phone), zap.Error(err))
+				client.Disconnect()
+				return nil, err
+			}
+			s.events.Publish(eventSvc.NewQRCodeEvent(phone, evt.Code))
+
+			err = qrCodeH.StoreQrCode(evt.Code, filePath)
+			if err != nil {
+				s.log.Error("failed to write QR Code to file", zap.Error(err))
+			}
+
+			// prints qrcode in terminal (if enabled)
+			if s.qrToTerminal {
+				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+			}
+		case whatsmeow.QRChannelTimeout.Event:
+			s.log.Info(fmt.Sprintf("Login event: %s", evt.Event))
+			s.BotClients.SetQRTimeout(phone)
+		default:
+			s.log.Info(fmt.Sprintf("Login event: %s", evt.Event))
+		}
+	}
+
+	// new session has been created. deleting the file
+	// ignores error event if happens (e.g. ignore if file does not exists)
+	_ = fh.DeleteFile(filePath)
+
+	return s.newBot(client, phone, webhookUrl), nil
+}
+
+// connectNewClient connects a new client without any stored device, the returned channel emits the login events
+// until the device is linked or the login times out
+func (s *Service) connectNewClient() (*whatsmeow.Client, <-chan whatsmeow.QRChannelItem, error) {
+	myDevice := s.whatsAppBot.Container.NewDevice()
+	clientLog := waLog.Stdout("Client", "INFO", true)
+
+	// generates a new client
+	client := whatsmeow.NewClient(myDevice, clientLog)
+
+	// No ID stored, new login
+	qrChan, _ := client.GetQRChannel(context.Background())
+	err := client.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, qrChan, nil
+}
+
+// newBot wraps the client of a linked device into a bot
+func (s *Service) newBot(client *whatsmeow.Client, phone, webhookUrl string) *botHook.WaBot {
+	return &botHook.WaBot{
+		Client:       client,
+		Log:          s.log,
+		Phone:        fmt.Sprintf("+%s", phone),
+		HttpClient:   s.httpClient,
+		WebhookUrl:   webhookUrl,
+		ImageDir:     s.imageDir,
+		EchoMsg:      s.echoMsg,
+		WHookEnabled: s.wHookEnabled,
+	}
+}
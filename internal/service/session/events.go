@@ -0,0 +1,85 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow/types/events"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+// StateChangePublisher publishes every session state change to the event hub
+func StateChangePublisher(hub *eventSvc.Hub) func(Entry) {
+	return func(entry Entry) {
+		hub.Publish(eventSvc.NewConnectionStateEvent(entry.Phone, entry.JID, string(entry.State), entry.LastEventAt))
+	}
+}
+
+// connectionEventHandler keeps the registry in sync with the connection events of the session
+func (s *Service) connectionEventHandler(phone string) func(evt interface{}) {
+	return func(evt interface{}) {
+		var err error
+
+		switch v := evt.(type) {
+		case *events.Connected:
+			err = s.BotClients.Transition(phone, StateConnected, StateDisconnected)
+		case *events.Disconnected:
+			err = s.BotClients.Transition(phone, StateDisconnected, StateConnected)
+		case *events.LoggedOut:
+			s.BotClients.RecordEvent(phone, fmt.Errorf("logged out: %s", v.Reason))
+			s.BotClients.SetLoggedOut(phone)
+		case *events.StreamReplaced:
+			s.BotClients.RecordEvent(phone, fmt.Errorf("stream replaced by another client"))
+		case *events.TemporaryBan:
+			s.BotClients.RecordEvent(phone, errors.New(v.String()))
+		case *events.ConnectFailure:
+			s.BotClients.RecordEvent(phone, fmt.Errorf("connect failure: %s", v.Reason))
+		case *events.StreamError:
+			s.BotClients.RecordEvent(phone, fmt.Errorf("stream error: %s", v.Code))
+		case *events.KeepAliveTimeout:
+			s.BotClients.RecordEvent(phone, fmt.Errorf("keepalive timeout (%d errors)", v.ErrorCount))
+		default:
+			s.BotClients.RecordEvent(phone, nil)
+		}
+
+		// the state did not change, but the event still counts as an activity
+		if err != nil {
+			s.log.Debug(fmt.Sprintf("session [%s] kept its state: %s", phone, err.Error()))
+			s.BotClients.RecordEvent(phone, nil)
+		}
+	}
+}
+
+// streamEventHandler records the messages and receipts of the session and publishes them to the event hub,
+// along with the presences, the group updates and the calls
+func (s *Service) streamEventHandler(phone string) func(evt interface{}) {
+	return func(evt interface{}) {
+		switch v := evt.(type) {
+		case *events.Message:
+			s.recorder.RecordMessage(phone, v.Info, v.Message)
+			if e, ok := eventSvc.NewIncomingMessageEvent(phone, v); ok {
+				s.events.Publish(e)
+			}
+		case *events.Receipt:
+			s.recorder.RecordReceipt(phone, v)
+			for _, e := range eventSvc.NewReceiptEvent(phone, v) {
+				s.events.Publish(e)
+			}
+		case *events.Presence:
+			s.events.Publish(eventSvc.NewPresenceEvent(phone, v))
+		case *events.ChatPresence:
+			s.events.Publish(eventSvc.NewChatPresenceEvent(phone, v))
+		case *events.GroupInfo:
+			for _, e := range eventSvc.NewGroupInfoEvent(phone, v) {
+				s.events.Publish(e)
+			}
+		case *events.JoinedGroup:
+			s.events.Publish(eventSvc.NewJoinedGroupEvent(phone, v))
+		case *events.CallOffer, *events.CallOfferNotice, *events.CallTerminate:
+			if e, ok := eventSvc.NewCallEvent(phone, v); ok {
+				s.events.Publish(e)
+			}
+		}
+	}
+}
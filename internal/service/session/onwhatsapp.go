@@ -0,0 +1,230 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.uber.org/zap"
+)
+
+// ErrNoActiveSession is returned when no connected session can be used to reach the whatsapp servers
+var ErrNoActiveSession = errors.New("no active session to use")
+
+// OnWhatsapp is the registration status of a phone number
+type OnWhatsapp struct {
+	Phone     string    `json:"phone"`
+	JID       string    `json:"jid,omitempty"`
+	IsIn      bool      `json:"is_in"`
+	Error     string    `json:"error,omitempty"`
+	Cached    bool      `json:"cached"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// onWhatsappStorage provides the interface for the on-whatsapp cache operations
+type onWhatsappStorage interface {
+	GetOnWhatsappResults(ctx context.Context, phones []string) ([]OnWhatsapp, error)
+	UpsertOnWhatsappResults(ctx context.Context, results []OnWhatsapp, expiresAt time.Time) error
+}
+
+// CheckerConfig sets up the bulk on-whatsapp checker
+type CheckerConfig struct {
+	// BatchSize is the number of phones checked by a single request to the whatsapp servers
+	BatchSize int
+
+	// CacheTTL is how long a result is reused before the phone is checked again
+	CacheTTL time.Duration
+
+	// MaxNumbers is the maximum number of phones of a single check
+	MaxNumbers int
+
+	// Policy defines how the session used to check the phones is picked
+	Policy CheckerPolicy
+
+	// Phone is the lookup device used by the dedicated policy
+	Phone string
+}
+
+// Checker checks in bulk whether phone numbers are registered on whatsapp
+type Checker struct {
+	storage onWhatsappStorage
+	log     *logger.Logger
+	picker  *picker
+	cfg     CheckerConfig
+}
+
+// NewChecker creates a bulk on-whatsapp checker
+func NewChecker(storage onWhatsappStorage, log *logger.Logger, registry *Registry, cfg CheckerConfig) *Checker {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+
+	return &Checker{
+		storage: storage,
+		log:     log,
+		picker:  newPicker(registry, cfg.Policy, cfg.Phone),
+		cfg:     cfg,
+	}
+}
+
+// Check returns the registration status of each phone, in the given order.
+// The cached results are reused, the other phones are checked by batch with the session designated by via,
+// or with the session picked by the checker policy if via is empty
+func (c *Checker) Check(ctx context.Context, phones []string, via string) ([]OnWhatsapp, error) {
+	if len(phones) == 0 {
+		return nil, fmt.Errorf("at least one phone is required")
+	}
+	if c.cfg.MaxNumbers > 0 && len(phones) > c.cfg.MaxNumbers {
+		return nil, fmt.Errorf("at most %d phones can be checked at once", c.cfg.MaxNumbers)
+	}
+
+	// sanitizes and deduplicates the phones
+	normalized := make([]string, len(phones))
+	results := make(map[string]OnWhatsapp, len(phones))
+	unique := make([]string, 0, len(phones))
+	for i, phone := range phones {
+		normalized[i] = normalizePhone(phone)
+		if _, ok := results[normalized[i]]; ok {
+			continue
+		}
+		if normalized[i] == "" {
+			results[normalized[i]] = OnWhatsapp{Phone: phone, Error: "invalid phone"}
+			continue
+		}
+
+		results[normalized[i]] = OnWhatsapp{}
+		unique = append(unique, normalized[i])
+	}
+
+	// reuses the cached results
+	if len(unique) > 0 {
+		cached, err := c.storage.GetOnWhatsappResults(ctx, unique)
+		if err != nil {
+			c.log.Warn("failed to read the on-whatsapp cache", zap.Error(err))
+		}
+		for _, result := range cached {
+			result.Cached = true
+			results[result.Phone] = result
+		}
+	}
+
+	missing := make([]string, 0, len(unique))
+	for _, phone := range unique {
+		if results[phone].Phone == "" {
+			missing = append(missing, phone)
+		}
+	}
+
+	if len(missing) > 0 {
+		checked, err := c.checkMissing(ctx, missing, via)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range checked {
+			results[result.Phone] = result
+		}
+	}
+
+	ordered := make([]OnWhatsapp, len(phones))
+	for i, phone := range normalized {
+		ordered[i] = results[phone]
+		if phone == "" {
+			ordered[i].Phone = phones[i]
+		}
+	}
+
+	return ordered, nil
+}
+
+// checkMissing checks the phones by batch on the whatsapp servers and caches the results
+func (c *Checker) checkMissing(ctx context.Context, phones []string, via string) ([]OnWhatsapp, error) {
+	session, err := c.picker.pick(via)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Debug(fmt.Sprintf("checking %d phones with the session [%s]", len(phones), session.phone))
+
+	checked := make([]OnWhatsapp, 0, len(phones))
+	for start := 0; start < len(phones); start += c.cfg.BatchSize {
+		end := start + c.cfg.BatchSize
+		if end > len(phones) {
+			end = len(phones)
+		}
+
+		batch, err := c.checkBatch(session.bot, phones[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		// caches each batch right away, so that a retry after a failure does not check it again
+		if c.cfg.CacheTTL > 0 {
+			err = c.storage.UpsertOnWhatsappResults(ctx, batch, time.Now().UTC().Add(c.cfg.CacheTTL))
+			if err != nil {
+				c.log.Warn("failed to update the on-whatsapp cache", zap.Error(err))
+			}
+		}
+
+		checked = append(checked, batch...)
+	}
+
+	return checked, nil
+}
+
+// checkBatch checks a batch of phones with a single request to the whatsapp servers
+func (c *Checker) checkBatch(bot *botHook.WaBot, phones []string) ([]OnWhatsapp, error) {
+	queries := make([]string, len(phones))
+	for i, phone := range phones {
+		queries[i] = "+" + phone
+	}
+
+	resp, err := bot.Client.IsOnWhatsApp(queries)
+	if err != nil {
+		c.log.Error("failed to check on the Whatsapp Server", zap.Error(err))
+		return nil, fmt.Errorf("failed to check on the Whatsapp Server: %w", err)
+	}
+
+	now := time.Now().UTC()
+	byQuery := make(map[string]OnWhatsapp, len(resp))
+	for _, r := range resp {
+		phone := normalizePhone(r.Query)
+		result := OnWhatsapp{Phone: phone, IsIn: r.IsIn, CheckedAt: now}
+		if r.IsIn {
+			result.JID = r.JID.ToNonAD().String()
+		}
+		byQuery[phone] = result
+	}
+
+	// the phones left out of the response are not registered
+	batch := make([]OnWhatsapp, len(phones))
+	for i, phone := range phones {
+		result, ok := byQuery[phone]
+		if !ok {
+			result = OnWhatsapp{Phone: phone, CheckedAt: now}
+		}
+		batch[i] = result
+	}
+
+	return batch, nil
+}
+
+// normalizePhone sanitizes the phone number without the `+` symbol, or returns an empty string if it is invalid
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return ""
+	}
+
+	plusSymbol := false
+	phone = common.SanitizePhone(phone, &plusSymbol)
+	phone = strings.TrimPrefix(strings.NewReplacer("(", "", ")", "", ".", "").Replace(phone), "+")
+	if phone == "" || strings.Trim(phone, "0123456789") != "" {
+		return ""
+	}
+
+	return phone
+}
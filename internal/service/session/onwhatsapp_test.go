@@ -0,0 +1,72 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOnWhatsappStorage is an in memory on-whatsapp cache
+type fakeOnWhatsappStorage struct {
+	results map[string]OnWhatsapp
+}
+
+func (f *fakeOnWhatsappStorage) GetOnWhatsappResults(_ context.Context, phones []string) ([]OnWhatsapp, error) {
+	var results []OnWhatsapp
+	for _, phone := range phones {
+		if result, ok := f.results[phone]; ok {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+func (f *fakeOnWhatsappStorage) UpsertOnWhatsappResults(_ context.Context, results []OnWhatsapp,
+	_ time.Time) error {
+	for _, result := range results {
+		f.results[result.Phone] = result
+	}
+
+	return nil
+}
+
+func TestNormalizePhone(t *testing.T) {
+	assert.Equal(t, "628111222", normalizePhone("+62 811-1222"))
+	assert.Equal(t, "628111222", normalizePhone(" (62) 811.1222 "))
+	assert.Equal(t, "", normalizePhone(""))
+	assert.Equal(t, "", normalizePhone("   "))
+	assert.Equal(t, "", normalizePhone("62811abc"))
+}
+
+func TestCheckerCheck(t *testing.T) {
+	storage := &fakeOnWhatsappStorage{results: map[string]OnWhatsapp{
+		"628111": {Phone: "628111", JID: "628111@s.whatsapp.net", IsIn: true},
+		"628222": {Phone: "628222"},
+	}}
+	checker := NewChecker(storage, nil, NewRegistry(), CheckerConfig{BatchSize: 10, CacheTTL: time.Hour, MaxNumbers: 5})
+
+	// the cached phones are answered without any session, in the given order
+	results, err := checker.Check(context.Background(), []string{"+628222", "bad", "628111", "+62 8111"}, "")
+	assert.NoError(t, err)
+	assert.Len(t, results, 4)
+	assert.Equal(t, "628222", results[0].Phone)
+	assert.False(t, results[0].IsIn)
+	assert.True(t, results[0].Cached)
+	assert.Equal(t, "bad", results[1].Phone)
+	assert.Equal(t, "invalid phone", results[1].Error)
+	assert.Equal(t, "628111@s.whatsapp.net", results[2].JID)
+	assert.Equal(t, results[2], results[3])
+
+	// the phones missing from the cache need a connected session
+	_, err = checker.Check(context.Background(), []string{"628333"}, "")
+	assert.ErrorIs(t, err, ErrNoActiveSession)
+
+	// the number of phones is bounded
+	_, err = checker.Check(context.Background(), nil, "")
+	assert.Error(t, err)
+	_, err = checker.Check(context.Background(), []string{"1", "2", "3", "4", "5", "6"}, "")
+	assert.Error(t, err)
+}
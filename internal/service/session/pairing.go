@@ -0,0 +1,113 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+)
+
+const (
+	// LoginModeQR links the device by scanning a QR Code
+	LoginModeQR = "qr"
+
+	// LoginModeCode links the device by entering a pairing code on the phone
+	LoginModeCode = "code"
+
+	// pairingClientDisplayName is the linked device name shown on the phone, whatsapp only accepts `Browser (OS)`
+	pairingClientDisplayName = "Chrome (Linux)"
+)
+
+var (
+	// ErrInvalidLoginMode is returned when the requested login mode is not supported
+	ErrInvalidLoginMode = errors.New("invalid login mode. valid modes are: qr, code")
+
+	// ErrDeviceLinked is returned when requesting a pairing code for a device which has been linked
+	ErrDeviceLinked = errors.New("device has been linked. disconnect it before pairing again")
+)
+
+// ValidateLoginMode validates the login mode, QR Code is used by default
+func ValidateLoginMode(mode string) (string, error) {
+	switch mode {
+	case "", LoginModeQR:
+		return LoginModeQR, nil
+	case LoginModeCode:
+		return LoginModeCode, nil
+	default:
+		return "", ErrInvalidLoginMode
+	}
+}
+
+// NewWithPairingCode creates a new session which is linked by entering a pairing code on the phone
+// once linking succeeds, the JID is stored in the same way as the QR Code login
+func (s *Service) NewWithPairingCode(ctx context.Context, phone string) (string, error) {
+	// validates if phone exists in the database
+	device, err := s.deviceSvc.GetDeviceByPhone(ctx, phone)
+	if err != nil {
+		return "", err
+	}
+
+	// a pairing code is only meaningful for a device without any session
+	if device.JID != "" {
+		return "", ErrDeviceLinked
+	}
+
+	// reserves the phone, so that any concurrent request will be rejected
+	err = s.BotClients.Reserve(phone, StatePendingCode)
+	if err != nil {
+		return "", err
+	}
+
+	client, loginChan, err := s.connectNewClient()
+	if err != nil {
+		s.BotClients.SetFailed(phone, err)
+		return "", err
+	}
+
+	code, err := client.PairPhone(phone, true, whatsmeow.PairClientChrome, pairingClientDisplayName)
+	if err != nil {
+		client.Disconnect()
+		s.BotClients.SetFailed(phone, err)
+		return "", err
+	}
+
+	// waits for the code to be entered in background process
+	go s.processPairing(phone, device, client, loginChan)
+
+	return code, nil
+}
+
+// processPairing waits until the pairing code has been entered on the phone, or until the login times out,
+// the login times out once whatsapp stops rotating the QR Codes that are sent alongside the pairing code
+func (s *Service) processPairing(phone string, device svc.Device, client *whatsmeow.Client,
+	loginChan <-chan whatsmeow.QRChannelItem) {
+	for evt := range loginChan {
+		switch evt.Event {
+		case whatsmeow.QRChannelEventCode:
+			// the device is linked with the pairing code, the QR Codes are not used
+		case whatsmeow.QRChannelTimeout.Event:
+			s.log.Info(fmt.Sprintf("Login event: %s", evt.Event))
+			s.BotClients.SetPairingCodeTimeout(phone)
+		default:
+			s.log.Info(fmt.Sprintf("Login event: %s", evt.Event))
+		}
+	}
+
+	if client.Store.ID == nil {
+		s.log.Warn("failed to enter the pairing code due to a timeout")
+		s.BotClients.SetPairingCodeTimeout(phone)
+		return
+	}
+
+	bot := s.newBot(client, phone, device.WebhookUrl)
+	thisJID, err := s.storeJID(bot, device)
+	if err != nil {
+		s.BotClients.SetFailed(phone, err)
+		return
+	}
+
+	s.activate(phone, thisJID, bot)
+}
@@ -0,0 +1,120 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+)
+
+// CheckerPolicy defines how the session used to check the phones on whatsapp is picked
+type CheckerPolicy string
+
+const (
+	// CheckerPolicyDedicated always uses the lookup device, the other sessions are never exposed
+	CheckerPolicyDedicated CheckerPolicy = "dedicated"
+
+	// CheckerPolicyRoundRobin uses the connected sessions in turn
+	CheckerPolicyRoundRobin CheckerPolicy = "round-robin"
+
+	// CheckerPolicyLeastRecentlyUsed uses the connected session which has not checked any phone for the longest time
+	CheckerPolicyLeastRecentlyUsed CheckerPolicy = "lru"
+)
+
+// picked is the session picked to check the phones
+type picked struct {
+	phone string
+	bot   *botHook.WaBot
+}
+
+// picker picks the session used to check the phones according to the checker policy
+type picker struct {
+	mu       sync.Mutex
+	registry *Registry
+	policy   CheckerPolicy
+	phone    string
+	next     int
+	lastUsed map[string]time.Time
+}
+
+// newPicker creates a session picker, an unknown policy falls back to round-robin
+func newPicker(registry *Registry, policy CheckerPolicy, phone string) *picker {
+	switch policy {
+	case CheckerPolicyDedicated, CheckerPolicyLeastRecentlyUsed:
+	default:
+		policy = CheckerPolicyRoundRobin
+	}
+
+	return &picker{
+		registry: registry,
+		policy:   policy,
+		phone:    normalizePhone(phone),
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// pick picks the session designated by via, or one according to the policy if via is empty
+func (p *picker) pick(via string) (picked, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var session picked
+	var err error
+	switch {
+	case via != "":
+		session, err = p.connected(normalizePhone(via))
+	case p.policy == CheckerPolicyDedicated:
+		session, err = p.connected(p.phone)
+		if err != nil {
+			err = fmt.Errorf("%w: lookup device [%s]: %w", ErrNoActiveSession, p.phone, err)
+		}
+	default:
+		session, err = p.pickConnected()
+	}
+	if err != nil {
+		return picked{}, err
+	}
+
+	p.lastUsed[session.phone] = time.Now()
+
+	return session, nil
+}
+
+// connected returns the session of the phone if it is connected
+func (p *picker) connected(phone string) (picked, error) {
+	bot, err := p.registry.Bot(phone)
+	if err != nil {
+		return picked{}, err
+	}
+
+	return picked{phone: phone, bot: bot}, nil
+}
+
+// pickConnected picks one of the connected sessions by round-robin or by least recent use
+func (p *picker) pickConnected() (picked, error) {
+	connected := p.registry.Connected()
+	if len(connected) == 0 {
+		return picked{}, ErrNoActiveSession
+	}
+
+	// the registry has no order, sorts the sessions to take turns in a stable order
+	sort.Slice(connected, func(i, j int) bool {
+		return connected[i].Phone < connected[j].Phone
+	})
+
+	chosen := connected[0]
+	if p.policy == CheckerPolicyLeastRecentlyUsed {
+		for _, entry := range connected[1:] {
+			if p.lastUsed[entry.Phone].Before(p.lastUsed[chosen.Phone]) {
+				chosen = entry
+			}
+		}
+	} else {
+		chosen = connected[p.next%len(connected)]
+		p.next++
+	}
+
+	return picked{phone: chosen.Phone, bot: chosen.Bot}, nil
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"testing"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectedRegistry creates a registry with the given phones connected and one pending session
+func connectedRegistry(t *testing.T, phones ...string) *Registry {
+	r := NewRegistry()
+	for _, phone := range phones {
+		assert.NoError(t, r.Reserve(phone, StateConnecting))
+		assert.NoError(t, r.SetConnected(phone, phone+"@s.whatsapp.net", &botHook.WaBot{Phone: phone}))
+	}
+	assert.NoError(t, r.Reserve("628999", StatePendingQR))
+
+	return r
+}
+
+// pickPhones picks n sessions and returns their phones
+func pickPhones(t *testing.T, p *picker, n int) []string {
+	phones := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		session, err := p.pick("")
+		assert.NoError(t, err)
+		phones = append(phones, session.phone)
+	}
+
+	return phones
+}
+
+func TestPickerRoundRobin(t *testing.T) {
+	p := newPicker(connectedRegistry(t, "628333", "628111", "628222"), CheckerPolicyRoundRobin, "")
+	assert.Equal(t, []string{"628111", "628222", "628333", "628111"}, pickPhones(t, p, 4))
+
+	// an unknown policy falls back to round-robin
+	p = newPicker(connectedRegistry(t, "628111", "628222"), "random", "")
+	assert.Equal(t, CheckerPolicyRoundRobin, p.policy)
+
+	// the pending sessions are never picked
+	_, err := newPicker(connectedRegistry(t), CheckerPolicyRoundRobin, "").pick("")
+	assert.ErrorIs(t, err, ErrNoActiveSession)
+}
+
+func TestPickerLeastRecentlyUsed(t *testing.T) {
+	p := newPicker(connectedRegistry(t, "628111", "628222", "628333"), CheckerPolicyLeastRecentlyUsed, "")
+	assert.Equal(t, []string{"628111", "628222", "628333"}, pickPhones(t, p, 3))
+
+	// a forced session counts as used
+	_, err := p.pick("+628111")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"628222", "628333", "628111"}, pickPhones(t, p, 3))
+}
+
+func TestPickerDedicated(t *testing.T) {
+	p := newPicker(connectedRegistry(t, "628111", "628222"), CheckerPolicyDedicated, "+628222")
+	assert.Equal(t, []string{"628222", "628222"}, pickPhones(t, p, 2))
+
+	// the other sessions are never used when the lookup device is not connected
+	p = newPicker(connectedRegistry(t, "628111"), CheckerPolicyDedicated, "628999")
+	_, err := p.pick("")
+	assert.ErrorIs(t, err, ErrNoActiveSession)
+	assert.ErrorIs(t, err, ErrSessionNotReady)
+}
+
+func TestPickerVia(t *testing.T) {
+	p := newPicker(connectedRegistry(t, "628111", "628222"), CheckerPolicyDedicated, "628111")
+
+	session, err := p.pick("+62 8222")
+	assert.NoError(t, err)
+	assert.Equal(t, "628222", session.phone)
+
+	_, err = p.pick("628999")
+	assert.ErrorIs(t, err, ErrSessionNotReady)
+
+	_, err = p.pick("628444")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+	"github.com/yougg/go-qrcode"
+)
+
+const (
+	// QRFormatPNG renders the QR Code as a PNG image
+	QRFormatPNG = "png"
+
+	// QRFormatSVG renders the QR Code as an SVG image
+	QRFormatSVG = "svg"
+
+	// QRFormatString returns the raw QR Code content
+	QRFormatString = "string"
+
+	// qrImageSize is the width and height of the rendered PNG image
+	qrImageSize = 256
+
+	// qrModuleSize is the size of each SVG module (QR Code "pixel")
+	qrModuleSize = 8
+)
+
+var (
+	// ErrQRNotReady is returned when the session is pending but no QR Code has been received yet
+	ErrQRNotReady = errors.New("QR Code is not available yet")
+
+	// ErrQRNotPending is returned when the session is not waiting to be paired
+	ErrQRNotPending = errors.New("session for this device is not waiting for a QR Code")
+
+	// ErrQRInvalidFormat is returned when the requested QR Code format is not supported
+	ErrQRInvalidFormat = errors.New("invalid QR Code format. valid formats are: png, svg, string")
+)
+
+// QRCode is the latest QR Code of a pending session
+type QRCode struct {
+	Phone     string    `json:"phone"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetQRCode returns the current QR Code of a session waiting to be paired
+func (s *Service) GetQRCode(phone string) (QRCode, error) {
+	plusSymbol := false
+	phone = common.SanitizePhone(phone, &plusSymbol)
+
+	entry, ok := s.BotClients.Get(phone)
+	if !ok {
+		return QRCode{}, ErrSessionNotFound
+	}
+
+	if entry.QRTimedOut {
+		return QRCode{}, ErrQRTimeout
+	}
+
+	if entry.State != StatePendingQR {
+		return QRCode{}, ErrQRNotPending
+	}
+
+	if entry.QRCode == "" {
+		return QRCode{}, ErrQRNotReady
+	}
+
+	return QRCode{
+		Phone:     entry.Phone,
+		Code:      entry.QRCode,
+		ExpiresAt: entry.QRExpiresAt,
+	}, nil
+}
+
+// RenderQRCode renders the QR Code content as an image and returns it with its content type
+func RenderQRCode(code, format string) ([]byte, string, error) {
+	switch format {
+	case QRFormatPNG:
+		png, err := qrcode.Encode(code, qrcode.Medium, qrImageSize, qrImageSize, 0)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return png, "image/png", nil
+	case QRFormatSVG:
+		q, err := qrcode.New(code, qrcode.Level(qrcode.Medium))
+		if err != nil {
+			return nil, "", err
+		}
+
+		return buildSvg(q.Bitmap()), "image/svg+xml", nil
+	default:
+		return nil, "", ErrQRInvalidFormat
+	}
+}
+
+// buildSvg draws each set module of the bitmap as an SVG rectangle
+func buildSvg(bitmap [][]bool) []byte {
+	size := len(bitmap) * qrModuleSize
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		size, size, size, size))
+	buf.WriteString(fmt.Sprintf(`<rect width="%d" height="%d" fill="#ffffff"/>`, size, size))
+	for y, row := range bitmap {
+		for x, isSet := range row {
+			if isSet {
+				buf.WriteString(fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" fill="#000000"/>`,
+					x*qrModuleSize, y*qrModuleSize, qrModuleSize, qrModuleSize))
+			}
+		}
+	}
+	buf.WriteString(`</svg>`)
+
+	return buf.Bytes()
+}
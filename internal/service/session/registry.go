@@ -0,0 +1,415 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+)
+
+// State defines the lifecycle state of a whatsapp session
+type State string
+
+const (
+	// StatePendingQR means that the session is waiting for the QR Code to be scanned
+	StatePendingQR State = "PENDING_QR"
+
+	// StatePendingCode means that the session is waiting for the pairing code to be entered on the phone
+	StatePendingCode State = "PENDING_CODE"
+
+	// StateConnecting means that the session is opening the connection to the Whatsapp server
+	StateConnecting State = "CONNECTING"
+
+	// StateConnected means that the session is ready to be used
+	StateConnected State = "CONNECTED"
+
+	// StateDisconnected means that the connection dropped and the client is trying to reconnect
+	StateDisconnected State = "DISCONNECTED"
+
+	// StateLoggedOut means that the session has been logged out from the phone
+	StateLoggedOut State = "LOGGED_OUT"
+
+	// StateFailed means that the session failed to be opened
+	StateFailed State = "FAILED"
+
+	// StateClosed means that the session has been closed on request and removed from the registry
+	StateClosed State = "CLOSED"
+)
+
+var (
+	// ErrSessionNotFound is returned when no session is registered for the phone
+	ErrSessionNotFound = errors.New("no active session found for this device")
+
+	// ErrSessionNotReady is returned when the session is registered but not connected yet
+	ErrSessionNotReady = errors.New("session for this device is not ready yet")
+
+	// ErrSessionExists is returned when trying to reserve a phone which already has an active session
+	ErrSessionExists = errors.New("session for this device has been logged in")
+
+	// ErrInvalidTransition is returned when the requested state transition is not allowed
+	ErrInvalidTransition = errors.New("invalid session state transition")
+
+	// ErrQRTimeout is returned when the QR Code has not been scanned on time
+	ErrQRTimeout = errors.New("QR Code scan timeout")
+
+	// ErrPairingCodeTimeout is returned when the pairing code has not been entered on time
+	ErrPairingCodeTimeout = errors.New("pairing code timeout")
+)
+
+// Entry is a snapshot of a registered session
+type Entry struct {
+	Phone          string
+	JID            string
+	State          State
+	Bot            *botHook.WaBot
+	ConnectedSince time.Time
+	LastEventAt    time.Time
+	LastError      string
+	QRCode         string
+	QRExpiresAt    time.Time
+	QRTimedOut     bool
+}
+
+// IsActive returns true when the session is pending, connecting, connected or waiting to reconnect
+func (e Entry) IsActive() bool {
+	return e.State == StatePendingQR || e.State == StatePendingCode || e.State == StateConnecting || e.State == StateConnected ||
+		e.State == StateDisconnected
+}
+
+// Registry stores the whatsapp sessions and guards every access with a lock
+type Registry struct {
+	mu       sync.RWMutex
+	entries  map[string]*Entry
+	jids     map[string]string
+	listener func(Entry)
+}
+
+// NewRegistry creates an empty session registry
+func NewRegistry() *Registry {
+	return &Registry{
+		entries: make(map[string]*Entry),
+		jids:    make(map[string]string),
+	}
+}
+
+// OnStateChange registers a listener which is called with a snapshot on every state change
+// the listener is called while holding the lock, hence it must neither block nor use the registry
+func (r *Registry) OnStateChange(listener func(Entry)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listener = listener
+}
+
+// notify calls the state change listener, if any
+func (r *Registry) notify(entry *Entry) {
+	if r.listener != nil {
+		r.listener(*entry)
+	}
+}
+
+// Reserve atomically registers the phone with the given initial state
+// it fails with ErrSessionExists when the phone already has an active session
+func (r *Registry) Reserve(phone string, state State) error {
+	if state != StatePendingQR && state != StatePendingCode && state != StateConnecting {
+		return fmt.Errorf("%w: cannot reserve with state %s", ErrInvalidTransition, state)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[phone]; ok && entry.IsActive() {
+		return ErrSessionExists
+	}
+
+	entry := &Entry{
+		Phone:       phone,
+		State:       state,
+		LastEventAt: time.Now().UTC(),
+	}
+	r.entries[phone] = entry
+	r.notify(entry)
+
+	return nil
+}
+
+// Transition moves the session to the designated state when its current state is one of the allowed ones
+func (r *Registry) Transition(phone string, to State, from ...State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	if !stateIn(entry.State, from) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, entry.State, to)
+	}
+
+	now := time.Now().UTC()
+	entry.State = to
+	entry.LastEventAt = now
+	if to == StateConnected {
+		entry.ConnectedSince = now
+		entry.LastError = ""
+	}
+	r.notify(entry)
+
+	return nil
+}
+
+// RecordEvent updates the last event time of the session and stores the error, if any
+func (r *Registry) RecordEvent(phone string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return
+	}
+
+	entry.LastEventAt = time.Now().UTC()
+	if err != nil {
+		entry.LastError = err.Error()
+	}
+}
+
+// SetConnected marks the session as connected and attaches the bot client
+func (r *Registry) SetConnected(phone, jid string, bot *botHook.WaBot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	if !stateIn(entry.State, []State{StatePendingQR, StatePendingCode, StateConnecting}) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, entry.State, StateConnected)
+	}
+
+	// drops the previous JID index if the session has been re-linked
+	if entry.JID != "" && entry.JID != jid {
+		delete(r.jids, entry.JID)
+	}
+
+	now := time.Now().UTC()
+	entry.State = StateConnected
+	entry.JID = jid
+	entry.Bot = bot
+	entry.ConnectedSince = now
+	entry.LastEventAt = now
+	entry.LastError = ""
+	entry.QRCode = ""
+	entry.QRExpiresAt = time.Time{}
+	r.jids[jid] = phone
+	r.notify(entry)
+
+	return nil
+}
+
+// SetQRCode stores the latest QR Code of a session waiting to be paired
+func (r *Registry) SetQRCode(phone, code string, timeout time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	if entry.State != StatePendingQR {
+		return fmt.Errorf("%w: %s does not accept a QR Code", ErrInvalidTransition, entry.State)
+	}
+
+	now := time.Now().UTC()
+	entry.QRCode = code
+	entry.QRExpiresAt = now.Add(timeout)
+	entry.LastEventAt = now
+
+	return nil
+}
+
+// SetQRTimeout marks the session as failed since the QR Code has not been scanned on time
+func (r *Registry) SetQRTimeout(phone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok || entry.State != StatePendingQR {
+		return
+	}
+
+	entry.State = StateFailed
+	entry.QRCode = ""
+	entry.QRTimedOut = true
+	entry.LastError = ErrQRTimeout.Error()
+	entry.LastEventAt = time.Now().UTC()
+	r.notify(entry)
+}
+
+// SetPairingCodeTimeout marks the session as failed since the pairing code has not been entered on time
+func (r *Registry) SetPairingCodeTimeout(phone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok || entry.State != StatePendingCode {
+		return
+	}
+
+	entry.State = StateFailed
+	entry.LastError = ErrPairingCodeTimeout.Error()
+	entry.LastEventAt = time.Now().UTC()
+	r.notify(entry)
+}
+
+// SetFailed marks the session as failed and stores the error
+func (r *Registry) SetFailed(phone string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return
+	}
+
+	entry.State = StateFailed
+	entry.Bot = nil
+	entry.LastEventAt = time.Now().UTC()
+	if err != nil {
+		entry.LastError = err.Error()
+	}
+	r.notify(entry)
+}
+
+// SetLoggedOut marks the session as logged out and releases the bot client
+func (r *Registry) SetLoggedOut(phone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return
+	}
+
+	entry.State = StateLoggedOut
+	entry.Bot = nil
+	entry.LastEventAt = time.Now().UTC()
+	r.notify(entry)
+}
+
+// Remove deletes the session and returns its last snapshot
+func (r *Registry) Remove(phone string) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return Entry{}, false
+	}
+
+	delete(r.entries, phone)
+	if entry.JID != "" && r.jids[entry.JID] == phone {
+		delete(r.jids, entry.JID)
+	}
+
+	// notifies with a closed copy, the returned snapshot keeps the last known state
+	closed := *entry
+	closed.State = StateClosed
+	closed.Bot = nil
+	closed.LastEventAt = time.Now().UTC()
+	r.notify(&closed)
+
+	return *entry, true
+}
+
+// Get returns a snapshot of the session registered for the phone
+func (r *Registry) Get(phone string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return *entry, true
+}
+
+// GetByJID returns a snapshot of the session registered for the JID
+func (r *Registry) GetByJID(jid string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	phone, ok := r.jids[jid]
+	if !ok {
+		return Entry{}, false
+	}
+
+	entry, ok := r.entries[phone]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return *entry, true
+}
+
+// Bot returns the bot client of a connected session
+func (r *Registry) Bot(phone string) (*botHook.WaBot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[phone]
+	if !ok || !entry.IsActive() {
+		return nil, ErrSessionNotFound
+	}
+
+	if entry.State != StateConnected || entry.Bot == nil {
+		return nil, ErrSessionNotReady
+	}
+
+	return entry.Bot, nil
+}
+
+// List returns snapshots of all registered sessions
+func (r *Registry) List() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, *entry)
+	}
+
+	return entries
+}
+
+// Connected returns snapshots of all connected sessions
+func (r *Registry) Connected() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		if entry.State == StateConnected && entry.Bot != nil {
+			entries = append(entries, *entry)
+		}
+	}
+
+	return entries
+}
+
+// stateIn verifies if the state is one of the listed states
+func stateIn(state State, states []State) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
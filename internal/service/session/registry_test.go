@@ -0,0 +1,230 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryReserve(t *testing.T) {
+	r := NewRegistry()
+
+	err := r.Reserve("628111", StatePendingQR)
+	assert.NoError(t, err)
+
+	// an active session can not be reserved twice
+	err = r.Reserve("628111", StateConnecting)
+	assert.ErrorIs(t, err, ErrSessionExists)
+
+	// only the initial states are allowed
+	err = r.Reserve("628222", StateConnected)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// a failed session can be reserved again
+	r.SetFailed("628111", errors.New("timeout"))
+	entry, ok := r.Get("628111")
+	assert.True(t, ok)
+	assert.Equal(t, StateFailed, entry.State)
+	assert.Equal(t, "timeout", entry.LastError)
+
+	err = r.Reserve("628111", StateConnecting)
+	assert.NoError(t, err)
+}
+
+func TestRegistryTransition(t *testing.T) {
+	r := NewRegistry()
+
+	err := r.Transition("628111", StateConnecting, StatePendingQR)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	assert.NoError(t, r.Reserve("628111", StatePendingQR))
+
+	err = r.Transition("628111", StateConnecting, StatePendingQR)
+	assert.NoError(t, err)
+
+	// the current state is no longer pending
+	err = r.Transition("628111", StateConnecting, StatePendingQR)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := NewRegistry()
+	bot := &botHook.WaBot{Phone: "628111"}
+
+	_, err := r.Bot("628111")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	assert.NoError(t, r.Reserve("628111", StateConnecting))
+
+	_, err = r.Bot("628111")
+	assert.ErrorIs(t, err, ErrSessionNotReady)
+
+	assert.NoError(t, r.SetConnected("628111", "628111.0:1@s.whatsapp.net", bot))
+
+	found, err := r.Bot("628111")
+	assert.NoError(t, err)
+	assert.Same(t, bot, found)
+
+	entry, ok := r.GetByJID("628111.0:1@s.whatsapp.net")
+	assert.True(t, ok)
+	assert.Equal(t, "628111", entry.Phone)
+	assert.Equal(t, StateConnected, entry.State)
+	assert.False(t, entry.ConnectedSince.IsZero())
+	assert.Len(t, r.Connected(), 1)
+
+	// a connected session can not be connected again
+	err = r.SetConnected("628111", "628111.0:1@s.whatsapp.net", bot)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	r.SetLoggedOut("628111")
+	_, err = r.Bot("628111")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	assert.Len(t, r.Connected(), 0)
+
+	_, ok = r.Remove("628111")
+	assert.True(t, ok)
+	_, ok = r.GetByJID("628111.0:1@s.whatsapp.net")
+	assert.False(t, ok)
+	assert.Len(t, r.List(), 0)
+}
+
+func TestRegistryConcurrentReserve(t *testing.T) {
+	r := NewRegistry()
+
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.Reserve("628111", StatePendingQR) == nil {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// only one of the concurrent requests may win the reservation
+	assert.Equal(t, int32(1), reserved)
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	r := NewRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		phone := fmt.Sprintf("62811%d", i)
+		wg.Add(2)
+
+		// writer: goes through the whole session lifecycle
+		go func() {
+			defer wg.Done()
+			_ = r.Reserve(phone, StatePendingQR)
+			_ = r.Transition(phone, StateConnecting, StatePendingQR)
+			_ = r.SetConnected(phone, phone+"@s.whatsapp.net", &botHook.WaBot{Phone: phone})
+			r.SetFailed(phone, errors.New("disconnected"))
+			_, _ = r.Remove(phone)
+		}()
+
+		// reader: keeps looking up the sessions
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, _ = r.Bot(phone)
+				_, _ = r.Get(phone)
+				_, _ = r.GetByJID(phone + "@s.whatsapp.net")
+				_ = r.List()
+				_ = r.Connected()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, r.List(), 0)
+}
+
+func TestRegistryReconnect(t *testing.T) {
+	r := NewRegistry()
+
+	assert.NoError(t, r.Reserve("628111", StateConnecting))
+	assert.NoError(t, r.SetConnected("628111", "628111@s.whatsapp.net", &botHook.WaBot{}))
+
+	// a dropped connection keeps the session active but not ready
+	assert.NoError(t, r.Transition("628111", StateDisconnected, StateConnected))
+	r.RecordEvent("628111", errors.New("keepalive timeout"))
+
+	_, err := r.Bot("628111")
+	assert.ErrorIs(t, err, ErrSessionNotReady)
+	assert.ErrorIs(t, r.Reserve("628111", StateConnecting), ErrSessionExists)
+
+	status := toStatus(mustGet(t, r, "628111"))
+	assert.Equal(t, StateDisconnected, status.State)
+	assert.Equal(t, "keepalive timeout", status.LastError)
+	assert.NotNil(t, status.ConnectedSince)
+
+	// reconnecting clears the last error
+	assert.NoError(t, r.Transition("628111", StateConnected, StateDisconnected))
+	status = toStatus(mustGet(t, r, "628111"))
+	assert.Equal(t, StateConnected, status.State)
+	assert.Empty(t, status.LastError)
+}
+
+func mustGet(t *testing.T, r *Registry, phone string) Entry {
+	entry, ok := r.Get(phone)
+	assert.True(t, ok)
+
+	return entry
+}
+
+func TestRegistryQRCode(t *testing.T) {
+	r := NewRegistry()
+
+	assert.ErrorIs(t, r.SetQRCode("628111", "code-1", time.Minute), ErrSessionNotFound)
+
+	assert.NoError(t, r.Reserve("628111", StatePendingQR))
+	assert.NoError(t, r.SetQRCode("628111", "code-1", time.Minute))
+	assert.NoError(t, r.SetQRCode("628111", "code-2", 20*time.Second))
+
+	entry := mustGet(t, r, "628111")
+	assert.Equal(t, "code-2", entry.QRCode)
+	assert.True(t, entry.QRExpiresAt.After(time.Now()))
+
+	// the QR Code is gone once the session times out
+	r.SetQRTimeout("628111")
+	entry = mustGet(t, r, "628111")
+	assert.Equal(t, StateFailed, entry.State)
+	assert.True(t, entry.QRTimedOut)
+	assert.Empty(t, entry.QRCode)
+	assert.ErrorIs(t, r.SetQRCode("628111", "code-3", time.Minute), ErrInvalidTransition)
+
+	// a new reservation starts a fresh pairing
+	assert.NoError(t, r.Reserve("628111", StatePendingQR))
+	assert.False(t, mustGet(t, r, "628111").QRTimedOut)
+}
+
+func TestRegistryPairingCode(t *testing.T) {
+	r := NewRegistry()
+
+	assert.NoError(t, r.Reserve("628111", StatePendingCode))
+	assert.ErrorIs(t, r.Reserve("628111", StatePendingQR), ErrSessionExists)
+
+	// a session waiting for the pairing code does not serve any QR Code
+	assert.ErrorIs(t, r.SetQRCode("628111", "code-1", time.Minute), ErrInvalidTransition)
+
+	r.SetPairingCodeTimeout("628111")
+	entry := mustGet(t, r, "628111")
+	assert.Equal(t, StateFailed, entry.State)
+	assert.Equal(t, ErrPairingCodeTimeout.Error(), entry.LastError)
+
+	// the code is entered on time
+	assert.NoError(t, r.Reserve("628111", StatePendingCode))
+	assert.NoError(t, r.SetConnected("628111", "628111@s.whatsapp.net", &botHook.WaBot{Phone: "628111"}))
+	r.SetPairingCodeTimeout("628111")
+	assert.Equal(t, StateConnected, mustGet(t, r, "628111").State)
+}
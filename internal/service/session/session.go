@@ -3,22 +3,33 @@ package session
 import (
 	"context"
 	"fmt"
-	"go.mau.fi/whatsmeow/types"
 	"go.uber.org/zap"
-	"math/rand"
 	"net/http"
 
 	"github.com/ardihikaru/go-modules/pkg/logger"
 	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
 	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
 )
 
+// messageRecorder stores the messages of the conversations of the devices
+type messageRecorder interface {
+	RecordMessage(phone string, info types.MessageInfo, msg *waProto.Message)
+	RecordReceipt(phone string, v *events.Receipt)
+}
+
 // Service prepares the interfaces related with this auth service
 type Service struct {
 	deviceSvc    *svc.Service
 	log          *logger.Logger
 	whatsAppBot  *botHook.WaManager
-	BotClients   *botHook.BotClientList
+	BotClients   *Registry
+	events       *eventSvc.Hub
+	recorder     messageRecorder
 	httpClient   *http.Client
 	imageDir     string
 	qrCodeDir    string
@@ -30,7 +41,11 @@ type Service struct {
 // NewService creates a new auth service
 func NewService(deviceSvc *svc.Service, log *logger.Logger,
 	whatsAppBot *botHook.WaManager, httpClient *http.Client, imageDir, qrCodeDir string,
-	echoMsg, wHookEnabled, qrToTerminal bool, bcList *botHook.BotClientList) *Service {
+	echoMsg, wHookEnabled, qrToTerminal bool, registry *Registry, eventHub *eventSvc.Hub,
+	recorder messageRecorder) *Service {
+	// the webhook deliveries are signed and retried by the webhook forwarder,
+	// the whatsapp bot module only keeps the echo mode which does not call any webhook
+	wHookEnabled = wHookEnabled && echoMsg
 
 	return &Service{
 		deviceSvc:    deviceSvc,
@@ -42,7 +57,9 @@ func NewService(deviceSvc *svc.Service, log *logger.Logger,
 		echoMsg:      echoMsg,
 		wHookEnabled: wHookEnabled,
 		qrToTerminal: qrToTerminal,
-		BotClients:   bcList,
+		BotClients:   registry,
+		events:       eventHub,
+		recorder:     recorder,
 	}
 }
 
@@ -54,8 +71,15 @@ func (s *Service) New(ctx context.Context, phone string) error {
 		return err
 	}
 
-	// lock it with a null value
-	(*s.BotClients)[phone] = nil
+	// reserves the phone, so that any concurrent request will be rejected
+	state := StateConnecting
+	if device.JID == "" {
+		state = StatePendingQR
+	}
+	err = s.BotClients.Reserve(phone, state)
+	if err != nil {
+		return err
+	}
 
 	// run in background process
 	go s.Process(phone, device)
@@ -72,11 +96,10 @@ func (s *Service) Process(phone string, device svc.Device) {
 	if device.JID == "" {
 		// creates new bot client
 		s.log.Info("creating a new whatsapp session")
-		bot, err = botHook.NewWhatsappClient(s.httpClient, device.WebhookUrl, s.imageDir, s.whatsAppBot.Container, s.log,
-			phone, s.qrCodeDir, s.echoMsg, s.wHookEnabled, s.qrToTerminal)
+		bot, err = s.newWhatsappClient(phone, device.WebhookUrl)
 		if err != nil {
 			s.log.Warn("error create whatsapp client")
-			delete(*s.BotClients, phone)
+			s.BotClients.SetFailed(phone, err)
 			return
 		}
 
@@ -84,20 +107,15 @@ func (s *Service) Process(phone string, device svc.Device) {
 		// in this case, the ID will be null
 		if bot.Client.Store.ID == nil {
 			s.log.Warn("failed to scan the QR Code due to a timeout")
-			delete(*s.BotClients, phone)
+			s.BotClients.SetQRTimeout(phone)
 			return
 		}
 
-		thisJID = bot.Client.Store.ID.String()
-
-		// updates JID and webhook from the device document
-		err = s.deviceSvc.UpdateJID(context.Background(), thisJID, device.ID)
+		thisJID, err = s.storeJID(bot, device)
 		if err != nil {
-			s.log.Warn("failed to update JID information")
-			delete(*s.BotClients, phone)
+			s.BotClients.SetFailed(phone, err)
 			return
 		}
-		s.log.Warn("finished updating the JID information")
 	} else {
 		// opens an existing session
 		s.log.Info(fmt.Sprintf("reconnecting an existing whatsapp session with JID -> %s", device.JID))
@@ -107,18 +125,46 @@ func (s *Service) Process(phone string, device svc.Device) {
 		if err != nil {
 			s.log.Warn(fmt.Sprintf("error create whatsapp client with an existing JID -> %s", device.JID),
 				zap.Error(err))
-			delete(*s.BotClients, phone)
+			s.BotClients.SetFailed(phone, err)
 			return
 		}
 
 		thisJID = device.JID
 	}
 
-	// registers event handler
+	s.activate(phone, thisJID, bot)
+}
+
+// storeJID stores the JID of a freshly linked device
+func (s *Service) storeJID(bot *botHook.WaBot, device svc.Device) (string, error) {
+	thisJID := bot.Client.Store.ID.String()
+
+	// updates JID and webhook from the device document
+	err := s.deviceSvc.UpdateJID(context.Background(), thisJID, device.ID)
+	if err != nil {
+		s.log.Warn("failed to update JID information")
+		return "", err
+	}
+	s.log.Warn("finished updating the JID information")
+
+	return thisJID, nil
+}
+
+// activate registers the event handlers of the bot and marks the session as connected
+func (s *Service) activate(phone, thisJID string, bot *botHook.WaBot) {
+	// registers event handlers
 	bot.Register()
+	bot.Client.AddEventHandler(s.connectionEventHandler(phone))
+	bot.Client.AddEventHandler(s.streamEventHandler(phone))
 
-	// add to client list
-	(*s.BotClients)[phone] = bot
+	// marks the session as connected
+	// the session may have been disconnected while waiting, in this case, closes the fresh connection
+	err := s.BotClients.SetConnected(phone, thisJID, bot)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("session [%s] is no longer reserved. closing the connection", phone), zap.Error(err))
+		bot.Client.Disconnect()
+		return
+	}
 
 	// prints JID
 	s.log.Info(fmt.Sprintf("captured JID -> %s", thisJID))
@@ -128,13 +174,12 @@ func (s *Service) Process(phone string, device svc.Device) {
 func (s *Service) Disconnect(phone string) string {
 	var msg string
 
-	// if key exists, disconnect and remove the key first
-	if _, ok := (*s.BotClients)[phone]; ok {
+	// if key exists, remove the key first and disconnect it
+	if entry, ok := s.BotClients.Remove(phone); ok {
 		// get session client and disconnect it
-		(*s.BotClients)[phone].Client.Disconnect()
-
-		// removes from the map
-		delete(*s.BotClients, phone)
+		if entry.Bot != nil {
+			entry.Bot.Client.Disconnect()
+		}
 
 		msg = fmt.Sprintf("session has been disconnected")
 		s.log.Info(fmt.Sprintf("session [%s] has been disconnected", phone))
@@ -145,162 +190,3 @@ func (s *Service) Disconnect(phone string) string {
 
 	return msg
 }
-
-// SendTextMessage sends a text message
-func (s *Service) SendTextMessage(payload botHook.MessagePayload) error {
-	payload.Sanitize()
-	err := payload.Validate()
-	if err != nil {
-		return err
-	}
-
-	// if device in From (=phone) does not exists, rejects
-	if _, ok := (*s.BotClients)[payload.From]; !ok {
-		return fmt.Errorf("no active session found for this device")
-	} else {
-		// if session is not ready yet, rejects
-		if (*s.BotClients)[payload.From] == nil {
-			return fmt.Errorf("session for this device is not ready yet")
-		}
-
-		// validates phone number and get the recipient
-		recipient, err := (*s.BotClients)[payload.From].ValidateAndGetRecipient(payload.To, true)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("phone [%s] got validation error(s)", payload.To), zap.Error(err))
-			return fmt.Errorf("phone got validation error(s)")
-		}
-
-		// starts sending the message in a background
-		go s.sendTextMessageInBackground(recipient, payload)
-	}
-
-	return nil
-}
-
-// SendImageMessage sends ann image-based message
-func (s *Service) SendImageMessage(payload botHook.MessagePayload) error {
-	payload.Sanitize()
-	err := payload.Validate()
-	if err != nil {
-		return err
-	}
-
-	// if device in From (=phone) does not exists, rejects
-	if _, ok := (*s.BotClients)[payload.From]; !ok {
-		return fmt.Errorf("no active session found for this device")
-	} else {
-		// if session is not ready yet, rejects
-		if (*s.BotClients)[payload.From] == nil {
-			return fmt.Errorf("session for this device is not ready yet")
-		}
-
-		// validates phone number and get the recipient
-		recipient, err := (*s.BotClients)[payload.From].ValidateAndGetRecipient(payload.To, true)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("phone [%s] got validation error(s)", payload.To), zap.Error(err))
-			return fmt.Errorf("phone got validation error(s)")
-		}
-
-		// starts sending the message in a background
-		go s.sendImageMessageInBackground(recipient, payload)
-	}
-
-	return nil
-}
-
-// sendTextMessageInBackground sends a text message in a background
-func (s *Service) sendTextMessageInBackground(recipient *types.JID, payload botHook.MessagePayload) {
-	err := (*s.BotClients)[payload.From].SendMsg(*recipient, payload.Message)
-	if err != nil {
-		s.log.Error(fmt.Sprintf("failed to send the message to [%s]", payload.To), zap.Error(err))
-	}
-}
-
-// sendImageMessageInBackground sends an image-based message in a background
-func (s *Service) sendImageMessageInBackground(recipient *types.JID, payload botHook.MessagePayload) {
-	var err error
-
-	// builds image full path
-	imgPath := fmt.Sprintf("%s/%s", s.imageDir, payload.ImageFileName)
-
-	// uploads to whatsapp server
-	imgInBytes, uploaded, err := (*s.BotClients)[payload.From].UploadImgToWhatsapp(imgPath)
-	if err != nil {
-		s.log.Error(fmt.Sprintf("failed to upload file (=%s) to Whatsapp server", payload.ImageFileName), zap.Error(err))
-		return
-	}
-
-	// prepares image information
-	contentType := http.DetectContentType(*imgInBytes)
-	fileLength := uint64(len(*imgInBytes))
-
-	// sends image message to whatsapp
-	err = (*s.BotClients)[payload.From].SendImgMsg(*recipient, uploaded, payload.ImageCaption, contentType, fileLength)
-	if err != nil {
-		s.log.Error(fmt.Sprintf("failed to send the image message to [%s]", payload.To), zap.Error(err))
-	}
-}
-
-// IsOnWhatsapp verify if this phone number on Whatsapp or not
-func (s *Service) IsOnWhatsapp(phone string) (*bool, error) {
-	var onWa *bool
-	var err error
-
-	// picks one random active client session
-	clientPhone := s.getRandomPhoneAsClient()
-	if clientPhone == nil {
-		s.log.Warn("no active session to be used")
-		return onWa, nil
-	}
-
-	phones := buildValidatedPhone(phone)
-	onWhatsapp, err := (*s.BotClients)[*clientPhone].Client.IsOnWhatsApp(phones)
-	if err != nil {
-		onWa = &onWhatsapp[0].IsIn
-		s.log.Error("failed to check on the Whatsapp Server", zap.Error(err))
-		return onWa, err
-	}
-	s.log.Debug(fmt.Sprintf("%v", onWhatsapp))
-
-	onWa = &onWhatsapp[0].IsIn
-	return onWa, nil
-}
-
-func (s *Service) getRandomPhoneAsClient() *string {
-	// returns nil if no active session found
-	totalSession := len(*s.BotClients)
-	if totalSession == 0 {
-		return nil
-	}
-
-	randIter := rand.Intn(totalSession-0) + 0
-	for phone, _ := range *s.BotClients {
-		if randIter == 0 {
-			// fix bug for random selection
-			// in this case, the value is nil
-			if (*s.BotClients)[phone] == nil {
-				s.log.Warn(fmt.Sprintf("the selected phone (%s) is nil. trying to randomize other phone", phone))
-				return s.getRandomPhoneAsClient()
-			} else {
-				return &phone
-			}
-		}
-
-		randIter -= 1
-	}
-
-	return nil
-}
-
-func buildValidatedPhone(phone string) []string {
-	phones := make([]string, 1)
-
-	// enriches with `+` symbol if missing
-	if phone[0:1] != "+" {
-		phone = fmt.Sprintf("+%s", phone)
-	}
-
-	phones[0] = phone
-
-	return phones
-}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/common"
+)
+
+// Status is the public representation of a registered session
+type Status struct {
+	Phone          string     `json:"phone"`
+	JID            string     `json:"jid,omitempty"`
+	State          State      `json:"state"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty"`
+	LastEventAt    time.Time  `json:"last_event_at"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// toStatus converts the registry entry into a session status
+func toStatus(entry Entry) Status {
+	status := Status{
+		Phone:       entry.Phone,
+		JID:         entry.JID,
+		State:       entry.State,
+		LastEventAt: entry.LastEventAt,
+		LastError:   entry.LastError,
+	}
+
+	// connected since is only relevant for a running session
+	if !entry.ConnectedSince.IsZero() && (entry.State == StateConnected || entry.State == StateDisconnected) {
+		connectedSince := entry.ConnectedSince
+		status.ConnectedSince = &connectedSince
+	}
+
+	return status
+}
+
+// GetStatuses lists the status of all registered sessions, ordered by phone
+func (s *Service) GetStatuses() []Status {
+	entries := s.BotClients.List()
+
+	statuses := make([]Status, 0, len(entries))
+	for _, entry := range entries {
+		statuses = append(statuses, toStatus(entry))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Phone < statuses[j].Phone
+	})
+
+	return statuses
+}
+
+// GetStatus returns the status of the session registered for the phone
+func (s *Service) GetStatus(phone string) (Status, error) {
+	plusSymbol := false
+	phone = common.SanitizePhone(phone, &plusSymbol)
+
+	entry, ok := s.BotClients.Get(phone)
+	if !ok {
+		return Status{}, ErrSessionNotFound
+	}
+
+	return toStatus(entry), nil
+}
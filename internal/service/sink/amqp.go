@@ -0,0 +1,232 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// amqpReconnectDelay is the delay before opening again a consumer which channel has been closed
+const amqpReconnectDelay = 5 * time.Second
+
+// errAMQPClosed is returned once the broker has been closed on request
+var errAMQPClosed = errors.New("amqp broker is closed")
+
+// amqpBroker publishes on the durable RabbitMQ queues through the default exchange, the topic names the queue,
+// the connection and the channels are opened again once closed, e.g. when the server restarts
+type amqpBroker struct {
+	url string
+	log *logger.Logger
+
+	// mu guards the connection and the publishing channel, which is not safe for a concurrent use
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	declared map[string]struct{}
+	closed   bool
+}
+
+// newAMQPBroker connects to the RabbitMQ server
+func newAMQPBroker(url string, log *logger.Logger) (*amqpBroker, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &amqpBroker{
+		url:      url,
+		log:      log,
+		conn:     conn,
+		ch:       ch,
+		declared: make(map[string]struct{}),
+	}, nil
+}
+
+// channel opens a new channel, the connection is dialled again if it has been closed, the lock must be held
+func (b *amqpBroker) channel() (*amqp.Channel, error) {
+	if b.closed {
+		return nil, errAMQPClosed
+	}
+
+	if b.conn.IsClosed() {
+		conn, err := amqp.Dial(b.url)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+	}
+
+	return b.conn.Channel()
+}
+
+// Publish sends the persistent payload to the queue of the topic, the queue is declared on its first use
+func (b *amqpBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the publishing channel is closed by the server on an error, or along with the connection
+	if b.ch.IsClosed() {
+		ch, err := b.channel()
+		if err != nil {
+			return err
+		}
+		b.ch = ch
+		b.declared = make(map[string]struct{})
+	}
+
+	if _, ok := b.declared[topic]; !ok {
+		_, err := b.ch.QueueDeclare(topic, true, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		b.declared[topic] = struct{}{}
+	}
+
+	return b.ch.PublishWithContext(ctx, "", topic, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         payload,
+	})
+}
+
+// Consume reads the queue of the topic on a dedicated channel, a message is acknowledged once handled,
+// the channel and the consumer are declared again whenever the channel is closed.
+// The consumers of a queue share its messages, hence the group is the name of the consumer only
+func (b *amqpBroker) Consume(ctx context.Context, topic, group string,
+	handler func(ctx context.Context, payload []byte)) error {
+	consumer, err := b.consume(topic, group)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			closeErr := consumer.handle(ctx, handler)
+			_ = consumer.ch.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			b.log.Warn(fmt.Sprintf("the consumer of the queue [%s] has been closed, reconnecting", topic),
+				zap.Error(closeErr))
+
+			consumer, err = b.reconsume(ctx, topic, group)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reconsume opens the consumer again until it succeeds, or until the context is done or the broker is closed
+func (b *amqpBroker) reconsume(ctx context.Context, topic, group string) (amqpConsumer, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return amqpConsumer{}, ctx.Err()
+		case <-time.After(amqpReconnectDelay):
+		}
+
+		consumer, err := b.consume(topic, group)
+		if err == nil {
+			b.log.Info(fmt.Sprintf("the consumer of the queue [%s] has been reconnected", topic))
+			return consumer, nil
+		}
+		if errors.Is(err, errAMQPClosed) {
+			return amqpConsumer{}, err
+		}
+		b.log.Warn(fmt.Sprintf("failed to reconnect the consumer of the queue [%s]", topic), zap.Error(err))
+	}
+}
+
+// amqpConsumer is a consumer of a queue along with its channel
+type amqpConsumer struct {
+	ch         *amqp.Channel
+	deliveries <-chan amqp.Delivery
+	closed     <-chan *amqp.Error
+}
+
+// consume declares the queue and its consumer on a new channel
+func (b *amqpBroker) consume(topic, group string) (amqpConsumer, error) {
+	b.mu.Lock()
+	ch, err := b.channel()
+	b.mu.Unlock()
+	if err != nil {
+		return amqpConsumer{}, err
+	}
+
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	_, err = ch.QueueDeclare(topic, true, false, false, false, nil)
+	if err == nil {
+		err = ch.Qos(1, 0, false)
+	}
+	var deliveries <-chan amqp.Delivery
+	if err == nil {
+		deliveries, err = ch.Consume(topic, group, false, false, false, false, nil)
+	}
+	if err != nil {
+		_ = ch.Close()
+		return amqpConsumer{}, err
+	}
+
+	return amqpConsumer{ch: ch, deliveries: deliveries, closed: closed}, nil
+}
+
+// handle hands the deliveries to the handler until the context is done, or until the channel is closed,
+// it returns the reason of the closing, if any
+func (c amqpConsumer) handle(ctx context.Context, handler func(ctx context.Context, payload []byte)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case amqpErr := <-c.closed:
+			return closeReason(amqpErr)
+		case d, ok := <-c.deliveries:
+			if !ok {
+				// the server cancels the consumer, e.g. when the queue is deleted, without closing the channel
+				select {
+				case amqpErr := <-c.closed:
+					return closeReason(amqpErr)
+				default:
+					return errors.New("amqp consumer cancelled")
+				}
+			}
+			handler(ctx, d.Body)
+			_ = d.Ack(false)
+		}
+	}
+}
+
+// closeReason converts the closing notification of the channel into an error,
+// a channel closed without any error has been closed along with the connection
+func closeReason(amqpErr *amqp.Error) error {
+	if amqpErr == nil {
+		return errors.New("amqp channel closed")
+	}
+
+	return amqpErr
+}
+
+// Close disconnects from the server, along with its channels, the consumers are not opened again
+func (b *amqpBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+
+	return b.conn.Close()
+}
@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+// Command is the header of a send message command,
+// the rest of the command is the JSON body of the matching `/api/message/{type}` request
+type Command struct {
+	// Ref is an optional reference of the sender, it is returned on the result
+	Ref string `json:"ref,omitempty"`
+
+	// Type is the message type, e.g. `text`, `image`, `document` or `location`
+	Type string `json:"type"`
+
+	// IdempotencyKey deduplicates the commands delivered more than once by the broker
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// CommandResult is published on the results topic once the command has been handled
+type CommandResult struct {
+	Ref      string `json:"ref,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Replayed bool   `json:"replayed,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleCommand queues the commanded message and publishes its result
+func (s *Service) handleCommand(ctx context.Context, payload []byte) {
+	var cmd Command
+	var result CommandResult
+
+	err := json.Unmarshal(payload, &cmd)
+	if err == nil {
+		result.Ref = cmd.Ref
+
+		var msg messageSvc.OutboundMessage
+		msg, result.Replayed, err = messageSvc.SendJSON(ctx, s.sender, cmd.Type, payload, cmd.IdempotencyKey)
+		result.ID, result.Status = msg.ID, msg.Status
+		if errors.Is(err, messageSvc.ErrUnsupportedType) {
+			err = fmt.Errorf("unsupported command type [%s]", cmd.Type)
+		}
+	}
+	if err != nil {
+		s.log.Debug(fmt.Sprintf("failed to handle the %s command [%s]", cmd.Type, cmd.Ref), zap.Error(err))
+		result.Error = err.Error()
+	}
+
+	if s.cfg.ResultsTopic == "" {
+		return
+	}
+
+	b, _ := json.Marshal(result)
+	pubCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	err = s.broker.Publish(pubCtx, s.cfg.ResultsTopic, b)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to publish the result of the command [%s]", cmd.Ref), zap.Error(err))
+	}
+}
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBroker publishes on the NATS subjects, the messages are not persisted by the core NATS server
+type natsBroker struct {
+	conn *nats.Conn
+}
+
+// newNATSBroker connects to the NATS server, the connection is restored by the client once lost
+func newNATSBroker(url string) (*natsBroker, error) {
+	conn, err := nats.Connect(url, nats.Name("go-whatsapp-multi-device"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsBroker{conn: conn}, nil
+}
+
+// Publish sends the payload on the subject
+func (b *natsBroker) Publish(_ context.Context, topic string, payload []byte) error {
+	return b.conn.Publish(topic, payload)
+}
+
+// Consume subscribes to the subject, the group is the NATS queue group
+func (b *natsBroker) Consume(ctx context.Context, topic, group string,
+	handler func(ctx context.Context, payload []byte)) error {
+	sub, err := b.conn.QueueSubscribe(topic, group, func(msg *nats.Msg) {
+		handler(ctx, msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = sub.Unsubscribe()
+	}()
+
+	return nil
+}
+
+// Close drains the pending messages and disconnects
+func (b *natsBroker) Close() error {
+	return b.conn.Drain()
+}
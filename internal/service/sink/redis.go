@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// streamMaxLen trims the streams approximately, the oldest entries are removed beyond
+	streamMaxLen = 100000
+
+	// streamPayloadField is the entry field carrying the payload
+	streamPayloadField = "payload"
+
+	// streamBlock is how long a read waits for a new entry, the context is checked in between
+	streamBlock = 5 * time.Second
+
+	// streamClaimMinIdle is how long an entry stays pending before another consumer of the group claims it
+	streamClaimMinIdle = time.Minute
+
+	// streamBatchSize is the number of the entries read at once
+	streamBatchSize = 10
+)
+
+// redisBroker publishes on the Redis streams, the topic names the stream
+type redisBroker struct {
+	client *redis.Client
+}
+
+// newRedisBroker connects to the Redis server given by a `redis://` URL
+func newRedisBroker(url string) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	err = client.Ping(context.Background()).Err()
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &redisBroker{client: client}, nil
+}
+
+// Publish appends the payload to the stream
+func (b *redisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{streamPayloadField: payload},
+	}).Err()
+}
+
+// Consume reads the new entries of the stream as a member of the consumer group, an entry is acknowledged once
+// handled, the group is created on the stream if it does not exist yet
+func (b *redisBroker) Consume(ctx context.Context, topic, group string,
+	handler func(ctx context.Context, payload []byte)) error {
+	err := b.client.XGroupCreateMkStream(ctx, topic, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	// the consumer name is unique among the instances of the group and stable across restarts,
+	// so that an instance reads its pending entries again once restarted
+	consumer, err := os.Hostname()
+	if err != nil || consumer == "" {
+		consumer = group
+	}
+
+	go func() {
+		b.recoverPending(ctx, topic, group, consumer, handler)
+
+		for ctx.Err() == nil {
+			streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  []string{topic, ">"},
+				Count:    streamBatchSize,
+				Block:    streamBlock,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				// waits for the connection to be restored
+				select {
+				case <-ctx.Done():
+				case <-time.After(streamBlock):
+				}
+				continue
+			}
+
+			for _, stream := range streams {
+				b.handle(ctx, topic, group, stream.Messages, handler)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// recoverPending handles the entries left pending by this consumer before a restart, and claims the entries
+// left pending for too long by the other consumers of the group, e.g. an instance which is gone for good
+func (b *redisBroker) recoverPending(ctx context.Context, topic, group, consumer string,
+	handler func(ctx context.Context, payload []byte)) {
+	// the pending entries of the consumer are read from the start, they are acknowledged once handled
+	for ctx.Err() == nil {
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{topic, "0"},
+			Count:    streamBatchSize,
+		}).Result()
+		if err != nil || len(streams) == 0 || len(streams[0].Messages) == 0 {
+			break
+		}
+		b.handle(ctx, topic, group, streams[0].Messages, handler)
+	}
+
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   topic,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  streamClaimMinIdle,
+			Start:    start,
+			Count:    streamBatchSize,
+		}).Result()
+		if err != nil {
+			return
+		}
+		b.handle(ctx, topic, group, msgs, handler)
+
+		// the whole pending list has been scanned
+		if next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+// handle hands the entries to the handler and acknowledges them,
+// the entries deleted from the stream meanwhile have no payload and are only acknowledged
+func (b *redisBroker) handle(ctx context.Context, topic, group string, msgs []redis.XMessage,
+	handler func(ctx context.Context, payload []byte)) {
+	for _, msg := range msgs {
+		if payload, ok := msg.Values[streamPayloadField].(string); ok {
+			handler(ctx, []byte(payload))
+		}
+		_ = b.client.XAck(ctx, topic, group, msg.ID).Err()
+	}
+}
+
+// Close disconnects from the server
+func (b *redisBroker) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,174 @@
+// Package sink publishes the session events to a message broker, and consumes the send message commands from it,
+// so that the consumers behind the broker neither expose an HTTP webhook nor call the REST API
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"go.uber.org/zap"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+const (
+	// DriverNATS publishes on the NATS subjects
+	DriverNATS = "nats"
+
+	// DriverAMQP publishes on the durable RabbitMQ queues, one queue per topic
+	DriverAMQP = "amqp"
+
+	// DriverRedis publishes on the Redis streams, one stream per topic
+	DriverRedis = "redis"
+
+	// publishTimeout bounds a single publication on the broker
+	publishTimeout = 5 * time.Second
+
+	// publishQueueSize is the number of the events waiting to be published, the events beyond are lost
+	publishQueueSize = 1000
+)
+
+// Broker publishes the messages on the topics of a message broker, and consumes them
+type Broker interface {
+	// Publish sends the payload on the topic
+	Publish(ctx context.Context, topic string, payload []byte) error
+
+	// Consume hands the messages of the topic to the handler until the context is done,
+	// the consumers sharing the same group receive each message once
+	Consume(ctx context.Context, topic, group string, handler func(ctx context.Context, payload []byte)) error
+
+	// Close disconnects from the broker
+	Close() error
+}
+
+// NewBroker connects to the broker of the given driver
+func NewBroker(driver, url string, log *logger.Logger) (Broker, error) {
+	switch driver {
+	case DriverNATS:
+		return newNATSBroker(url)
+	case DriverAMQP:
+		return newAMQPBroker(url, log)
+	case DriverRedis:
+		return newRedisBroker(url)
+	default:
+		return nil, fmt.Errorf("unknown event sink driver [%s]", driver)
+	}
+}
+
+// Config sets up the event sink
+type Config struct {
+	// EventsTopic receives the session events, except the QR codes
+	EventsTopic string
+
+	// CommandsTopic is consumed for the send message commands, an empty topic consumes no command
+	CommandsTopic string
+
+	// ResultsTopic receives the result of each command, an empty topic publishes no result
+	ResultsTopic string
+
+	// Group is the consumer group of the commands, the instances sharing it receive each command once
+	Group string
+
+	// BaseURL is the public address of this service, it prefixes the media download links
+	BaseURL string
+}
+
+// Service publishes the session events on the broker, and sends the messages commanded through it
+type Service struct {
+	broker Broker
+	log    *logger.Logger
+	sender messageSvc.Sender
+	cfg    Config
+	queue  chan eventSvc.Event
+}
+
+// NewService creates an event sink service
+func NewService(broker Broker, log *logger.Logger, sender messageSvc.Sender, cfg Config) *Service {
+	return &Service{
+		broker: broker,
+		log:    log,
+		sender: sender,
+		cfg:    cfg,
+		queue:  make(chan eventSvc.Event, publishQueueSize),
+	}
+}
+
+// Start publishes the events of the hub and consumes the commands until the context is done
+func (s *Service) Start(ctx context.Context, hub *eventSvc.Hub) error {
+	if s.cfg.CommandsTopic != "" {
+		err := s.broker.Consume(ctx, s.cfg.CommandsTopic, s.cfg.Group, s.handleCommand)
+		if err != nil {
+			return fmt.Errorf("failed to consume the commands topic [%s]: %w", s.cfg.CommandsTopic, err)
+		}
+	}
+
+	// a single publisher keeps the events in order
+	go s.work(ctx)
+
+	sub := hub.Subscribe("")
+
+	go func() {
+		defer hub.Unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.C:
+				// the QR codes authenticate a device, they are only given to the API clients
+				if evt.Body.EventType == eventSvc.TypeQRCode {
+					continue
+				}
+
+				// the hub drops the events of a slow subscriber, hence the publications never block it
+				select {
+				case s.queue <- evt:
+				default:
+					s.log.Warn(fmt.Sprintf("the %s event [%s] has been dropped, the publish queue is full",
+						evt.Body.EventType, evt.Body.MsgId))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// work publishes the queued events one at a time
+func (s *Service) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-s.queue:
+			s.publish(ctx, evt)
+		}
+	}
+}
+
+// publish sends the event on the events topic, the event is lost if the broker does not accept it
+func (s *Service) publish(ctx context.Context, evt eventSvc.Event) {
+	body := evt.Body
+	if body.MediaURL != "" {
+		body.MediaURL = s.cfg.BaseURL + body.MediaURL
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to encode the %s event [%s]", body.EventType, body.MsgId), zap.Error(err))
+		return
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	err = s.broker.Publish(pubCtx, s.cfg.EventsTopic, payload)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to publish the %s event [%s] on the topic [%s]",
+			body.EventType, body.MsgId, s.cfg.EventsTopic), zap.Error(err))
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+)
+
+// memBroker is an in-memory broker, the messages are handed to the consumers synchronously,
+// the publications wait for the gate, if any
+type memBroker struct {
+	mu        sync.Mutex
+	published map[string][][]byte
+	handlers  map[string]func(ctx context.Context, payload []byte)
+	gate      chan struct{}
+}
+
+func newMemBroker() *memBroker {
+	return &memBroker{
+		published: map[string][][]byte{},
+		handlers:  map[string]func(ctx context.Context, payload []byte){},
+	}
+}
+
+func (b *memBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	if b.gate != nil {
+		<-b.gate
+	}
+
+	b.mu.Lock()
+	b.published[topic] = append(b.published[topic], payload)
+	handler := b.handlers[topic]
+	b.mu.Unlock()
+
+	if handler != nil {
+		handler(ctx, payload)
+	}
+
+	return nil
+}
+
+func (b *memBroker) Consume(_ context.Context, topic, _ string,
+	handler func(ctx context.Context, payload []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[topic] = handler
+
+	return nil
+}
+
+func (b *memBroker) Close() error {
+	return nil
+}
+
+func (b *memBroker) messages(topic string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([][]byte(nil), b.published[topic]...)
+}
+
+func newTestService(broker Broker, sender messageSvc.Sender) *Service {
+	return NewService(broker, &logger.Logger{Logger: zap.NewNop()}, sender, Config{
+		EventsTopic:   "events",
+		CommandsTopic: "commands",
+		ResultsTopic:  "results",
+		Group:         "test",
+		BaseURL:       "https://wa.example.com",
+	})
+}
+
+func TestPublishEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newMemBroker()
+	hub := eventSvc.NewHub(10)
+	assert.NoError(t, newTestService(broker, &servicetest.Sender{}).Start(ctx, hub))
+
+	hub.Publish(eventSvc.NewQRCodeEvent("628123", "code"))
+	hub.Publish(eventSvc.Event{
+		Phone: "628123",
+		Body: eventSvc.Body{
+			WebhookBody: botHook.WebhookBody{EventType: eventSvc.TypeIncomingMessage, MsgId: "ABC"},
+			MediaURL:    "/api/media/628123/ABC",
+		},
+	})
+
+	assert.Eventually(t, func() bool {
+		return len(broker.messages("events")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the QR code is not published, the media link is absolute
+	var body eventSvc.Body
+	assert.NoError(t, json.Unmarshal(broker.messages("events")[0], &body))
+	assert.Equal(t, "ABC", body.MsgId)
+	assert.Equal(t, "https://wa.example.com/api/media/628123/ABC", body.MediaURL)
+}
+
+func TestPublishEventsSlowBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := newMemBroker()
+	broker.gate = make(chan struct{})
+	hub := eventSvc.NewHub(1)
+	assert.NoError(t, newTestService(broker, &servicetest.Sender{}).Start(ctx, hub))
+
+	// the broker is stalled, the hub must not drop the events of the sink meanwhile
+	for i := 0; i < 5; i++ {
+		hub.Publish(eventSvc.NewConnectionStateEvent("628123", "", "CONNECTED", time.Now()))
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(broker.gate)
+
+	assert.Eventually(t, func() bool {
+		return len(broker.messages("events")) == 5
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandleCommand(t *testing.T) {
+	broker := newMemBroker()
+	sender := &servicetest.Sender{}
+	assert.NoError(t, newTestService(broker, sender).Start(context.Background(), eventSvc.NewHub(1)))
+
+	_ = broker.Publish(context.Background(), "commands", []byte(`{"ref":"r-1","type":"text",
+		"idempotency_key":"k-1","from":"628123","to":"628456","message":"hello"}`))
+	_ = broker.Publish(context.Background(), "commands", []byte(`{"ref":"r-2","type":"document",
+		"from":"628123","to":"628456","url":"https://example.com/a.pdf","document_name":"a.pdf"}`))
+	_ = broker.Publish(context.Background(), "commands", []byte(`{"ref":"r-3","type":"sticker"}`))
+	_ = broker.Publish(context.Background(), "commands", []byte(`not a json`))
+
+	assert.Equal(t, []botHook.MessagePayload{{From: "628123", To: "628456", Message: "hello"}}, sender.Texts)
+	assert.Equal(t, []string{"k-1", ""}, sender.Keys)
+	assert.Equal(t, "a.pdf", sender.Media[0].DocumentName)
+	assert.Equal(t, "https://example.com/a.pdf", sender.Sources[0].URL)
+
+	results := broker.messages("results")
+	assert.Len(t, results, 4)
+
+	var result CommandResult
+	assert.NoError(t, json.Unmarshal(results[0], &result))
+	assert.Equal(t, CommandResult{Ref: "r-1", ID: "msg-1", Status: messageSvc.StatusQueued}, result)
+
+	result = CommandResult{}
+	assert.NoError(t, json.Unmarshal(results[2], &result))
+	assert.Equal(t, "r-3", result.Ref)
+	assert.Contains(t, result.Error, "unsupported command type")
+
+	result = CommandResult{}
+	assert.NoError(t, json.Unmarshal(results[3], &result))
+	assert.NotEmpty(t, result.Error)
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"go.uber.org/zap"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+// ErrDeadLetterNotFound is returned when the dead letter does not exist, or has been replayed already
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a delivery which has not been acknowledged by the webhook after its last attempt
+// a dead letter without subscription ID has been posted to the webhook URL of the device
+type DeadLetter struct {
+	ID             string        `json:"id"`
+	Phone          string        `json:"phone"`
+	EventType      string        `json:"event_type"`
+	MsgID          string        `json:"msg_id"`
+	SubscriptionID string        `json:"subscription_id,omitempty"`
+	WebhookURL     string        `json:"webhook_url"`
+	Body           eventSvc.Body `json:"body"`
+	Attempts       int           `json:"attempts"`
+	LastError      string        `json:"last_error"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// GetDeadLetters lists the dead letters, of a device if the phone is set
+func (f *Forwarder) GetDeadLetters(ctx context.Context, phone string, params httputils.GetQueryParams) (int64,
+	[]DeadLetter, error) {
+	return f.storage.GetWebhookDeadLetters(ctx, strings.TrimPrefix(phone, "+"), params)
+}
+
+// GetDeadLetter extracts a dead letter based on the ID
+func (f *Forwarder) GetDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	return f.storage.GetWebhookDeadLetter(ctx, id)
+}
+
+// DeleteDeadLetter discards a dead letter without delivering it
+func (f *Forwarder) DeleteDeadLetter(ctx context.Context, id string) error {
+	return f.storage.DeleteWebhookDeadLetter(ctx, id)
+}
+
+// Replay delivers a dead letter once more to the current URL of its webhook subscription,
+// or of the device webhook, it is removed once acknowledged, otherwise its attempts and last error are updated
+func (f *Forwarder) Replay(ctx context.Context, id string) error {
+	letter, err := f.storage.GetWebhookDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	device, err := f.signingDevice(ctx, letter.Phone)
+	if err != nil {
+		return err
+	}
+
+	url := device.WebhookUrl
+	if letter.SubscriptionID != "" {
+		sub, err := f.storage.GetWebhookSubscription(ctx, device.ID, letter.SubscriptionID)
+		if err != nil {
+			return err
+		}
+		url = sub.URL
+	}
+	if url == "" {
+		return fmt.Errorf("the device has no webhook URL")
+	}
+
+	_, _, err = f.post(ctx, device, url, letter.Body)
+	if err != nil {
+		updateErr := f.storage.UpdateWebhookDeadLetterFailure(ctx, id, err.Error())
+		if updateErr != nil {
+			f.log.Warn(fmt.Sprintf("failed to update the dead letter [%s]", id), zap.Error(updateErr))
+		}
+
+		return err
+	}
+
+	return f.storage.DeleteWebhookDeadLetter(ctx, id)
+}
+
+// deadLetter stores the delivery which could not be acknowledged, so that it can be inspected and replayed
+func (f *Forwarder) deadLetter(ctx context.Context, evt eventSvc.Event, t target, attempts int,
+	deliveryErr error) {
+	f.log.Warn(fmt.Sprintf("failed to deliver the %s event [%s] to the webhook after %d attempt(s)",
+		evt.Body.EventType, evt.Body.MsgId, attempts), zap.Error(deliveryErr))
+
+	f.storeDeadLetter(ctx, newDeadLetter(evt, t, attempts, deliveryErr))
+}
+
+// deferDeadLetter hands the event which could not be queued over to the overflow goroutine, without blocking,
+// the event is lost when the overflow is full as well
+func (f *Forwarder) deferDeadLetter(evt eventSvc.Event, deliveryErr error) {
+	select {
+	case f.overflow <- newDeadLetter(evt, target{}, 0, deliveryErr):
+	default:
+		f.log.Error(fmt.Sprintf("the %s event [%s] has been dropped, the dead letter queue is full",
+			evt.Body.EventType, evt.Body.MsgId), zap.Error(deliveryErr))
+	}
+}
+
+// storeOverflow stores the dead letters of the events which could not be queued until the context is done
+func (f *Forwarder) storeOverflow(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case letter := <-f.overflow:
+			f.log.Warn(fmt.Sprintf("failed to queue the %s event [%s] to the webhook: %s", letter.EventType,
+				letter.MsgID, letter.LastError))
+			f.storeDeadLetter(ctx, letter)
+		}
+	}
+}
+
+// storeDeadLetter inserts the dead letter
+func (f *Forwarder) storeDeadLetter(ctx context.Context, letter DeadLetter) {
+	_, err := f.storage.InsertWebhookDeadLetter(ctx, letter)
+	if err != nil {
+		f.log.Error(fmt.Sprintf("failed to store the dead letter of the message [%s]", letter.MsgID),
+			zap.Error(err))
+	}
+}
+
+// newDeadLetter builds the dead letter of the delivery
+func newDeadLetter(evt eventSvc.Event, t target, attempts int, deliveryErr error) DeadLetter {
+	now := time.Now().UTC()
+
+	return DeadLetter{
+		Phone:          evt.Phone,
+		EventType:      evt.Body.EventType,
+		MsgID:          evt.Body.MsgId,
+		SubscriptionID: t.subscriptionID,
+		WebhookURL:     t.url,
+		Body:           evt.Body,
+		Attempts:       attempts,
+		LastError:      deliveryErr.Error(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+const (
+	// HeaderSignature carries the HMAC-SHA256 signature of the delivery, see Sign
+	HeaderSignature = "X-Webhook-Signature"
+
+	// HeaderTimestamp carries the unix time of the delivery attempt, it is also the `sent_at` of the payload
+	HeaderTimestamp = "X-Webhook-Timestamp"
+
+	// signaturePrefix names the algorithm of the signature
+	signaturePrefix = "sha256="
+)
+
+// Sign computes the signature of a delivery with the webhook secret of the device,
+// the signed content is `<timestamp>.<body>` so that a delivery can not be replayed with another timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery, it is the check expected from the webhook receivers
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
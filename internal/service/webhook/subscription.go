@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+)
+
+const (
+	// EventMessage subscribes to the incoming messages
+	EventMessage = "message"
+
+	// EventReceipt subscribes to the delivery and read receipts
+	EventReceipt = "receipt"
+
+	// EventPresence subscribes to the presences of the contacts, and to their typing in the chats
+	EventPresence = "presence"
+
+	// EventGroupUpdate subscribes to the group joins, and to the group info and participants changes
+	EventGroupUpdate = "group_update"
+
+	// EventConnectionState subscribes to the session state changes
+	EventConnectionState = "connection_state"
+
+	// EventCall subscribes to the call offers and terminations
+	EventCall = "call"
+)
+
+const (
+	// ChatPrivate filters the events of the private chats
+	ChatPrivate = "private"
+
+	// ChatGroup filters the events of the group chats
+	ChatGroup = "group"
+)
+
+// eventTypes maps the subscribed event types into the event types of the hub
+var eventTypes = map[string]string{
+	EventMessage:         eventSvc.TypeIncomingMessage,
+	EventReceipt:         eventSvc.TypeReceipt,
+	EventPresence:        eventSvc.TypePresence,
+	EventGroupUpdate:     eventSvc.TypeGroupUpdate,
+	EventConnectionState: eventSvc.TypeConnectionState,
+	EventCall:            eventSvc.TypeCall,
+}
+
+// ErrSubscriptionNotFound is returned when the webhook subscription does not exist on the device
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+
+// Subscription is a webhook endpoint of a device, it receives the subscribed event types only
+// an empty list of chat types receives the events of any chat
+type Subscription struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	ChatTypes []string  `json:"chat_types,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SubscriptionPayload is the input JSON body captured from the webhook subscription request
+type SubscriptionPayload struct {
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	ChatTypes []string `json:"chat_types"`
+}
+
+// Sanitize normalizes the event and chat types, and removes their duplicates
+func (p *SubscriptionPayload) Sanitize() {
+	p.URL = strings.TrimSpace(p.URL)
+	p.Events = normalizeList(p.Events)
+	p.ChatTypes = normalizeList(p.ChatTypes)
+}
+
+// Validate validates the input data
+func (p *SubscriptionPayload) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+
+	if len(p.Events) == 0 {
+		return fmt.Errorf("events must have at least one event type")
+	}
+	for _, e := range p.Events {
+		if _, ok := eventTypes[e]; !ok {
+			return fmt.Errorf("unknown event type [%s]", e)
+		}
+	}
+
+	for _, c := range p.ChatTypes {
+		if c != ChatPrivate && c != ChatGroup {
+			return fmt.Errorf("unknown chat type [%s], expected `%s` or `%s`", c, ChatPrivate, ChatGroup)
+		}
+	}
+
+	return nil
+}
+
+// Matches checks whether the event is subscribed,
+// the chat types only filter the events bound to a chat, e.g. the connection state changes are never filtered
+func (s Subscription) Matches(evt eventSvc.Event) bool {
+	subscribed := false
+	for _, e := range s.Events {
+		if eventTypes[e] == evt.Body.EventType {
+			subscribed = true
+			break
+		}
+	}
+	if !subscribed {
+		return false
+	}
+
+	if len(s.ChatTypes) == 0 {
+		return true
+	}
+
+	chatType := eventChatType(evt)
+	if chatType == "" {
+		return true
+	}
+	for _, c := range s.ChatTypes {
+		if c == chatType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetSubscriptions lists the webhook subscriptions of the device
+func (f *Forwarder) GetSubscriptions(ctx context.Context, deviceID string) ([]Subscription, error) {
+	_, err := f.storage.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.storage.GetWebhookSubscriptions(ctx, deviceID)
+}
+
+// GetSubscription extracts a webhook subscription of the device based on the ID
+func (f *Forwarder) GetSubscription(ctx context.Context, deviceID, id string) (Subscription, error) {
+	return f.storage.GetWebhookSubscription(ctx, deviceID, id)
+}
+
+// CreateSubscription adds a webhook subscription to the device
+func (f *Forwarder) CreateSubscription(ctx context.Context, deviceID string,
+	payload SubscriptionPayload) (Subscription, error) {
+	payload.Sanitize()
+	err := payload.Validate()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	_, err = f.storage.GetDeviceByID(ctx, deviceID)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	now := time.Now().UTC()
+
+	sub, err := f.storage.InsertWebhookSubscription(ctx, Subscription{
+		DeviceID:  deviceID,
+		URL:       payload.URL,
+		Events:    payload.Events,
+		ChatTypes: payload.ChatTypes,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+	f.invalidateSubscriptions(deviceID)
+
+	return sub, nil
+}
+
+// UpdateSubscription replaces the endpoint and the filters of a webhook subscription
+func (f *Forwarder) UpdateSubscription(ctx context.Context, deviceID, id string,
+	payload SubscriptionPayload) (Subscription, error) {
+	payload.Sanitize()
+	err := payload.Validate()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub, err := f.storage.GetWebhookSubscription(ctx, deviceID, id)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub.URL = payload.URL
+	sub.Events = payload.Events
+	sub.ChatTypes = payload.ChatTypes
+	sub.UpdatedAt = time.Now().UTC()
+
+	err = f.storage.UpdateWebhookSubscription(ctx, sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	f.invalidateSubscriptions(deviceID)
+
+	return sub, nil
+}
+
+// DeleteSubscription removes a webhook subscription of the device
+func (f *Forwarder) DeleteSubscription(ctx context.Context, deviceID, id string) error {
+	err := f.storage.DeleteWebhookSubscription(ctx, deviceID, id)
+	if err != nil {
+		return err
+	}
+	f.invalidateSubscriptions(deviceID)
+
+	return nil
+}
+
+// eventChatType classifies the chat of the event, it is empty if the event is not bound to a chat
+func eventChatType(evt eventSvc.Event) string {
+	switch evt.Body.EventType {
+	case eventSvc.TypeConnectionState:
+		return ""
+	case eventSvc.TypeGroupUpdate:
+		return ChatGroup
+	}
+
+	jid, err := types.ParseJID(evt.Body.ChatJID)
+	if err != nil || evt.Body.ChatJID == "" {
+		return ""
+	}
+	if jid.Server == types.GroupServer {
+		return ChatGroup
+	}
+
+	return ChatPrivate
+}
+
+// normalizeList lowercases the values and removes the empty and the duplicated ones
+func normalizeList(values []string) []string {
+	res := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if _, ok := seen[v]; ok || v == "" {
+			continue
+		}
+		seen[v] = struct{}{}
+		res = append(res, v)
+	}
+
+	return res
+}
@@ -0,0 +1,470 @@
+// Package webhook delivers the session events to the device webhook and to its webhook subscriptions,
+// the deliveries are signed and retried, and the failed deliveries are kept as dead letters to be replayed later on
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/web"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"go.mau.fi/whatsmeow/types"
+	"go.uber.org/zap"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	messageSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// maxRetryBackoff caps the exponential backoff between two attempts
+	maxRetryBackoff = 5 * time.Minute
+
+	// deliveryQueueSize is the number of the events waiting for a worker, the events beyond are dead-lettered
+	deliveryQueueSize = 1000
+
+	// deadLetterQueueSize is the number of the overflowing events waiting to be stored as dead letters
+	deadLetterQueueSize = 1000
+
+	// maxResponseSize bounds the webhook response read to extract the reply message
+	maxResponseSize = 1 << 20
+
+	// subscriptionsCacheTTL bounds the time the subscriptions of a device are cached,
+	// e.g. when they are changed by another instance
+	subscriptionsCacheTTL = time.Minute
+)
+
+// ErrDeliveryFailed is returned when the webhook does not acknowledge the delivery
+var ErrDeliveryFailed = errors.New("webhook delivery failed")
+
+// storage provides the interface for the functionality of MongoDB
+type storage interface {
+	GetDeviceByID(ctx context.Context, id string) (deviceSvc.Device, error)
+	GetDeviceByPhone(ctx context.Context, phone string) (deviceSvc.Device, error)
+	InitWebhookSecret(ctx context.Context, id, secret string) error
+	InsertWebhookSubscription(ctx context.Context, doc Subscription) (Subscription, error)
+	GetWebhookSubscription(ctx context.Context, deviceID, id string) (Subscription, error)
+	GetWebhookSubscriptions(ctx context.Context, deviceID string) ([]Subscription, error)
+	UpdateWebhookSubscription(ctx context.Context, doc Subscription) error
+	DeleteWebhookSubscription(ctx context.Context, deviceID, id string) error
+	InsertWebhookDeadLetter(ctx context.Context, doc DeadLetter) (DeadLetter, error)
+	GetWebhookDeadLetter(ctx context.Context, id string) (DeadLetter, error)
+	GetWebhookDeadLetters(ctx context.Context, phone string, params httputils.GetQueryParams) (int64,
+		[]DeadLetter, error)
+	UpdateWebhookDeadLetterFailure(ctx context.Context, id, lastError string) error
+	DeleteWebhookDeadLetter(ctx context.Context, id string) error
+}
+
+// Config sets up the webhook forwarder
+type Config struct {
+	// Enabled forwards the events, it follows the webhook settings of the whatsapp bot
+	Enabled bool
+
+	// BaseURL is the public address of this service, it prefixes the media download links
+	BaseURL string
+
+	// Workers is the number of the concurrent deliveries
+	Workers int
+
+	// MaxAttempts is the number of attempts before a delivery is dead-lettered
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, it is doubled on each attempt
+	RetryBackoff time.Duration
+}
+
+// Payload is the body posted to the webhook, SentAt is the unix time of the attempt and is covered by the signature
+type Payload struct {
+	eventSvc.Body
+	SentAt int64 `json:"sent_at"`
+}
+
+// target is an endpoint receiving an event,
+// the webhook URL of the device has no subscription and keeps receiving the private incoming messages only
+type target struct {
+	subscriptionID string
+	url            string
+}
+
+// deviceSubscriptions are the cached webhook subscriptions of a device
+type deviceSubscriptions struct {
+	subs      []Subscription
+	expiresAt time.Time
+}
+
+// Forwarder posts the session events to the device webhook and to the matching webhook subscriptions,
+// it replaces the unsigned and fire-and-forget delivery of the whatsapp bot module
+type Forwarder struct {
+	storage    storage
+	log        *logger.Logger
+	BotClients *sessionSvc.Registry
+	sender     messageSvc.Sender
+	httpClient *http.Client
+	cfg        Config
+	queue      chan eventSvc.Event
+	overflow   chan DeadLetter
+
+	// subs caches the subscriptions of each device ID, the generation is increased whenever a subscription changes,
+	// hence the subscriptions loaded before a change are not cached
+	subsMu     sync.Mutex
+	subs       map[string]deviceSubscriptions
+	generation uint64
+}
+
+// NewForwarder creates a webhook forwarder
+func NewForwarder(storage storage, log *logger.Logger, registry *sessionSvc.Registry, sender messageSvc.Sender,
+	httpClient *http.Client, cfg Config) *Forwarder {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	return &Forwarder{
+		storage:    storage,
+		log:        log,
+		BotClients: registry,
+		sender:     sender,
+		httpClient: httpClient,
+		cfg:        cfg,
+		queue:      make(chan eventSvc.Event, deliveryQueueSize),
+		overflow:   make(chan DeadLetter, deadLetterQueueSize),
+		subs:       make(map[string]deviceSubscriptions),
+	}
+}
+
+// Start forwards the events published on the hub until the context is done
+func (f *Forwarder) Start(ctx context.Context, hub *eventSvc.Hub) {
+	if !f.cfg.Enabled {
+		return
+	}
+
+	for i := 0; i < f.cfg.Workers; i++ {
+		go f.work(ctx)
+	}
+	go f.storeOverflow(ctx)
+
+	sub := hub.Subscribe("")
+
+	go func() {
+		defer hub.Unsubscribe(sub)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.C:
+				if !deliverable(evt) {
+					continue
+				}
+				if evt.Body.MediaURL != "" {
+					evt.Body.MediaURL = f.cfg.BaseURL + evt.Body.MediaURL
+				}
+
+				// the hub drops the events of a slow subscriber, hence neither the deliveries nor the dead letters block it
+				select {
+				case f.queue <- evt:
+				default:
+					f.deferDeadLetter(evt, fmt.Errorf("the delivery queue is full"))
+				}
+			}
+		}
+	}()
+}
+
+// work delivers the queued events one at a time
+func (f *Forwarder) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-f.queue:
+			f.deliver(ctx, evt)
+		}
+	}
+}
+
+// deliver posts the event to each of its targets concurrently
+func (f *Forwarder) deliver(ctx context.Context, evt eventSvc.Event) {
+	device, err := f.signingDevice(ctx, evt.Phone)
+	if err != nil {
+		f.deadLetter(ctx, evt, target{}, 0, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range f.targets(ctx, device, evt) {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			f.deliverTo(ctx, device, t, evt)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// signingDevice fetches the device, and gives a webhook secret to the devices created before the deliveries were signed
+func (f *Forwarder) signingDevice(ctx context.Context, phone string) (deviceSvc.Device, error) {
+	device, err := f.storage.GetDeviceByPhone(ctx, "+"+phone)
+	if err != nil || device.WebhookSecret != "" {
+		return device, err
+	}
+
+	secret, err := deviceSvc.NewWebhookSecret()
+	if err != nil {
+		return deviceSvc.Device{}, err
+	}
+
+	// another delivery may have set the secret in the meantime, hence the device is fetched again
+	err = f.storage.InitWebhookSecret(ctx, device.ID, secret)
+	if err != nil {
+		return deviceSvc.Device{}, err
+	}
+
+	device, err = f.storage.GetDeviceByID(ctx, device.ID)
+	if err != nil {
+		return deviceSvc.Device{}, err
+	}
+	if device.WebhookSecret == "" {
+		return deviceSvc.Device{}, fmt.Errorf("the device has no webhook secret")
+	}
+
+	return device, nil
+}
+
+// targets lists the endpoints receiving the event,
+// the subscriptions which can not be fetched are skipped, the webhook URL of the device is still delivered
+func (f *Forwarder) targets(ctx context.Context, device deviceSvc.Device, evt eventSvc.Event) []target {
+	targets := make([]target, 0, 1)
+	if device.WebhookUrl != "" && forwarded(evt) {
+		targets = append(targets, target{url: device.WebhookUrl})
+	}
+
+	subs, err := f.deviceSubscriptions(ctx, device.ID)
+	if err != nil {
+		f.log.Warn(fmt.Sprintf("failed to fetch the webhook subscriptions of the device [%s]", device.Phone),
+			zap.Error(err))
+		return targets
+	}
+	for _, sub := range subs {
+		if sub.Matches(evt) {
+			targets = append(targets, target{subscriptionID: sub.ID, url: sub.URL})
+		}
+	}
+
+	return targets
+}
+
+// deviceSubscriptions returns the subscriptions of the device, from the cache unless they have expired
+func (f *Forwarder) deviceSubscriptions(ctx context.Context, deviceID string) ([]Subscription, error) {
+	now := time.Now()
+
+	f.subsMu.Lock()
+	cached, ok := f.subs[deviceID]
+	generation := f.generation
+	f.subsMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.subs, nil
+	}
+
+	subs, err := f.storage.GetWebhookSubscriptions(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.subsMu.Lock()
+	if f.generation == generation {
+		f.subs[deviceID] = deviceSubscriptions{subs: subs, expiresAt: now.Add(subscriptionsCacheTTL)}
+	}
+	f.subsMu.Unlock()
+
+	return subs, nil
+}
+
+// invalidateSubscriptions drops the cached subscriptions of the device
+func (f *Forwarder) invalidateSubscriptions(deviceID string) {
+	f.subsMu.Lock()
+	defer f.subsMu.Unlock()
+
+	f.generation++
+	delete(f.subs, deviceID)
+}
+
+// deliverTo posts the event until the target acknowledges it, the event is dead-lettered after the last attempt
+// only the webhook URL of the device may reply to the incoming message, as the whatsapp bot module does
+func (f *Forwarder) deliverTo(ctx context.Context, device deviceSvc.Device, t target, evt eventSvc.Event) {
+	var err error
+	var attempts int
+	for attempts = 1; attempts <= f.cfg.MaxAttempts; attempts++ {
+		var resp []byte
+		var retryable bool
+		resp, retryable, err = f.post(ctx, device, t.url, evt.Body)
+		if err == nil {
+			if t.subscriptionID == "" {
+				f.reply(ctx, evt, resp)
+			}
+			return
+		}
+		if !retryable || attempts == f.cfg.MaxAttempts {
+			break
+		}
+
+		backoff := retryBackoff(f.cfg.RetryBackoff, attempts)
+		f.log.Debug(fmt.Sprintf("failed to deliver the %s event [%s] to [%s], retrying in %s",
+			evt.Body.EventType, evt.Body.MsgId, t.url, backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			f.deadLetter(context.Background(), evt, t, attempts, err)
+			return
+		case <-time.After(backoff):
+		}
+	}
+
+	f.deadLetter(ctx, evt, t, attempts, err)
+}
+
+// post signs the body with the secret of the device and posts it to the URL, it returns the response body on success
+// the errors of the network, the timeouts, the rate limits and the server errors are retryable
+func (f *Forwarder) post(ctx context.Context, device deviceSvc.Device, url string, body eventSvc.Body) ([]byte,
+	bool, error) {
+	sentAt := time.Now().UTC().Unix()
+	payload, err := json.Marshal(Payload{Body: body, SentAt: sentAt})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// builds request
+	req, err := web.BuildRequest(url, http.MethodPost, bytes.NewReader(payload))
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, fmt.Sprintf("%d", sentAt))
+	req.Header.Set(HeaderSignature, Sign(device.WebhookSecret, sentAt, payload))
+
+	// sends request
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: %s", ErrDeliveryFailed, err.Error())
+	}
+	defer resp.Body.Close()
+
+	// validates response
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("%w: got error response from the webhook: %s", ErrDeliveryFailed,
+			resp.Status)
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+
+	return respBody, false, nil
+}
+
+// reply queues the reply message returned by the webhook, as the whatsapp bot module does,
+// and marks the incoming message as read.
+// The idempotency key prevents replying twice to a message delivered twice by whatsapp
+func (f *Forwarder) reply(ctx context.Context, evt eventSvc.Event, resp []byte) {
+	var respPayload httputils.Response
+	if json.Unmarshal(resp, &respPayload) != nil || respPayload.Data == nil {
+		return
+	}
+
+	// extracts response payload
+	byteData, _ := json.Marshal(respPayload.Data)
+	var replyMsg botHook.ReplyMessage
+	if json.Unmarshal(byteData, &replyMsg) != nil || (replyMsg.Message == "" && !replyMsg.WithImage) {
+		return
+	}
+
+	chat, err := types.ParseJID(evt.Body.ChatJID)
+	if err != nil {
+		return
+	}
+
+	key := "webhook:" + evt.Body.MsgId
+	if replyMsg.WithImage {
+		_, _, err = f.sender.SendImageMessage(ctx, botHook.MessagePayload{
+			From:          evt.Phone,
+			To:            chat.String(),
+			ImageFileName: replyMsg.ImageFileName,
+			ImageCaption:  replyMsg.Message,
+		}, messageSvc.MessageContext{}, messageSvc.MediaSource{}, key)
+	} else {
+		_, _, err = f.sender.SendTextMessage(ctx, botHook.MessagePayload{
+			From:    evt.Phone,
+			To:      chat.String(),
+			Message: replyMsg.Message,
+		}, messageSvc.MessageContext{}, key)
+	}
+	if err != nil {
+		f.log.Warn(fmt.Sprintf("failed to reply the message [%s]", evt.Body.MsgId), zap.Error(err))
+		return
+	}
+
+	bot, err := f.BotClients.Bot(evt.Phone)
+	if err != nil {
+		return
+	}
+
+	// the target JID is the sender of the incoming message
+	sender, _ := types.ParseJID(evt.Body.TargetJID)
+	err = bot.Client.MarkRead([]types.MessageID{evt.Body.MsgId}, time.Now().UTC(), chat, sender)
+	if err != nil {
+		f.log.Warn(fmt.Sprintf("failed to mark the message [%s] as read", evt.Body.MsgId), zap.Error(err))
+	}
+}
+
+// deliverable checks whether the event can be subscribed, the incoming messages without any content are skipped
+func deliverable(evt eventSvc.Event) bool {
+	if evt.Body.EventType == eventSvc.TypeIncomingMessage {
+		return evt.Body.Message != "" || evt.Body.MediaURL != ""
+	}
+
+	for _, t := range eventTypes {
+		if t == evt.Body.EventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded checks whether the event is forwarded to the webhook URL of the device,
+// only the incoming messages with a content are forwarded and the group messages are skipped
+func forwarded(evt eventSvc.Event) bool {
+	if !deliverable(evt) || evt.Body.EventType != eventSvc.TypeIncomingMessage {
+		return false
+	}
+
+	jid, err := types.ParseJID(evt.Body.ChatJID)
+
+	return err == nil && jid.Server != types.GroupServer
+}
+
+// retryBackoff doubles the base backoff on each attempt
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,445 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/logger"
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	botHook "github.com/ardihikaru/go-modules/pkg/whatsappbot/wawebhook"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	deviceSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/device"
+	eventSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/event"
+	"github.com/ardihikaru/go-whatsapp-multi-device/internal/service/servicetest"
+	sessionSvc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+// fakeStorage is an in-memory storage of the device, its webhook subscriptions and its dead letters
+type fakeStorage struct {
+	mu      sync.Mutex
+	device  deviceSvc.Device
+	subs    []Subscription
+	loads   int
+	letters map[string]DeadLetter
+}
+
+func (f *fakeStorage) GetDeviceByID(_ context.Context, _ string) (deviceSvc.Device, error) {
+	return f.device, nil
+}
+
+func (f *fakeStorage) GetDeviceByPhone(_ context.Context, _ string) (deviceSvc.Device, error) {
+	return f.device, nil
+}
+
+func (f *fakeStorage) InitWebhookSecret(_ context.Context, _, secret string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.device.WebhookSecret == "" {
+		f.device.WebhookSecret = secret
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) InsertWebhookSubscription(_ context.Context, doc Subscription) (Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	doc.ID = "sub-" + strconv.Itoa(len(f.subs)+1)
+	f.subs = append(f.subs, doc)
+
+	return doc, nil
+}
+
+func (f *fakeStorage) GetWebhookSubscription(_ context.Context, _, id string) (Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, sub := range f.subs {
+		if sub.ID == id {
+			return sub, nil
+		}
+	}
+
+	return Subscription{}, ErrSubscriptionNotFound
+}
+
+func (f *fakeStorage) GetWebhookSubscriptions(_ context.Context, _ string) ([]Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.loads++
+	return append([]Subscription(nil), f.subs...), nil
+}
+
+func (f *fakeStorage) UpdateWebhookSubscription(_ context.Context, doc Subscription) error {
+	return nil
+}
+
+func (f *fakeStorage) DeleteWebhookSubscription(_ context.Context, _, _ string) error {
+	return nil
+}
+
+func (f *fakeStorage) InsertWebhookDeadLetter(_ context.Context, doc DeadLetter) (DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	doc.ID = strconv.Itoa(len(f.letters) + 1)
+	f.letters[doc.ID] = doc
+
+	return doc, nil
+}
+
+func (f *fakeStorage) GetWebhookDeadLetter(_ context.Context, id string) (DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	letter, ok := f.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+
+	return letter, nil
+}
+
+func (f *fakeStorage) GetWebhookDeadLetters(_ context.Context, _ string, _ httputils.GetQueryParams) (int64,
+	[]DeadLetter, error) {
+	return 0, nil, nil
+}
+
+func (f *fakeStorage) UpdateWebhookDeadLetterFailure(_ context.Context, id, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	letter := f.letters[id]
+	letter.Attempts++
+	letter.LastError = lastError
+	f.letters[id] = letter
+
+	return nil
+}
+
+func (f *fakeStorage) DeleteWebhookDeadLetter(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.letters, id)
+
+	return nil
+}
+
+// newTestForwarder creates a forwarder posting to the server, without any delay between the attempts
+func newTestForwarder(url string) (*Forwarder, *fakeStorage) {
+	f, storage, _ := newTestReplyForwarder(url)
+
+	return f, storage
+}
+
+// newTestReplyForwarder creates a forwarder posting to the server, which queues the replies on the returned sender
+func newTestReplyForwarder(url string) (*Forwarder, *fakeStorage, *servicetest.Sender) {
+	storage := &fakeStorage{
+		device:  deviceSvc.Device{ID: "dev-1", Phone: "+628123", WebhookUrl: url, WebhookSecret: "secret"},
+		letters: map[string]DeadLetter{},
+	}
+	sender := &servicetest.Sender{}
+
+	return NewForwarder(storage, &logger.Logger{Logger: zap.NewNop()}, sessionSvc.NewRegistry(), sender,
+		http.DefaultClient, Config{Enabled: true, MaxAttempts: 3}), storage, sender
+}
+
+func incomingEvent() eventSvc.Event {
+	return eventSvc.Event{
+		Phone: "628123",
+		Body: eventSvc.Body{
+			WebhookBody: botHook.WebhookBody{
+				EventType: eventSvc.TypeIncomingMessage,
+				MsgId:     "ABC",
+				Message:   "hello",
+				TargetJID: "628456@s.whatsapp.net",
+			},
+			ChatJID: "628456@s.whatsapp.net",
+		},
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"msg_id":"ABC"}`)
+	signature := Sign("secret", 1700000000, body)
+
+	assert.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	assert.True(t, Verify("secret", 1700000000, body, signature))
+	assert.False(t, Verify("other", 1700000000, body, signature))
+	assert.False(t, Verify("secret", 1700000001, body, signature))
+	assert.False(t, Verify("secret", 1700000000, []byte(`{"msg_id":"XYZ"}`), signature))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryBackoff(time.Second, 1))
+	assert.Equal(t, 4*time.Second, retryBackoff(time.Second, 3))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(time.Second, 20))
+}
+
+func TestForwarded(t *testing.T) {
+	evt := incomingEvent()
+	assert.True(t, forwarded(evt))
+
+	group := incomingEvent()
+	group.Body.ChatJID = "1203630@g.us"
+	assert.False(t, forwarded(group))
+
+	empty := incomingEvent()
+	empty.Body.Message = ""
+	assert.False(t, forwarded(empty))
+
+	media := empty
+	media.Body.MediaURL = "/api/media/628123/ABC"
+	assert.True(t, forwarded(media))
+
+	receipt := incomingEvent()
+	receipt.Body.EventType = eventSvc.TypeReceipt
+	assert.False(t, forwarded(receipt))
+	assert.True(t, deliverable(receipt))
+
+	qrCode := eventSvc.NewQRCodeEvent("628123", "code")
+	assert.False(t, deliverable(qrCode))
+}
+
+func TestSubscriptionPayloadValidate(t *testing.T) {
+	payload := SubscriptionPayload{
+		URL:       " https://example.com/hook ",
+		Events:    []string{"Message", "receipt", "message"},
+		ChatTypes: []string{"GROUP"},
+	}
+	payload.Sanitize()
+	assert.NoError(t, payload.Validate())
+	assert.Equal(t, "https://example.com/hook", payload.URL)
+	assert.Equal(t, []string{EventMessage, EventReceipt}, payload.Events)
+	assert.Equal(t, []string{ChatGroup}, payload.ChatTypes)
+
+	assert.Error(t, (&SubscriptionPayload{URL: "ftp://example.com", Events: []string{EventCall}}).Validate())
+	assert.Error(t, (&SubscriptionPayload{URL: "https://example.com"}).Validate())
+	assert.Error(t, (&SubscriptionPayload{URL: "https://example.com", Events: []string{"typing"}}).Validate())
+	assert.Error(t, (&SubscriptionPayload{URL: "https://example.com", Events: []string{EventCall},
+		ChatTypes: []string{"channel"}}).Validate())
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	sub := Subscription{Events: []string{EventMessage, EventConnectionState}, ChatTypes: []string{ChatGroup}}
+
+	group := incomingEvent()
+	group.Body.ChatJID = "1203630@g.us"
+	assert.True(t, sub.Matches(group))
+
+	// the private chats are filtered out
+	assert.False(t, sub.Matches(incomingEvent()))
+
+	// the connection state is not bound to a chat, it is never filtered by the chat type
+	state := eventSvc.NewConnectionStateEvent("628123", "628123@s.whatsapp.net", "CONNECTED", time.Now())
+	assert.True(t, sub.Matches(state))
+
+	// the receipts are not subscribed
+	receipt := group
+	receipt.Body.EventType = eventSvc.TypeReceipt
+	assert.False(t, sub.Matches(receipt))
+
+	// no chat type receives any chat
+	sub.ChatTypes = nil
+	assert.True(t, sub.Matches(incomingEvent()))
+}
+
+func TestDeliverFansOutToSubscriptions(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	f, storage := newTestForwarder(server.URL + "/device")
+	storage.subs = []Subscription{
+		{ID: "sub-1", URL: server.URL + "/messages", Events: []string{EventMessage}},
+		{ID: "sub-2", URL: server.URL + "/receipts", Events: []string{EventReceipt}},
+		{ID: "sub-3", URL: server.URL + "/groups", Events: []string{EventMessage}, ChatTypes: []string{ChatGroup}},
+	}
+
+	f.deliver(context.Background(), incomingEvent())
+	assert.Equal(t, map[string]int{"/device": 1, "/messages": 1}, hits)
+
+	receipt := incomingEvent()
+	receipt.Body.EventType = eventSvc.TypeReceipt
+	f.deliver(context.Background(), receipt)
+	assert.Equal(t, map[string]int{"/device": 1, "/messages": 1, "/receipts": 1}, hits)
+	assert.Empty(t, storage.letters)
+}
+
+func TestDeliverCachesSubscriptions(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	f, storage := newTestForwarder(server.URL + "/device")
+	f.deliver(context.Background(), incomingEvent())
+	f.deliver(context.Background(), incomingEvent())
+	assert.Equal(t, 1, storage.loads)
+
+	// a new subscription receives the next event
+	_, err := f.CreateSubscription(context.Background(), "dev-1", SubscriptionPayload{
+		URL:    server.URL + "/messages",
+		Events: []string{EventMessage},
+	})
+	assert.NoError(t, err)
+
+	f.deliver(context.Background(), incomingEvent())
+	assert.Equal(t, 2, storage.loads)
+	assert.Equal(t, map[string]int{"/device": 3, "/messages": 1}, hits)
+}
+
+func TestReplaySubscriptionDeadLetter(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	f, storage := newTestForwarder("")
+	storage.letters["1"] = DeadLetter{ID: "1", Phone: "628123", SubscriptionID: "sub-1", Body: incomingEvent().Body}
+
+	// the subscription has been removed since
+	assert.ErrorIs(t, f.Replay(context.Background(), "1"), ErrSubscriptionNotFound)
+
+	// the dead letter is posted to the current URL of the subscription, although the device has no webhook URL
+	storage.subs = []Subscription{{ID: "sub-1", URL: server.URL, Events: []string{EventMessage}}}
+	assert.NoError(t, f.Replay(context.Background(), "1"))
+	assert.Equal(t, 1, hits)
+	assert.Empty(t, storage.letters)
+}
+
+func TestDeliverSignsAndRetries(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		assert.NoError(t, err)
+		assert.True(t, Verify("secret", timestamp, body, r.Header.Get(HeaderSignature)))
+
+		var payload Payload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, timestamp, payload.SentAt)
+		assert.Equal(t, "ABC", payload.MsgId)
+
+		if calls < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	f, storage := newTestForwarder(server.URL)
+	f.deliver(context.Background(), incomingEvent())
+
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, storage.letters)
+}
+
+func TestDeliverSignsWithoutSecret(t *testing.T) {
+	var body []byte
+	var timestamp int64
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		timestamp, _ = strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		signature = r.Header.Get(HeaderSignature)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// the device has been created before the deliveries were signed, it is given a secret on the first delivery
+	f, storage := newTestForwarder(server.URL)
+	storage.device.WebhookSecret = ""
+	f.deliver(context.Background(), incomingEvent())
+
+	assert.NotEmpty(t, storage.device.WebhookSecret)
+	assert.True(t, Verify(storage.device.WebhookSecret, timestamp, body, signature))
+	assert.Empty(t, storage.letters)
+}
+
+func TestDeliverQueuesReply(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(httputils.Response{
+			Success: true,
+			Data:    botHook.ReplyMessage{Message: "thanks", WithImage: true, ImageFileName: "logo.png"},
+		})
+	}))
+	defer server.Close()
+
+	f, _, sender := newTestReplyForwarder(server.URL)
+	f.deliver(context.Background(), incomingEvent())
+
+	// the reply goes through the message service with the same idempotency key on each delivery, hence it is sent once
+	f.deliver(context.Background(), incomingEvent())
+	assert.Len(t, sender.Images, 2)
+	assert.Equal(t, []string{"webhook:ABC", "webhook:ABC"}, sender.Keys)
+	assert.Equal(t, botHook.MessagePayload{
+		From:          "628123",
+		To:            "628456@s.whatsapp.net",
+		ImageFileName: "logo.png",
+		ImageCaption:  "thanks",
+	}, sender.Images[0])
+}
+
+func TestDeliverDeadLetters(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	f, storage := newTestForwarder(server.URL)
+	f.deliver(context.Background(), incomingEvent())
+
+	// a client error is not retried
+	assert.Equal(t, 1, calls)
+	assert.Len(t, storage.letters, 1)
+	letter := storage.letters["1"]
+	assert.Equal(t, "628123", letter.Phone)
+	assert.Equal(t, "ABC", letter.MsgID)
+	assert.Equal(t, 1, letter.Attempts)
+	assert.Contains(t, letter.LastError, "400")
+
+	// the replay fails as well, the dead letter is kept
+	err := f.Replay(context.Background(), "1")
+	assert.ErrorIs(t, err, ErrDeliveryFailed)
+	assert.Equal(t, 2, storage.letters["1"].Attempts)
+
+	// the webhook has been fixed, the replay removes the dead letter
+	storage.device.WebhookUrl = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).URL
+	assert.NoError(t, f.Replay(context.Background(), "1"))
+	assert.Empty(t, storage.letters)
+
+	assert.ErrorIs(t, f.Replay(context.Background(), "1"), ErrDeadLetterNotFound)
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/autoreply"
+)
+
+const (
+	// AutoReplyRuleCollection defines the collection name
+	AutoReplyRuleCollection = "auto_reply_rules"
+
+	// FnAutoReplyRulesId defines the main identifier that acts as a Primary Key
+	FnAutoReplyRulesId = string("_id")
+
+	// FnAutoReplyRulesDeviceID defines the ID of the device owning the rule
+	FnAutoReplyRulesDeviceID = string("device_id")
+
+	// FnAutoReplyRulesName defines the name of the rule
+	FnAutoReplyRulesName = string("name")
+
+	// FnAutoReplyRulesEnabled defines whether the rule answers the incoming messages
+	FnAutoReplyRulesEnabled = string("enabled")
+
+	// FnAutoReplyRulesPriority defines the evaluation order of the rule, the lowest first
+	FnAutoReplyRulesPriority = string("priority")
+
+	// FnAutoReplyRulesMatch defines the texts triggering the rule
+	FnAutoReplyRulesMatch = string("match")
+
+	// FnAutoReplyRulesScope defines the chats answered by the rule
+	FnAutoReplyRulesScope = string("scope")
+
+	// FnAutoReplyRulesActiveHours defines the daily time window of the rule
+	FnAutoReplyRulesActiveHours = string("active_hours")
+
+	// FnAutoReplyRulesCooldownSeconds defines the delay between two answers to the same contact
+	FnAutoReplyRulesCooldownSeconds = string("cooldown_seconds")
+
+	// FnAutoReplyRulesResponse defines the answer of the rule
+	FnAutoReplyRulesResponse = string("response")
+
+	// FnAutoReplyRulesCreatedAt defines the creation time
+	FnAutoReplyRulesCreatedAt = string("created_at")
+
+	// FnAutoReplyRulesUpdatedAt defines the update time
+	FnAutoReplyRulesUpdatedAt = string("updated_at")
+)
+
+// AutoReplyMatchDoc is the document prepared for the match of an auto-reply rule
+type AutoReplyMatchDoc struct {
+	Type          string `bson:"type"`
+	Pattern       string `bson:"pattern"`
+	CaseSensitive bool   `bson:"case_sensitive"`
+}
+
+// AutoReplyScopeDoc is the document prepared for the scope of an auto-reply rule
+type AutoReplyScopeDoc struct {
+	Chats string   `bson:"chats"`
+	JIDs  []string `bson:"jids,omitempty"`
+}
+
+// AutoReplyActiveHoursDoc is the document prepared for the active hours of an auto-reply rule
+type AutoReplyActiveHoursDoc struct {
+	Start    string `bson:"start"`
+	End      string `bson:"end"`
+	Timezone string `bson:"timezone,omitempty"`
+	Days     []int  `bson:"days,omitempty"`
+}
+
+// AutoReplyResponseDoc is the document prepared for the answer of an auto-reply rule
+type AutoReplyResponseDoc struct {
+	Type     string `bson:"type"`
+	Text     string `bson:"text,omitempty"`
+	FileName string `bson:"file_name,omitempty"`
+	URL      string `bson:"url,omitempty"`
+	Quote    bool   `bson:"quote"`
+}
+
+// AutoReplyRuleDoc is the document prepared for an auto-reply rule of a device
+type AutoReplyRuleDoc struct {
+	ID              primitive.ObjectID       `bson:"_id"`
+	DeviceID        string                   `bson:"device_id"`
+	Name            string                   `bson:"name"`
+	Enabled         bool                     `bson:"enabled"`
+	Priority        int                      `bson:"priority"`
+	Match           AutoReplyMatchDoc        `bson:"match"`
+	Scope           AutoReplyScopeDoc        `bson:"scope"`
+	ActiveHours     *AutoReplyActiveHoursDoc `bson:"active_hours,omitempty"`
+	CooldownSeconds int                      `bson:"cooldown_seconds"`
+	Response        AutoReplyResponseDoc     `bson:"response"`
+	CreatedAt       primitive.DateTime       `bson:"created_at"`
+	UpdatedAt       primitive.DateTime       `bson:"updated_at"`
+}
+
+// ToService converts the AutoReplyRuleDoc struct into Rule struct
+func (u *AutoReplyRuleDoc) ToService() svc.Rule {
+	return svc.Rule{
+		ID:       u.ID.Hex(),
+		DeviceID: u.DeviceID,
+		RuleSpec: svc.RuleSpec{
+			Name:            u.Name,
+			Enabled:         u.Enabled,
+			Priority:        u.Priority,
+			Match:           svc.Match(u.Match),
+			Scope:           svc.Scope(u.Scope),
+			ActiveHours:     (*svc.ActiveHours)(u.ActiveHours),
+			CooldownSeconds: u.CooldownSeconds,
+			Response:        svc.Response(u.Response),
+		},
+		CreatedAt: u.CreatedAt.Time(),
+		UpdatedAt: u.UpdatedAt.Time(),
+	}
+}
+
+// autoReplyRuleToBsonObject converts the Rule struct into AutoReplyRuleDoc struct
+func autoReplyRuleToBsonObject(u svc.Rule) AutoReplyRuleDoc {
+	return AutoReplyRuleDoc{
+		ID:              primitive.NewObjectID(),
+		DeviceID:        u.DeviceID,
+		Name:            u.Name,
+		Enabled:         u.Enabled,
+		Priority:        u.Priority,
+		Match:           AutoReplyMatchDoc(u.Match),
+		Scope:           AutoReplyScopeDoc(u.Scope),
+		ActiveHours:     (*AutoReplyActiveHoursDoc)(u.ActiveHours),
+		CooldownSeconds: u.CooldownSeconds,
+		Response:        AutoReplyResponseDoc(u.Response),
+		CreatedAt:       primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt:       primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// InsertAutoReplyRule stores an auto-reply rule
+func (d *DataStoreMongo) InsertAutoReplyRule(ctx context.Context, doc svc.Rule) (svc.Rule, error) {
+	collection := d.Client.Database(d.DBName).Collection(AutoReplyRuleCollection)
+
+	// build document
+	ruleDoc := autoReplyRuleToBsonObject(doc)
+
+	_, err := collection.InsertOne(ctx, ruleDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert auto-reply rule: %w", err)
+	}
+
+	// enrich with _id
+	doc.ID = ruleDoc.ID.Hex()
+
+	return doc, nil
+}
+
+// GetAutoReplyRule fetch an auto-reply rule of the device by ID
+func (d *DataStoreMongo) GetAutoReplyRule(ctx context.Context, deviceID, id string) (svc.Rule, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.Rule{}, svc.ErrRuleNotFound
+	}
+
+	// prepares the filter
+	filter := bson.D{
+		{Key: FnAutoReplyRulesId, Value: objID},
+		{Key: FnAutoReplyRulesDeviceID, Value: deviceID},
+	}
+
+	doc := AutoReplyRuleDoc{}
+	collection := d.Client.Database(d.DBName).Collection(AutoReplyRuleCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.Rule{}, svc.ErrRuleNotFound
+	}
+	if err != nil {
+		return svc.Rule{}, fmt.Errorf("cannot find auto-reply rule: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetAutoReplyRules fetches the auto-reply rules of the device, by ascending priority then the oldest first
+func (d *DataStoreMongo) GetAutoReplyRules(ctx context.Context, deviceID string, enabledOnly bool) ([]svc.Rule,
+	error) {
+	// sets order option
+	opts := options.Find().SetSort(bson.D{
+		{Key: FnAutoReplyRulesPriority, Value: 1},
+		{Key: FnAutoReplyRulesCreatedAt, Value: 1},
+	})
+
+	// builds filter
+	filter := bson.D{{Key: FnAutoReplyRulesDeviceID, Value: deviceID}}
+	if enabledOnly {
+		filter = append(filter, bson.E{Key: FnAutoReplyRulesEnabled, Value: true})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(AutoReplyRuleCollection)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find any auto-reply rule: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res := make([]svc.Rule, 0)
+	for cur.Next(ctx) {
+		doc := AutoReplyRuleDoc{}
+
+		err = cur.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode auto-reply rule doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return res, nil
+}
+
+// UpdateAutoReplyRule replaces the definition of an auto-reply rule
+func (d *DataStoreMongo) UpdateAutoReplyRule(ctx context.Context, doc svc.Rule) error {
+	objID, err := primitive.ObjectIDFromHex(doc.ID)
+	if err != nil {
+		return svc.ErrRuleNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnAutoReplyRulesId, Value: objID},
+		{Key: FnAutoReplyRulesDeviceID, Value: doc.DeviceID},
+	}
+
+	// prepares document to update
+	ruleDoc := autoReplyRuleToBsonObject(doc)
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnAutoReplyRulesName, Value: ruleDoc.Name},
+			{Key: FnAutoReplyRulesEnabled, Value: ruleDoc.Enabled},
+			{Key: FnAutoReplyRulesPriority, Value: ruleDoc.Priority},
+			{Key: FnAutoReplyRulesMatch, Value: ruleDoc.Match},
+			{Key: FnAutoReplyRulesScope, Value: ruleDoc.Scope},
+			{Key: FnAutoReplyRulesActiveHours, Value: ruleDoc.ActiveHours},
+			{Key: FnAutoReplyRulesCooldownSeconds, Value: ruleDoc.CooldownSeconds},
+			{Key: FnAutoReplyRulesResponse, Value: ruleDoc.Response},
+			{Key: FnAutoReplyRulesUpdatedAt, Value: ruleDoc.UpdatedAt},
+		}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(AutoReplyRuleCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrRuleNotFound
+	}
+
+	return nil
+}
+
+// DeleteAutoReplyRule removes an auto-reply rule of the device
+func (d *DataStoreMongo) DeleteAutoReplyRule(ctx context.Context, deviceID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrRuleNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnAutoReplyRulesId, Value: objID},
+		{Key: FnAutoReplyRulesDeviceID, Value: deviceID},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(AutoReplyRuleCollection)
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return svc.ErrRuleNotFound
+	}
+
+	return nil
+}
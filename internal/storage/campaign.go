@@ -0,0 +1,516 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/campaign"
+)
+
+const (
+	// CampaignCollection defines the collection name
+	CampaignCollection = "campaigns"
+
+	// FnCampaignsId defines the main identifier that acts as a Primary Key
+	FnCampaignsId = string("_id")
+
+	// FnCampaignsPhone defines the phone number of the device, without the `+` symbol
+	FnCampaignsPhone = string("phone")
+
+	// FnCampaignsStatus defines the status of the campaign
+	FnCampaignsStatus = string("status")
+
+	// FnCampaignsCreatedAt defines the creation time
+	FnCampaignsCreatedAt = string("created_at")
+
+	// FnCampaignsUpdatedAt defines the update time
+	FnCampaignsUpdatedAt = string("updated_at")
+
+	// FnCampaignsCompletedAt defines the time when every recipient got a result
+	FnCampaignsCompletedAt = string("completed_at")
+)
+
+const (
+	// CampaignRecipientCollection defines the collection name
+	CampaignRecipientCollection = "campaign_recipients"
+
+	// FnCampaignRecipientsId defines the main identifier that acts as a Primary Key
+	FnCampaignRecipientsId = string("_id")
+
+	// FnCampaignRecipientsCampaignID defines the ID of the campaign
+	FnCampaignRecipientsCampaignID = string("campaign_id")
+
+	// FnCampaignRecipientsStatus defines the result of the recipient
+	FnCampaignRecipientsStatus = string("status")
+
+	// FnCampaignRecipientsMessageID defines the ID of the outbound message queued for the recipient
+	FnCampaignRecipientsMessageID = string("message_id")
+
+	// FnCampaignRecipientsError defines the reason of the failure
+	FnCampaignRecipientsError = string("error")
+
+	// FnCampaignRecipientsUpdatedAt defines the update time
+	FnCampaignRecipientsUpdatedAt = string("updated_at")
+)
+
+// CampaignThrottleDoc is the sub-document prepared for the throttle of a campaign
+type CampaignThrottleDoc struct {
+	IntervalSeconds int `bson:"interval_seconds"`
+	JitterSeconds   int `bson:"jitter_seconds"`
+}
+
+// CampaignDoc is the document prepared for a campaign
+type CampaignDoc struct {
+	ID          primitive.ObjectID  `bson:"_id"`
+	Phone       string              `bson:"phone"`
+	Name        string              `bson:"name"`
+	Type        string              `bson:"type"`
+	Template    string              `bson:"template"`
+	FileName    string              `bson:"file_name,omitempty"`
+	Throttle    CampaignThrottleDoc `bson:"throttle"`
+	Status      string              `bson:"status"`
+	CreatedAt   primitive.DateTime  `bson:"created_at"`
+	UpdatedAt   primitive.DateTime  `bson:"updated_at"`
+	CompletedAt *primitive.DateTime `bson:"completed_at,omitempty"`
+}
+
+// ToService converts the CampaignDoc struct into Campaign struct
+func (u *CampaignDoc) ToService() svc.Campaign {
+	c := svc.Campaign{
+		ID:        u.ID.Hex(),
+		Phone:     u.Phone,
+		Name:      u.Name,
+		Type:      u.Type,
+		Template:  u.Template,
+		FileName:  u.FileName,
+		Throttle:  svc.Throttle(u.Throttle),
+		Status:    u.Status,
+		CreatedAt: u.CreatedAt.Time(),
+		UpdatedAt: u.UpdatedAt.Time(),
+	}
+	if u.CompletedAt != nil {
+		completedAt := u.CompletedAt.Time().UTC()
+		c.CompletedAt = &completedAt
+	}
+
+	return c
+}
+
+// campaignToBsonObject converts the Campaign struct into CampaignDoc struct
+func campaignToBsonObject(u svc.Campaign) CampaignDoc {
+	return CampaignDoc{
+		ID:        primitive.NewObjectID(),
+		Phone:     u.Phone,
+		Name:      u.Name,
+		Type:      u.Type,
+		Template:  u.Template,
+		FileName:  u.FileName,
+		Throttle:  CampaignThrottleDoc(u.Throttle),
+		Status:    u.Status,
+		CreatedAt: primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt: primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// CampaignRecipientDoc is the document prepared for a recipient of a campaign
+type CampaignRecipientDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	CampaignID string             `bson:"campaign_id"`
+	Phone      string             `bson:"phone"`
+	Variables  map[string]string  `bson:"variables,omitempty"`
+	Status     string             `bson:"status"`
+	MessageID  string             `bson:"message_id,omitempty"`
+	Error      string             `bson:"error,omitempty"`
+	UpdatedAt  primitive.DateTime `bson:"updated_at"`
+}
+
+// ToService converts the CampaignRecipientDoc struct into Recipient struct
+func (u *CampaignRecipientDoc) ToService() svc.Recipient {
+	return svc.Recipient{
+		ID:         u.ID.Hex(),
+		CampaignID: u.CampaignID,
+		Phone:      u.Phone,
+		Variables:  u.Variables,
+		Status:     u.Status,
+		MessageID:  u.MessageID,
+		Error:      u.Error,
+		UpdatedAt:  u.UpdatedAt.Time(),
+	}
+}
+
+// campaignRecipientToBsonObject converts the Recipient struct into CampaignRecipientDoc struct
+func campaignRecipientToBsonObject(u svc.Recipient) CampaignRecipientDoc {
+	return CampaignRecipientDoc{
+		ID:         primitive.NewObjectID(),
+		CampaignID: u.CampaignID,
+		Phone:      u.Phone,
+		Variables:  u.Variables,
+		Status:     u.Status,
+		UpdatedAt:  primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// InsertCampaign stores a campaign
+func (d *DataStoreMongo) InsertCampaign(ctx context.Context, doc svc.Campaign) (svc.Campaign, error) {
+	collection := d.Client.Database(d.DBName).Collection(CampaignCollection)
+
+	// build document
+	campaignDoc := campaignToBsonObject(doc)
+
+	_, err := collection.InsertOne(ctx, campaignDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert campaign: %w", err)
+	}
+
+	// enrich with _id
+	doc.ID = campaignDoc.ID.Hex()
+
+	return doc, nil
+}
+
+// InsertCampaignRecipients stores the recipients of a campaign, in the given order
+func (d *DataStoreMongo) InsertCampaignRecipients(ctx context.Context, docs []svc.Recipient) error {
+	collection := d.Client.Database(d.DBName).Collection(CampaignRecipientCollection)
+
+	// build documents
+	recipientDocs := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		recipientDocs[i] = campaignRecipientToBsonObject(doc)
+	}
+
+	_, err := collection.InsertMany(ctx, recipientDocs)
+	if err != nil {
+		return fmt.Errorf("cannot insert campaign recipients: %w", err)
+	}
+
+	return nil
+}
+
+// GetCampaign fetch a campaign by ID
+func (d *DataStoreMongo) GetCampaign(ctx context.Context, id string) (svc.Campaign, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.Campaign{}, svc.ErrCampaignNotFound
+	}
+
+	// prepares the filter
+	filter := bson.D{{Key: FnCampaignsId, Value: objID}}
+
+	doc := CampaignDoc{}
+	collection := d.Client.Database(d.DBName).Collection(CampaignCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.Campaign{}, svc.ErrCampaignNotFound
+	}
+	if err != nil {
+		return svc.Campaign{}, fmt.Errorf("cannot find campaign: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetCampaigns fetches the campaigns by creation time, of a device and of a status if they are set
+func (d *DataStoreMongo) GetCampaigns(ctx context.Context, phone, status string,
+	params httputils.GetQueryParams) (int64, []svc.Campaign, error) {
+	// prepares the options
+	var opts = options.Find()
+
+	// set query parameters
+	opts.SetLimit(params.Limit)
+	opts.SetSkip(params.Offset)
+
+	// sets order option
+	order := 1
+	if params.Order == query.DESC {
+		order = -1
+	}
+	opts.SetSort(bson.D{
+		{Key: FnCampaignsCreatedAt, Value: order},
+		{Key: FnCampaignsId, Value: order},
+	})
+
+	// builds filter
+	filter := bson.D{}
+	if phone != "" {
+		filter = append(filter, bson.E{Key: FnCampaignsPhone, Value: phone})
+	}
+	if status != "" {
+		filter = append(filter, bson.E{Key: FnCampaignsStatus, Value: status})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(CampaignCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot count campaigns: %w", err)
+	}
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any campaign: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res, err := decodeCampaigns(ctx, cur)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, res, nil
+}
+
+// GetRunningCampaigns fetches the running campaigns, the oldest first
+func (d *DataStoreMongo) GetRunningCampaigns(ctx context.Context) ([]svc.Campaign, error) {
+	// sets order option
+	opts := options.Find().SetSort(bson.D{{Key: FnCampaignsCreatedAt, Value: 1}})
+
+	// builds filter
+	filter := bson.D{{Key: FnCampaignsStatus, Value: svc.StatusRunning}}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(CampaignCollection)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find any running campaign: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	return decodeCampaigns(ctx, cur)
+}
+
+// UpdateCampaignStatus changes the status of a campaign that is in one of the given statuses,
+// the completion time is set once the campaign is completed
+func (d *DataStoreMongo) UpdateCampaignStatus(ctx context.Context, id string, from []string, to string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrCampaignNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnCampaignsId, Value: objID},
+		{Key: FnCampaignsStatus, Value: bson.D{{Key: "$in", Value: from}}},
+	}
+
+	// prepares document to update
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	set := bson.D{
+		{Key: FnCampaignsStatus, Value: to},
+		{Key: FnCampaignsUpdatedAt, Value: now},
+	}
+	if to == svc.StatusCompleted {
+		set = append(set, bson.E{Key: FnCampaignsCompletedAt, Value: now})
+	}
+	docBson := bson.D{{Key: "$set", Value: set}}
+
+	collection := d.Client.Database(d.DBName).Collection(CampaignCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrCampaignNotFound
+	}
+
+	return nil
+}
+
+// CountCampaignRecipients counts the recipients of the campaigns by status, in a single aggregation,
+// the campaigns without any recipient are left out of the result
+func (d *DataStoreMongo) CountCampaignRecipients(ctx context.Context,
+	campaignIDs []string) (map[string]svc.Counts, error) {
+	res := make(map[string]svc.Counts, len(campaignIDs))
+	if len(campaignIDs) == 0 {
+		return res, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: FnCampaignRecipientsCampaignID, Value: bson.D{{Key: "$in", Value: campaignIDs}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "campaign", Value: "$" + FnCampaignRecipientsCampaignID},
+				{Key: "status", Value: "$" + FnCampaignRecipientsStatus},
+			}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(CampaignRecipientCollection)
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("cannot count campaign recipients: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var group struct {
+			ID struct {
+				Campaign string `bson:"campaign"`
+				Status   string `bson:"status"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+
+		err = cur.Decode(&group)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode campaign recipient count: %w", err)
+		}
+
+		counts := res[group.ID.Campaign]
+		counts.Total += group.Count
+		switch group.ID.Status {
+		case svc.RecipientPending:
+			counts.Pending = group.Count
+		case svc.RecipientSent:
+			counts.Sent = group.Count
+		case svc.RecipientFailed:
+			counts.Failed = group.Count
+		case svc.RecipientNotOnWhatsapp:
+			counts.NotOnWhatsapp = group.Count
+		}
+		res[group.ID.Campaign] = counts
+	}
+
+	return res, nil
+}
+
+// GetCampaignRecipients fetches the recipients of a campaign in their given order, of a status if it is set
+func (d *DataStoreMongo) GetCampaignRecipients(ctx context.Context, campaignID, status string,
+	params httputils.GetQueryParams) (int64, []svc.Recipient, error) {
+	// prepares the options
+	var opts = options.Find()
+
+	// set query parameters
+	opts.SetLimit(params.Limit)
+	opts.SetSkip(params.Offset)
+
+	// sets order option
+	order := 1
+	if params.Order == query.DESC {
+		order = -1
+	}
+	opts.SetSort(bson.D{{Key: FnCampaignRecipientsId, Value: order}})
+
+	// builds filter
+	filter := bson.D{{Key: FnCampaignRecipientsCampaignID, Value: campaignID}}
+	if status != "" {
+		filter = append(filter, bson.E{Key: FnCampaignRecipientsStatus, Value: status})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(CampaignRecipientCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot count campaign recipients: %w", err)
+	}
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any campaign recipient: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res, err := decodeCampaignRecipients(ctx, cur)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, res, nil
+}
+
+// GetPendingCampaignRecipients fetches the next pending recipients of a campaign, in their given order
+func (d *DataStoreMongo) GetPendingCampaignRecipients(ctx context.Context, campaignID string,
+	limit int64) ([]svc.Recipient, error) {
+	// sets order option
+	opts := options.Find().SetLimit(limit).SetSort(bson.D{{Key: FnCampaignRecipientsId, Value: 1}})
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnCampaignRecipientsCampaignID, Value: campaignID},
+		{Key: FnCampaignRecipientsStatus, Value: svc.RecipientPending},
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(CampaignRecipientCollection)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find any pending campaign recipient: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	return decodeCampaignRecipients(ctx, cur)
+}
+
+// UpdateCampaignRecipient updates the result of a recipient
+func (d *DataStoreMongo) UpdateCampaignRecipient(ctx context.Context, doc svc.Recipient) error {
+	objID, err := primitive.ObjectIDFromHex(doc.ID)
+	if err != nil {
+		return fmt.Errorf("invalid campaign recipient ID [%s]", doc.ID)
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnCampaignRecipientsId, Value: objID}}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnCampaignRecipientsStatus, Value: doc.Status},
+			{Key: FnCampaignRecipientsMessageID, Value: doc.MessageID},
+			{Key: FnCampaignRecipientsError, Value: doc.Error},
+			{Key: FnCampaignRecipientsUpdatedAt, Value: primitive.NewDateTimeFromTime(doc.UpdatedAt)},
+		}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(CampaignRecipientCollection)
+	_, err = collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return fmt.Errorf("cannot update campaign recipient: %w", err)
+	}
+
+	return nil
+}
+
+// decodeCampaigns decodes the campaign docs of the cursor
+func decodeCampaigns(ctx context.Context, cur *mongo.Cursor) ([]svc.Campaign, error) {
+	res := make([]svc.Campaign, 0)
+	for cur.Next(ctx) {
+		doc := CampaignDoc{}
+
+		err := cur.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode campaign doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return res, nil
+}
+
+// decodeCampaignRecipients decodes the campaign recipient docs of the cursor
+func decodeCampaignRecipients(ctx context.Context, cur *mongo.Cursor) ([]svc.Recipient, error) {
+	res := make([]svc.Recipient, 0)
+	for cur.Next(ctx) {
+		doc := CampaignRecipientDoc{}
+
+		err := cur.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode campaign recipient doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return res, nil
+}
@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/chat"
+)
+
+const (
+	// ChatMessageCollection defines the collection name
+	ChatMessageCollection = "messages"
+
+	// FnChatMessagesId defines the main identifier that acts as a Primary Key
+	FnChatMessagesId = string("_id")
+
+	// FnChatMessagesPhone defines the phone number of the device, without the `+` symbol
+	FnChatMessagesPhone = string("phone")
+
+	// FnChatMessagesChat defines the JID of the conversation
+	FnChatMessagesChat = string("chat")
+
+	// FnChatMessagesMessageID defines the message ID given by the Whatsapp server
+	FnChatMessagesMessageID = string("message_id")
+
+	// FnChatMessagesFromMe defines whether the message has been sent by the device
+	FnChatMessagesFromMe = string("from_me")
+
+	// FnChatMessagesText defines the text or the caption of the message
+	FnChatMessagesText = string("text")
+
+	// FnChatMessagesMedia defines the metadata of the attached media
+	FnChatMessagesMedia = string("media")
+
+	// FnChatMessagesLocation defines the shared location
+	FnChatMessagesLocation = string("location")
+
+	// FnChatMessagesStatus defines the delivery or read status
+	FnChatMessagesStatus = string("status")
+
+	// FnChatMessagesEdited defines whether the message has been edited
+	FnChatMessagesEdited = string("edited")
+
+	// FnChatMessagesRevoked defines whether the message has been revoked
+	FnChatMessagesRevoked = string("revoked")
+
+	// FnChatMessagesTimestamp defines the time the message has been sent
+	FnChatMessagesTimestamp = string("timestamp")
+
+	// FnChatMessagesUpdatedAt defines the update time
+	FnChatMessagesUpdatedAt = string("updated_at")
+)
+
+// ChatMessageDoc is the document prepared for a message of a conversation
+type ChatMessageDoc struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Phone      string             `bson:"phone"`
+	Chat       string             `bson:"chat"`
+	MessageID  string             `bson:"message_id"`
+	Sender     string             `bson:"sender"`
+	SenderName string             `bson:"sender_name,omitempty"`
+	FromMe     bool               `bson:"from_me"`
+	Type       string             `bson:"type"`
+	Text       string             `bson:"text,omitempty"`
+	Media      *ChatMediaDoc      `bson:"media,omitempty"`
+	Location   *ChatLocationDoc   `bson:"location,omitempty"`
+	ReplyTo    string             `bson:"reply_to,omitempty"`
+	Status     string             `bson:"status"`
+	Edited     bool               `bson:"edited,omitempty"`
+	Revoked    bool               `bson:"revoked,omitempty"`
+	Timestamp  primitive.DateTime `bson:"timestamp"`
+	CreatedAt  primitive.DateTime `bson:"created_at"`
+	UpdatedAt  primitive.DateTime `bson:"updated_at"`
+}
+
+// ChatMediaDoc is the document prepared for the media metadata of a message
+type ChatMediaDoc struct {
+	MimeType      string `bson:"mimetype,omitempty"`
+	FileName      string `bson:"file_name,omitempty"`
+	FileLength    uint64 `bson:"file_length,omitempty"`
+	URL           string `bson:"url,omitempty"`
+	DirectPath    string `bson:"direct_path,omitempty"`
+	MediaKey      []byte `bson:"media_key,omitempty"`
+	FileSHA256    []byte `bson:"file_sha256,omitempty"`
+	FileEncSHA256 []byte `bson:"file_enc_sha256,omitempty"`
+}
+
+// ChatLocationDoc is the document prepared for the location of a message
+type ChatLocationDoc struct {
+	Latitude  float64 `bson:"latitude"`
+	Longitude float64 `bson:"longitude"`
+	Name      string  `bson:"name,omitempty"`
+	Address   string  `bson:"address,omitempty"`
+	Live      bool    `bson:"live,omitempty"`
+}
+
+// ChatDoc is the result of the aggregation of the messages by conversation
+type ChatDoc struct {
+	JID         string         `bson:"_id"`
+	LastMessage ChatMessageDoc `bson:"last_message"`
+	UnreadCount int64          `bson:"unread_count"`
+}
+
+// ToService converts the ChatMessageDoc struct into Message struct
+func (u *ChatMessageDoc) ToService() svc.Message {
+	return svc.Message{
+		ID:         u.ID.Hex(),
+		Phone:      u.Phone,
+		Chat:       u.Chat,
+		MessageID:  u.MessageID,
+		Sender:     u.Sender,
+		SenderName: u.SenderName,
+		FromMe:     u.FromMe,
+		Type:       u.Type,
+		Text:       u.Text,
+		Media:      (*svc.Media)(u.Media),
+		Location:   (*svc.Location)(u.Location),
+		ReplyTo:    u.ReplyTo,
+		Status:     u.Status,
+		Edited:     u.Edited,
+		Revoked:    u.Revoked,
+		Timestamp:  u.Timestamp.Time(),
+		CreatedAt:  u.CreatedAt.Time(),
+		UpdatedAt:  u.UpdatedAt.Time(),
+	}
+}
+
+// ToService converts the ChatDoc struct into Chat struct
+func (u *ChatDoc) ToService() svc.Chat {
+	return svc.Chat{
+		JID:         u.JID,
+		LastMessage: u.LastMessage.ToService(),
+		UnreadCount: u.UnreadCount,
+	}
+}
+
+// chatMessageToBsonObject converts the Message struct from the service into the document
+func chatMessageToBsonObject(u svc.Message) ChatMessageDoc {
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+
+	return ChatMessageDoc{
+		ID:         primitive.NewObjectID(),
+		Phone:      u.Phone,
+		Chat:       u.Chat,
+		MessageID:  u.MessageID,
+		Sender:     u.Sender,
+		SenderName: u.SenderName,
+		FromMe:     u.FromMe,
+		Type:       u.Type,
+		Text:       u.Text,
+		Media:      (*ChatMediaDoc)(u.Media),
+		Location:   (*ChatLocationDoc)(u.Location),
+		ReplyTo:    u.ReplyTo,
+		Status:     u.Status,
+		Timestamp:  primitive.NewDateTimeFromTime(u.Timestamp),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// InsertChatMessage stores a message of a conversation, a message stored already is left untouched
+func (d *DataStoreMongo) InsertChatMessage(ctx context.Context, doc svc.Message) error {
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+
+	_, err := collection.InsertOne(ctx, chatMessageToBsonObject(doc))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("cannot insert chat message: %w", err)
+	}
+
+	return nil
+}
+
+// GetChatMessage fetches a message of the device by its whatsapp message ID
+func (d *DataStoreMongo) GetChatMessage(ctx context.Context, phone, messageID string) (svc.Message, error) {
+	// prepares the filter
+	filter := bson.D{
+		{Key: FnChatMessagesPhone, Value: phone},
+		{Key: FnChatMessagesMessageID, Value: messageID},
+	}
+
+	doc := ChatMessageDoc{}
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+	err := collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.Message{}, svc.ErrMessageNotFound
+	}
+	if err != nil {
+		return svc.Message{}, fmt.Errorf("cannot find chat message: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// EditChatMessage replaces the text of a message of a conversation
+func (d *DataStoreMongo) EditChatMessage(ctx context.Context, phone, chat, messageID, text string) error {
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnChatMessagesText, Value: text},
+			{Key: FnChatMessagesEdited, Value: true},
+			{Key: FnChatMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	}
+
+	return d.updateChatMessage(ctx, phone, chat, messageID, docBson)
+}
+
+// RevokeChatMessage marks a message of a conversation as revoked and removes its content
+func (d *DataStoreMongo) RevokeChatMessage(ctx context.Context, phone, chat, messageID string) error {
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnChatMessagesRevoked, Value: true},
+			{Key: FnChatMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+		{Key: "$unset", Value: bson.D{
+			{Key: FnChatMessagesText, Value: ""},
+			{Key: FnChatMessagesMedia, Value: ""},
+			{Key: FnChatMessagesLocation, Value: ""},
+		}},
+	}
+
+	return d.updateChatMessage(ctx, phone, chat, messageID, docBson)
+}
+
+// UpdateChatMessagesStatus updates the status of the messages of a conversation
+// when their current status is one of from
+func (d *DataStoreMongo) UpdateChatMessagesStatus(ctx context.Context, phone, chat string, messageIDs []string,
+	fromMe bool, status string, from []string) error {
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnChatMessagesPhone, Value: phone},
+		{Key: FnChatMessagesChat, Value: chat},
+		{Key: FnChatMessagesMessageID, Value: bson.D{{Key: "$in", Value: messageIDs}}},
+		{Key: FnChatMessagesFromMe, Value: fromMe},
+		{Key: FnChatMessagesStatus, Value: bson.D{{Key: "$in", Value: from}}},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnChatMessagesStatus, Value: status},
+			{Key: FnChatMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	}
+
+	_, err := collection.UpdateMany(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetChats fetches the conversations of the device with their last message and their unread count,
+// sorted by the time of their last message
+func (d *DataStoreMongo) GetChats(ctx context.Context, phone string, params httputils.GetQueryParams) (int64,
+	[]svc.Chat, error) {
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+
+	order := -1
+	if params.Order == query.ASC {
+		order = 1
+	}
+
+	// the inbound messages are unread until the device owner reads them
+	unread := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$eq", Value: bson.A{"$" + FnChatMessagesFromMe, false}}},
+			bson.D{{Key: "$ne", Value: bson.A{"$" + FnChatMessagesStatus, svc.StatusRead}}},
+		}}},
+		1,
+		0,
+	}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: FnChatMessagesPhone, Value: phone}}}},
+		{{Key: "$sort", Value: bson.D{{Key: FnChatMessagesTimestamp, Value: -1}, {Key: FnChatMessagesId, Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + FnChatMessagesChat},
+			{Key: "last_message", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}},
+			{Key: "unread_count", Value: bson.D{{Key: "$sum", Value: unread}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "last_message." + FnChatMessagesTimestamp, Value: order}}}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "total"}}}},
+			{Key: "chats", Value: bson.A{
+				bson.D{{Key: "$skip", Value: params.Offset}},
+				bson.D{{Key: "$limit", Value: params.Limit}},
+			}},
+		}}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any chat: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			Total int64 `bson:"total"`
+		} `bson:"total"`
+		Chats []ChatDoc `bson:"chats"`
+	}
+	err = cur.All(ctx, &result)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot decode chat doc: %w", err)
+	}
+
+	res := make([]svc.Chat, 0)
+	if len(result) == 0 || len(result[0].Total) == 0 {
+		return 0, res, nil
+	}
+
+	for _, doc := range result[0].Chats {
+		res = append(res, doc.ToService())
+	}
+
+	return result[0].Total[0].Total, res, nil
+}
+
+// GetChatMessages fetches the messages of a conversation, sorted by their time,
+// the search keyword filters the messages by their text
+func (d *DataStoreMongo) GetChatMessages(ctx context.Context, phone, chat string,
+	params httputils.GetQueryParams) (int64, []svc.Message, error) {
+	// prepares the options
+	var opts = options.Find()
+
+	// set query parameters
+	opts.SetLimit(params.Limit)
+	opts.SetSkip(params.Offset)
+
+	// sets order option
+	order := -1
+	if params.Order == query.ASC {
+		order = 1
+	}
+	opts.SetSort(bson.D{{Key: FnChatMessagesTimestamp, Value: order}, {Key: FnChatMessagesId, Value: order}})
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnChatMessagesPhone, Value: phone},
+		{Key: FnChatMessagesChat, Value: chat},
+	}
+	if params.Search != "" {
+		filter = append(filter, bson.E{Key: FnChatMessagesText, Value: primitive.Regex{
+			Pattern: regexp.QuoteMeta(params.Search),
+			Options: "i",
+		}})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot count chat messages: %w", err)
+	}
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any chat message: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res := make([]svc.Message, 0)
+	for cur.Next(ctx) {
+		doc := ChatMessageDoc{}
+
+		err = cur.Decode(&doc)
+		if err != nil {
+			return 0, nil, fmt.Errorf("cannot decode chat message doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return total, res, nil
+}
+
+// updateChatMessage updates a message of a conversation by its whatsapp message ID
+func (d *DataStoreMongo) updateChatMessage(ctx context.Context, phone, chat, messageID string,
+	docBson bson.D) error {
+	collection := d.Client.Database(d.DBName).Collection(ChatMessageCollection)
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnChatMessagesPhone, Value: phone},
+		{Key: FnChatMessagesChat, Value: chat},
+		{Key: FnChatMessagesMessageID, Value: messageID},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
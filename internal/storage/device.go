@@ -31,6 +31,12 @@ const (
 	// FnDevicesWebhookUrl defines the Webhook URL
 	FnDevicesWebhookUrl = string("webhook_url")
 
+	// FnDevicesWebhookSecret defines the secret to sign the webhook deliveries
+	FnDevicesWebhookSecret = string("webhook_secret")
+
+	// FnDevicesRateLimit defines the rate limit override of the device
+	FnDevicesRateLimit = string("rate_limit")
+
 	// FnDevicesCreatedAt defines the creation time
 	FnDevicesCreatedAt = string("_c")
 
@@ -40,38 +46,51 @@ const (
 
 // DeviceDoc is the document prepared for the captured user information
 type DeviceDoc struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	JID        string             `bson:"jid"`
-	Phone      string             `bson:"phone"`
-	Name       string             `bson:"name"`
-	WebhookUrl string             `bson:"webhook_url"`
-	CreatedAt  primitive.DateTime `bson:"created_at"`
-	UpdatedAt  primitive.DateTime `bson:"updated_at"`
+	ID            primitive.ObjectID `bson:"_id"`
+	JID           string             `bson:"jid"`
+	Phone         string             `bson:"phone"`
+	Name          string             `bson:"name"`
+	WebhookUrl    string             `bson:"webhook_url"`
+	WebhookSecret string             `bson:"webhook_secret,omitempty"`
+	RateLimit     *RateLimitDoc      `bson:"rate_limit,omitempty"`
+	CreatedAt     primitive.DateTime `bson:"created_at"`
+	UpdatedAt     primitive.DateTime `bson:"updated_at"`
+}
+
+// RateLimitDoc is the embedded document of the device rate limit override
+type RateLimitDoc struct {
+	MessagesPerMinute *int   `bson:"messages_per_minute,omitempty"`
+	MinDelayMs        *int64 `bson:"min_delay_ms,omitempty"`
+	JitterMs          *int64 `bson:"jitter_ms,omitempty"`
+	DailyCap          *int   `bson:"daily_cap,omitempty"`
 }
 
 // ToService converts the DeviceDoc struct into Device struct
 func (u *DeviceDoc) ToService() svc.Device {
 	return svc.Device{
-		ID:         u.ID.Hex(),
-		JID:        u.JID,
-		Phone:      u.Phone,
-		Name:       u.Name,
-		WebhookUrl: u.WebhookUrl,
-		CreatedAt:  u.CreatedAt.Time(),
-		UpdatedAt:  u.UpdatedAt.Time(),
+		ID:            u.ID.Hex(),
+		JID:           u.JID,
+		Phone:         u.Phone,
+		Name:          u.Name,
+		WebhookUrl:    u.WebhookUrl,
+		WebhookSecret: u.WebhookSecret,
+		RateLimit:     (*svc.RateLimit)(u.RateLimit),
+		CreatedAt:     u.CreatedAt.Time(),
+		UpdatedAt:     u.UpdatedAt.Time(),
 	}
 }
 
 // deviceToBsonObject converts the accountDoc struct into account struct from the service
 func deviceToBsonObject(u svc.Device) (DeviceDoc, error) {
 	return DeviceDoc{
-		ID:         primitive.NewObjectID(),
-		JID:        u.JID,
-		Phone:      u.Phone,
-		Name:       u.Name,
-		WebhookUrl: u.WebhookUrl,
-		CreatedAt:  primitive.NewDateTimeFromTime(u.CreatedAt),
-		UpdatedAt:  primitive.NewDateTimeFromTime(u.UpdatedAt),
+		ID:            primitive.NewObjectID(),
+		JID:           u.JID,
+		Phone:         u.Phone,
+		Name:          u.Name,
+		WebhookUrl:    u.WebhookUrl,
+		WebhookSecret: u.WebhookSecret,
+		CreatedAt:     primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt:     primitive.NewDateTimeFromTime(u.UpdatedAt),
 	}, nil
 }
 
@@ -155,10 +174,10 @@ func buildDeviceFilterOption(search string) bson.D {
 	search = reformatPhoneQuery(search)
 
 	if search != "" {
-		regex := bson.D{{"$regex", primitive.Regex{Pattern: "^.*" + search + ".*$", Options: "i"}}}
+		regex := bson.D{{Key: "$regex", Value: primitive.Regex{Pattern: "^.*" + search + ".*$", Options: "i"}}}
 
 		filter = bson.D{
-			{"$or", bson.A{
+			{Key: "$or", Value: bson.A{
 				bson.M{FnDevicesJID: regex},
 				bson.M{FnDevicesPhone: regex},
 				bson.M{FnDevicesName: regex},
@@ -321,3 +340,92 @@ func (d *DataStoreMongo) UpdateWebhook(ctx context.Context, id, webhook string)
 
 	return nil
 }
+
+// UpdateWebhookSecret updates the secret to sign the webhook deliveries
+func (d *DataStoreMongo) UpdateWebhookSecret(ctx context.Context, id, secret string) error {
+	collection := d.Client.Database(d.DBName).Collection(DeviceCollection)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnDevicesId, Value: objID}}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{{Key: FnDevicesWebhookSecret, Value: secret}}},
+	}
+
+	// finds document by ID and executes update action
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("device not found")
+	}
+
+	return nil
+}
+
+// InitWebhookSecret sets the secret to sign the webhook deliveries, unless the device has one already
+func (d *DataStoreMongo) InitWebhookSecret(ctx context.Context, id, secret string) error {
+	collection := d.Client.Database(d.DBName).Collection(DeviceCollection)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnDevicesId, Value: objID},
+		{Key: FnDevicesWebhookSecret, Value: bson.D{{Key: "$in", Value: bson.A{nil, ""}}}},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{{Key: FnDevicesWebhookSecret, Value: secret}}},
+	}
+
+	// finds document by ID and executes update action, the secret set in the meantime is kept
+	_, err = collection.UpdateOne(ctx, filter, docBson)
+
+	return err
+}
+
+// UpdateRateLimit sets the rate limit override, a nil value removes it
+func (d *DataStoreMongo) UpdateRateLimit(ctx context.Context, id string, rateLimit *svc.RateLimit) error {
+	collection := d.Client.Database(d.DBName).Collection(DeviceCollection)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnDevicesId, Value: objID}}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$unset", Value: bson.D{{Key: FnDevicesRateLimit, Value: ""}}},
+	}
+	if rateLimit != nil {
+		docBson = bson.D{
+			{Key: "$set", Value: bson.D{{Key: FnDevicesRateLimit, Value: (*RateLimitDoc)(rateLimit)}}},
+		}
+	}
+
+	// finds document by ID and executes update action
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("device not found")
+	}
+
+	return nil
+}
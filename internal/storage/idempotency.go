@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+const (
+	// IdempotencyKeyCollection defines the collection name
+	IdempotencyKeyCollection = "idempotency_keys"
+
+	// FnIdempotencyKeysPhone defines the phone number of the device owning the key
+	FnIdempotencyKeysPhone = string("phone")
+
+	// FnIdempotencyKeysKey defines the key supplied by the client
+	FnIdempotencyKeysKey = string("key")
+
+	// FnIdempotencyKeysMessageID defines the ID of the message queued with the key
+	FnIdempotencyKeysMessageID = string("message_id")
+
+	// FnIdempotencyKeysExpiresAt defines the expiration time, mongo removes the key afterward
+	FnIdempotencyKeysExpiresAt = string("expires_at")
+)
+
+// IdempotencyKeyDoc is the document prepared for the idempotency key
+type IdempotencyKeyDoc struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Phone     string             `bson:"phone"`
+	Key       string             `bson:"key"`
+	MessageID string             `bson:"message_id,omitempty"`
+	CreatedAt primitive.DateTime `bson:"created_at"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+// ToService converts the IdempotencyKeyDoc struct into IdempotencyKey struct
+func (u *IdempotencyKeyDoc) ToService() svc.IdempotencyKey {
+	return svc.IdempotencyKey{
+		Phone:     u.Phone,
+		Key:       u.Key,
+		MessageID: u.MessageID,
+		CreatedAt: u.CreatedAt.Time(),
+		ExpiresAt: u.ExpiresAt.Time(),
+	}
+}
+
+// InsertIdempotencyKey stores a new idempotency key
+// it returns svc.ErrIdempotencyKeyExists if the device has used the key already
+func (d *DataStoreMongo) InsertIdempotencyKey(ctx context.Context, doc svc.IdempotencyKey) error {
+	collection := d.Client.Database(d.DBName).Collection(IdempotencyKeyCollection)
+
+	// build document
+	keyDoc := IdempotencyKeyDoc{
+		ID:        primitive.NewObjectID(),
+		Phone:     doc.Phone,
+		Key:       doc.Key,
+		CreatedAt: primitive.NewDateTimeFromTime(doc.CreatedAt),
+		ExpiresAt: primitive.NewDateTimeFromTime(doc.ExpiresAt),
+	}
+
+	_, err := collection.InsertOne(ctx, keyDoc)
+	if mongo.IsDuplicateKeyError(err) {
+		return svc.ErrIdempotencyKeyExists
+	}
+	if err != nil {
+		return fmt.Errorf("cannot insert idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// GetIdempotencyKey fetch idempotency key data of the device
+func (d *DataStoreMongo) GetIdempotencyKey(ctx context.Context, phone, key string) (svc.IdempotencyKey, error) {
+	collection := d.Client.Database(d.DBName).Collection(IdempotencyKeyCollection)
+
+	// prepares the filter
+	filter := bson.D{
+		{Key: FnIdempotencyKeysPhone, Value: phone},
+		{Key: FnIdempotencyKeysKey, Value: key},
+	}
+
+	doc := IdempotencyKeyDoc{}
+	err := collection.FindOne(ctx, filter).Decode(&doc)
+	if err != nil {
+		return svc.IdempotencyKey{}, fmt.Errorf("cannot find idempotency key: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// SetIdempotencyKeyMessage links the idempotency key to the queued message
+func (d *DataStoreMongo) SetIdempotencyKeyMessage(ctx context.Context, phone, key, messageID string) error {
+	collection := d.Client.Database(d.DBName).Collection(IdempotencyKeyCollection)
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnIdempotencyKeysPhone, Value: phone},
+		{Key: FnIdempotencyKeysKey, Value: key},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{{Key: FnIdempotencyKeysMessageID, Value: messageID}}},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKey removes the idempotency key of the device
+func (d *DataStoreMongo) DeleteIdempotencyKey(ctx context.Context, phone, key string) error {
+	collection := d.Client.Database(d.DBName).Collection(IdempotencyKeyCollection)
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnIdempotencyKeysPhone, Value: phone},
+		{Key: FnIdempotencyKeysKey, Value: key},
+	}
+
+	_, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
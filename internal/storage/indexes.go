@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes required by each collection
+var collectionIndexes = map[string][]mongo.IndexModel{
+	OutboundMessageCollection: {
+		{Keys: bson.D{
+			{Key: FnOutboundMessagesPhone, Value: 1},
+			{Key: FnOutboundMessagesStatus, Value: 1},
+			{Key: FnOutboundMessagesNextAttemptAt, Value: 1},
+		}},
+		{Keys: bson.D{
+			{Key: FnOutboundMessagesPhone, Value: 1},
+			{Key: FnOutboundMessagesWaMessageID, Value: 1},
+		}},
+	},
+	IdempotencyKeyCollection: {
+		{
+			Keys: bson.D{
+				{Key: FnIdempotencyKeysPhone, Value: 1},
+				{Key: FnIdempotencyKeysKey, Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// removes the keys once they expire
+			Keys:    bson.D{{Key: FnIdempotencyKeysExpiresAt, Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	},
+	ChatMessageCollection: {
+		{
+			// a message is stored once, whatever the number of times it is received
+			Keys: bson.D{
+				{Key: FnChatMessagesPhone, Value: 1},
+				{Key: FnChatMessagesChat, Value: 1},
+				{Key: FnChatMessagesMessageID, Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{
+			{Key: FnChatMessagesPhone, Value: 1},
+			{Key: FnChatMessagesChat, Value: 1},
+			{Key: FnChatMessagesTimestamp, Value: -1},
+		}},
+		{Keys: bson.D{
+			{Key: FnChatMessagesPhone, Value: 1},
+			{Key: FnChatMessagesTimestamp, Value: -1},
+		}},
+		{Keys: bson.D{
+			{Key: FnChatMessagesPhone, Value: 1},
+			{Key: FnChatMessagesMessageID, Value: 1},
+		}},
+	},
+	WebhookDeadLetterCollection: {
+		{Keys: bson.D{
+			{Key: FnWebhookDeadLettersPhone, Value: 1},
+			{Key: FnWebhookDeadLettersCreatedAt, Value: -1},
+		}},
+		{Keys: bson.D{{Key: FnWebhookDeadLettersCreatedAt, Value: -1}}},
+	},
+	WebhookSubscriptionCollection: {
+		{Keys: bson.D{{Key: FnWebhookSubscriptionsDeviceID, Value: 1}}},
+	},
+	AutoReplyRuleCollection: {
+		{Keys: bson.D{
+			{Key: FnAutoReplyRulesDeviceID, Value: 1},
+			{Key: FnAutoReplyRulesPriority, Value: 1},
+			{Key: FnAutoReplyRulesCreatedAt, Value: 1},
+		}},
+	},
+	ScheduledMessageCollection: {
+		{Keys: bson.D{
+			{Key: FnScheduledMessagesStatus, Value: 1},
+			{Key: FnScheduledMessagesFrom, Value: 1},
+			{Key: FnScheduledMessagesNextRunAt, Value: 1},
+		}},
+		{Keys: bson.D{
+			{Key: FnScheduledMessagesFrom, Value: 1},
+			{Key: FnScheduledMessagesNextRunAt, Value: 1},
+		}},
+	},
+	CampaignCollection: {
+		{Keys: bson.D{{Key: FnCampaignsStatus, Value: 1}}},
+		{Keys: bson.D{
+			{Key: FnCampaignsPhone, Value: 1},
+			{Key: FnCampaignsCreatedAt, Value: -1},
+		}},
+	},
+	CampaignRecipientCollection: {
+		{Keys: bson.D{
+			{Key: FnCampaignRecipientsCampaignID, Value: 1},
+			{Key: FnCampaignRecipientsStatus, Value: 1},
+			{Key: FnCampaignRecipientsId, Value: 1},
+		}},
+	},
+	OnWhatsappCollection: {
+		{
+			// removes the results once they expire
+			Keys:    bson.D{{Key: FnOnWhatsappExpiresAt, Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	},
+}
+
+// EnsureIndexes creates the missing indexes, the existing indexes are left untouched
+func (d *DataStoreMongo) EnsureIndexes(ctx context.Context) error {
+	for collectionName, indexes := range collectionIndexes {
+		collection := d.Client.Database(d.DBName).Collection(collectionName)
+
+		_, err := collection.Indexes().CreateMany(ctx, indexes)
+		if err != nil {
+			return fmt.Errorf("cannot create indexes of %s: %w", collectionName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/message"
+)
+
+const (
+	// OutboundMessageCollection defines the collection name
+	OutboundMessageCollection = "outbound_messages"
+
+	// FnOutboundMessagesId defines the main identifier that acts as a Primary Key
+	FnOutboundMessagesId = string("_id")
+
+	// FnOutboundMessagesPhone defines the phone number of the sender device
+	FnOutboundMessagesPhone = string("phone")
+
+	// FnOutboundMessagesStatus defines the delivery status
+	FnOutboundMessagesStatus = string("status")
+
+	// FnOutboundMessagesAttempts defines the number of attempts
+	FnOutboundMessagesAttempts = string("attempts")
+
+	// FnOutboundMessagesNextAttemptAt defines the time of the next attempt
+	FnOutboundMessagesNextAttemptAt = string("next_attempt_at")
+
+	// FnOutboundMessagesLastError defines the error of the last attempt
+	FnOutboundMessagesLastError = string("last_error")
+
+	// FnOutboundMessagesWaMessageID defines the message ID given by the Whatsapp server
+	FnOutboundMessagesWaMessageID = string("wa_message_id")
+
+	// FnOutboundMessagesSentAt defines the time the message has been sent
+	FnOutboundMessagesSentAt = string("sent_at")
+
+	// FnOutboundMessagesUpdatedAt defines the update time
+	FnOutboundMessagesUpdatedAt = string("updated_at")
+)
+
+// OutboundMessageDoc is the document prepared for the outbound message
+type OutboundMessageDoc struct {
+	ID            primitive.ObjectID  `bson:"_id"`
+	Phone         string              `bson:"phone"`
+	To            string              `bson:"to"`
+	Recipient     string              `bson:"recipient"`
+	Type          string              `bson:"type"`
+	Message       string              `bson:"message,omitempty"`
+	FileName      string              `bson:"file_name,omitempty"`
+	Caption       string              `bson:"caption,omitempty"`
+	MimeType      string              `bson:"mimetype,omitempty"`
+	DocumentName  string              `bson:"document_name,omitempty"`
+	PTT           bool                `bson:"ptt,omitempty"`
+	StoredMedia   bool                `bson:"stored_media,omitempty"`
+	Location      *LocationDoc        `bson:"location,omitempty"`
+	Contacts      []ContactDoc        `bson:"contacts,omitempty"`
+	ReplyTo       *ReplyToDoc         `bson:"reply_to,omitempty"`
+	Mentions      []string            `bson:"mentions,omitempty"`
+	Target        *TargetDoc          `bson:"target,omitempty"`
+	Status        string              `bson:"status"`
+	Attempts      int                 `bson:"attempts"`
+	NextAttemptAt primitive.DateTime  `bson:"next_attempt_at"`
+	LastError     string              `bson:"last_error,omitempty"`
+	WaMessageID   string              `bson:"wa_message_id,omitempty"`
+	SentAt        *primitive.DateTime `bson:"sent_at,omitempty"`
+	CreatedAt     primitive.DateTime  `bson:"created_at"`
+	UpdatedAt     primitive.DateTime  `bson:"updated_at"`
+}
+
+// LocationDoc is the document prepared for the location of the outbound message
+type LocationDoc struct {
+	Latitude  float64 `bson:"latitude"`
+	Longitude float64 `bson:"longitude"`
+	Name      string  `bson:"name,omitempty"`
+	Address   string  `bson:"address,omitempty"`
+	Live      bool    `bson:"live,omitempty"`
+	Caption   string  `bson:"caption,omitempty"`
+}
+
+// ContactDoc is the document prepared for a contact of the outbound message
+type ContactDoc struct {
+	DisplayName string `bson:"display_name"`
+	VCard       string `bson:"vcard"`
+}
+
+// ReplyToDoc is the document prepared for the message quoted by the outbound message
+type ReplyToDoc struct {
+	MessageID string `bson:"message_id"`
+	Sender    string `bson:"sender,omitempty"`
+	FromMe    bool   `bson:"from_me,omitempty"`
+	Text      string `bson:"text,omitempty"`
+}
+
+// TargetDoc is the document prepared for the message a reaction, an edit or a revoke acts on
+type TargetDoc struct {
+	MessageID string     `bson:"message_id"`
+	Sender    string     `bson:"sender,omitempty"`
+	SentAt    *time.Time `bson:"sent_at,omitempty"`
+}
+
+// contactsToService converts the ContactDoc list into Contact list
+func contactsToService(docs []ContactDoc) []svc.Contact {
+	if docs == nil {
+		return nil
+	}
+
+	contacts := make([]svc.Contact, len(docs))
+	for i, doc := range docs {
+		contacts[i] = svc.Contact(doc)
+	}
+
+	return contacts
+}
+
+// contactsToBsonObject converts the Contact list from the service into the documents
+func contactsToBsonObject(contacts []svc.Contact) []ContactDoc {
+	if contacts == nil {
+		return nil
+	}
+
+	docs := make([]ContactDoc, len(contacts))
+	for i, c := range contacts {
+		docs[i] = ContactDoc(c)
+	}
+
+	return docs
+}
+
+// ToService converts the OutboundMessageDoc struct into OutboundMessage struct
+func (u *OutboundMessageDoc) ToService() svc.OutboundMessage {
+	msg := svc.OutboundMessage{
+		ID:            u.ID.Hex(),
+		Phone:         u.Phone,
+		To:            u.To,
+		Recipient:     u.Recipient,
+		Type:          u.Type,
+		Message:       u.Message,
+		FileName:      u.FileName,
+		Caption:       u.Caption,
+		MimeType:      u.MimeType,
+		DocumentName:  u.DocumentName,
+		PTT:           u.PTT,
+		StoredMedia:   u.StoredMedia,
+		Location:      (*svc.Location)(u.Location),
+		Contacts:      contactsToService(u.Contacts),
+		ReplyTo:       (*svc.ReplyTo)(u.ReplyTo),
+		Mentions:      u.Mentions,
+		Target:        (*svc.Target)(u.Target),
+		Status:        u.Status,
+		Attempts:      u.Attempts,
+		NextAttemptAt: u.NextAttemptAt.Time(),
+		LastError:     u.LastError,
+		WaMessageID:   u.WaMessageID,
+		CreatedAt:     u.CreatedAt.Time(),
+		UpdatedAt:     u.UpdatedAt.Time(),
+	}
+
+	if u.SentAt != nil {
+		sentAt := u.SentAt.Time()
+		msg.SentAt = &sentAt
+	}
+
+	return msg
+}
+
+// outboundMessageToBsonObject converts the OutboundMessage struct from the service into the document
+func outboundMessageToBsonObject(u svc.OutboundMessage) OutboundMessageDoc {
+	return OutboundMessageDoc{
+		ID:            primitive.NewObjectID(),
+		Phone:         u.Phone,
+		To:            u.To,
+		Recipient:     u.Recipient,
+		Type:          u.Type,
+		Message:       u.Message,
+		FileName:      u.FileName,
+		Caption:       u.Caption,
+		MimeType:      u.MimeType,
+		DocumentName:  u.DocumentName,
+		PTT:           u.PTT,
+		StoredMedia:   u.StoredMedia,
+		Location:      (*LocationDoc)(u.Location),
+		Contacts:      contactsToBsonObject(u.Contacts),
+		ReplyTo:       (*ReplyToDoc)(u.ReplyTo),
+		Mentions:      u.Mentions,
+		Target:        (*TargetDoc)(u.Target),
+		Status:        u.Status,
+		Attempts:      u.Attempts,
+		NextAttemptAt: primitive.NewDateTimeFromTime(u.NextAttemptAt),
+		CreatedAt:     primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt:     primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// InsertOutboundMessage stores outbound message data
+func (d *DataStoreMongo) InsertOutboundMessage(ctx context.Context, doc svc.OutboundMessage) (svc.OutboundMessage,
+	error) {
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+
+	// build document
+	msgDoc := outboundMessageToBsonObject(doc)
+
+	_, err := collection.InsertOne(ctx, msgDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert outbound message: %w", err)
+	}
+
+	return msgDoc.ToService(), nil
+}
+
+// GetOutboundMessageByID fetch outbound message data by ID
+func (d *DataStoreMongo) GetOutboundMessageByID(ctx context.Context, id string) (svc.OutboundMessage, error) {
+	// Create a BSON ObjectID by passing string to ObjectIDFromHex() method
+	IdObject, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.OutboundMessage{}, err
+	}
+
+	// prepares the filter
+	filter := bson.D{{Key: FnOutboundMessagesId, Value: IdObject}}
+
+	// finds document by ID and convert the cursor result to bson object
+	doc := OutboundMessageDoc{}
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if err != nil {
+		return svc.OutboundMessage{}, fmt.Errorf("cannot find outbound message: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetOutboundMessageByWaMessageID fetch outbound message data of the device based on the whatsapp message ID
+func (d *DataStoreMongo) GetOutboundMessageByWaMessageID(ctx context.Context, phone,
+	waMessageID string) (svc.OutboundMessage, error) {
+	// prepares the filter
+	filter := bson.D{
+		{Key: FnOutboundMessagesPhone, Value: phone},
+		{Key: FnOutboundMessagesWaMessageID, Value: waMessageID},
+	}
+
+	doc := OutboundMessageDoc{}
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+	err := collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if err != nil {
+		return svc.OutboundMessage{}, fmt.Errorf("cannot find outbound message: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// ClaimOutboundMessage atomically takes the oldest due message of the device and marks it as being sent
+// it returns false if no message is due
+func (d *DataStoreMongo) ClaimOutboundMessage(ctx context.Context, phone string) (svc.OutboundMessage, bool,
+	error) {
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnOutboundMessagesPhone, Value: phone},
+		{Key: FnOutboundMessagesStatus, Value: svc.StatusQueued},
+		{Key: FnOutboundMessagesNextAttemptAt, Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusSending},
+			{Key: FnOutboundMessagesUpdatedAt, Value: now},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: FnOutboundMessagesAttempts, Value: 1}}},
+	}
+
+	// prepares the options
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: FnOutboundMessagesNextAttemptAt, Value: 1}, {Key: FnOutboundMessagesId, Value: 1}}).
+		SetReturnDocument(options.After)
+
+	doc := OutboundMessageDoc{}
+	err := collection.FindOneAndUpdate(ctx, filter, docBson, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return svc.OutboundMessage{}, false, nil
+	}
+	if err != nil {
+		return svc.OutboundMessage{}, false, fmt.Errorf("cannot claim outbound message: %w", err)
+	}
+
+	return doc.ToService(), true, nil
+}
+
+// ReleaseOutboundMessage puts the claimed message back to the queue without counting the attempt
+func (d *DataStoreMongo) ReleaseOutboundMessage(ctx context.Context, id string, nextAttemptAt time.Time) error {
+	return d.updateOutboundMessage(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusQueued},
+			{Key: FnOutboundMessagesNextAttemptAt, Value: primitive.NewDateTimeFromTime(nextAttemptAt)},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: FnOutboundMessagesAttempts, Value: -1}}},
+	})
+}
+
+// RetryOutboundMessage puts the failed message back to the queue for the next attempt
+func (d *DataStoreMongo) RetryOutboundMessage(ctx context.Context, id, lastError string,
+	nextAttemptAt time.Time) error {
+	return d.updateOutboundMessage(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusQueued},
+			{Key: FnOutboundMessagesLastError, Value: lastError},
+			{Key: FnOutboundMessagesNextAttemptAt, Value: primitive.NewDateTimeFromTime(nextAttemptAt)},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	})
+}
+
+// FailOutboundMessage marks the message as failed
+func (d *DataStoreMongo) FailOutboundMessage(ctx context.Context, id, lastError string) error {
+	return d.updateOutboundMessage(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusFailed},
+			{Key: FnOutboundMessagesLastError, Value: lastError},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	})
+}
+
+// MarkOutboundMessageSent marks the message as sent and stores the message ID given by the Whatsapp server
+func (d *DataStoreMongo) MarkOutboundMessageSent(ctx context.Context, id, waMessageID string,
+	sentAt time.Time) error {
+	return d.updateOutboundMessage(ctx, id, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusSent},
+			{Key: FnOutboundMessagesWaMessageID, Value: waMessageID},
+			{Key: FnOutboundMessagesSentAt, Value: primitive.NewDateTimeFromTime(sentAt)},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	})
+}
+
+// UpdateOutboundMessageReceipt updates the delivery status of a sent message when its current status is one of from
+func (d *DataStoreMongo) UpdateOutboundMessageReceipt(ctx context.Context, phone, waMessageID, status string,
+	from []string) error {
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnOutboundMessagesPhone, Value: phone},
+		{Key: FnOutboundMessagesWaMessageID, Value: waMessageID},
+		{Key: FnOutboundMessagesStatus, Value: bson.D{{Key: "$in", Value: from}}},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: status},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResetSendingOutboundMessages puts the messages interrupted while being sent back to the queue
+func (d *DataStoreMongo) ResetSendingOutboundMessages(ctx context.Context) (int64, error) {
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+
+	// builds filter
+	filter := bson.D{{Key: FnOutboundMessagesStatus, Value: svc.StatusSending}}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnOutboundMessagesStatus, Value: svc.StatusQueued},
+			{Key: FnOutboundMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, docBson)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// updateOutboundMessage updates the outbound message by ID
+func (d *DataStoreMongo) updateOutboundMessage(ctx context.Context, id string, docBson bson.D) error {
+	collection := d.Client.Database(d.DBName).Collection(OutboundMessageCollection)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnOutboundMessagesId, Value: objID}}
+
+	// finds document by ID and executes update action
+	_, err = collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
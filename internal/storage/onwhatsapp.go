@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/session"
+)
+
+const (
+	// OnWhatsappCollection defines the collection name
+	OnWhatsappCollection = "on_whatsapp_cache"
+
+	// FnOnWhatsappPhone defines the checked phone number, it acts as a Primary Key
+	FnOnWhatsappPhone = string("_id")
+
+	// FnOnWhatsappExpiresAt defines the expiration time, mongo removes the result afterward
+	FnOnWhatsappExpiresAt = string("expires_at")
+)
+
+// OnWhatsappDoc is the document prepared for the cached on-whatsapp result
+type OnWhatsappDoc struct {
+	Phone     string             `bson:"_id"`
+	JID       string             `bson:"jid,omitempty"`
+	IsIn      bool               `bson:"is_in"`
+	CheckedAt primitive.DateTime `bson:"checked_at"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+// ToService converts the OnWhatsappDoc struct into OnWhatsapp struct
+func (u *OnWhatsappDoc) ToService() svc.OnWhatsapp {
+	return svc.OnWhatsapp{
+		Phone:     u.Phone,
+		JID:       u.JID,
+		IsIn:      u.IsIn,
+		CheckedAt: u.CheckedAt.Time(),
+	}
+}
+
+// GetOnWhatsappResults fetch the cached on-whatsapp results of the phones which have not expired yet
+func (d *DataStoreMongo) GetOnWhatsappResults(ctx context.Context, phones []string) ([]svc.OnWhatsapp, error) {
+	collection := d.Client.Database(d.DBName).Collection(OnWhatsappCollection)
+
+	// prepares the filter, mongo removes the expired results periodically only
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	filter := bson.D{
+		{Key: FnOnWhatsappPhone, Value: bson.D{{Key: "$in", Value: phones}}},
+		{Key: FnOnWhatsappExpiresAt, Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find on-whatsapp results: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := make([]svc.OnWhatsapp, 0)
+	for cursor.Next(ctx) {
+		var doc OnWhatsappDoc
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, doc.ToService())
+	}
+
+	return results, cursor.Err()
+}
+
+// UpsertOnWhatsappResults stores the on-whatsapp results until they expire
+func (d *DataStoreMongo) UpsertOnWhatsappResults(ctx context.Context, results []svc.OnWhatsapp,
+	expiresAt time.Time) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(OnWhatsappCollection)
+
+	models := make([]mongo.WriteModel, len(results))
+	for i, result := range results {
+		doc := OnWhatsappDoc{
+			Phone:     result.Phone,
+			JID:       result.JID,
+			IsIn:      result.IsIn,
+			CheckedAt: primitive.NewDateTimeFromTime(result.CheckedAt),
+			ExpiresAt: primitive.NewDateTimeFromTime(expiresAt),
+		}
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: FnOnWhatsappPhone, Value: result.Phone}}).
+			SetReplacement(doc).
+			SetUpsert(true)
+	}
+
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("cannot store on-whatsapp results: %w", err)
+	}
+
+	return nil
+}
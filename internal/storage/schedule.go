@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/schedule"
+)
+
+const (
+	// ScheduledMessageCollection defines the collection name
+	ScheduledMessageCollection = "scheduled_messages"
+
+	// FnScheduledMessagesId defines the main identifier that acts as a Primary Key
+	FnScheduledMessagesId = string("_id")
+
+	// FnScheduledMessagesFrom defines the phone number of the device, without the `+` symbol
+	FnScheduledMessagesFrom = string("from")
+
+	// FnScheduledMessagesNextRunAt defines the time of the next dispatch
+	FnScheduledMessagesNextRunAt = string("next_run_at")
+
+	// FnScheduledMessagesStatus defines the status of the scheduled message
+	FnScheduledMessagesStatus = string("status")
+
+	// FnScheduledMessagesRuns defines the number of the dispatches
+	FnScheduledMessagesRuns = string("runs")
+
+	// FnScheduledMessagesLastRunAt defines the time of the last dispatch
+	FnScheduledMessagesLastRunAt = string("last_run_at")
+
+	// FnScheduledMessagesLastMessageID defines the ID of the outbound message queued by the last dispatch
+	FnScheduledMessagesLastMessageID = string("last_message_id")
+
+	// FnScheduledMessagesLastError defines the error of the last dispatch
+	FnScheduledMessagesLastError = string("last_error")
+
+	// FnScheduledMessagesUpdatedAt defines the update time
+	FnScheduledMessagesUpdatedAt = string("updated_at")
+)
+
+// ScheduledMessageDoc is the document prepared for a scheduled message
+type ScheduledMessageDoc struct {
+	ID            primitive.ObjectID  `bson:"_id"`
+	From          string              `bson:"from"`
+	Type          string              `bson:"type"`
+	Message       string              `bson:"message"`
+	SendAt        primitive.DateTime  `bson:"send_at"`
+	Cron          string              `bson:"cron,omitempty"`
+	Timezone      string              `bson:"timezone,omitempty"`
+	NextRunAt     primitive.DateTime  `bson:"next_run_at"`
+	Status        string              `bson:"status"`
+	Runs          int                 `bson:"runs"`
+	LastRunAt     *primitive.DateTime `bson:"last_run_at,omitempty"`
+	LastMessageID string              `bson:"last_message_id,omitempty"`
+	LastError     string              `bson:"last_error,omitempty"`
+	CreatedAt     primitive.DateTime  `bson:"created_at"`
+	UpdatedAt     primitive.DateTime  `bson:"updated_at"`
+}
+
+// ToService converts the ScheduledMessageDoc struct into ScheduledMessage struct
+func (u *ScheduledMessageDoc) ToService() svc.ScheduledMessage {
+	msg := svc.ScheduledMessage{
+		ID:            u.ID.Hex(),
+		From:          u.From,
+		Type:          u.Type,
+		Message:       json.RawMessage(u.Message),
+		SendAt:        u.SendAt.Time().UTC(),
+		Cron:          u.Cron,
+		Timezone:      u.Timezone,
+		NextRunAt:     u.NextRunAt.Time().UTC(),
+		Status:        u.Status,
+		Runs:          u.Runs,
+		LastMessageID: u.LastMessageID,
+		LastError:     u.LastError,
+		CreatedAt:     u.CreatedAt.Time(),
+		UpdatedAt:     u.UpdatedAt.Time(),
+	}
+	if u.LastRunAt != nil {
+		lastRunAt := u.LastRunAt.Time().UTC()
+		msg.LastRunAt = &lastRunAt
+	}
+
+	return msg
+}
+
+// scheduledMessageToBsonObject converts the ScheduledMessage struct into ScheduledMessageDoc struct
+func scheduledMessageToBsonObject(u svc.ScheduledMessage) ScheduledMessageDoc {
+	return ScheduledMessageDoc{
+		ID:        primitive.NewObjectID(),
+		From:      u.From,
+		Type:      u.Type,
+		Message:   string(u.Message),
+		SendAt:    primitive.NewDateTimeFromTime(u.SendAt),
+		Cron:      u.Cron,
+		Timezone:  u.Timezone,
+		NextRunAt: primitive.NewDateTimeFromTime(u.NextRunAt),
+		Status:    u.Status,
+		CreatedAt: primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt: primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// InsertScheduledMessage stores a scheduled message
+func (d *DataStoreMongo) InsertScheduledMessage(ctx context.Context, doc svc.ScheduledMessage) (svc.ScheduledMessage,
+	error) {
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+
+	// build document
+	msgDoc := scheduledMessageToBsonObject(doc)
+
+	_, err := collection.InsertOne(ctx, msgDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert scheduled message: %w", err)
+	}
+
+	// enrich with _id
+	doc.ID = msgDoc.ID.Hex()
+
+	return doc, nil
+}
+
+// GetScheduledMessage fetch a scheduled message by ID
+func (d *DataStoreMongo) GetScheduledMessage(ctx context.Context, id string) (svc.ScheduledMessage, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ScheduledMessage{}, svc.ErrScheduleNotFound
+	}
+
+	// prepares the filter
+	filter := bson.D{{Key: FnScheduledMessagesId, Value: objID}}
+
+	doc := ScheduledMessageDoc{}
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.ScheduledMessage{}, svc.ErrScheduleNotFound
+	}
+	if err != nil {
+		return svc.ScheduledMessage{}, fmt.Errorf("cannot find scheduled message: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetScheduledMessages fetches the scheduled messages by next dispatch, of a device and of a status if they are set
+func (d *DataStoreMongo) GetScheduledMessages(ctx context.Context, phone, status string,
+	params httputils.GetQueryParams) (int64, []svc.ScheduledMessage, error) {
+	// prepares the options
+	var opts = options.Find()
+
+	// set query parameters
+	opts.SetLimit(params.Limit)
+	opts.SetSkip(params.Offset)
+
+	// sets order option
+	order := 1
+	if params.Order == query.DESC {
+		order = -1
+	}
+	opts.SetSort(bson.D{
+		{Key: FnScheduledMessagesNextRunAt, Value: order},
+		{Key: FnScheduledMessagesId, Value: order},
+	})
+
+	// builds filter
+	filter := bson.D{}
+	if phone != "" {
+		filter = append(filter, bson.E{Key: FnScheduledMessagesFrom, Value: phone})
+	}
+	if status != "" {
+		filter = append(filter, bson.E{Key: FnScheduledMessagesStatus, Value: status})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot count scheduled messages: %w", err)
+	}
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any scheduled message: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res, err := decodeScheduledMessages(ctx, cur)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, res, nil
+}
+
+// GetDueScheduledMessages fetches the pending scheduled messages of the given devices due by the given time,
+// the oldest first
+func (d *DataStoreMongo) GetDueScheduledMessages(ctx context.Context, now time.Time, phones []string,
+	limit int64) ([]svc.ScheduledMessage, error) {
+	// sets order option
+	opts := options.Find().SetLimit(limit).SetSort(bson.D{{Key: FnScheduledMessagesNextRunAt, Value: 1}})
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnScheduledMessagesStatus, Value: svc.StatusScheduled},
+		{Key: FnScheduledMessagesFrom, Value: bson.D{{Key: "$in", Value: phones}}},
+		{Key: FnScheduledMessagesNextRunAt, Value: bson.D{{Key: "$lte", Value: primitive.NewDateTimeFromTime(now)}}},
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find any due scheduled message: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	return decodeScheduledMessages(ctx, cur)
+}
+
+// UpdateScheduledMessageRun records the dispatch of the occurrence planned at the given time,
+// the message is left untouched when it has been cancelled or dispatched by another instance meanwhile
+func (d *DataStoreMongo) UpdateScheduledMessageRun(ctx context.Context, doc svc.ScheduledMessage,
+	runAt time.Time) error {
+	objID, err := primitive.ObjectIDFromHex(doc.ID)
+	if err != nil {
+		return svc.ErrScheduleNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnScheduledMessagesId, Value: objID},
+		{Key: FnScheduledMessagesStatus, Value: svc.StatusScheduled},
+		{Key: FnScheduledMessagesNextRunAt, Value: primitive.NewDateTimeFromTime(runAt)},
+	}
+
+	// prepares document to update
+	set := bson.D{
+		{Key: FnScheduledMessagesStatus, Value: doc.Status},
+		{Key: FnScheduledMessagesNextRunAt, Value: primitive.NewDateTimeFromTime(doc.NextRunAt)},
+		{Key: FnScheduledMessagesRuns, Value: doc.Runs},
+		{Key: FnScheduledMessagesLastMessageID, Value: doc.LastMessageID},
+		{Key: FnScheduledMessagesLastError, Value: doc.LastError},
+		{Key: FnScheduledMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(doc.UpdatedAt)},
+	}
+	if doc.LastRunAt != nil {
+		set = append(set, bson.E{Key: FnScheduledMessagesLastRunAt, Value: primitive.NewDateTimeFromTime(*doc.LastRunAt)})
+	}
+	docBson := bson.D{{Key: "$set", Value: set}}
+
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrScheduleNotFound
+	}
+
+	return nil
+}
+
+// CancelScheduledMessage cancels a pending scheduled message
+func (d *DataStoreMongo) CancelScheduledMessage(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrScheduleNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnScheduledMessagesId, Value: objID},
+		{Key: FnScheduledMessagesStatus, Value: svc.StatusScheduled},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnScheduledMessagesStatus, Value: svc.StatusCancelled},
+			{Key: FnScheduledMessagesUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(ScheduledMessageCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrNotCancellable
+	}
+
+	return nil
+}
+
+// decodeScheduledMessages decodes the scheduled message docs of the cursor
+func decodeScheduledMessages(ctx context.Context, cur *mongo.Cursor) ([]svc.ScheduledMessage, error) {
+	res := make([]svc.ScheduledMessage, 0)
+	for cur.Next(ctx) {
+		doc := ScheduledMessageDoc{}
+
+		err := cur.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode scheduled message doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return res, nil
+}
@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardihikaru/go-modules/pkg/utils/httputils"
+	"github.com/ardihikaru/go-modules/pkg/utils/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	svc "github.com/ardihikaru/go-whatsapp-multi-device/internal/service/webhook"
+)
+
+const (
+	// WebhookDeadLetterCollection defines the collection name
+	WebhookDeadLetterCollection = "webhook_dead_letters"
+
+	// FnWebhookDeadLettersId defines the main identifier that acts as a Primary Key
+	FnWebhookDeadLettersId = string("_id")
+
+	// FnWebhookDeadLettersPhone defines the phone number of the device, without the `+` symbol
+	FnWebhookDeadLettersPhone = string("phone")
+
+	// FnWebhookDeadLettersAttempts defines the number of the delivery attempts
+	FnWebhookDeadLettersAttempts = string("attempts")
+
+	// FnWebhookDeadLettersLastError defines the error of the last delivery attempt
+	FnWebhookDeadLettersLastError = string("last_error")
+
+	// FnWebhookDeadLettersCreatedAt defines the creation time
+	FnWebhookDeadLettersCreatedAt = string("created_at")
+
+	// FnWebhookDeadLettersUpdatedAt defines the update time
+	FnWebhookDeadLettersUpdatedAt = string("updated_at")
+)
+
+const (
+	// WebhookSubscriptionCollection defines the collection name
+	WebhookSubscriptionCollection = "webhook_subscriptions"
+
+	// FnWebhookSubscriptionsId defines the main identifier that acts as a Primary Key
+	FnWebhookSubscriptionsId = string("_id")
+
+	// FnWebhookSubscriptionsDeviceID defines the ID of the device owning the subscription
+	FnWebhookSubscriptionsDeviceID = string("device_id")
+
+	// FnWebhookSubscriptionsURL defines the webhook endpoint
+	FnWebhookSubscriptionsURL = string("url")
+
+	// FnWebhookSubscriptionsEvents defines the subscribed event types
+	FnWebhookSubscriptionsEvents = string("events")
+
+	// FnWebhookSubscriptionsChatTypes defines the chat types filter
+	FnWebhookSubscriptionsChatTypes = string("chat_types")
+
+	// FnWebhookSubscriptionsCreatedAt defines the creation time
+	FnWebhookSubscriptionsCreatedAt = string("created_at")
+
+	// FnWebhookSubscriptionsUpdatedAt defines the update time
+	FnWebhookSubscriptionsUpdatedAt = string("updated_at")
+)
+
+// WebhookDeadLetterDoc is the document prepared for a failed webhook delivery
+// the body is kept as the JSON posted to the webhook, so that it is replayed as is
+type WebhookDeadLetterDoc struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Phone          string             `bson:"phone"`
+	EventType      string             `bson:"event_type"`
+	MsgID          string             `bson:"msg_id,omitempty"`
+	SubscriptionID string             `bson:"subscription_id,omitempty"`
+	WebhookURL     string             `bson:"webhook_url,omitempty"`
+	Body           string             `bson:"body"`
+	Attempts       int                `bson:"attempts"`
+	LastError      string             `bson:"last_error"`
+	CreatedAt      primitive.DateTime `bson:"created_at"`
+	UpdatedAt      primitive.DateTime `bson:"updated_at"`
+}
+
+// ToService converts the WebhookDeadLetterDoc struct into DeadLetter struct
+func (u *WebhookDeadLetterDoc) ToService() svc.DeadLetter {
+	letter := svc.DeadLetter{
+		ID:             u.ID.Hex(),
+		Phone:          u.Phone,
+		EventType:      u.EventType,
+		MsgID:          u.MsgID,
+		SubscriptionID: u.SubscriptionID,
+		WebhookURL:     u.WebhookURL,
+		Attempts:       u.Attempts,
+		LastError:      u.LastError,
+		CreatedAt:      u.CreatedAt.Time(),
+		UpdatedAt:      u.UpdatedAt.Time(),
+	}
+
+	// the body has been encoded by this service, it is always valid
+	_ = json.Unmarshal([]byte(u.Body), &letter.Body)
+
+	return letter
+}
+
+// webhookDeadLetterToBsonObject converts the DeadLetter struct into WebhookDeadLetterDoc struct
+func webhookDeadLetterToBsonObject(u svc.DeadLetter) (WebhookDeadLetterDoc, error) {
+	body, err := json.Marshal(u.Body)
+	if err != nil {
+		return WebhookDeadLetterDoc{}, err
+	}
+
+	return WebhookDeadLetterDoc{
+		ID:             primitive.NewObjectID(),
+		Phone:          u.Phone,
+		EventType:      u.EventType,
+		MsgID:          u.MsgID,
+		SubscriptionID: u.SubscriptionID,
+		WebhookURL:     u.WebhookURL,
+		Body:           string(body),
+		Attempts:       u.Attempts,
+		LastError:      u.LastError,
+		CreatedAt:      primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt:      primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}, nil
+}
+
+// InsertWebhookDeadLetter stores a failed webhook delivery
+func (d *DataStoreMongo) InsertWebhookDeadLetter(ctx context.Context, doc svc.DeadLetter) (svc.DeadLetter, error) {
+	collection := d.Client.Database(d.DBName).Collection(WebhookDeadLetterCollection)
+
+	// build document
+	letterDoc, err := webhookDeadLetterToBsonObject(doc)
+	if err != nil {
+		return doc, fmt.Errorf("bson object convertion failed: %w", err)
+	}
+
+	_, err = collection.InsertOne(ctx, letterDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert webhook dead letter: %w", err)
+	}
+
+	// enrich with _id
+	doc.ID = letterDoc.ID.Hex()
+
+	return doc, nil
+}
+
+// GetWebhookDeadLetter fetch a failed webhook delivery by ID
+func (d *DataStoreMongo) GetWebhookDeadLetter(ctx context.Context, id string) (svc.DeadLetter, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.DeadLetter{}, svc.ErrDeadLetterNotFound
+	}
+
+	// prepares the filter
+	filter := bson.D{{Key: FnWebhookDeadLettersId, Value: objID}}
+
+	doc := WebhookDeadLetterDoc{}
+	collection := d.Client.Database(d.DBName).Collection(WebhookDeadLetterCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.DeadLetter{}, svc.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return svc.DeadLetter{}, fmt.Errorf("cannot find webhook dead letter: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetWebhookDeadLetters fetches the failed webhook deliveries, of a device if the phone is set
+func (d *DataStoreMongo) GetWebhookDeadLetters(ctx context.Context, phone string,
+	params httputils.GetQueryParams) (int64, []svc.DeadLetter, error) {
+	// prepares the options
+	var opts = options.Find()
+
+	// set query parameters
+	opts.SetLimit(params.Limit)
+	opts.SetSkip(params.Offset)
+
+	// sets order option
+	order := -1
+	if params.Order == query.ASC {
+		order = 1
+	}
+	opts.SetSort(bson.D{
+		{Key: FnWebhookDeadLettersCreatedAt, Value: order},
+		{Key: FnWebhookDeadLettersId, Value: order},
+	})
+
+	// builds filter
+	filter := bson.D{}
+	if phone != "" {
+		filter = append(filter, bson.E{Key: FnWebhookDeadLettersPhone, Value: phone})
+	}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(WebhookDeadLetterCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot count webhook dead letters: %w", err)
+	}
+
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot find any webhook dead letter: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res := make([]svc.DeadLetter, 0)
+	for cur.Next(ctx) {
+		doc := WebhookDeadLetterDoc{}
+
+		err = cur.Decode(&doc)
+		if err != nil {
+			return 0, nil, fmt.Errorf("cannot decode webhook dead letter doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return total, res, nil
+}
+
+// UpdateWebhookDeadLetterFailure records a failed replay of a webhook delivery
+func (d *DataStoreMongo) UpdateWebhookDeadLetterFailure(ctx context.Context, id, lastError string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrDeadLetterNotFound
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnWebhookDeadLettersId, Value: objID}}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnWebhookDeadLettersLastError, Value: lastError},
+			{Key: FnWebhookDeadLettersUpdatedAt, Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: FnWebhookDeadLettersAttempts, Value: 1}}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(WebhookDeadLetterCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+// DeleteWebhookDeadLetter removes a failed webhook delivery
+func (d *DataStoreMongo) DeleteWebhookDeadLetter(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrDeadLetterNotFound
+	}
+
+	// builds filter
+	filter := bson.D{{Key: FnWebhookDeadLettersId, Value: objID}}
+
+	collection := d.Client.Database(d.DBName).Collection(WebhookDeadLetterCollection)
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return svc.ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+// WebhookSubscriptionDoc is the document prepared for a webhook subscription of a device
+type WebhookSubscriptionDoc struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	DeviceID  string             `bson:"device_id"`
+	URL       string             `bson:"url"`
+	Events    []string           `bson:"events"`
+	ChatTypes []string           `bson:"chat_types,omitempty"`
+	CreatedAt primitive.DateTime `bson:"created_at"`
+	UpdatedAt primitive.DateTime `bson:"updated_at"`
+}
+
+// ToService converts the WebhookSubscriptionDoc struct into Subscription struct
+func (u *WebhookSubscriptionDoc) ToService() svc.Subscription {
+	return svc.Subscription{
+		ID:        u.ID.Hex(),
+		DeviceID:  u.DeviceID,
+		URL:       u.URL,
+		Events:    u.Events,
+		ChatTypes: u.ChatTypes,
+		CreatedAt: u.CreatedAt.Time(),
+		UpdatedAt: u.UpdatedAt.Time(),
+	}
+}
+
+// webhookSubscriptionToBsonObject converts the Subscription struct into WebhookSubscriptionDoc struct
+func webhookSubscriptionToBsonObject(u svc.Subscription) WebhookSubscriptionDoc {
+	return WebhookSubscriptionDoc{
+		ID:        primitive.NewObjectID(),
+		DeviceID:  u.DeviceID,
+		URL:       u.URL,
+		Events:    u.Events,
+		ChatTypes: u.ChatTypes,
+		CreatedAt: primitive.NewDateTimeFromTime(u.CreatedAt),
+		UpdatedAt: primitive.NewDateTimeFromTime(u.UpdatedAt),
+	}
+}
+
+// InsertWebhookSubscription stores a webhook subscription
+func (d *DataStoreMongo) InsertWebhookSubscription(ctx context.Context, doc svc.Subscription) (svc.Subscription,
+	error) {
+	collection := d.Client.Database(d.DBName).Collection(WebhookSubscriptionCollection)
+
+	// build document
+	subDoc := webhookSubscriptionToBsonObject(doc)
+
+	_, err := collection.InsertOne(ctx, subDoc)
+	if err != nil {
+		return doc, fmt.Errorf("cannot insert webhook subscription: %w", err)
+	}
+
+	// enrich with _id
+	doc.ID = subDoc.ID.Hex()
+
+	return doc, nil
+}
+
+// GetWebhookSubscription fetch a webhook subscription of the device by ID
+func (d *DataStoreMongo) GetWebhookSubscription(ctx context.Context, deviceID, id string) (svc.Subscription,
+	error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.Subscription{}, svc.ErrSubscriptionNotFound
+	}
+
+	// prepares the filter
+	filter := bson.D{
+		{Key: FnWebhookSubscriptionsId, Value: objID},
+		{Key: FnWebhookSubscriptionsDeviceID, Value: deviceID},
+	}
+
+	doc := WebhookSubscriptionDoc{}
+	collection := d.Client.Database(d.DBName).Collection(WebhookSubscriptionCollection)
+	err = collection.FindOne(ctx, filter, options.FindOne()).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svc.Subscription{}, svc.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return svc.Subscription{}, fmt.Errorf("cannot find webhook subscription: %w", err)
+	}
+
+	return doc.ToService(), nil
+}
+
+// GetWebhookSubscriptions fetches all webhook subscriptions of the device, the oldest first
+func (d *DataStoreMongo) GetWebhookSubscriptions(ctx context.Context, deviceID string) ([]svc.Subscription,
+	error) {
+	// sets order option
+	opts := options.Find().SetSort(bson.D{{Key: FnWebhookSubscriptionsCreatedAt, Value: 1}})
+
+	// builds filter
+	filter := bson.D{{Key: FnWebhookSubscriptionsDeviceID, Value: deviceID}}
+
+	// gets cursor
+	collection := d.Client.Database(d.DBName).Collection(WebhookSubscriptionCollection)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find any webhook subscription: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	res := make([]svc.Subscription, 0)
+	for cur.Next(ctx) {
+		doc := WebhookSubscriptionDoc{}
+
+		err = cur.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode webhook subscription doc: %w", err)
+		}
+
+		res = append(res, doc.ToService())
+	}
+
+	return res, nil
+}
+
+// UpdateWebhookSubscription replaces the endpoint and the filters of a webhook subscription
+func (d *DataStoreMongo) UpdateWebhookSubscription(ctx context.Context, doc svc.Subscription) error {
+	objID, err := primitive.ObjectIDFromHex(doc.ID)
+	if err != nil {
+		return svc.ErrSubscriptionNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnWebhookSubscriptionsId, Value: objID},
+		{Key: FnWebhookSubscriptionsDeviceID, Value: doc.DeviceID},
+	}
+
+	// prepares document to update
+	docBson := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: FnWebhookSubscriptionsURL, Value: doc.URL},
+			{Key: FnWebhookSubscriptionsEvents, Value: doc.Events},
+			{Key: FnWebhookSubscriptionsChatTypes, Value: doc.ChatTypes},
+			{Key: FnWebhookSubscriptionsUpdatedAt, Value: primitive.NewDateTimeFromTime(doc.UpdatedAt)},
+		}},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(WebhookSubscriptionCollection)
+	result, err := collection.UpdateOne(ctx, filter, docBson)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return svc.ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// DeleteWebhookSubscription removes a webhook subscription of the device
+func (d *DataStoreMongo) DeleteWebhookSubscription(ctx context.Context, deviceID, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return svc.ErrSubscriptionNotFound
+	}
+
+	// builds filter
+	filter := bson.D{
+		{Key: FnWebhookSubscriptionsId, Value: objID},
+		{Key: FnWebhookSubscriptionsDeviceID, Value: deviceID},
+	}
+
+	collection := d.Client.Database(d.DBName).Collection(WebhookSubscriptionCollection)
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return svc.ErrSubscriptionNotFound
+	}
+
+	return nil
+}